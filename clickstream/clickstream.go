@@ -0,0 +1,131 @@
+// Package clickstream publishes raw click events to external sinks, such as rotated files, Redis Streams or Kafka,
+// for analytics pipelines. Events are buffered in memory and written in batches by a background worker, so that
+// slow or unavailable sinks never hold up redirects; events which do not fit into the buffer are dropped and counted.
+package clickstream
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/yexelm/shorty/metrics"
+)
+
+// Event is a click on a short link.
+type Event struct {
+	At        time.Time `json:"at"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Domain    string    `json:"domain,omitempty"`
+	Short     string    `json:"short"`
+	Long      string    `json:"long"`
+	Country   string    `json:"country,omitempty"`
+	Variant   string    `json:"variant,omitempty"`
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+}
+
+// Sink writes batches of events to an external system.
+type Sink interface {
+	// Write writes the events in order, either all of them or none.
+	Write(ctx context.Context, events []Event) error
+	// Close flushes and releases the sink.
+	Close() error
+}
+
+// Publisher buffers events and writes them to its sink in batches.
+type Publisher struct {
+	sink      Sink
+	batchSize int
+	interval  time.Duration
+	timeout   time.Duration
+
+	events chan Event
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewPublisher returns a Publisher buffering up to bufferSize events and starts its worker, which writes batches of
+// up to batchSize events to the sink at least every interval. Writes taking longer than timeout fail. The Publisher
+// must be closed to flush the buffer.
+func NewPublisher(sink Sink, bufferSize, batchSize int, interval, timeout time.Duration) *Publisher {
+	p := &Publisher{
+		sink:      sink,
+		batchSize: batchSize,
+		interval:  interval,
+		timeout:   timeout,
+		events:    make(chan Event, bufferSize),
+		stop:      make(chan struct{}),
+	}
+	p.wg.Add(1)
+	go p.run()
+
+	return p
+}
+
+// Publish queues the event for writing. It never blocks, the event is dropped if the buffer is full.
+func (p *Publisher) Publish(e Event) {
+	select {
+	case p.events <- e:
+	default:
+		metrics.ClickEvents.WithLabelValues("dropped").Inc()
+	}
+}
+
+// Close writes the buffered events, then closes the sink.
+func (p *Publisher) Close() error {
+	close(p.stop)
+	p.wg.Wait()
+
+	return p.sink.Close()
+}
+
+func (p *Publisher) run() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	batch := make([]Event, 0, p.batchSize)
+	for {
+		select {
+		case e := <-p.events:
+			batch = append(batch, e)
+			if len(batch) < p.batchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-p.stop:
+			for len(p.events) > 0 {
+				batch = append(batch, <-p.events)
+				if len(batch) == p.batchSize {
+					p.write(batch)
+					batch = batch[:0]
+				}
+			}
+			p.write(batch)
+			return
+		}
+
+		p.write(batch)
+		batch = batch[:0]
+	}
+}
+
+// write writes the batch to the sink. Failed batches are dropped, retrying would only grow the backlog of a sink
+// which cannot keep up.
+func (p *Publisher) write(batch []Event) {
+	if len(batch) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	if err := p.sink.Write(ctx, batch); err != nil {
+		metrics.ClickEvents.WithLabelValues("failed").Add(float64(len(batch)))
+		log.Printf("failed to write %v click events: %v", len(batch), err)
+		return
+	}
+	metrics.ClickEvents.WithLabelValues("published").Add(float64(len(batch)))
+}
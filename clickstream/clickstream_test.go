@@ -0,0 +1,126 @@
+package clickstream
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memory is a Sink keeping written batches, it blocks writes until unblocked.
+type memory struct {
+	mu      sync.Mutex
+	batches [][]Event
+	closed  bool
+	fail    bool
+	block   chan struct{}
+}
+
+func (m *memory) Write(_ context.Context, events []Event) error {
+	if m.block != nil {
+		<-m.block
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fail {
+		return errors.New("unavailable")
+	}
+	m.batches = append(m.batches, append([]Event(nil), events...))
+
+	return nil
+}
+
+func (m *memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+
+	return nil
+}
+
+func (m *memory) written() [][]Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.batches
+}
+
+func Test_Publisher(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+
+	sink := &memory{}
+	p := NewPublisher(sink, 10, 2, time.Hour, time.Second)
+
+	// full batches are written at once
+	p.Publish(Event{Short: "a"})
+	p.Publish(Event{Short: "b"})
+	ao.Eventually(func() bool { return len(sink.written()) == 1 }, time.Second, time.Millisecond)
+	ao.Equal([]Event{{Short: "a"}, {Short: "b"}}, sink.written()[0])
+
+	// the rest is written on close
+	p.Publish(Event{Short: "c"})
+	ao.NoError(p.Close())
+	ao.Equal([][]Event{{{Short: "a"}, {Short: "b"}}, {{Short: "c"}}}, sink.written())
+	ao.True(sink.closed)
+}
+
+func Test_PublisherInterval(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+
+	sink := &memory{}
+	p := NewPublisher(sink, 10, 100, 10*time.Millisecond, time.Second)
+	defer p.Close()
+
+	p.Publish(Event{Short: "a"})
+	ao.Eventually(func() bool { return len(sink.written()) == 1 }, time.Second, time.Millisecond)
+}
+
+func Test_PublisherDrops(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+
+	sink := &memory{block: make(chan struct{})}
+	p := NewPublisher(sink, 2, 1, time.Hour, time.Second)
+
+	// the worker is blocked by the first event, two more fit into the buffer and the rest is dropped
+	p.Publish(Event{Short: "a"})
+	ao.Eventually(func() bool { return len(p.events) == 0 }, time.Second, time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			p.Publish(Event{Short: "a"})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish must not block on a slow sink")
+	}
+
+	close(sink.block)
+	ao.NoError(p.Close())
+	ao.Len(sink.written(), 3)
+}
+
+func Test_PublisherFailures(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+
+	sink := &memory{fail: true}
+	p := NewPublisher(sink, 10, 1, time.Hour, time.Second)
+
+	// failed batches are dropped and do not stop the worker
+	p.Publish(Event{Short: "a"})
+	p.Publish(Event{Short: "b"})
+	ao.NoError(p.Close())
+	ao.Empty(sink.written())
+}
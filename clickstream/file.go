@@ -0,0 +1,116 @@
+package clickstream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// FileSink appends events to a file as newline delimited JSON. Once the file reaches its maximum size, it is
+// renamed to <file>.1, older files are shifted to <file>.2 and so on, and the oldest one is removed.
+type FileSink struct {
+	path     string
+	maxBytes int64
+	maxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewFileSink opens the file for appending. It is rotated after reaching maxBytes, keeping maxFiles rotated files.
+func NewFileSink(path string, maxBytes int64, maxFiles int) (*FileSink, error) {
+	s := &FileSink{path: path, maxBytes: maxBytes, maxFiles: maxFiles}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Write appends the events to the file, rotating it first if it is full.
+func (s *FileSink) Write(_ context.Context, events []Event) error {
+	var buf []byte
+	for _, e := range events {
+		// the error is always nil for events made of strings and times
+		b, _ := json.Marshal(e)
+		buf = append(append(buf, b...), '\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return os.ErrClosed
+	}
+	if s.size > 0 && s.size+int64(len(buf)) > s.maxBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(buf)
+	s.size += int64(n)
+
+	return err
+}
+
+// Close closes the file.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+
+	return err
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file, s.size = f, info.Size()
+
+	return nil
+}
+
+// rotate shifts rotated files, moves the current file to <file>.1 and opens a new one.
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	s.file = nil
+
+	if s.maxFiles > 0 {
+		for i := s.maxFiles - 1; i > 0; i-- {
+			err := os.Rename(rotated(s.path, i), rotated(s.path, i+1))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(s.path, rotated(s.path, 1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(s.path); err != nil {
+		return err
+	}
+
+	return s.open()
+}
+
+func rotated(path string, n int) string {
+	return fmt.Sprintf("%v.%v", path, n)
+}
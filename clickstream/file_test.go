@@ -0,0 +1,57 @@
+package clickstream
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_FileSink(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "clicks")
+	ao.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "clicks.ndjson")
+
+	line := `{"at":"0001-01-01T00:00:00Z","short":"a","long":"https://go.dev"}` + "\n"
+	e := Event{Short: "a", Long: "https://go.dev"}
+
+	// files are rotated once the next batch would not fit, keeping two rotated files
+	sink, err := NewFileSink(path, int64(2*len(line)), 2)
+	ao.NoError(err)
+	for i := 0; i < 4; i++ {
+		ao.NoError(sink.Write(context.Background(), []Event{e}))
+	}
+	ao.NoError(sink.Write(context.Background(), []Event{e, e}))
+	ao.NoError(sink.Close())
+
+	for name, expected := range map[string]string{
+		path:        line + line,
+		path + ".1": line + line,
+		path + ".2": line + line,
+	} {
+		b, err := ioutil.ReadFile(name)
+		ao.NoError(err)
+		ao.Equal(expected, string(b))
+	}
+	_, err = os.Stat(path + ".3")
+	ao.True(os.IsNotExist(err))
+
+	// the size of an existing file is taken into account
+	sink, err = NewFileSink(path, int64(3*len(line)), 2)
+	ao.NoError(err)
+	ao.NoError(sink.Write(context.Background(), []Event{e, e}))
+	ao.NoError(sink.Close())
+
+	b, err := ioutil.ReadFile(path)
+	ao.NoError(err)
+	ao.Equal(line+line, string(b))
+
+	ao.Equal(os.ErrClosed, sink.Write(context.Background(), []Event{e}))
+}
@@ -0,0 +1,44 @@
+package clickstream
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaSink produces events to a Kafka topic as JSON messages keyed by the short alias, so that clicks on a link
+// keep their order within a partition.
+type KafkaSink struct {
+	writer *kafka.Writer
+}
+
+// NewKafkaSink returns a KafkaSink producing to the topic on the given brokers.
+func NewKafkaSink(brokers []string, topic string) *KafkaSink {
+	return &KafkaSink{writer: &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireOne,
+		// batches are collected by the Publisher already, the writer should not wait for more messages
+		BatchTimeout: 10 * time.Millisecond,
+	}}
+}
+
+// Write produces the events and waits for the brokers to acknowledge them.
+func (s *KafkaSink) Write(ctx context.Context, events []Event) error {
+	msgs := make([]kafka.Message, len(events))
+	for i, e := range events {
+		// the error is always nil for events made of strings and times
+		b, _ := json.Marshal(e)
+		msgs[i] = kafka.Message{Key: []byte(e.Tenant + "/" + e.Short), Value: b, Time: e.At}
+	}
+
+	return s.writer.WriteMessages(ctx, msgs...)
+}
+
+// Close flushes pending messages and closes connections to the brokers.
+func (s *KafkaSink) Close() error {
+	return s.writer.Close()
+}
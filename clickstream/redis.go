@@ -0,0 +1,49 @@
+package clickstream
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// RedisSink adds events to a Redis stream, trimming it to about maxLen entries. Each entry has a single "event"
+// field holding the event as JSON.
+type RedisSink struct {
+	pool   *redis.Pool
+	stream string
+	maxLen int
+}
+
+// NewRedisSink returns a RedisSink adding events to the stream with connections from the pool.
+func NewRedisSink(pool *redis.Pool, stream string, maxLen int) *RedisSink {
+	return &RedisSink{pool: pool, stream: stream, maxLen: maxLen}
+}
+
+// Write adds the events to the stream in a single transaction.
+func (s *RedisSink) Write(ctx context.Context, events []Event) error {
+	conn, err := s.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.Send("MULTI"); err != nil {
+		return err
+	}
+	for _, e := range events {
+		// the error is always nil for events made of strings and times
+		b, _ := json.Marshal(e)
+		if err := conn.Send("XADD", s.stream, "MAXLEN", "~", s.maxLen, "*", "event", b); err != nil {
+			return err
+		}
+	}
+	_, err = conn.Do("EXEC")
+
+	return err
+}
+
+// Close does nothing, the pool is owned by the storage.
+func (s *RedisSink) Close() error {
+	return nil
+}
@@ -0,0 +1,41 @@
+package clickstream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RedisSink(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+
+	s, err := miniredis.Run()
+	ao.NoError(err)
+	defer s.Close()
+
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) { return redis.Dial("tcp", s.Addr()) }}
+	defer pool.Close()
+
+	sink := NewRedisSink(pool, "clicks", 2)
+	at := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	ao.NoError(sink.Write(context.Background(), []Event{
+		{At: at, Short: "a", Long: "https://go.dev"},
+		{At: at, Short: "b", Long: "https://golang.org"},
+		{At: at, Short: "c", Long: "https://pkg.go.dev", Country: "NL"},
+	}))
+	ao.NoError(sink.Close())
+
+	// the stream is trimmed to the latest entries
+	entries, err := s.Stream("clicks")
+	ao.NoError(err)
+	ao.Len(entries, 2)
+	ao.Equal([]string{"event", `{"at":"2021-03-01T00:00:00Z","short":"b","long":"https://golang.org"}`},
+		entries[0].Values)
+	ao.Equal([]string{"event",
+		`{"at":"2021-03-01T00:00:00Z","short":"c","long":"https://pkg.go.dev","country":"NL"}`}, entries[1].Values)
+}
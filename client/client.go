@@ -0,0 +1,413 @@
+// Package client is a Go client of the JSON API of shorty.
+//
+//	c := client.New("https://short.ly", apiKey)
+//	l, err := c.Shorten(ctx, client.ShortenRequest{URL: "https://go.dev"})
+//
+// Requests are retried with exponential backoff after network errors, 429 Too Many Requests and 502, 503 and 504
+// responses, honoring Retry-After. A failed attempt may still have been applied by the server, so retries are not
+// always harmless: shortening a URL without settings returns the same link again, and Delete treats the 404 of a
+// retry as success, but a link with settings, such as a password or a click limit, is created anew by every attempt,
+// so a retried Shorten or ShortenBatch may create it twice. Pass WithRetries(0) to handle such failures yourself.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRetries    = 3
+	defaultBackoff    = 200 * time.Millisecond
+	defaultMaxBackoff = 5 * time.Second
+	defaultTimeout    = 10 * time.Second
+)
+
+// Error is a response of the API other than 2xx.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("shorty: %v %v", e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether the error is caused by a link which does not exist.
+func IsNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == http.StatusNotFound
+}
+
+// ShortenRequest describes the link to create. Details are optional, see Meta for their meaning.
+type ShortenRequest struct {
+	URL string `json:"url"`
+	// Domain is one of the hosts of the tenant of the API key the link is created on.
+	Domain   string `json:"domain,omitempty"`
+	Password string `json:"password,omitempty"`
+	// NotBefore and NotAfter are RFC 3339 dates or times limiting the time the link is active.
+	NotBefore string `json:"not_before,omitempty"`
+	NotAfter  string `json:"not_after,omitempty"`
+	Meta
+}
+
+// Meta contains the details of a link.
+type Meta struct {
+	Creator     string            `json:"creator,omitempty"`
+	Title       string            `json:"title,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Notes       string            `json:"notes,omitempty"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	// MaxClicks limits the number of resolutions of the link, zero means no limit.
+	MaxClicks int `json:"max_clicks,omitempty"`
+	// NotBefore and NotAfter limit the time the link is active, FallbackURL is resolved instead outside of it.
+	NotBefore   *time.Time `json:"not_before,omitempty"`
+	NotAfter    *time.Time `json:"not_after,omitempty"`
+	FallbackURL string     `json:"fallback_url,omitempty"`
+	// Rules send visitors matching them to their own destinations, the first matching rule wins.
+	Rules []Rule `json:"rules,omitempty"`
+	// Variants split visitors not matching any rule between several destinations by weight.
+	Variants []Variant `json:"variants,omitempty"`
+	// ForwardQuery merges the query of the short URL into the destination.
+	ForwardQuery bool `json:"forward_query,omitempty"`
+	// Prefix makes the link resolve paths below the alias, the rest of the path is appended to the destination.
+	Prefix bool `json:"prefix,omitempty"`
+	// UTM lists UTM parameters without the utm_ prefix added to the destination unless it already has them.
+	UTM map[string]string `json:"utm,omitempty"`
+	// Preview shows visitors a page with the destination instead of sending them there right away.
+	Preview bool `json:"preview,omitempty"`
+	// OpenGraph is metadata of the destination shown by chat apps and social networks unfurling the short URL.
+	OpenGraph *OpenGraph `json:"open_graph,omitempty"`
+}
+
+// Rule routes visitors matching all of its conditions to its URL. Empty conditions match everybody.
+type Rule struct {
+	// Platform is ios, android, windows, macos or linux, or mobile and desktop for groups of them.
+	Platform string `json:"platform,omitempty"`
+	Language string `json:"language,omitempty"`
+	// Country is the ISO 3166 code of the country of the visitor.
+	Country string `json:"country,omitempty"`
+	// Query lists parameters of the request query with their values, empty values only require the parameter.
+	Query map[string]string `json:"query,omitempty"`
+	URL   string            `json:"url"`
+}
+
+// Variant is one of the destinations of a link rotating between several ones by weight.
+type Variant struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// OpenGraph is metadata of the destination of a link.
+type OpenGraph struct {
+	Title       string    `json:"title,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Image       string    `json:"image,omitempty"`
+	FetchedAt   time.Time `json:"fetched_at"`
+}
+
+// Link is a saved link. Its short URL is only returned for single links, not with pages of links.
+type Link struct {
+	ShortURL  string    `json:"short_url,omitempty"`
+	Short     string    `json:"short"`
+	Long      string    `json:"long"`
+	Disabled  bool      `json:"disabled"`
+	CreatedAt time.Time `json:"created_at"`
+	// Protected reports whether the link requires a password.
+	Protected bool `json:"protected,omitempty"`
+	Meta
+}
+
+// ActiveAt reports whether the link is active at the given time.
+func (l Link) ActiveAt(t time.Time) bool {
+	return (l.NotBefore == nil || !t.Before(*l.NotBefore)) && (l.NotAfter == nil || !t.After(*l.NotAfter))
+}
+
+// ClickStats contains the number of clicks of a link.
+type ClickStats struct {
+	Clicks    int `json:"clicks"`
+	MaxClicks int `json:"max_clicks,omitempty"`
+	// ClicksLeft is the number of resolutions left for links with a click limit.
+	ClicksLeft *int `json:"clicks_left,omitempty"`
+	// Countries counts resolutions by ISO 3166 codes of countries of the visitors, if they are known.
+	Countries map[string]int `json:"countries,omitempty"`
+	// Variants counts resolutions by names of the variants of links rotating between several destinations.
+	Variants map[string]int `json:"variants,omitempty"`
+}
+
+// BatchResult is either the link created for a URL of a batch or the reason it was not created.
+type BatchResult struct {
+	Link  *Link  `json:"link,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// ListOptions filters the links returned by List. Empty options do not filter anything.
+type ListOptions struct {
+	Creator string
+	Tag     string
+	Domain  string
+	// Search is a substring of the original URL.
+	Search string
+	// Cursor is the one returned with the previous page, empty for the first page.
+	Cursor string
+	Count  int
+}
+
+// Page is a page of links from the newest to the oldest. Cursor is empty on the last page.
+type Page struct {
+	Links  []Link `json:"links"`
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// Client calls the API with an API key. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient makes the Client send requests with the given HTTP client.
+func WithHTTPClient(c *http.Client) Option {
+	return func(cl *Client) {
+		cl.httpClient = c
+	}
+}
+
+// WithRetries makes the Client retry failed requests up to retries times, waiting backoff before the first retry and
+// twice as long before every next one, up to maxBackoff.
+func WithRetries(retries int, backoff, maxBackoff time.Duration) Option {
+	return func(cl *Client) {
+		cl.retries, cl.backoff, cl.maxBackoff = retries, backoff, maxBackoff
+	}
+}
+
+// New returns a Client of the API served at baseURL, such as https://short.ly, authenticated with the API key.
+func New(baseURL, apiKey string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		apiKey:     apiKey,
+		httpClient: &http.Client{Timeout: defaultTimeout},
+		retries:    defaultRetries,
+		backoff:    defaultBackoff,
+		maxBackoff: defaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Shorten creates a link to the URL. If the URL has been shortened before and neither the request nor the existing
+// link has settings, the existing link is returned unchanged.
+func (c *Client) Shorten(ctx context.Context, req ShortenRequest) (*Link, error) {
+	var l Link
+	if err := c.do(ctx, http.MethodPost, "/api/v1/links", req, &l); err != nil {
+		return nil, err
+	}
+
+	return &l, nil
+}
+
+// ShortenBatch creates links to up to 100 URLs in a single request. The links fail separately, the results are in
+// the order of the requests.
+func (c *Client) ShortenBatch(ctx context.Context, reqs []ShortenRequest) ([]BatchResult, error) {
+	var resp struct {
+		Results []BatchResult `json:"results"`
+	}
+	body := struct {
+		Links []ShortenRequest `json:"links"`
+	}{reqs}
+	if err := c.do(ctx, http.MethodPost, "/api/v1/links/batch", body, &resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Results, nil
+}
+
+// Resolve returns the link with the short alias without counting a click.
+func (c *Client) Resolve(ctx context.Context, short string) (*Link, error) {
+	var l Link
+	if err := c.do(ctx, http.MethodGet, linkPath(short), nil, &l); err != nil {
+		return nil, err
+	}
+
+	return &l, nil
+}
+
+// Delete deletes the link with the short alias. If the link is not found by a retry, it is considered deleted by
+// the previous attempt whose response has been lost.
+func (c *Client) Delete(ctx context.Context, short string) error {
+	retried, err := c.retry(ctx, http.MethodDelete, linkPath(short), nil, nil)
+	if retried && IsNotFound(err) {
+		return nil
+	}
+
+	return err
+}
+
+// Stats returns the number of clicks of the link with the short alias.
+func (c *Client) Stats(ctx context.Context, short string) (*ClickStats, error) {
+	var stats ClickStats
+	if err := c.do(ctx, http.MethodGet, linkPath(short)+"/stats", nil, &stats); err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// List returns a page of the links matching the options.
+func (c *Client) List(ctx context.Context, opts ListOptions) (*Page, error) {
+	q := url.Values{}
+	for k, v := range map[string]string{
+		"creator": opts.Creator, "tag": opts.Tag, "domain": opts.Domain, "q": opts.Search, "cursor": opts.Cursor,
+	} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	if opts.Count > 0 {
+		q.Set("count", strconv.Itoa(opts.Count))
+	}
+
+	path := "/api/v1/links"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+
+	var page Page
+	if err := c.do(ctx, http.MethodGet, path, nil, &page); err != nil {
+		return nil, err
+	}
+
+	return &page, nil
+}
+
+func linkPath(short string) string {
+	return "/api/v1/links/" + url.PathEscape(short)
+}
+
+// do sends the request with the JSON body, retrying it if needed, and decodes the JSON response into out unless it
+// is nil.
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	_, err := c.retry(ctx, method, path, in, out)
+	return err
+}
+
+// retry is do which also reports whether the result comes from a retry.
+func (c *Client) retry(ctx context.Context, method, path string, in, out interface{}) (bool, error) {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return false, err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		wait, err := c.send(ctx, method, path, body, out)
+		if wait < 0 || attempt == c.retries {
+			return attempt > 0, err
+		}
+		if wait == 0 {
+			wait = c.delay(attempt)
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return attempt > 0, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// send sends the request once. Unless the request can be retried, a negative wait is returned. Otherwise, it is the
+// time the server asked to wait or zero.
+func (c *Client) send(ctx context.Context, method, path string, body []byte, out interface{}) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return -1, ctx.Err()
+		}
+		return 0, err
+	}
+	defer func() {
+		// drain the body so that the connection is reused
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if out == nil || resp.StatusCode == http.StatusNoContent {
+			return -1, nil
+		}
+		return -1, json.NewDecoder(resp.Body).Decode(out)
+	}
+
+	apiErr := &Error{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+	var e struct {
+		Error string `json:"error"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Error != "" {
+		apiErr.Message = e.Error
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return retryAfter(resp.Header.Get("Retry-After")), apiErr
+	default:
+		return -1, apiErr
+	}
+}
+
+// delay returns the time to wait before the retry following the given attempt.
+func (c *Client) delay(attempt int) time.Duration {
+	d := c.backoff
+	for i := 0; i < attempt && d < c.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > c.maxBackoff {
+		d = c.maxBackoff
+	}
+
+	return d
+}
+
+// retryAfter parses the Retry-After header given in seconds, zero is returned if it is missing or malformed.
+func retryAfter(v string) time.Duration {
+	s, err := strconv.Atoi(v)
+	if err != nil || s < 0 {
+		return 0
+	}
+
+	return time.Duration(s) * time.Second
+}
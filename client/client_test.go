@@ -0,0 +1,233 @@
+package client_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+
+	"github.com/yexelm/shorty/client"
+	"github.com/yexelm/shorty/config"
+	"github.com/yexelm/shorty/handlers"
+	"github.com/yexelm/shorty/store"
+)
+
+// serve serves the API of an Environment backed by miniredis in memory, passing requests through the middleware,
+// and returns a client calling it with a new API key.
+func serve(t *testing.T, middleware func(fasthttp.RequestHandler) fasthttp.RequestHandler,
+	opts ...client.Option) *client.Client {
+	t.Helper()
+
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+
+	st, err := store.New(s.Addr(), 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(st.Close)
+
+	key, err := st.IssueKey("", "team")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := &handlers.Environment{
+		Config:    config.New(),
+		Cache:     st,
+		Admin:     st,
+		Links:     st,
+		Keys:      st,
+		Tenants:   st,
+		Passwords: st,
+		Webhooks:  st,
+		Toggles:   handlers.NewToggles(),
+	}
+
+	ln := fasthttputil.NewInmemoryListener()
+	go fasthttp.Serve(ln, middleware(env.Handle))
+	t.Cleanup(func() { ln.Close() })
+
+	httpClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(context.Context, string, string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}}
+
+	return client.New("http://short.ly/", key, append([]client.Option{client.WithHTTPClient(httpClient)}, opts...)...)
+}
+
+func direct(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return h
+}
+
+func Test_Client(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	c := serve(t, direct)
+	ctx := context.Background()
+
+	l, err := c.Shorten(ctx, client.ShortenRequest{
+		URL:  "https://go.dev",
+		Meta: client.Meta{Title: "Go", Tags: []string{"Lang"}},
+	})
+	ao.NoError(err)
+	ao.Equal("http://short.ly/"+l.Short, l.ShortURL)
+	ao.Equal("https://go.dev", l.Long)
+	ao.Equal("team", l.Creator)
+	ao.Equal([]string{"lang"}, l.Tags)
+
+	again, err := c.Shorten(ctx, client.ShortenRequest{URL: "https://go.dev"})
+	ao.NoError(err)
+	ao.Equal(l.Short, again.Short)
+
+	limited, err := c.Shorten(ctx, client.ShortenRequest{URL: "https://go.dev", NotAfter: "2099-01-01"})
+	ao.NoError(err)
+	ao.NotEqual(l.Short, limited.Short)
+	ao.NotNil(limited.NotAfter)
+
+	resolved, err := c.Resolve(ctx, l.Short)
+	ao.NoError(err)
+	ao.Equal(l.Long, resolved.Long)
+
+	stats, err := c.Stats(ctx, l.Short)
+	ao.NoError(err)
+	ao.Equal(0, stats.Clicks)
+
+	results, err := c.ShortenBatch(ctx, []client.ShortenRequest{{URL: "https://golang.org"}, {}})
+	ao.NoError(err)
+	ao.Len(results, 2)
+	ao.Equal("https://golang.org", results[0].Link.Long)
+	ao.Equal("empty url", results[1].Error)
+
+	page, err := c.List(ctx, client.ListOptions{Count: 1})
+	ao.NoError(err)
+	ao.Len(page.Links, 1)
+	ao.NotEmpty(page.Cursor)
+	page, err = c.List(ctx, client.ListOptions{Cursor: page.Cursor, Count: 1})
+	ao.NoError(err)
+	ao.Len(page.Links, 1)
+	page, err = c.List(ctx, client.ListOptions{Tag: "lang"})
+	ao.NoError(err)
+	ao.Len(page.Links, 1)
+	ao.Equal(l.Short, page.Links[0].Short)
+
+	ao.NoError(c.Delete(ctx, l.Short))
+	_, err = c.Resolve(ctx, l.Short)
+	ao.True(client.IsNotFound(err))
+	ao.EqualError(err, "shorty: 404 the requested short code not found")
+
+	_, err = c.Shorten(ctx, client.ShortenRequest{URL: "https://go.dev", NotAfter: "someday"})
+	ao.EqualError(err, "shorty: 400 invalid date, expected RFC 3339 date or time")
+}
+
+func Test_ClientAuth(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+
+	// the key is replaced by a wrong one on the way
+	c := serve(t, func(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			ctx.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer wrong")
+			h(ctx)
+		}
+	})
+
+	_, err := c.Resolve(context.Background(), "b")
+	ao.Equal(&client.Error{StatusCode: http.StatusUnauthorized, Message: "invalid API key"}, err)
+}
+
+func Test_ClientRetries(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+
+	// the first two requests fail
+	var requests int32
+	flaky := func(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			if atomic.AddInt32(&requests, 1) <= 2 {
+				ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+				return
+			}
+			h(ctx)
+		}
+	}
+
+	c := serve(t, flaky, client.WithRetries(2, time.Millisecond, time.Millisecond))
+	l, err := c.Shorten(context.Background(), client.ShortenRequest{URL: "https://go.dev"})
+	ao.NoError(err)
+	ao.Equal("https://go.dev", l.Long)
+	ao.Equal(int32(3), atomic.LoadInt32(&requests))
+
+	// requests are not retried more often than allowed
+	atomic.StoreInt32(&requests, 0)
+	c = serve(t, flaky, client.WithRetries(1, time.Millisecond, time.Millisecond))
+	_, err = c.Shorten(context.Background(), client.ShortenRequest{URL: "https://go.dev"})
+	ao.Equal(&client.Error{StatusCode: http.StatusServiceUnavailable, Message: "Service Unavailable"}, err)
+	ao.Equal(int32(2), atomic.LoadInt32(&requests))
+
+	// client errors are not retried
+	atomic.StoreInt32(&requests, 2)
+	_, err = c.Shorten(context.Background(), client.ShortenRequest{})
+	ao.EqualError(err, "shorty: 400 empty url")
+	ao.Equal(int32(3), atomic.LoadInt32(&requests))
+}
+
+func Test_ClientDeleteRetried(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+
+	// the first deletion is applied, but its response is lost by a proxy
+	var deletions int32
+	lossy := func(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			h(ctx)
+			if ctx.IsDelete() && atomic.AddInt32(&deletions, 1) == 1 {
+				ctx.Response.Reset()
+				ctx.SetStatusCode(fasthttp.StatusBadGateway)
+			}
+		}
+	}
+
+	c := serve(t, lossy, client.WithRetries(1, time.Millisecond, time.Millisecond))
+	l, err := c.Shorten(context.Background(), client.ShortenRequest{URL: "https://go.dev"})
+	ao.NoError(err)
+	ao.NoError(c.Delete(context.Background(), l.Short), "the link deleted by the lost attempt must not be reported missing")
+	ao.Equal(int32(2), atomic.LoadInt32(&deletions))
+
+	// links missing from the start are still reported
+	err = c.Delete(context.Background(), l.Short)
+	ao.True(client.IsNotFound(err))
+}
+
+func Test_ClientContext(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+
+	// the server asks to wait longer than the caller is willing to
+	limited := func(fasthttp.RequestHandler) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			ctx.Response.Header.Set(fasthttp.HeaderRetryAfter, "60")
+			ctx.SetStatusCode(fasthttp.StatusTooManyRequests)
+		}
+	}
+	c := serve(t, limited)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := c.Resolve(ctx, "b")
+	ao.Equal(context.DeadlineExceeded, err)
+	ao.Less(int64(time.Since(start)), int64(time.Second))
+}
@@ -1,56 +1,280 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"runtime"
 	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/valyala/fasthttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
+	"github.com/yexelm/shorty/config"
 	"github.com/yexelm/shorty/handlers"
+	shortymetrics "github.com/yexelm/shorty/metrics"
+	"github.com/yexelm/shorty/tlsutil"
+)
+
+// Exit codes of the application.
+const (
+	exitOK = iota
+	// exitServeError means one of the servers failed to start or stopped unexpectedly.
+	exitServeError
+	// exitShutdownError means in-flight requests were not drained before the shutdown deadline.
+	exitShutdownError
+)
+
+// opsShutdownTimeout is the time given to scrapes and probes served by the ops server to complete on shutdown.
+const opsShutdownTimeout = 5 * time.Second
+
+// version and revision are set at build time via -ldflags "-X main.version=... -X main.revision=...".
+var (
+	version  = "dev"
+	revision = "unknown"
 )
 
 func main() {
+	os.Exit(run())
+}
+
+func run() int {
 	env := handlers.LoadEnvironment()
+	shortymetrics.SetBuildInfo(version, revision, runtime.Version())
 
 	srv := &fasthttp.Server{
-		Handler:      env.Handle,
-		ReadTimeout:  time.Second,
-		WriteTimeout: time.Second,
+		Handler:         env.Handle,
+		ReadTimeout:     time.Second,
+		WriteTimeout:    time.Second,
+		CloseOnShutdown: true,
+	}
+	servers := []*fasthttp.Server{srv}
+	ops := opsServer(env)
+
+	admin := &fasthttp.Server{
+		Handler:         env.HandleAdmin,
+		ReadTimeout:     time.Second,
+		WriteTimeout:    time.Minute,
+		CloseOnShutdown: true,
 	}
+	servers = append(servers, admin)
 
-	go metrics()
+	certs, err := loadCerts(env.Config)
+	if err != nil {
+		log.Printf("failed to load TLS certificate: %v", err)
+		env.Close()
+		return exitServeError
+	}
 
-	go stop(srv)
+	// visitors following short links have no client certificates, so they are only required by the API handlers
+	ln, err := listen(":"+strconv.Itoa(env.Config.HostPort), certs, env.Config.TLSClientCAFile,
+		tls.VerifyClientCertIfGiven)
+	if err != nil {
+		log.Printf("failed to listen on port %v: %v", env.Config.HostPort, err)
+		env.Close()
+		return exitServeError
+	}
 
-	err := srv.ListenAndServe(":" + strconv.Itoa(env.Config.HostPort))
+	adminLn, err := listen(env.Config.AdminAddr, certs, env.Config.AdminTLSClientCAFile, tls.RequireAndVerifyClientCert)
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("failed to listen on admin address %v: %v", env.Config.AdminAddr, err)
+		ln.Close()
+		env.Close()
+		return exitServeError
 	}
-}
 
-func metrics() {
-	http.Handle("/metrics", promhttp.Handler())
-	err := http.ListenAndServe(":8081", nil)
+	rpcSrv, rpcLn, err := grpcServer(env, certs)
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("failed to listen on gRPC address %v: %v", env.Config.GRPCAddr, err)
+		ln.Close()
+		adminLn.Close()
+		env.Close()
+		return exitServeError
 	}
-}
 
-func stop(s *fasthttp.Server) {
+	errChan := make(chan error, 5)
+	go func() {
+		errChan <- srv.Serve(ln)
+	}()
+	go func() {
+		errChan <- admin.Serve(adminLn)
+	}()
+	go func() {
+		errChan <- rpcSrv.Serve(rpcLn)
+	}()
+	go func() {
+		if err := ops.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			errChan <- err
+		}
+	}()
+
+	if env.Config.TLSEnabled() && env.Config.HTTPRedirectPort != 0 {
+		redirect := &fasthttp.Server{
+			Handler:         env.RedirectToHTTPS,
+			ReadTimeout:     time.Second,
+			WriteTimeout:    time.Second,
+			CloseOnShutdown: true,
+		}
+		servers = append(servers, redirect)
+		go func() {
+			errChan <- redirect.ListenAndServe(":" + strconv.Itoa(env.Config.HTTPRedirectPort))
+		}()
+	}
+	env.SetReady(true)
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	sig := <-sigChan
-	log.Printf("received %v signal, shutting down", sig)
-	err := s.Shutdown()
-	if err != nil {
-		log.Printf("failed to gracefully shutdown the server due to %v", err)
+
+	code := exitOK
+	select {
+	case sig := <-sigChan:
+		log.Printf("received %v signal, shutting down", sig)
+	case err := <-errChan:
+		log.Printf("server stopped unexpectedly: %v", err)
+		code = exitServeError
+	}
+
+	if err := stop(env, servers, rpcSrv, ops); err != nil {
+		log.Printf("failed to gracefully shutdown the application due to %v", err)
+		if code == exitOK {
+			code = exitShutdownError
+		}
 	}
 
 	log.Println("application stopped")
+	return code
+}
+
+// loadCerts returns the reloader of the configured TLS certificate or nil if TLS is disabled.
+func loadCerts(cfg *config.Config) (*tlsutil.CertReloader, error) {
+	if !cfg.TLSEnabled() {
+		return nil, nil
+	}
+
+	return tlsutil.NewCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+}
+
+// listen opens the listener on the given address, terminating TLS on it if certs is not nil. If clientCAFile is not
+// empty, client certificates must be signed by one of its CAs and are requested according to clientAuth.
+func listen(addr string, certs *tlsutil.CertReloader, clientCAFile string, clientAuth tls.ClientAuthType) (net.Listener,
+	error) {
+	ln, err := net.Listen("tcp4", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if certs == nil {
+		return ln, nil
+	}
+
+	tlsCfg, err := tlsutil.ServerConfig(certs, clientCAFile)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	if tlsCfg.ClientCAs != nil {
+		tlsCfg.ClientAuth = clientAuth
+	}
+
+	return tls.NewListener(ln, tlsCfg), nil
+}
+
+// grpcServer returns the gRPC server along with its listener. TLS is terminated by the server, since gRPC requires
+// HTTP/2 to be negotiated.
+func grpcServer(env *handlers.Environment, certs *tlsutil.CertReloader) (*grpc.Server, net.Listener, error) {
+	ln, err := net.Listen("tcp4", env.Config.GRPCAddr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if certs == nil {
+		return env.NewGRPCServer(), ln, nil
+	}
+
+	tlsCfg, err := tlsutil.ServerConfig(certs, env.Config.TLSClientCAFile)
+	if err != nil {
+		ln.Close()
+		return nil, nil, err
+	}
+
+	return env.NewGRPCServer(grpc.Creds(credentials.NewTLS(tlsCfg))), ln, nil
+}
+
+// opsServer returns the server exposing metrics, liveness and readiness probes.
+func opsServer(env *handlers.Environment) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		if !env.Ready() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	return &http.Server{Addr: env.Config.MetricsAddr, Handler: mux}
+}
+
+// stop takes the application out of rotation, drains in-flight requests and calls within the configured deadline,
+// then stops background workers, flushes buffered data and closes connections to Redis. Requests cut off by the
+// deadline are logged and keep the connections to Redis open until the process exits. The ops server is stopped last
+// so that metrics and probes stay available during the shutdown.
+func stop(env *handlers.Environment, servers []*fasthttp.Server, rpcSrv *grpc.Server, ops *http.Server) error {
+	env.SetReady(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), env.Config.ShutdownTimeout)
+	defer cancel()
+
+	drained := make(chan error, len(servers)+1)
+	for _, srv := range servers {
+		go func(srv *fasthttp.Server) {
+			drained <- srv.Shutdown()
+		}(srv)
+	}
+	go func() {
+		rpcSrv.GracefulStop()
+		drained <- nil
+	}()
+
+	var err error
+drain:
+	for i := 0; i <= len(servers); i++ {
+		select {
+		case shutdownErr := <-drained:
+			if err == nil {
+				err = shutdownErr
+			}
+		case <-ctx.Done():
+			err = errors.New("in-flight requests were not drained in " + env.Config.ShutdownTimeout.String())
+			rpcSrv.Stop()
+			break drain
+		}
+	}
+
+	if n := env.InFlight(); n > 0 {
+		log.Printf("%v in-flight requests were cut off by the shutdown deadline", n)
+		env.StopWorkers()
+	} else {
+		env.Close()
+	}
+
+	opsCtx, opsCancel := context.WithTimeout(context.Background(), opsShutdownTimeout)
+	defer opsCancel()
+	if opsErr := ops.Shutdown(opsCtx); opsErr != nil && err == nil {
+		err = opsErr
+	}
+
+	return err
 }
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/yexelm/shorty/client"
+	"github.com/yexelm/shorty/store"
+)
+
+var (
+	// errInvalidURL is returned by the store backend for URLs which are not absolute HTTP(S) ones.
+	errInvalidURL = errors.New("invalid url, expected an absolute http or https URL")
+	// errInvalidTime is returned by the store backend for times the link is active which are not RFC 3339 ones.
+	errInvalidTime = errors.New("invalid time, expected RFC 3339 time")
+)
+
+// backend manages links either through the API or in Redis.
+type backend interface {
+	shorten(ctx context.Context, req client.ShortenRequest) (*client.Link, error)
+	resolve(ctx context.Context, short string) (*client.Link, error)
+	delete(ctx context.Context, short string) error
+	stats(ctx context.Context, short string) (*client.ClickStats, error)
+	list(ctx context.Context, opts client.ListOptions) (*client.Page, error)
+}
+
+// apiBackend manages links of the tenant of the API key through the API.
+type apiBackend struct {
+	client *client.Client
+	// domain is the host links are created on.
+	domain string
+}
+
+func (b *apiBackend) shorten(ctx context.Context, req client.ShortenRequest) (*client.Link, error) {
+	req.Domain = b.domain
+	return b.client.Shorten(ctx, req)
+}
+
+func (b *apiBackend) resolve(ctx context.Context, short string) (*client.Link, error) {
+	return b.client.Resolve(ctx, short)
+}
+
+func (b *apiBackend) delete(ctx context.Context, short string) error {
+	return b.client.Delete(ctx, short)
+}
+
+func (b *apiBackend) stats(ctx context.Context, short string) (*client.ClickStats, error) {
+	return b.client.Stats(ctx, short)
+}
+
+func (b *apiBackend) list(ctx context.Context, opts client.ListOptions) (*client.Page, error) {
+	return b.client.List(ctx, opts)
+}
+
+// storeBackend manages links of the namespace in Redis. Links are saved as is: hooks of the server such as webhooks
+// and fetching Open Graph metadata are not run.
+type storeBackend struct {
+	store *store.Storage
+	ns    store.Namespace
+	// baseURL is the scheme and host short URLs of the default namespace are built on, they are omitted if empty.
+	baseURL string
+}
+
+func (b *storeBackend) shorten(_ context.Context, req client.ShortenRequest) (*client.Link, error) {
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errInvalidURL
+	}
+
+	var meta store.Meta
+	if err := convert(req.Meta, &meta); err != nil {
+		return nil, err
+	}
+	if meta.NotBefore, err = parseTime(req.NotBefore, meta.NotBefore); err != nil {
+		return nil, err
+	}
+	if meta.NotAfter, err = parseTime(req.NotAfter, meta.NotAfter); err != nil {
+		return nil, err
+	}
+	if req.Password != "" {
+		if meta.PasswordHash, err = store.HashPassword(req.Password); err != nil {
+			return nil, err
+		}
+	}
+
+	short, _, err := b.store.Shorter(b.ns, []byte(req.URL), meta)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.link(string(short))
+}
+
+func (b *storeBackend) resolve(_ context.Context, short string) (*client.Link, error) {
+	return b.link(short)
+}
+
+func (b *storeBackend) delete(_ context.Context, short string) error {
+	return notFound(b.store.Delete(b.ns, []byte(short)))
+}
+
+func (b *storeBackend) stats(_ context.Context, short string) (*client.ClickStats, error) {
+	stats, err := b.store.ClickStats(b.ns, []byte(short))
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	var converted client.ClickStats
+	if err := convert(stats, &converted); err != nil {
+		return nil, err
+	}
+
+	return &converted, nil
+}
+
+func (b *storeBackend) list(_ context.Context, opts client.ListOptions) (*client.Page, error) {
+	links, cursor, err := b.store.Search(b.ns, store.Query{
+		Creator: opts.Creator,
+		Tag:     opts.Tag,
+		Domain:  opts.Domain,
+		Search:  opts.Search,
+		Cursor:  opts.Cursor,
+		Count:   opts.Count,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	page := client.Page{Cursor: cursor}
+	if err := convert(links, &page.Links); err != nil {
+		return nil, err
+	}
+
+	return &page, nil
+}
+
+// parseTime parses the RFC 3339 time of the request, def is returned if it is empty.
+func parseTime(v string, def *time.Time) (*time.Time, error) {
+	if v == "" {
+		return def, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, errInvalidTime
+	}
+
+	return &t, nil
+}
+
+// link returns the saved link with its short URL.
+func (b *storeBackend) link(short string) (*client.Link, error) {
+	l, err := b.store.Link(b.ns, []byte(short))
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	shortURL, err := b.shortURL(short)
+	if err != nil {
+		return nil, err
+	}
+
+	converted := client.Link{ShortURL: shortURL}
+	if err := convert(l, &converted); err != nil {
+		return nil, err
+	}
+
+	return &converted, nil
+}
+
+// convert copies the value of a type of the store into the matching type of the client, which has the same JSON.
+func convert(from, to interface{}) error {
+	raw, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(raw, to)
+}
+
+// shortURL builds the short URL of the alias on the host of the namespace like the server does.
+func (b *storeBackend) shortURL(short string) (string, error) {
+	scheme := "https"
+	if i := strings.Index(b.baseURL, "://"); i > 0 {
+		scheme = b.baseURL[:i]
+	}
+
+	host := b.ns.Domain
+	if host == "" && b.ns.Tenant != "" {
+		t, err := b.store.Tenant(b.ns.Tenant)
+		if err != nil {
+			return "", err
+		}
+		host = t.DefaultHost
+	}
+
+	switch {
+	case host != "":
+		return scheme + "://" + host + "/" + short, nil
+	case b.baseURL != "":
+		return b.baseURL + "/" + short, nil
+	default:
+		return "", nil
+	}
+}
+
+// notFound replaces the error of Redis about a missing link with the one the API returns.
+func notFound(err error) error {
+	if err == redis.ErrNil {
+		return &client.Error{StatusCode: http.StatusNotFound, Message: "the requested short code not found"}
+	}
+
+	return err
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yexelm/shorty/client"
+	"github.com/yexelm/shorty/store"
+)
+
+// exportPageSize is the number of links loaded at once while exporting, which is the largest page of the API.
+const exportPageSize = 1000
+
+func (c *cli) shorten(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	title := fs.String("title", "", "title of the links")
+	tags := fs.String("tags", "", "comma separated tags of the links")
+	notes := fs.String("notes", "", "notes about the links")
+	password := fs.String("password", "", "password protecting the links")
+	maxClicks := fs.Int("max-clicks", 0, "number of resolutions of the links, zero means no limit")
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+
+	meta := client.Meta{Title: *title, Notes: *notes, MaxClicks: *maxClicks}
+	if *tags != "" {
+		meta.Tags = strings.Split(*tags, ",")
+	}
+
+	links := make([]client.Link, 0, fs.NArg())
+	for _, u := range fs.Args() {
+		l, err := c.backend.shorten(ctx, client.ShortenRequest{URL: u, Password: *password, Meta: meta})
+		if err != nil {
+			return fmt.Errorf("failed to shorten %v: %w", u, err)
+		}
+		links = append(links, *l)
+	}
+
+	return c.out.links(links)
+}
+
+func (c *cli) resolve(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+
+	links := make([]client.Link, 0, fs.NArg())
+	for _, short := range fs.Args() {
+		l, err := c.backend.resolve(ctx, short)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %v: %w", short, err)
+		}
+		links = append(links, *l)
+	}
+
+	return c.out.links(links)
+}
+
+func (c *cli) delete(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+
+	for _, short := range fs.Args() {
+		if err := c.backend.delete(ctx, short); err != nil {
+			return fmt.Errorf("failed to delete %v: %w", short, err)
+		}
+	}
+
+	deleted := fs.Args()
+	return c.out.print(map[string][]string{"deleted": deleted}, func() table {
+		t := table{header: []string{"DELETED"}}
+		for _, short := range deleted {
+			t.rows = append(t.rows, []string{short})
+		}
+		return t
+	})
+}
+
+func (c *cli) list(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	var opts client.ListOptions
+	fs.StringVar(&opts.Creator, "creator", "", "owner of the API key the links were created with")
+	fs.StringVar(&opts.Tag, "tag", "", "tag of the links")
+	fs.StringVar(&opts.Domain, "domain", "", "domain of the original URLs")
+	fs.StringVar(&opts.Search, "q", "", "substring of the original URLs")
+	fs.StringVar(&opts.Cursor, "cursor", "", "cursor printed with the previous page")
+	fs.IntVar(&opts.Count, "count", 50, "number of links per page")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	page, err := c.backend.list(ctx, opts)
+	if err != nil {
+		return err
+	}
+	if c.out.format == formatJSON {
+		return c.out.print(page, nil)
+	}
+
+	if err := c.out.links(page.Links); err != nil {
+		return err
+	}
+	if page.Cursor != "" {
+		fmt.Fprintf(c.out.w, "\nmore links: shortyctl list -cursor %v\n", page.Cursor)
+	}
+
+	return nil
+}
+
+func (c *cli) stats(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+
+	stats := make([]*client.ClickStats, 0, fs.NArg())
+	for _, short := range fs.Args() {
+		s, err := c.backend.stats(ctx, short)
+		if err != nil {
+			return fmt.Errorf("failed to load stats of %v: %w", short, err)
+		}
+		stats = append(stats, s)
+	}
+
+	return c.out.stats(fs.Args(), stats)
+}
+
+// export writes all links as newline delimited JSON in the format of the export of the admin API, which import
+// reads back.
+func (c *cli) export(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	file := fs.String("file", "", "file the links are written to instead of stdout")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	w := c.out.w
+	if *file != "" {
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	opts := client.ListOptions{Count: exportPageSize}
+	for {
+		page, err := c.backend.list(ctx, opts)
+		if err != nil {
+			return err
+		}
+		for _, l := range page.Links {
+			if err := enc.Encode(l); err != nil {
+				return err
+			}
+		}
+
+		if page.Cursor == "" {
+			return bw.Flush()
+		}
+		opts.Cursor = page.Cursor
+	}
+}
+
+// imported is the result of importing a link.
+type imported struct {
+	Short string `json:"short"`
+	Long  string `json:"long"`
+	// New is the alias of the link after the import, which is a new one unless the URL had been shortened before.
+	New   string `json:"new,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// importLinks shortens the links written by export along with their details. Passwords are not exported, so
+// protected links are imported unprotected.
+func (c *cli) importLinks(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	file := fs.String("file", "", "file the links are read from instead of stdin")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	r := c.in
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var (
+		results []imported
+		failed  int
+	)
+	dec := json.NewDecoder(r)
+	for {
+		var l client.Link
+		err := dec.Decode(&l)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read link %v: %w", len(results)+1, err)
+		}
+
+		res := imported{Short: l.Short, Long: l.Long}
+		created, err := c.backend.shorten(ctx, importRequest(l))
+		if err != nil {
+			res.Error = err.Error()
+			failed++
+		} else {
+			res.New = created.Short
+		}
+		results = append(results, res)
+	}
+
+	if err := c.out.print(results, func() table {
+		t := table{header: []string{"SHORT", "NEW", "URL", "ERROR"}}
+		for _, r := range results {
+			t.rows = append(t.rows, []string{r.Short, dash(r.New), r.Long, dash(r.Error)})
+		}
+		return t
+	}); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%v of %v links failed to import", failed, len(results))
+	}
+
+	return nil
+}
+
+// importRequest returns the request creating the exported link with its details.
+func importRequest(l client.Link) client.ShortenRequest {
+	req := client.ShortenRequest{URL: l.Long, Meta: l.Meta}
+	// the time bounds are sent as strings, the metadata of the destination is fetched again
+	if l.NotBefore != nil {
+		req.NotBefore = l.NotBefore.Format(time.RFC3339)
+	}
+	if l.NotAfter != nil {
+		req.NotAfter = l.NotAfter.Format(time.RFC3339)
+	}
+	req.Meta.NotBefore, req.Meta.NotAfter, req.Meta.OpenGraph = nil, nil, nil
+
+	return req
+}
+
+func (c *cli) keys(_ context.Context, fs *flag.FlagSet, args []string) error {
+	if err := parse(fs, args, 2); err != nil {
+		return err
+	}
+	if c.store == nil {
+		return errStoreOnly
+	}
+
+	action, owner := fs.Arg(0), fs.Arg(1)
+	switch action {
+	case "create":
+		key, err := c.store.IssueKey(c.ns.Tenant, owner)
+		if err != nil {
+			return err
+		}
+		created := map[string]string{"tenant": c.ns.Tenant, "owner": owner, "key": key}
+		return c.out.print(created, func() table {
+			return table{header: []string{"TENANT", "OWNER", "KEY"}, rows: [][]string{{dash(c.ns.Tenant), owner, key}}}
+		})
+	case "revoke":
+		if err := c.store.RevokeKey(c.ns.Tenant, owner); err != nil {
+			return err
+		}
+		revoked := map[string]string{"tenant": c.ns.Tenant, "owner": owner}
+		return c.out.print(revoked, func() table {
+			return table{header: []string{"TENANT", "REVOKED"}, rows: [][]string{{dash(c.ns.Tenant), owner}}}
+		})
+	default:
+		return fmt.Errorf("keys: unknown action %q, expected create or revoke", action)
+	}
+}
+
+// integrity reports the problems of the matches between aliases and URLs saved in the namespace and repairs them if
+// asked, failing if any problem is left.
+func (c *cli) integrity(_ context.Context, fs *flag.FlagSet, args []string) error {
+	repair := fs.Bool("repair", false, "repair the problems which can be repaired")
+	dryRun := fs.Bool("dry-run", false, "only show the repairs")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if c.store == nil {
+		return errStoreOnly
+	}
+
+	apply := *repair && !*dryRun
+	r, err := c.store.CheckIntegrity(c.ns, apply)
+	if err != nil {
+		return err
+	}
+
+	if err := c.out.print(r, func() table {
+		t := table{header: []string{"PROBLEM", "SHORT", "URL", "OTHER", "FIX"}}
+		if apply {
+			t.header = append(t.header, "FIXED")
+		}
+		for _, p := range r.Problems {
+			row := []string{p.Kind, p.Short, p.Long, dash(p.Other), dash(p.Fix)}
+			if apply {
+				row = append(row, strconv.FormatBool(p.Fixed))
+			}
+			t.rows = append(t.rows, row)
+		}
+		return t
+	}); err != nil {
+		return err
+	}
+
+	left := 0
+	for _, p := range r.Problems {
+		if !p.Fixed {
+			left++
+		}
+	}
+	switch {
+	case left == 0:
+		return nil
+	case apply:
+		return fmt.Errorf("%v of %v problem(s) left, problems without a fix need manual repair", left,
+			len(r.Problems))
+	default:
+		return fmt.Errorf("found %v problem(s) in %v aliases and %v URLs", left, r.Aliases, r.URLs)
+	}
+}
+
+// migrate applies pending migrations of the stored data, reporting their progress to stderr, and shows the state of
+// all migrations.
+func (c *cli) migrate(_ context.Context, fs *flag.FlagSet, args []string) error {
+	status := fs.Bool("status", false, "only show the state of migrations")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if c.store == nil {
+		return errStoreOnly
+	}
+
+	if !*status {
+		err := c.store.Migrate(func(p store.MigrationProgress) {
+			fmt.Fprintf(c.errOut, "migration %v (%v): %v entries processed\n", p.Version, p.Description, p.Processed)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	migrations, err := c.store.Migrations()
+	if err != nil {
+		return err
+	}
+
+	return c.out.print(migrations, func() table {
+		t := table{header: []string{"VERSION", "DESCRIPTION", "STATUS", "PROCESSED"}}
+		for _, m := range migrations {
+			state := "pending"
+			if m.Applied {
+				state = "applied"
+			}
+			t.rows = append(t.rows, []string{strconv.Itoa(m.Version), m.Description, state, strconv.Itoa(m.Processed)})
+		}
+		return t
+	})
+}
@@ -0,0 +1,172 @@
+// Command shortyctl manages links of shorty either through the JSON API or directly in Redis.
+//
+//	shortyctl -api https://short.ly -key $KEY shorten https://go.dev
+//	shortyctl -redis localhost:6379 -tenant acme list -tag docs
+//	shortyctl -o json resolve b
+//
+// The API is used when its URL is given by -api or SHORTY_API_URL, Redis otherwise. Managing API keys, checking
+// integrity and applying migrations are only available with direct access to Redis.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/yexelm/shorty/client"
+	"github.com/yexelm/shorty/config"
+	"github.com/yexelm/shorty/store"
+)
+
+// Exit codes of the command.
+const (
+	exitOK = iota
+	exitFailure
+	exitUsage
+)
+
+// errStoreOnly is returned by commands which need direct access to Redis when the API is used.
+var errStoreOnly = errors.New("the command requires direct access to Redis, run it without -api")
+
+const usage = `Usage: shortyctl [flags] <command> [arguments]
+
+Commands:
+  shorten [-title T] [-tags a,b] [-notes N] [-password P] [-max-clicks N] URL...
+  resolve SHORT...                  show links without counting clicks
+  delete SHORT...
+  list [-creator C] [-tag T] [-domain D] [-q S] [-count N] [-cursor C]
+  stats SHORT...
+  export [-file F]                  write all links as newline delimited JSON
+  import [-file F]                  shorten links written by export
+  keys create|revoke OWNER          manage API keys of the tenant
+  integrity [-repair] [-dry-run]    check and repair matches between aliases and URLs
+  migrate [-status]                 apply pending migrations of the stored data
+
+Flags:
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	os.Exit(run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// cli is the parsed command line along with the backend the command runs against.
+type cli struct {
+	backend backend
+	out     *output
+	in      io.Reader
+	// errOut receives progress of long running commands.
+	errOut io.Writer
+	// store is nil when the API is used.
+	store *store.Storage
+	ns    store.Namespace
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	cfg := config.New()
+
+	fs := flag.NewFlagSet("shortyctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+	apiURL := fs.String("api", os.Getenv("SHORTY_API_URL"), "base URL of the API, Redis is used directly if empty")
+	apiKey := fs.String("key", os.Getenv("SHORTY_API_KEY"), "API key")
+	redisURL := fs.String("redis", cfg.RedisURL, "address of Redis")
+	db := fs.Int("db", cfg.DbNum, "number of the Redis database")
+	prefix := fs.String("prefix", cfg.KeyPrefix, "prefix of the keys of the deployment in Redis")
+	tenant := fs.String("tenant", "", "tenant whose default host is managed in Redis")
+	domain := fs.String("domain", "", "host whose links are managed, one of the hosts of the tenant of the API key")
+	format := fs.String("o", formatTable, "output format, table or json")
+	verbose := fs.Bool("v", false, "log details to stderr")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() == 0 || (*format != formatTable && *format != formatJSON) {
+		fs.Usage()
+		return exitUsage
+	}
+	if !*verbose {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	c := &cli{out: &output{w: stdout, format: *format}, in: stdin, errOut: stderr}
+	if *apiURL != "" {
+		c.backend = &apiBackend{client: client.New(*apiURL, *apiKey), domain: *domain}
+	} else {
+		st, err := store.New(*redisURL, *db, *prefix)
+		if err != nil {
+			fmt.Fprintf(stderr, "shortyctl: failed to connect to Redis: %v\n", err)
+			return exitFailure
+		}
+		defer st.Close()
+
+		c.ns = store.Namespace{Tenant: *tenant}
+		if *domain != "" {
+			if c.ns, err = st.NamespaceByHost(*domain); err != nil {
+				fmt.Fprintf(stderr, "shortyctl: %v\n", err)
+				return exitFailure
+			}
+			if c.ns.Tenant != *tenant {
+				fmt.Fprintf(stderr, "shortyctl: %v is not a host of the tenant %q\n", *domain, *tenant)
+				return exitFailure
+			}
+		}
+		c.store = st
+		c.backend = &storeBackend{store: st, ns: c.ns, baseURL: cfg.PublicBaseURL}
+	}
+
+	if err := c.run(ctx, fs.Arg(0), fs.Args()[1:]); err != nil {
+		fmt.Fprintf(stderr, "shortyctl: %v\n", err)
+		return exitFailure
+	}
+
+	return exitOK
+}
+
+func (c *cli) run(ctx context.Context, cmd string, args []string) error {
+	commands := map[string]func(context.Context, *flag.FlagSet, []string) error{
+		"shorten":   c.shorten,
+		"resolve":   c.resolve,
+		"delete":    c.delete,
+		"list":      c.list,
+		"stats":     c.stats,
+		"export":    c.export,
+		"import":    c.importLinks,
+		"keys":      c.keys,
+		"integrity": c.integrity,
+		"migrate":   c.migrate,
+	}
+
+	fn, ok := commands[cmd]
+	if !ok {
+		return fmt.Errorf("unknown command %q, run shortyctl -h for the list of commands", cmd)
+	}
+
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+
+	return fn(ctx, fs, args)
+}
+
+// parse parses the arguments of the command, requiring at least min positional ones.
+func parse(fs *flag.FlagSet, args []string, min int) error {
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%v: %v", fs.Name(), err)
+	}
+	if fs.NArg() < min {
+		return fmt.Errorf("%v: expected at least %v argument(s), run shortyctl -h for usage", fs.Name(), min)
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yexelm/shorty/client"
+	"github.com/yexelm/shorty/store"
+)
+
+// shortyctl runs the command against Redis and returns its exit code along with its stdout and stderr.
+func shortyctl(addr, stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), append([]string{"-redis", addr}, args...), strings.NewReader(stdin), &stdout,
+		&stderr)
+
+	return code, stdout.String(), stderr.String()
+}
+
+func Test_run(t *testing.T) {
+	ao := assert.New(t)
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	code, stdout, stderr := shortyctl(s.Addr(), "", "-o", "json", "shorten", "-tags", "Go,docs",
+		"https://go.dev", "https://go.dev/doc")
+	ao.Equal(exitOK, code, stderr)
+	var links []client.Link
+	ao.NoError(json.Unmarshal([]byte(stdout), &links))
+	ao.Len(links, 2)
+	ao.Equal("b", links[0].Short)
+	ao.Equal([]string{"go", "docs"}, links[1].Tags)
+
+	code, stdout, _ = shortyctl(s.Addr(), "", "resolve", "c")
+	ao.Equal(exitOK, code)
+	ao.Contains(stdout, "SHORT")
+	ao.Contains(stdout, "https://go.dev/doc")
+
+	code, _, stderr = shortyctl(s.Addr(), "", "resolve", "z")
+	ao.Equal(exitFailure, code)
+	ao.Equal("shortyctl: failed to resolve z: shorty: 404 the requested short code not found\n", stderr)
+
+	code, stdout, _ = shortyctl(s.Addr(), "", "-o", "json", "stats", "b")
+	ao.Equal(exitOK, code)
+	ao.JSONEq(`[{"short": "b", "clicks": 0}]`, stdout)
+
+	code, stdout, _ = shortyctl(s.Addr(), "", "list", "-count", "1")
+	ao.Equal(exitOK, code)
+	ao.Contains(stdout, "https://go.dev/doc")
+	ao.Contains(stdout, "more links: shortyctl list -cursor c")
+
+	code, export, _ := shortyctl(s.Addr(), "", "export")
+	ao.Equal(exitOK, code)
+	ao.Equal(2, strings.Count(export, "\n"))
+
+	code, _, _ = shortyctl(s.Addr(), "", "delete", "b")
+	ao.Equal(exitOK, code)
+
+	code, stdout, _ = shortyctl(s.Addr(), export, "-o", "json", "import")
+	ao.Equal(exitOK, code)
+	var results []imported
+	ao.NoError(json.Unmarshal([]byte(stdout), &results))
+	ao.ElementsMatch([]imported{
+		{Short: "c", Long: "https://go.dev/doc", New: "c"},
+		{Short: "b", Long: "https://go.dev", New: "d"},
+	}, results)
+
+	code, stdout, _ = shortyctl(s.Addr(), "", "-o", "json", "keys", "create", "ops")
+	ao.Equal(exitOK, code)
+	var created map[string]string
+	ao.NoError(json.Unmarshal([]byte(stdout), &created))
+	ao.Len(created["key"], 64)
+
+	code, stdout, _ = shortyctl(s.Addr(), "", "integrity")
+	ao.Equal(exitOK, code)
+	ao.Equal("PROBLEM  SHORT  URL  OTHER  FIX\n", stdout)
+
+	s.HDel("longToShort", "https://go.dev")
+	code, stdout, stderr = shortyctl(s.Addr(), "", "-o", "json", "integrity")
+	ao.Equal(exitFailure, code)
+	var r store.IntegrityReport
+	ao.NoError(json.Unmarshal([]byte(stdout), &r))
+	problem := store.Problem{Kind: store.ProblemOrphanAlias, Short: "d", Long: "https://go.dev", Fix: store.FixLinkURL}
+	ao.Equal([]store.Problem{problem}, r.Problems)
+	ao.Equal("shortyctl: found 1 problem(s) in 2 aliases and 1 URLs\n", stderr)
+
+	code, stdout, _ = shortyctl(s.Addr(), "", "integrity", "-repair", "-dry-run")
+	ao.Equal(exitFailure, code)
+	ao.Contains(stdout, "link_url")
+	ao.NotContains(stdout, "FIXED")
+
+	code, stdout, stderr = shortyctl(s.Addr(), "", "integrity", "-repair")
+	ao.Equal(exitOK, code, stderr)
+	ao.Contains(stdout, "true")
+
+	code, _, _ = shortyctl(s.Addr(), "", "integrity")
+	ao.Equal(exitOK, code)
+
+	code, stdout, _ = shortyctl(s.Addr(), "", "migrate", "-status")
+	ao.Equal(exitOK, code)
+	ao.Contains(stdout, "pending")
+
+	code, stdout, stderr = shortyctl(s.Addr(), "", "-o", "json", "migrate")
+	ao.Equal(exitOK, code)
+	ao.Contains(stderr, "migration 1 (index links saved before secondary indexes)")
+	var migrations []store.MigrationProgress
+	ao.NoError(json.Unmarshal([]byte(stdout), &migrations))
+	ao.True(migrations[0].Applied)
+
+	code, _, stderr = shortyctl(s.Addr(), "", "unknown")
+	ao.Equal(exitFailure, code)
+	ao.Contains(stderr, `unknown command "unknown"`)
+}
+
+func Test_runAPIOnly(t *testing.T) {
+	ao := assert.New(t)
+
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), []string{"-api", "http://localhost:1", "integrity"}, nil, &stdout, &stderr)
+	ao.Equal(exitFailure, code)
+	ao.Equal("shortyctl: "+errStoreOnly.Error()+"\n", stderr.String())
+
+	code = run(context.Background(), []string{"-o", "yaml", "list"}, nil, &stdout, &stderr)
+	ao.Equal(exitUsage, code)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yexelm/shorty/client"
+)
+
+// Output formats.
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
+// output prints results of commands either as aligned tables or as indented JSON.
+type output struct {
+	w      io.Writer
+	format string
+}
+
+// table is a result of a command printed as a table unless JSON is requested.
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+// print writes v as JSON or the table built by it.
+func (o *output) print(v interface{}, build func() table) error {
+	if o.format == formatJSON {
+		enc := json.NewEncoder(o.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	t := build()
+	tw := tabwriter.NewWriter(o.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(t.header, "\t"))
+	for _, row := range t.rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+func (o *output) links(links []client.Link) error {
+	return o.print(links, func() table {
+		t := table{header: []string{"SHORT", "SHORT URL", "URL", "CREATED", "CREATOR", "TAGS", "STATUS"}}
+		for _, l := range links {
+			t.rows = append(t.rows, []string{
+				l.Short, dash(l.ShortURL), l.Long, l.CreatedAt.Format(time.RFC3339), dash(l.Creator),
+				dash(strings.Join(l.Tags, ",")), status(l),
+			})
+		}
+		return t
+	})
+}
+
+func (o *output) stats(shorts []string, stats []*client.ClickStats) error {
+	type result struct {
+		Short string `json:"short"`
+		*client.ClickStats
+	}
+
+	results := make([]result, len(stats))
+	for i := range stats {
+		results[i] = result{Short: shorts[i], ClickStats: stats[i]}
+	}
+
+	return o.print(results, func() table {
+		t := table{header: []string{"SHORT", "CLICKS", "LEFT", "COUNTRIES", "VARIANTS"}}
+		for _, r := range results {
+			left := "-"
+			if r.ClicksLeft != nil {
+				left = strconv.Itoa(*r.ClicksLeft)
+			}
+			t.rows = append(t.rows, []string{
+				r.Short, strconv.Itoa(r.Clicks), left, counts(r.Countries), counts(r.Variants),
+			})
+		}
+		return t
+	})
+}
+
+// status describes the state of the link the way operators look for it.
+func status(l client.Link) string {
+	var s []string
+	if l.Disabled {
+		s = append(s, "disabled")
+	}
+	if l.Protected {
+		s = append(s, "protected")
+	}
+	if !l.ActiveAt(time.Now()) {
+		s = append(s, "inactive")
+	}
+	if len(s) == 0 {
+		return "active"
+	}
+
+	return strings.Join(s, ",")
+}
+
+// counts formats counters ordered by their names as name=count pairs.
+func counts(m map[string]int) string {
+	if len(m) == 0 {
+		return "-"
+	}
+
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + strconv.Itoa(m[name])
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// dash replaces empty cells so that columns stay aligned.
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
@@ -1,8 +1,12 @@
 package config
 
 import (
+	"fmt"
+	"net"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 const (
@@ -10,6 +14,60 @@ const (
 	hostPort, defaultHostPort           = "HOST_PORT", 8080
 	containerPort, defaultContainerPort = "CONTAINER_PORT", 8080
 	dbNum, defaultDbNum                 = "DB_NUM", 0
+	keyPrefix, defaultKeyPrefix         = "KEY_PREFIX", ""
+	metricsAddr, defaultMetricsAddr     = "METRICS_ADDR", ":8081"
+
+	shutdownTimeout, defaultShutdownTimeout = "SHUTDOWN_TIMEOUT", 15 * time.Second
+	migrateOnStart, defaultMigrateOnStart   = "MIGRATE_ON_START", true
+
+	tlsCertFile, defaultTLSCertFile           = "TLS_CERT_FILE", ""
+	tlsKeyFile, defaultTLSKeyFile             = "TLS_KEY_FILE", ""
+	tlsClientCAFile, defaultTLSClientCAFile   = "TLS_CLIENT_CA_FILE", ""
+	httpRedirectPort, defaultHTTPRedirectPort = "HTTP_REDIRECT_PORT", 0
+
+	adminAddr, defaultAdminAddr                       = "ADMIN_ADDR", "127.0.0.1:8082"
+	adminToken, defaultAdminToken                     = "ADMIN_TOKEN", ""
+	adminTLSClientCAFile, defaultAdminTLSClientCAFile = "ADMIN_TLS_CLIENT_CA_FILE", ""
+	requireAPIKey, defaultRequireAPIKey               = "REQUIRE_API_KEY", false
+	rateLimit, defaultRateLimit                       = "RATE_LIMIT", 0
+	rateLimitBurst, defaultRateLimitBurst             = "RATE_LIMIT_BURST", 20
+	grpcAddr, defaultGRPCAddr                         = "GRPC_ADDR", ":8083"
+
+	publicBaseURL, defaultPublicBaseURL   = "PUBLIC_BASE_URL", ""
+	trustedProxies, defaultTrustedProxies = "TRUSTED_PROXIES", ""
+
+	cookieSecret, defaultCookieSecret               = "COOKIE_SECRET", ""
+	unlockTTL, defaultUnlockTTL                     = "UNLOCK_TTL", 15 * time.Minute
+	passwordMaxAttempts, defaultPasswordMaxAttempts = "PASSWORD_MAX_ATTEMPTS", 5
+	passwordLockout, defaultPasswordLockout         = "PASSWORD_LOCKOUT", 15 * time.Minute
+
+	inactivePageFile, defaultInactivePageFile     = "INACTIVE_PAGE_FILE", ""
+	geoIPFile, defaultGeoIPFile                   = "GEOIP_DB_FILE", ""
+	variantTTL, defaultVariantTTL                 = "VARIANT_TTL", 30 * 24 * time.Hour
+	utmDefaults, defaultUTMDefaults               = "UTM_DEFAULTS", ""
+	previewPageFile, defaultPreviewPageFile       = "PREVIEW_PAGE_FILE", ""
+	unfurl, defaultUnfurl                         = "UNFURL", false
+	unfurlWorkers, defaultUnfurlWorkers           = "UNFURL_WORKERS", 2
+	unfurlTimeout, defaultUnfurlTimeout           = "UNFURL_TIMEOUT", 5 * time.Second
+	unfurlMaxBytes, defaultUnfurlMaxBytes         = "UNFURL_MAX_BYTES", 1 << 20
+	webhookWorkers, defaultWebhookWorkers         = "WEBHOOK_WORKERS", 8
+	webhookTimeout, defaultWebhookTimeout         = "WEBHOOK_TIMEOUT", 10 * time.Second
+	webhookMaxAttempts, defaultWebhookMaxAttempts = "WEBHOOK_MAX_ATTEMPTS", 8
+	webhookBackoff, defaultWebhookBackoff         = "WEBHOOK_BACKOFF", 30 * time.Second
+	webhookMaxBackoff, defaultWebhookMaxBackoff   = "WEBHOOK_MAX_BACKOFF", time.Hour
+
+	clickSink, defaultClickSink                         = "CLICK_SINK", ""
+	clickSinkFile, defaultClickSinkFile                 = "CLICK_SINK_FILE", "clicks.ndjson"
+	clickSinkFileMaxBytes, defaultClickSinkFileMaxBytes = "CLICK_SINK_FILE_MAX_BYTES", 100 << 20
+	clickSinkFileMaxFiles, defaultClickSinkFileMaxFiles = "CLICK_SINK_FILE_MAX_FILES", 5
+	clickSinkStream, defaultClickSinkStream             = "CLICK_SINK_STREAM", "clicks"
+	clickSinkStreamMaxLen, defaultClickSinkStreamMaxLen = "CLICK_SINK_STREAM_MAXLEN", 1000000
+	clickSinkKafkaBrokers, defaultClickSinkKafkaBrokers = "CLICK_SINK_KAFKA_BROKERS", "kafka:9092"
+	clickSinkKafkaTopic, defaultClickSinkKafkaTopic     = "CLICK_SINK_KAFKA_TOPIC", "clicks"
+	clickBuffer, defaultClickBuffer                     = "CLICK_BUFFER", 10000
+	clickBatch, defaultClickBatch                       = "CLICK_BATCH", 100
+	clickFlushInterval, defaultClickFlushInterval       = "CLICK_FLUSH_INTERVAL", time.Second
+	clickWriteTimeout, defaultClickWriteTimeout         = "CLICK_WRITE_TIMEOUT", 5 * time.Second
 )
 
 // Config contains app configuration
@@ -18,6 +76,96 @@ type Config struct {
 	HostPort      int
 	ContainerPort int
 	DbNum         int
+	MetricsAddr   string
+	// KeyPrefix is prepended to all keys in Redis, so that several deployments can share a database.
+	KeyPrefix string
+
+	// ShutdownTimeout limits the time given to in-flight requests to complete on shutdown.
+	ShutdownTimeout time.Duration
+	// MigrateOnStart applies pending migrations of the stored data on startup.
+	MigrateOnStart bool
+
+	// TLSCertFile and TLSKeyFile enable TLS termination when both are set.
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSClientCAFile enables mutual TLS, requiring clients to present a certificate signed by one of its CAs.
+	TLSClientCAFile string
+	// HTTPRedirectPort is the port of the plain HTTP listener redirecting to HTTPS, it is disabled when zero.
+	HTTPRedirectPort int
+
+	// AdminAddr is the address of the listener serving the management API.
+	AdminAddr string
+	// AdminToken, when set, must be passed by admin clients as a bearer token.
+	AdminToken string
+	// AdminTLSClientCAFile enables mutual TLS on the admin listener.
+	AdminTLSClientCAFile string
+	// RequireAPIKey makes an API key mandatory for shortening links.
+	RequireAPIKey bool
+	// RateLimit limits requests to the API of each API key, or of each address for requests without a key, per minute
+	// with bursts of up to RateLimitBurst requests. Requests are not limited if it is zero.
+	RateLimit      int
+	RateLimitBurst int
+	// GRPCAddr is the address of the listener serving the gRPC API.
+	GRPCAddr string
+
+	// PublicBaseURL is the scheme and host short URLs of the default namespace are built on instead of the host of
+	// the request.
+	PublicBaseURL string
+	// TrustedProxies are networks of reverse proxies whose X-Forwarded-* headers are honored.
+	TrustedProxies []*net.IPNet
+
+	// CookieSecret signs cookies of visitors who unlocked password protected links. A random secret is generated
+	// when it is empty, so unlocked links are prompted for again after a restart and on other instances.
+	CookieSecret string
+	// UnlockTTL is how long a password protected link stays unlocked for a visitor.
+	UnlockTTL time.Duration
+	// PasswordMaxAttempts is the number of wrong passwords after which a link is locked for PasswordLockout.
+	PasswordMaxAttempts int
+	PasswordLockout     time.Duration
+
+	// InactivePageFile is the html/template file served for links outside of their active time, plain text errors are
+	// served if it is empty.
+	InactivePageFile string
+	// GeoIPFile is the MaxMind database countries of visitors are looked up in, GeoIP is disabled if it is empty.
+	GeoIPFile string
+	// VariantTTL is how long visitors are kept on the variant of a rotating link they were assigned to.
+	VariantTTL time.Duration
+	// UTMDefaults are UTM parameters without the utm_ prefix added to destinations of all links, parameters of the
+	// links take precedence.
+	UTMDefaults map[string]string
+	// PreviewPageFile is the html/template file showing previews of links, a built-in page is used if it is empty.
+	PreviewPageFile string
+	// Unfurl enables fetching titles, descriptions and images of destinations of new links in the background.
+	Unfurl         bool
+	UnfurlWorkers  int
+	UnfurlTimeout  time.Duration
+	UnfurlMaxBytes int
+	// WebhookWorkers limits concurrent deliveries. WebhookTimeout limits a delivery attempt, failed deliveries are
+	// attempted WebhookMaxAttempts times in total with delays doubling from WebhookBackoff up to WebhookMaxBackoff.
+	WebhookWorkers     int
+	WebhookTimeout     time.Duration
+	WebhookMaxAttempts int
+	WebhookBackoff     time.Duration
+	WebhookMaxBackoff  time.Duration
+
+	// ClickSink selects where click events are published: "file", "redis" or "kafka". Publishing is disabled if it
+	// is empty.
+	ClickSink string
+	// ClickSinkFile is rotated after reaching ClickSinkFileMaxBytes, keeping ClickSinkFileMaxFiles rotated files.
+	ClickSinkFile         string
+	ClickSinkFileMaxBytes int
+	ClickSinkFileMaxFiles int
+	// ClickSinkStream is trimmed to about ClickSinkStreamMaxLen entries.
+	ClickSinkStream       string
+	ClickSinkStreamMaxLen int
+	ClickSinkKafkaBrokers []string
+	ClickSinkKafkaTopic   string
+	// ClickBuffer limits events waiting to be written, more are dropped. Events are written in batches of up to
+	// ClickBatch events at least every ClickFlushInterval, writes failing after ClickWriteTimeout.
+	ClickBuffer        int
+	ClickBatch         int
+	ClickFlushInterval time.Duration
+	ClickWriteTimeout  time.Duration
 }
 
 // New returns a new instance of Config
@@ -28,10 +176,68 @@ func New() *Config {
 	c.HostPort = setIntField(hostPort, defaultHostPort)
 	c.ContainerPort = setIntField(containerPort, defaultContainerPort)
 	c.DbNum = setIntField(dbNum, defaultDbNum)
+	c.KeyPrefix = setStringField(keyPrefix, defaultKeyPrefix)
+	c.MetricsAddr = setStringField(metricsAddr, defaultMetricsAddr)
+	c.ShutdownTimeout = setDurationField(shutdownTimeout, defaultShutdownTimeout)
+	c.MigrateOnStart = setBoolField(migrateOnStart, defaultMigrateOnStart)
+
+	c.TLSCertFile = setStringField(tlsCertFile, defaultTLSCertFile)
+	c.TLSKeyFile = setStringField(tlsKeyFile, defaultTLSKeyFile)
+	c.TLSClientCAFile = setStringField(tlsClientCAFile, defaultTLSClientCAFile)
+	c.HTTPRedirectPort = setIntField(httpRedirectPort, defaultHTTPRedirectPort)
+
+	c.AdminAddr = setStringField(adminAddr, defaultAdminAddr)
+	c.AdminToken = setStringField(adminToken, defaultAdminToken)
+	c.AdminTLSClientCAFile = setStringField(adminTLSClientCAFile, defaultAdminTLSClientCAFile)
+	c.RequireAPIKey = setBoolField(requireAPIKey, defaultRequireAPIKey)
+	c.RateLimit = setIntField(rateLimit, defaultRateLimit)
+	c.RateLimitBurst = setIntField(rateLimitBurst, defaultRateLimitBurst)
+	c.GRPCAddr = setStringField(grpcAddr, defaultGRPCAddr)
+
+	c.PublicBaseURL = strings.TrimSuffix(setStringField(publicBaseURL, defaultPublicBaseURL), "/")
+	c.TrustedProxies = setCIDRsField(trustedProxies, defaultTrustedProxies)
+
+	c.CookieSecret = setStringField(cookieSecret, defaultCookieSecret)
+	c.UnlockTTL = setDurationField(unlockTTL, defaultUnlockTTL)
+	c.PasswordMaxAttempts = setIntField(passwordMaxAttempts, defaultPasswordMaxAttempts)
+	c.PasswordLockout = setDurationField(passwordLockout, defaultPasswordLockout)
+
+	c.InactivePageFile = setStringField(inactivePageFile, defaultInactivePageFile)
+	c.GeoIPFile = setStringField(geoIPFile, defaultGeoIPFile)
+	c.VariantTTL = setDurationField(variantTTL, defaultVariantTTL)
+	c.UTMDefaults = setPairsField(utmDefaults, defaultUTMDefaults)
+	c.PreviewPageFile = setStringField(previewPageFile, defaultPreviewPageFile)
+	c.Unfurl = setBoolField(unfurl, defaultUnfurl)
+	c.UnfurlWorkers = setIntField(unfurlWorkers, defaultUnfurlWorkers)
+	c.UnfurlTimeout = setDurationField(unfurlTimeout, defaultUnfurlTimeout)
+	c.UnfurlMaxBytes = setIntField(unfurlMaxBytes, defaultUnfurlMaxBytes)
+	c.WebhookWorkers = setIntField(webhookWorkers, defaultWebhookWorkers)
+	c.WebhookTimeout = setDurationField(webhookTimeout, defaultWebhookTimeout)
+	c.WebhookMaxAttempts = setIntField(webhookMaxAttempts, defaultWebhookMaxAttempts)
+	c.WebhookBackoff = setDurationField(webhookBackoff, defaultWebhookBackoff)
+	c.WebhookMaxBackoff = setDurationField(webhookMaxBackoff, defaultWebhookMaxBackoff)
+
+	c.ClickSink = setStringField(clickSink, defaultClickSink)
+	c.ClickSinkFile = setStringField(clickSinkFile, defaultClickSinkFile)
+	c.ClickSinkFileMaxBytes = setIntField(clickSinkFileMaxBytes, defaultClickSinkFileMaxBytes)
+	c.ClickSinkFileMaxFiles = setIntField(clickSinkFileMaxFiles, defaultClickSinkFileMaxFiles)
+	c.ClickSinkStream = setStringField(clickSinkStream, defaultClickSinkStream)
+	c.ClickSinkStreamMaxLen = setIntField(clickSinkStreamMaxLen, defaultClickSinkStreamMaxLen)
+	c.ClickSinkKafkaBrokers = setListField(clickSinkKafkaBrokers, defaultClickSinkKafkaBrokers)
+	c.ClickSinkKafkaTopic = setStringField(clickSinkKafkaTopic, defaultClickSinkKafkaTopic)
+	c.ClickBuffer = setIntField(clickBuffer, defaultClickBuffer)
+	c.ClickBatch = setIntField(clickBatch, defaultClickBatch)
+	c.ClickFlushInterval = setDurationField(clickFlushInterval, defaultClickFlushInterval)
+	c.ClickWriteTimeout = setDurationField(clickWriteTimeout, defaultClickWriteTimeout)
 
 	return &c
 }
 
+// TLSEnabled reports whether the application serves HTTPS.
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
 func setIntField(key string, defaultValue int) int {
 	v, ok := os.LookupEnv(key)
 	if !ok {
@@ -46,6 +252,75 @@ func setIntField(key string, defaultValue int) int {
 	return intV
 }
 
+func setBoolField(key string, defaultValue bool) bool {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return defaultValue
+	}
+
+	return b
+}
+
+// setDurationField parses a positive duration, the default value is returned for zero and negative ones since all
+// durations limit or schedule work, e.g. drive tickers which panic otherwise.
+func setDurationField(key string, defaultValue time.Duration) time.Duration {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return defaultValue
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return defaultValue
+	}
+
+	return d
+}
+
+// setCIDRsField parses a comma separated list of networks in CIDR notation, single IP addresses are also accepted.
+func setCIDRsField(key, defaultValue string) []*net.IPNet {
+	if v, ok := os.LookupEnv(key); ok {
+		if nets, err := parseCIDRs(v); err == nil {
+			return nets
+		}
+	}
+
+	nets, _ := parseCIDRs(defaultValue)
+	return nets
+}
+
+func parseCIDRs(v string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range strings.Split(v, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		if ip := net.ParseIP(s); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
 func setStringField(key, defaultValue string) string {
 	v, ok := os.LookupEnv(key)
 	if !ok {
@@ -54,3 +329,52 @@ func setStringField(key, defaultValue string) string {
 
 	return v
 }
+
+// setListField parses a comma separated list of values.
+func setListField(key, defaultValue string) []string {
+	return parseList(setStringField(key, defaultValue))
+}
+
+func parseList(v string) []string {
+	var list []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+
+	return list
+}
+
+// setPairsField parses a comma separated list of key=value pairs, the default value is used if any pair is malformed.
+func setPairsField(key, defaultValue string) map[string]string {
+	if v, ok := os.LookupEnv(key); ok {
+		if pairs, err := parsePairs(v); err == nil {
+			return pairs
+		}
+	}
+
+	pairs, _ := parsePairs(defaultValue)
+	return pairs
+}
+
+func parsePairs(v string) (map[string]string, error) {
+	var pairs map[string]string
+	for _, s := range strings.Split(v, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		i := strings.IndexByte(s, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid pair %q", s)
+		}
+		if pairs == nil {
+			pairs = make(map[string]string)
+		}
+		pairs[strings.TrimSpace(s[:i])] = strings.TrimSpace(s[i+1:])
+	}
+
+	return pairs, nil
+}
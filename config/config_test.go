@@ -1,8 +1,10 @@
 package config
 
 import (
+	"net"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -82,6 +84,188 @@ func Test_setIntField(t *testing.T) {
 	}
 }
 
+func Test_setBoolField(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	os.Setenv("bool", "true")
+	os.Setenv("bad_bool", "yes please")
+
+	type testData struct {
+		tCase        string
+		key          string
+		defaultValue bool
+		expected     bool
+	}
+
+	testTable := []testData{
+		{
+			tCase:        "success",
+			key:          "bool",
+			defaultValue: false,
+			expected:     true,
+		},
+		{
+			tCase:        "default value",
+			key:          "no_bool",
+			defaultValue: true,
+			expected:     true,
+		},
+		{
+			tCase:        "failed to parse value from env",
+			key:          "bad_bool",
+			defaultValue: false,
+			expected:     false,
+		},
+	}
+
+	for _, tc := range testTable {
+		t.Run(tc.tCase, func(t *testing.T) {
+			ao.Equal(tc.expected, setBoolField(tc.key, tc.defaultValue))
+		})
+	}
+}
+
+func Test_setDurationField(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	os.Setenv("duration", "3s")
+	os.Setenv("bad_duration", "3 seconds")
+	os.Setenv("zero_duration", "0s")
+	os.Setenv("negative_duration", "-1s")
+
+	type testData struct {
+		tCase        string
+		key          string
+		defaultValue time.Duration
+		expected     time.Duration
+	}
+
+	testTable := []testData{
+		{
+			tCase:        "success",
+			key:          "duration",
+			defaultValue: time.Second,
+			expected:     3 * time.Second,
+		},
+		{
+			tCase:        "default value",
+			key:          "no_duration",
+			defaultValue: time.Minute,
+			expected:     time.Minute,
+		},
+		{
+			tCase:        "failed to parse value from env",
+			key:          "bad_duration",
+			defaultValue: time.Hour,
+			expected:     time.Hour,
+		},
+		{
+			tCase:        "zero duration",
+			key:          "zero_duration",
+			defaultValue: time.Second,
+			expected:     time.Second,
+		},
+		{
+			tCase:        "negative duration",
+			key:          "negative_duration",
+			defaultValue: time.Second,
+			expected:     time.Second,
+		},
+	}
+
+	for _, tc := range testTable {
+		t.Run(tc.tCase, func(t *testing.T) {
+			ao.Equal(tc.expected, setDurationField(tc.key, tc.defaultValue))
+		})
+	}
+}
+
+func Test_setCIDRsField(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	os.Setenv("cidrs", "10.0.0.0/8, 127.0.0.1,::1")
+	os.Setenv("bad_cidrs", "10.0.0.0/8,proxy")
+
+	type testData struct {
+		tCase        string
+		key          string
+		defaultValue string
+		expected     []*net.IPNet
+	}
+
+	testTable := []testData{
+		{
+			tCase:        "success",
+			key:          "cidrs",
+			defaultValue: "",
+			expected: []*net.IPNet{
+				{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(8, 32)},
+				{IP: net.IP{127, 0, 0, 1}, Mask: net.CIDRMask(32, 32)},
+				{IP: net.IPv6loopback, Mask: net.CIDRMask(128, 128)},
+			},
+		},
+		{
+			tCase:        "default value",
+			key:          "no_cidrs",
+			defaultValue: "",
+			expected:     nil,
+		},
+		{
+			tCase:        "failed to parse value from env",
+			key:          "bad_cidrs",
+			defaultValue: "192.168.0.0/16",
+			expected:     []*net.IPNet{{IP: net.IP{192, 168, 0, 0}, Mask: net.CIDRMask(16, 32)}},
+		},
+	}
+
+	for _, tc := range testTable {
+		t.Run(tc.tCase, func(t *testing.T) {
+			ao.Equal(tc.expected, setCIDRsField(tc.key, tc.defaultValue))
+		})
+	}
+}
+
+func Test_setPairsField(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	os.Setenv("pairs", "source=shorty, medium = link,,")
+	os.Setenv("bad_pairs", "source=shorty,medium")
+
+	type testData struct {
+		tCase        string
+		key          string
+		defaultValue string
+		expected     map[string]string
+	}
+
+	testTable := []testData{
+		{
+			tCase:        "success",
+			key:          "pairs",
+			defaultValue: "",
+			expected:     map[string]string{"source": "shorty", "medium": "link"},
+		},
+		{
+			tCase:        "default value",
+			key:          "no_pairs",
+			defaultValue: "",
+			expected:     nil,
+		},
+		{
+			tCase:        "failed to parse value from env",
+			key:          "bad_pairs",
+			defaultValue: "source=default",
+			expected:     map[string]string{"source": "default"},
+		},
+	}
+
+	for _, tc := range testTable {
+		t.Run(tc.tCase, func(t *testing.T) {
+			ao.Equal(tc.expected, setPairsField(tc.key, tc.defaultValue))
+		})
+	}
+}
+
 func Test_New(t *testing.T) {
 	t.Parallel()
 	ao := assert.New(t)
@@ -99,6 +283,57 @@ func Test_New(t *testing.T) {
 				HostPort:      defaultHostPort,
 				ContainerPort: defaultContainerPort,
 				DbNum:         defaultDbNum,
+				MetricsAddr:   defaultMetricsAddr,
+
+				ShutdownTimeout: defaultShutdownTimeout,
+				MigrateOnStart:  defaultMigrateOnStart,
+
+				TLSCertFile:      defaultTLSCertFile,
+				TLSKeyFile:       defaultTLSKeyFile,
+				TLSClientCAFile:  defaultTLSClientCAFile,
+				HTTPRedirectPort: defaultHTTPRedirectPort,
+
+				AdminAddr:            defaultAdminAddr,
+				AdminToken:           defaultAdminToken,
+				AdminTLSClientCAFile: defaultAdminTLSClientCAFile,
+				RequireAPIKey:        defaultRequireAPIKey,
+				RateLimit:            defaultRateLimit,
+				RateLimitBurst:       defaultRateLimitBurst,
+				GRPCAddr:             defaultGRPCAddr,
+
+				PublicBaseURL: defaultPublicBaseURL,
+
+				CookieSecret:        defaultCookieSecret,
+				UnlockTTL:           defaultUnlockTTL,
+				PasswordMaxAttempts: defaultPasswordMaxAttempts,
+				PasswordLockout:     defaultPasswordLockout,
+
+				InactivePageFile: defaultInactivePageFile,
+				GeoIPFile:        defaultGeoIPFile,
+				VariantTTL:       defaultVariantTTL,
+				PreviewPageFile:  defaultPreviewPageFile,
+				Unfurl:           defaultUnfurl,
+				UnfurlWorkers:    defaultUnfurlWorkers,
+				UnfurlTimeout:    defaultUnfurlTimeout,
+				UnfurlMaxBytes:   defaultUnfurlMaxBytes,
+
+				WebhookWorkers:     defaultWebhookWorkers,
+				WebhookTimeout:     defaultWebhookTimeout,
+				WebhookMaxAttempts: defaultWebhookMaxAttempts,
+				WebhookBackoff:     defaultWebhookBackoff,
+				WebhookMaxBackoff:  defaultWebhookMaxBackoff,
+
+				ClickSinkFile:         defaultClickSinkFile,
+				ClickSinkFileMaxBytes: defaultClickSinkFileMaxBytes,
+				ClickSinkFileMaxFiles: defaultClickSinkFileMaxFiles,
+				ClickSinkStream:       defaultClickSinkStream,
+				ClickSinkStreamMaxLen: defaultClickSinkStreamMaxLen,
+				ClickSinkKafkaBrokers: []string{"kafka:9092"},
+				ClickSinkKafkaTopic:   defaultClickSinkKafkaTopic,
+				ClickBuffer:           defaultClickBuffer,
+				ClickBatch:            defaultClickBatch,
+				ClickFlushInterval:    defaultClickFlushInterval,
+				ClickWriteTimeout:     defaultClickWriteTimeout,
 			},
 		},
 	}
@@ -109,3 +344,43 @@ func Test_New(t *testing.T) {
 		})
 	}
 }
+
+func Test_setListField(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	os.Setenv("list", "kafka-1:9092, kafka-2:9092,,")
+
+	type testData struct {
+		tCase        string
+		key          string
+		defaultValue string
+		expected     []string
+	}
+
+	testTable := []testData{
+		{
+			tCase:        "success",
+			key:          "list",
+			defaultValue: "",
+			expected:     []string{"kafka-1:9092", "kafka-2:9092"},
+		},
+		{
+			tCase:        "default value",
+			key:          "no_list",
+			defaultValue: "kafka:9092",
+			expected:     []string{"kafka:9092"},
+		},
+		{
+			tCase:        "empty default value",
+			key:          "no_list",
+			defaultValue: "",
+			expected:     nil,
+		},
+	}
+
+	for _, tc := range testTable {
+		t.Run(tc.tCase, func(t *testing.T) {
+			ao.Equal(tc.expected, setListField(tc.key, tc.defaultValue))
+		})
+	}
+}
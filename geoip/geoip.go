@@ -0,0 +1,113 @@
+package geoip
+
+import (
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// checkInterval limits how often the database file is checked for changes.
+var checkInterval = 10 * time.Second
+
+// DB looks up countries of IP addresses in a local MaxMind database, such as GeoLite2 Country or City, and reloads it
+// after the file has been replaced, so that updates are picked up without restarting the application.
+type DB struct {
+	file string
+
+	mu        sync.Mutex
+	reader    *maxminddb.Reader
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// Open loads the database from the given file and returns an instance of DB.
+func Open(file string) (*DB, error) {
+	db := DB{file: file}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.load(info.ModTime()); err != nil {
+		return nil, err
+	}
+
+	return &db, nil
+}
+
+// Country returns the ISO 3166 code of the country of the IP address in upper case, or an empty string if it is
+// unknown.
+func (db *DB) Country(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+
+	var r record
+	if err := db.current().Lookup(ip, &r); err != nil {
+		return ""
+	}
+
+	return strings.ToUpper(r.Country.ISOCode)
+}
+
+// current returns the loaded database, reloading it first if the file has changed. If the reload fails, the
+// previously loaded database is kept.
+func (db *DB) current() *maxminddb.Reader {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if time.Since(db.checkedAt) < checkInterval {
+		return db.reader
+	}
+	db.checkedAt = time.Now()
+
+	info, err := os.Stat(db.file)
+	if err != nil {
+		log.Printf("failed to check GeoIP database %v for changes: %v", db.file, err)
+		return db.reader
+	}
+
+	if info.ModTime().Equal(db.modTime) {
+		return db.reader
+	}
+
+	if err := db.load(info.ModTime()); err != nil {
+		log.Printf("failed to reload GeoIP database %v, keeping the previous one: %v", db.file, err)
+		return db.reader
+	}
+	log.Printf("reloaded GeoIP database %v", db.file)
+
+	return db.reader
+}
+
+// load reads the whole database into memory, which must be called with mu held or before db is shared. The file is
+// not memory mapped, so the previous reader stays valid for lookups in progress while the file is replaced.
+func (db *DB) load(modTime time.Time) error {
+	buf, err := os.ReadFile(db.file)
+	if err != nil {
+		return err
+	}
+
+	reader, err := maxminddb.FromBytes(buf)
+	if err != nil {
+		return err
+	}
+
+	db.reader = reader
+	db.modTime = modTime
+	db.checkedAt = time.Now()
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package geoip
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeDB writes an IPv4 MaxMind database with 24 bit records matching the networks to the country codes into file.
+// The networks must not overlap.
+func writeDB(t *testing.T, file string, countries map[string]string) {
+	t.Helper()
+
+	type node struct {
+		children [2]*node
+		// data is the offset of the record in the data section plus one, zero for internal nodes
+		data int
+		id   int
+	}
+
+	root := &node{}
+	var data []byte
+	for cidr, country := range countries {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ones, _ := network.Mask.Size()
+		ip := network.IP.To4()
+
+		n := root
+		for i := 0; i < ones; i++ {
+			bit := ip[i/8] >> (7 - i%8) & 1
+			if n.children[bit] == nil {
+				n.children[bit] = &node{}
+			}
+			n = n.children[bit]
+		}
+		n.data = len(data) + 1
+		data = append(data, encodeMap(encodeString("country"), encodeMap(encodeString("iso_code"), encodeString(country)))...)
+	}
+
+	var nodes []*node
+	for queue := []*node{root}; len(queue) > 0; queue = queue[1:] {
+		n := queue[0]
+		n.id = len(nodes)
+		nodes = append(nodes, n)
+		for _, c := range n.children {
+			if c != nil && c.data == 0 {
+				queue = append(queue, c)
+			}
+		}
+	}
+
+	var buf []byte
+	for _, n := range nodes {
+		for _, c := range n.children {
+			v := len(nodes)
+			switch {
+			case c != nil && c.data > 0:
+				v = len(nodes) + 16 + c.data - 1
+			case c != nil:
+				v = c.id
+			}
+			buf = append(buf, byte(v>>16), byte(v>>8), byte(v))
+		}
+	}
+	buf = append(buf, make([]byte, 16)...)
+	buf = append(buf, data...)
+	buf = append(buf, "\xAB\xCD\xEFMaxMind.com"...)
+	buf = append(buf, encodeMap(
+		encodeString("node_count"), encodeUint(6, uint64(len(nodes))),
+		encodeString("record_size"), encodeUint(5, 24),
+		encodeString("ip_version"), encodeUint(5, 4),
+		encodeString("database_type"), encodeString("Test-Country"),
+		encodeString("binary_format_major_version"), encodeUint(5, 2),
+		encodeString("binary_format_minor_version"), encodeUint(5, 0),
+	)...)
+
+	if err := os.WriteFile(file, buf, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func encodeString(s string) []byte {
+	return append([]byte{2<<5 | byte(len(s))}, s...)
+}
+
+func encodeUint(typ byte, v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	for len(b) > 0 && b[0] == 0 {
+		b = b[1:]
+	}
+
+	return append([]byte{typ<<5 | byte(len(b))}, b...)
+}
+
+func encodeMap(pairs ...[]byte) []byte {
+	buf := []byte{7<<5 | byte(len(pairs)/2)}
+	for _, p := range pairs {
+		buf = append(buf, p...)
+	}
+
+	return buf
+}
+
+func Test_DB(t *testing.T) {
+	ao := assert.New(t)
+
+	file := filepath.Join(t.TempDir(), "countries.mmdb")
+	writeDB(t, file, map[string]string{
+		"81.2.69.0/24":   "gb",
+		"89.160.20.0/22": "SE",
+	})
+
+	_, err := Open(filepath.Join(t.TempDir(), "missing.mmdb"))
+	ao.Error(err)
+
+	db, err := Open(file)
+	ao.NoError(err)
+	ao.Equal("GB", db.Country(net.ParseIP("81.2.69.142")))
+	ao.Equal("SE", db.Country(net.ParseIP("89.160.23.1")))
+	ao.Empty(db.Country(net.ParseIP("10.0.0.1")))
+	ao.Empty(db.Country(net.ParseIP("2001:db8::1")), "IPv6 addresses cannot be found in IPv4 databases")
+	ao.Empty(db.Country(nil))
+
+	checkInterval = 0
+	defer func() { checkInterval = 10 * time.Second }()
+
+	writeDB(t, file, map[string]string{"81.2.69.0/24": "IE"})
+	later := time.Now().Add(time.Minute)
+	ao.NoError(os.Chtimes(file, later, later))
+	ao.Equal("IE", db.Country(net.ParseIP("81.2.69.142")), "the changed database must be reloaded")
+	ao.Empty(db.Country(net.ParseIP("89.160.23.1")))
+
+	ao.NoError(os.WriteFile(file, []byte("broken"), 0600))
+	latest := later.Add(time.Minute)
+	ao.NoError(os.Chtimes(file, latest, latest))
+	ao.Equal("IE", db.Country(net.ParseIP("81.2.69.142")), "the previous database must be kept if the new one is broken")
+}
@@ -6,7 +6,13 @@ require (
 	github.com/alicebob/miniredis/v2 v2.14.2
 	github.com/golang/mock v1.5.0
 	github.com/gomodule/redigo v1.8.3
+	github.com/oschwald/maxminddb-golang v1.6.0
 	github.com/prometheus/client_golang v1.9.0
-	github.com/stretchr/testify v1.5.1
+	github.com/segmentio/kafka-go v0.4.12
+	github.com/stretchr/testify v1.6.1
 	github.com/valyala/fasthttp v1.23.0
+	golang.org/x/crypto v0.0.0-20210220033148-5ea612d1eb83
+	golang.org/x/net v0.0.0-20210226101413-39120d07d75e
+	google.golang.org/grpc v1.36.0
+	google.golang.org/protobuf v1.26.0
 )
@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/metrics"
+	"github.com/yexelm/shorty/store"
+)
+
+//go:generate mockgen -source=admin.go -destination=admin_mocks.go -package=handlers -self_package=shorty/handlers
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 1000
+)
+
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrInvalidCount  = errors.New("invalid count")
+	ErrInvalidToggle = errors.New("toggle value must be true or false")
+	ErrInvalidDryRun = errors.New("dry_run must be true or false")
+)
+
+type Admin interface {
+	Links(ns store.Namespace, cursor uint64, count int, query string) ([]store.Link, uint64, error)
+	Each(ns store.Namespace, fn func(store.Link) error) error
+	Disable(ns store.Namespace, short []byte) error
+	Enable(ns store.Namespace, short []byte) error
+	Delete(ns store.Namespace, short []byte) error
+	Stats(ns store.Namespace) (store.Stats, error)
+	IssueKey(tenant, owner string) (string, error)
+	RevokeKey(tenant, owner string) error
+	Tenants() ([]store.Tenant, error)
+	SaveTenant(t store.Tenant) (store.Tenant, error)
+	DeleteTenant(id string) error
+	CheckIntegrity(ns store.Namespace, repair bool) (store.IntegrityReport, error)
+}
+
+type linksPage struct {
+	Links  []store.Link `json:"links"`
+	Cursor uint64       `json:"cursor"`
+}
+
+type issuedKey struct {
+	Tenant string `json:"tenant,omitempty"`
+	Owner  string `json:"owner"`
+	Key    string `json:"key"`
+}
+
+// HandleAdmin serves the management API, which is expected to be exposed on a separate private listener. Requests
+// about links, keys and stats work in the default namespace unless a tenant or a domain is given in the query.
+func (env *Environment) HandleAdmin(ctx *fasthttp.RequestCtx) {
+	defer env.track()()
+	defer observe("admin", ctx, time.Now())
+
+	if !env.adminAuthorized(ctx) {
+		writeError(ctx, fasthttp.StatusUnauthorized, ErrUnauthorized)
+		return
+	}
+
+	ns, err := env.adminNamespace(ctx)
+	if err != nil {
+		env.adminFailed(ctx, err)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(string(ctx.Path()), "/"), "/")
+
+	switch {
+	case parts[0] == "links" && len(parts) == 1 && ctx.IsGet():
+		env.adminLinks(ctx, ns)
+	case parts[0] == "links" && len(parts) == 2 && ctx.IsDelete():
+		env.adminDelete(ctx, ns, parts[1])
+	case parts[0] == "links" && len(parts) == 3 && ctx.IsPost() && parts[2] == "disable":
+		env.adminSetDisabled(ctx, ns, parts[1], env.Admin.Disable)
+	case parts[0] == "links" && len(parts) == 3 && ctx.IsPost() && parts[2] == "enable":
+		env.adminSetDisabled(ctx, ns, parts[1], env.Admin.Enable)
+	case parts[0] == "stats" && len(parts) == 1 && ctx.IsGet():
+		env.adminStats(ctx, ns)
+	case parts[0] == "keys" && len(parts) == 2 && ctx.IsPost():
+		env.adminIssueKey(ctx, ns.Tenant, parts[1])
+	case parts[0] == "keys" && len(parts) == 2 && ctx.IsDelete():
+		env.adminRevokeKey(ctx, ns.Tenant, parts[1])
+	case parts[0] == "export" && len(parts) == 1 && ctx.IsGet():
+		env.adminExport(ctx, ns)
+	case parts[0] == "integrity" && len(parts) == 1 && ctx.IsGet():
+		env.adminIntegrity(ctx, ns, false)
+	case parts[0] == "integrity" && len(parts) == 2 && ctx.IsPost() && parts[1] == "repair":
+		env.adminIntegrity(ctx, ns, true)
+	case parts[0] == "toggles" && len(parts) == 1 && ctx.IsGet():
+		writeJSON(ctx, fasthttp.StatusOK, env.Toggles.All())
+	case parts[0] == "toggles" && len(parts) == 2 && ctx.IsPut():
+		env.adminSetToggle(ctx, parts[1])
+	case parts[0] == "tenants" && len(parts) == 1 && ctx.IsGet():
+		env.adminTenants(ctx)
+	case parts[0] == "tenants" && len(parts) == 2 && ctx.IsGet():
+		env.adminTenant(ctx, parts[1])
+	case parts[0] == "tenants" && len(parts) == 2 && ctx.IsPut():
+		env.adminSaveTenant(ctx, parts[1])
+	case parts[0] == "tenants" && len(parts) == 2 && ctx.IsDelete():
+		env.adminDeleteTenant(ctx, parts[1])
+	case parts[0] == "webhooks" && len(parts) == 1 && ctx.IsGet():
+		env.adminWebhooks(ctx, ns.Tenant)
+	case parts[0] == "webhooks" && len(parts) == 1 && ctx.IsPost():
+		env.adminSaveWebhook(ctx, ns.Tenant, "")
+	case parts[0] == "webhooks" && len(parts) == 2 && ctx.IsPut():
+		env.adminSaveWebhook(ctx, ns.Tenant, parts[1])
+	case parts[0] == "webhooks" && len(parts) == 2 && ctx.IsDelete():
+		env.adminDeleteWebhook(ctx, ns.Tenant, parts[1])
+	case parts[0] == "webhooks" && len(parts) == 3 && ctx.IsGet() && parts[2] == "deliveries":
+		env.adminDeliveries(ctx, ns.Tenant, parts[1])
+	default:
+		writeError(ctx, fasthttp.StatusNotFound, ErrNotFound)
+	}
+}
+
+// adminLinks returns a page of saved links, optionally filtered by a substring of the original URL.
+func (env *Environment) adminLinks(ctx *fasthttp.RequestCtx, ns store.Namespace) {
+	args := ctx.QueryArgs()
+
+	var cursor uint64
+	if c := args.Peek("cursor"); len(c) > 0 {
+		var err error
+		if cursor, err = strconv.ParseUint(string(c), 10, 64); err != nil {
+			writeError(ctx, fasthttp.StatusBadRequest, store.ErrInvalidCursor)
+			return
+		}
+	}
+
+	count, err := parseCount(args.Peek("count"))
+	if err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err)
+		return
+	}
+
+	links, next, err := env.Admin.Links(ns, cursor, count, string(args.Peek("q")))
+	if err != nil {
+		env.adminFailed(ctx, err)
+		return
+	}
+
+	writeJSON(ctx, fasthttp.StatusOK, linksPage{Links: links, Cursor: next})
+}
+
+func (env *Environment) adminDelete(ctx *fasthttp.RequestCtx, ns store.Namespace, short string) {
+	if err := env.Admin.Delete(ns, []byte(short)); err != nil {
+		env.adminFailed(ctx, err)
+		return
+	}
+	env.notify(EventLinkDeleted, ns, store.Link{Short: short}, nil)
+
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
+
+func (env *Environment) adminSetDisabled(ctx *fasthttp.RequestCtx, ns store.Namespace, short string,
+	set func(store.Namespace, []byte) error) {
+	if err := set(ns, []byte(short)); err != nil {
+		env.adminFailed(ctx, err)
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
+
+func (env *Environment) adminStats(ctx *fasthttp.RequestCtx, ns store.Namespace) {
+	stats, err := env.Admin.Stats(ns)
+	if err != nil {
+		env.adminFailed(ctx, err)
+		return
+	}
+
+	writeJSON(ctx, fasthttp.StatusOK, stats)
+}
+
+// adminIssueKey issues a new API key for the owner within the tenant, revoking the previous one.
+func (env *Environment) adminIssueKey(ctx *fasthttp.RequestCtx, tenant, owner string) {
+	key, err := env.Admin.IssueKey(tenant, owner)
+	if err != nil {
+		env.adminFailed(ctx, err)
+		return
+	}
+
+	writeJSON(ctx, fasthttp.StatusCreated, issuedKey{Tenant: tenant, Owner: owner, Key: key})
+}
+
+func (env *Environment) adminRevokeKey(ctx *fasthttp.RequestCtx, tenant, owner string) {
+	if err := env.Admin.RevokeKey(tenant, owner); err != nil {
+		env.adminFailed(ctx, err)
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
+
+// adminExport streams all links saved in the namespace as newline delimited JSON.
+func (env *Environment) adminExport(ctx *fasthttp.RequestCtx, ns store.Namespace) {
+	ctx.SetContentType("application/x-ndjson")
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		enc := json.NewEncoder(w)
+		err := env.Admin.Each(ns, func(l store.Link) error {
+			return enc.Encode(l)
+		})
+		if err != nil {
+			metrics.Errors.WithLabelValues("admin").Inc()
+			log.Printf("failed to export links: %v", err)
+		}
+	})
+}
+
+// adminIntegrity reports the problems of the matches between aliases and URLs saved in the namespace along with
+// their fixes, and applies the fixes if asked to repair them unless it is a dry run.
+func (env *Environment) adminIntegrity(ctx *fasthttp.RequestCtx, ns store.Namespace, repair bool) {
+	if v := ctx.QueryArgs().Peek("dry_run"); len(v) > 0 {
+		dryRun, err := strconv.ParseBool(string(v))
+		if err != nil {
+			writeError(ctx, fasthttp.StatusBadRequest, ErrInvalidDryRun)
+			return
+		}
+		repair = repair && !dryRun
+	}
+
+	r, err := env.Admin.CheckIntegrity(ns, repair)
+	if err != nil {
+		env.adminFailed(ctx, err)
+		return
+	}
+
+	writeJSON(ctx, fasthttp.StatusOK, r)
+}
+
+func (env *Environment) adminSetToggle(ctx *fasthttp.RequestCtx, name string) {
+	on, err := strconv.ParseBool(strings.TrimSpace(string(ctx.Request.Body())))
+	if err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, ErrInvalidToggle)
+		return
+	}
+
+	if err := env.Toggles.Set(name, on); err != nil {
+		writeError(ctx, fasthttp.StatusNotFound, err)
+		return
+	}
+
+	writeJSON(ctx, fasthttp.StatusOK, env.Toggles.All())
+}
+
+// parseCount parses the requested page size, which is defaultPageSize if not given.
+func parseCount(v []byte) (int, error) {
+	if len(v) == 0 {
+		return defaultPageSize, nil
+	}
+
+	count, err := strconv.Atoi(string(v))
+	if err != nil || count <= 0 || count > maxPageSize {
+		return 0, ErrInvalidCount
+	}
+
+	return count, nil
+}
+
+// adminFailed writes the response for a failed storage operation.
+func (env *Environment) adminFailed(ctx *fasthttp.RequestCtx, err error) {
+	if err == redis.ErrNil {
+		writeError(ctx, fasthttp.StatusNotFound, ErrNotFound)
+		return
+	}
+
+	metrics.Errors.WithLabelValues("admin").Inc()
+	writeError(ctx, fasthttp.StatusInternalServerError, err)
+}
+
+func writeJSON(ctx *fasthttp.RequestCtx, code int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.WriteString(err.Error())
+		return
+	}
+
+	ctx.SetStatusCode(code)
+	ctx.SetContentType("application/json")
+	ctx.Write(body)
+}
+
+func writeError(ctx *fasthttp.RequestCtx, code int, err error) {
+	writeJSON(ctx, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,210 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: admin.go
+
+// Package handlers is a generated GoMock package.
+package handlers
+
+import (
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+	store "github.com/yexelm/shorty/store"
+)
+
+// MockAdmin is a mock of Admin interface.
+type MockAdmin struct {
+	ctrl     *gomock.Controller
+	recorder *MockAdminMockRecorder
+}
+
+// MockAdminMockRecorder is the mock recorder for MockAdmin.
+type MockAdminMockRecorder struct {
+	mock *MockAdmin
+}
+
+// NewMockAdmin creates a new mock instance.
+func NewMockAdmin(ctrl *gomock.Controller) *MockAdmin {
+	mock := &MockAdmin{ctrl: ctrl}
+	mock.recorder = &MockAdminMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAdmin) EXPECT() *MockAdminMockRecorder {
+	return m.recorder
+}
+
+// CheckIntegrity mocks base method.
+func (m *MockAdmin) CheckIntegrity(ns store.Namespace, repair bool) (store.IntegrityReport, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CheckIntegrity", ns, repair)
+	ret0, _ := ret[0].(store.IntegrityReport)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CheckIntegrity indicates an expected call of CheckIntegrity.
+func (mr *MockAdminMockRecorder) CheckIntegrity(ns, repair interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckIntegrity", reflect.TypeOf((*MockAdmin)(nil).CheckIntegrity), ns, repair)
+}
+
+// Delete mocks base method.
+func (m *MockAdmin) Delete(ns store.Namespace, short []byte) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", ns, short)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockAdminMockRecorder) Delete(ns, short interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockAdmin)(nil).Delete), ns, short)
+}
+
+// DeleteTenant mocks base method.
+func (m *MockAdmin) DeleteTenant(id string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteTenant", id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteTenant indicates an expected call of DeleteTenant.
+func (mr *MockAdminMockRecorder) DeleteTenant(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteTenant", reflect.TypeOf((*MockAdmin)(nil).DeleteTenant), id)
+}
+
+// Disable mocks base method.
+func (m *MockAdmin) Disable(ns store.Namespace, short []byte) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Disable", ns, short)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Disable indicates an expected call of Disable.
+func (mr *MockAdminMockRecorder) Disable(ns, short interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Disable", reflect.TypeOf((*MockAdmin)(nil).Disable), ns, short)
+}
+
+// Each mocks base method.
+func (m *MockAdmin) Each(ns store.Namespace, fn func(store.Link) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Each", ns, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Each indicates an expected call of Each.
+func (mr *MockAdminMockRecorder) Each(ns, fn interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Each", reflect.TypeOf((*MockAdmin)(nil).Each), ns, fn)
+}
+
+// Enable mocks base method.
+func (m *MockAdmin) Enable(ns store.Namespace, short []byte) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Enable", ns, short)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Enable indicates an expected call of Enable.
+func (mr *MockAdminMockRecorder) Enable(ns, short interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Enable", reflect.TypeOf((*MockAdmin)(nil).Enable), ns, short)
+}
+
+// IssueKey mocks base method.
+func (m *MockAdmin) IssueKey(tenant, owner string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IssueKey", tenant, owner)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IssueKey indicates an expected call of IssueKey.
+func (mr *MockAdminMockRecorder) IssueKey(tenant, owner interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IssueKey", reflect.TypeOf((*MockAdmin)(nil).IssueKey), tenant, owner)
+}
+
+// Links mocks base method.
+func (m *MockAdmin) Links(ns store.Namespace, cursor uint64, count int, query string) ([]store.Link, uint64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Links", ns, cursor, count, query)
+	ret0, _ := ret[0].([]store.Link)
+	ret1, _ := ret[1].(uint64)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// Links indicates an expected call of Links.
+func (mr *MockAdminMockRecorder) Links(ns, cursor, count, query interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Links", reflect.TypeOf((*MockAdmin)(nil).Links), ns, cursor, count, query)
+}
+
+// RevokeKey mocks base method.
+func (m *MockAdmin) RevokeKey(tenant, owner string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeKey", tenant, owner)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeKey indicates an expected call of RevokeKey.
+func (mr *MockAdminMockRecorder) RevokeKey(tenant, owner interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeKey", reflect.TypeOf((*MockAdmin)(nil).RevokeKey), tenant, owner)
+}
+
+// SaveTenant mocks base method.
+func (m *MockAdmin) SaveTenant(t store.Tenant) (store.Tenant, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveTenant", t)
+	ret0, _ := ret[0].(store.Tenant)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SaveTenant indicates an expected call of SaveTenant.
+func (mr *MockAdminMockRecorder) SaveTenant(t interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveTenant", reflect.TypeOf((*MockAdmin)(nil).SaveTenant), t)
+}
+
+// Stats mocks base method.
+func (m *MockAdmin) Stats(ns store.Namespace) (store.Stats, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Stats", ns)
+	ret0, _ := ret[0].(store.Stats)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Stats indicates an expected call of Stats.
+func (mr *MockAdminMockRecorder) Stats(ns interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stats", reflect.TypeOf((*MockAdmin)(nil).Stats), ns)
+}
+
+// Tenants mocks base method.
+func (m *MockAdmin) Tenants() ([]store.Tenant, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Tenants")
+	ret0, _ := ret[0].([]store.Tenant)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Tenants indicates an expected call of Tenants.
+func (mr *MockAdminMockRecorder) Tenants() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Tenants", reflect.TypeOf((*MockAdmin)(nil).Tenants))
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/store"
+)
+
+func Test_HandleAdmin(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+	env.Config.AdminToken = "secret"
+
+	type testData struct {
+		tCase        string
+		method       string
+		URI          string
+		body         []byte
+		token        string
+		expectedFunc func()
+
+		expectedBody string
+		expectedCode int
+	}
+
+	testTable := []testData{
+		{
+			tCase:        "unauthorized",
+			method:       "GET",
+			URI:          "/stats",
+			token:        "wrong",
+			expectedFunc: func() {},
+			expectedBody: `{"error":"unauthorized"}`,
+			expectedCode: fasthttp.StatusUnauthorized,
+		},
+		{
+			tCase:        "unknown endpoint",
+			method:       "GET",
+			URI:          "/unknown",
+			token:        "secret",
+			expectedFunc: func() {},
+			expectedBody: `{"error":"not found"}`,
+			expectedCode: fasthttp.StatusNotFound,
+		},
+		{
+			tCase:  "list links",
+			method: "GET",
+			URI:    "/links?cursor=5&count=2&q=go.dev",
+			token:  "secret",
+			expectedFunc: func() {
+				mockEnv.Admin.EXPECT().Links(store.Namespace{}, uint64(5), 2, "go.dev").Return([]store.Link{
+					{Short: "b", Long: "https://go.dev"},
+				}, uint64(7), nil)
+			},
+			expectedBody: `{"links":[{"short":"b","long":"https://go.dev","disabled":false,"created_at":"0001-01-01T00:00:00Z"}],"cursor":7}`,
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:        "list links with invalid count",
+			method:       "GET",
+			URI:          "/links?count=100000",
+			token:        "secret",
+			expectedFunc: func() {},
+			expectedBody: `{"error":"invalid count"}`,
+			expectedCode: fasthttp.StatusBadRequest,
+		},
+		{
+			tCase:  "disable",
+			method: "POST",
+			URI:    "/links/b/disable",
+			token:  "secret",
+			expectedFunc: func() {
+				mockEnv.Admin.EXPECT().Disable(store.Namespace{}, []byte("b")).Return(nil)
+			},
+			expectedCode: fasthttp.StatusNoContent,
+		},
+		{
+			tCase:  "enable unknown link",
+			method: "POST",
+			URI:    "/links/b/enable",
+			token:  "secret",
+			expectedFunc: func() {
+				mockEnv.Admin.EXPECT().Enable(store.Namespace{}, []byte("b")).Return(redis.ErrNil)
+			},
+			expectedBody: `{"error":"not found"}`,
+			expectedCode: fasthttp.StatusNotFound,
+		},
+		{
+			tCase:  "force delete",
+			method: "DELETE",
+			URI:    "/links/b",
+			token:  "secret",
+			expectedFunc: func() {
+				mockEnv.Admin.EXPECT().Delete(store.Namespace{}, []byte("b")).Return(nil)
+			},
+			expectedCode: fasthttp.StatusNoContent,
+		},
+		{
+			tCase:  "stats error",
+			method: "GET",
+			URI:    "/stats",
+			token:  "secret",
+			expectedFunc: func() {
+				mockEnv.Admin.EXPECT().Stats(store.Namespace{}).Return(store.Stats{}, errors.New("some error"))
+			},
+			expectedBody: `{"error":"some error"}`,
+			expectedCode: fasthttp.StatusInternalServerError,
+		},
+		{
+			tCase:  "stats",
+			method: "GET",
+			URI:    "/stats",
+			token:  "secret",
+			expectedFunc: func() {
+				mockEnv.Admin.EXPECT().Stats(store.Namespace{}).Return(store.Stats{Links: 3, Disabled: 1, LastID: 4}, nil)
+			},
+			expectedBody: `{"links":3,"disabled":1,"last_id":4}`,
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:  "reissue API key",
+			method: "POST",
+			URI:    "/keys/team",
+			token:  "secret",
+			expectedFunc: func() {
+				mockEnv.Admin.EXPECT().IssueKey("", "team").Return("key", nil)
+			},
+			expectedBody: `{"owner":"team","key":"key"}`,
+			expectedCode: fasthttp.StatusCreated,
+		},
+		{
+			tCase:  "revoke API key",
+			method: "DELETE",
+			URI:    "/keys/team",
+			token:  "secret",
+			expectedFunc: func() {
+				mockEnv.Admin.EXPECT().RevokeKey("", "team").Return(nil)
+			},
+			expectedCode: fasthttp.StatusNoContent,
+		},
+		{
+			tCase:  "export",
+			method: "GET",
+			URI:    "/export",
+			token:  "secret",
+			expectedFunc: func() {
+				mockEnv.Admin.EXPECT().Each(store.Namespace{}, gomock.Any()).DoAndReturn(func(_ store.Namespace, fn func(store.Link) error) error {
+					_ = fn(store.Link{Short: "b", Long: "https://go.dev"})
+					return fn(store.Link{Short: "c", Long: "https://ya.ru", Disabled: true})
+				})
+			},
+			expectedBody: `{"short":"b","long":"https://go.dev","disabled":false,"created_at":"0001-01-01T00:00:00Z"}` + "\n" +
+				`{"short":"c","long":"https://ya.ru","disabled":true,"created_at":"0001-01-01T00:00:00Z"}` + "\n",
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:  "check integrity",
+			method: "GET",
+			URI:    "/integrity?tenant=acme",
+			token:  "secret",
+			expectedFunc: func() {
+				mockEnv.Admin.EXPECT().CheckIntegrity(store.Namespace{Tenant: "acme"}, false).Return(store.IntegrityReport{
+					Aliases: 1, URLs: 0, LastID: 1,
+					Problems: []store.Problem{{Kind: store.ProblemOrphanAlias, Short: "b", Long: "https://go.dev", Fix: store.FixLinkURL}},
+				}, nil)
+			},
+			expectedBody: `{"aliases":1,"urls":0,"last_id":1,"problems":[{"kind":"orphan_alias","short":"b","long":"https://go.dev","fix":"link_url"}]}`,
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:  "repair integrity",
+			method: "POST",
+			URI:    "/integrity/repair",
+			token:  "secret",
+			expectedFunc: func() {
+				mockEnv.Admin.EXPECT().CheckIntegrity(store.Namespace{}, true).Return(store.IntegrityReport{Problems: []store.Problem{}}, nil)
+			},
+			expectedBody: `{"aliases":0,"urls":0,"last_id":0,"problems":[]}`,
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:  "repair integrity dry run",
+			method: "POST",
+			URI:    "/integrity/repair?dry_run=true",
+			token:  "secret",
+			expectedFunc: func() {
+				mockEnv.Admin.EXPECT().CheckIntegrity(store.Namespace{}, false).Return(store.IntegrityReport{Problems: []store.Problem{}}, nil)
+			},
+			expectedBody: `{"aliases":0,"urls":0,"last_id":0,"problems":[]}`,
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:        "invalid dry run",
+			method:       "POST",
+			URI:          "/integrity/repair?dry_run=maybe",
+			token:        "secret",
+			expectedFunc: func() {},
+			expectedBody: `{"error":"dry_run must be true or false"}`,
+			expectedCode: fasthttp.StatusBadRequest,
+		},
+		{
+			tCase:        "switch toggle off",
+			method:       "PUT",
+			URI:          "/toggles/shorten",
+			body:         []byte("false"),
+			token:        "secret",
+			expectedFunc: func() {},
+			expectedBody: `{"resolve":true,"shorten":false}`,
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:        "unknown toggle",
+			method:       "PUT",
+			URI:          "/toggles/unknown",
+			body:         []byte("true"),
+			token:        "secret",
+			expectedFunc: func() {},
+			expectedBody: `{"error":"unknown toggle"}`,
+			expectedCode: fasthttp.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testTable {
+		t.Run(tc.tCase, func(t *testing.T) {
+			ctx := initCtx(tc.method, tc.URI, tc.body)
+			ctx.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+tc.token)
+			tc.expectedFunc()
+			env.HandleAdmin(ctx)
+
+			ao.Equal(tc.expectedCode, ctx.Response.StatusCode())
+			ao.Equal(tc.expectedBody, string(ctx.Response.Body()))
+		})
+	}
+}
@@ -0,0 +1,548 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/metrics"
+	"github.com/yexelm/shorty/store"
+)
+
+//go:generate mockgen -source=api.go -destination=api_mocks.go -package=handlers -self_package=shorty/handlers
+
+// Limits of link details.
+const (
+	// maxBatchShorten limits links shortened by a single request.
+	maxBatchShorten = 100
+
+	maxTextLen    = 1024
+	maxNotesLen   = 8192
+	maxTags       = 32
+	maxTagLen     = 64
+	maxAttributes = 32
+)
+
+var (
+	ErrInvalidDate   = errors.New("invalid date, expected RFC 3339 date or time")
+	ErrInvalidJSON   = errors.New("invalid JSON body")
+	ErrInvalidMeta   = errors.New("invalid link details")
+	ErrEmptyLongURL  = errors.New("empty url")
+	ErrBatchTooLarge = errors.New("too many links in the batch")
+
+	apiPrefix = []byte("/api/v1/")
+)
+
+type LinkStore interface {
+	Search(ns store.Namespace, q store.Query) ([]store.Link, string, error)
+	Link(ns store.Namespace, short []byte) (store.Link, error)
+	UpdateMeta(ns store.Namespace, short []byte, patch store.MetaPatch) (store.Link, error)
+	ClickStats(ns store.Namespace, short []byte) (store.ClickStats, error)
+	SetOpenGraph(ns store.Namespace, short []byte, og store.OpenGraph) error
+}
+
+type searchPage struct {
+	Links  []store.Link `json:"links"`
+	Cursor string       `json:"cursor,omitempty"`
+}
+
+type createRequest struct {
+	URL string `json:"url"`
+	// Domain is one of the hosts of the tenant the link is created on, the host of the request is used by default.
+	Domain string `json:"domain"`
+	// Password protects the link, it is saved as a hash only.
+	Password string `json:"password"`
+	// NotBefore and NotAfter are RFC 3339 dates or times limiting the time the link is active.
+	NotBefore string `json:"not_before"`
+	NotAfter  string `json:"not_after"`
+	store.Meta
+}
+
+type patchRequest struct {
+	store.MetaPatch
+	// Password replaces the password of the link, an empty one removes the protection.
+	Password *string `json:"password"`
+	// NotBefore and NotAfter replace the bounds of the time the link is active, empty ones remove them.
+	NotBefore *string `json:"not_before"`
+	NotAfter  *string `json:"not_after"`
+}
+
+type linkResponse struct {
+	ShortURL string `json:"short_url"`
+	store.Link
+}
+
+type batchRequest struct {
+	Links []createRequest `json:"links"`
+}
+
+type batchResponse struct {
+	Results []batchResult `json:"results"`
+}
+
+// batchResult is either the saved link or the reason it was not saved.
+type batchResult struct {
+	Link  *linkResponse `json:"link,omitempty"`
+	Error string        `json:"error,omitempty"`
+}
+
+// api serves the JSON API, which requires an API key. Only links of the tenant the key belongs to are available.
+func (env *Environment) api(ctx *fasthttp.RequestCtx) {
+	owner, ns, code, err := env.authenticate(ctx, true)
+	if err != nil {
+		writeError(ctx, code, err)
+		return
+	}
+	if env.rateLimited(ctx, "api", ns, owner) {
+		writeError(ctx, fasthttp.StatusTooManyRequests, ErrRateLimited)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(string(ctx.Path()[len(apiPrefix):]), "/"), "/")
+
+	switch {
+	case parts[0] == "links" && len(parts) == 1 && ctx.IsGet():
+		env.searchLinks(ctx, ns)
+	case parts[0] == "links" && len(parts) == 1 && ctx.IsPost():
+		env.createLink(ctx, ns, owner)
+	case parts[0] == "links" && len(parts) == 2 && ctx.IsPost() && parts[1] == "batch":
+		env.createLinks(ctx, ns, owner)
+	case parts[0] == "links" && len(parts) == 2 && ctx.IsGet():
+		env.getLink(ctx, ns, parts[1])
+	case parts[0] == "links" && len(parts) == 2 && ctx.IsDelete():
+		env.deleteLink(ctx, ns, parts[1])
+	case parts[0] == "links" && len(parts) == 2 && string(ctx.Method()) == fasthttp.MethodPatch:
+		env.updateLink(ctx, ns, parts[1])
+	case parts[0] == "links" && len(parts) == 3 && parts[2] == "stats" && ctx.IsGet():
+		env.linkStats(ctx, ns, parts[1])
+	default:
+		writeError(ctx, fasthttp.StatusNotFound, ErrNotFound)
+	}
+}
+
+// searchLinks returns a page of links matching the filters passed as query arguments, from newest to oldest.
+func (env *Environment) searchLinks(ctx *fasthttp.RequestCtx, ns store.Namespace) {
+	args := ctx.QueryArgs()
+	q := store.Query{
+		Creator: string(args.Peek("creator")),
+		Tag:     string(args.Peek("tag")),
+		Domain:  string(args.Peek("domain")),
+		Search:  string(args.Peek("q")),
+		Cursor:  string(args.Peek("cursor")),
+	}
+
+	var err error
+	if q.Count, err = parseCount(args.Peek("count")); err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err)
+		return
+	}
+	if q.From, err = parseDate(args.Peek("from"), false); err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err)
+		return
+	}
+	if q.To, err = parseDate(args.Peek("to"), true); err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err)
+		return
+	}
+
+	links, cursor, err := env.Links.Search(ns, q)
+	if err == store.ErrInvalidCursor {
+		writeError(ctx, fasthttp.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		metrics.Errors.WithLabelValues("api").Inc()
+		writeError(ctx, fasthttp.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(ctx, fasthttp.StatusOK, searchPage{Links: links, Cursor: cursor})
+}
+
+// createLink shortens the URL passed in the JSON body along with its details. If the URL has been shortened before,
+// the existing link is returned unchanged.
+func (env *Environment) createLink(ctx *fasthttp.RequestCtx, ns store.Namespace, owner string) {
+	if !env.Toggles.Enabled(ToggleShorten) {
+		writeError(ctx, fasthttp.StatusServiceUnavailable, ErrTemporarilyOff)
+		return
+	}
+
+	var req createRequest
+	if err := json.Unmarshal(ctx.Request.Body(), &req); err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, ErrInvalidJSON)
+		return
+	}
+
+	l, code, err := env.newLink(ctx, ns, owner, req)
+	if err != nil {
+		writeError(ctx, code, err)
+		return
+	}
+
+	writeJSON(ctx, fasthttp.StatusOK, l)
+}
+
+// createLinks shortens up to maxBatchShorten URLs passed in the JSON body like createLink does. The links fail
+// separately, the results are in the order of the requested links.
+func (env *Environment) createLinks(ctx *fasthttp.RequestCtx, ns store.Namespace, owner string) {
+	if !env.Toggles.Enabled(ToggleShorten) {
+		writeError(ctx, fasthttp.StatusServiceUnavailable, ErrTemporarilyOff)
+		return
+	}
+
+	var req batchRequest
+	if err := json.Unmarshal(ctx.Request.Body(), &req); err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, ErrInvalidJSON)
+		return
+	}
+	if len(req.Links) > maxBatchShorten {
+		writeError(ctx, fasthttp.StatusBadRequest, ErrBatchTooLarge)
+		return
+	}
+
+	results := make([]batchResult, len(req.Links))
+	for i, r := range req.Links {
+		l, _, err := env.newLink(ctx, ns, owner, r)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].Link = &l
+	}
+
+	writeJSON(ctx, fasthttp.StatusOK, batchResponse{Results: results})
+}
+
+// newLink saves the requested link and returns it. If the link cannot be saved, the status code of the response is
+// returned along with the reason.
+func (env *Environment) newLink(ctx *fasthttp.RequestCtx, ns store.Namespace, owner string,
+	req createRequest) (linkResponse, int, error) {
+	if req.URL == "" {
+		return linkResponse{}, fasthttp.StatusBadRequest, ErrEmptyLongURL
+	}
+	notBefore, err := parseDate([]byte(req.NotBefore), false)
+	if err != nil {
+		return linkResponse{}, fasthttp.StatusBadRequest, err
+	}
+	notAfter, err := parseDate([]byte(req.NotAfter), true)
+	if err != nil {
+		return linkResponse{}, fasthttp.StatusBadRequest, err
+	}
+	if !notBefore.IsZero() {
+		req.Meta.NotBefore = &notBefore
+	}
+	if !notAfter.IsZero() {
+		req.Meta.NotAfter = &notAfter
+	}
+
+	req.Creator = owner
+
+	ns, short, err := env.saveLink(ns, req.URL, req.Domain, req.Password, req.Meta)
+	if errors.Is(err, ErrInvalidMeta) || err == ErrUnknownDomain {
+		return linkResponse{}, fasthttp.StatusBadRequest, err
+	}
+	if err != nil {
+		code, err := apiError(err)
+		return linkResponse{}, code, err
+	}
+
+	l, err := env.Links.Link(ns, short)
+	if err == nil {
+		short, err = env.shortURL(ctx, ns, short)
+	}
+	if err != nil {
+		code, err := apiError(err)
+		return linkResponse{}, code, err
+	}
+
+	return linkResponse{ShortURL: string(short), Link: l}, fasthttp.StatusOK, nil
+}
+
+// saveLink validates details of the link and shortens the URL on the domain, which is one of the hosts of the tenant
+// of the namespace, or in the namespace itself if the domain is empty. The namespace the link is saved in is returned
+// along with its short alias. Errors wrapping ErrInvalidMeta and ErrUnknownDomain are caused by the request.
+func (env *Environment) saveLink(ns store.Namespace, url, domain, password string,
+	meta store.Meta) (store.Namespace, []byte, error) {
+	if err := validateMeta(meta); err != nil {
+		return ns, nil, err
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return ns, nil, err
+	}
+	meta.PasswordHash = hash
+
+	if domain != "" {
+		if ns, err = env.domainNamespace(ns.Tenant, domain); err != nil {
+			return ns, nil, err
+		}
+	}
+
+	// metadata of destinations is only fetched
+	meta.OpenGraph = nil
+	short, created, err := env.Cache.Shorter(ns, []byte(url), meta)
+	if err != nil {
+		return ns, nil, err
+	}
+	// links returned again have been unfurled and announced when they were created
+	if created {
+		env.unfurl(ns, short, url)
+		env.notify(EventLinkCreated, ns, store.Link{Short: string(short), Long: url}, nil)
+	}
+
+	return ns, short, nil
+}
+
+func (env *Environment) getLink(ctx *fasthttp.RequestCtx, ns store.Namespace, short string) {
+	l, err := env.Links.Link(ns, []byte(short))
+	if err != nil {
+		env.apiFailed(ctx, err)
+		return
+	}
+
+	env.writeLink(ctx, ns, l)
+}
+
+// updateLink edits details of the link. Only the fields present in the JSON body are changed, attributes set to
+// null are removed.
+func (env *Environment) updateLink(ctx *fasthttp.RequestCtx, ns store.Namespace, short string) {
+	var req patchRequest
+	if err := json.Unmarshal(ctx.Request.Body(), &req); err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, ErrInvalidJSON)
+		return
+	}
+	if err := validatePatch(req.MetaPatch); err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err)
+		return
+	}
+
+	patch := req.MetaPatch
+	for _, f := range []struct {
+		dst **time.Time
+		src *string
+		end bool
+	}{
+		{&patch.NotBefore, req.NotBefore, false},
+		{&patch.NotAfter, req.NotAfter, true},
+	} {
+		if f.src == nil {
+			continue
+		}
+		// the zero time of an empty value removes the bound
+		t, err := parseDate([]byte(*f.src), f.end)
+		if err != nil {
+			writeError(ctx, fasthttp.StatusBadRequest, err)
+			return
+		}
+		*f.dst = &t
+	}
+	if err := validateWindow(patch.NotBefore, patch.NotAfter); err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err)
+		return
+	}
+
+	if req.Password != nil {
+		hash, err := hashPassword(*req.Password)
+		if errors.Is(err, ErrInvalidMeta) {
+			writeError(ctx, fasthttp.StatusBadRequest, err)
+			return
+		}
+		if err != nil {
+			env.apiFailed(ctx, err)
+			return
+		}
+		patch.PasswordHash = &hash
+	}
+
+	l, err := env.Links.UpdateMeta(ns, []byte(short), patch)
+	if err != nil {
+		env.apiFailed(ctx, err)
+		return
+	}
+
+	env.writeLink(ctx, ns, l)
+}
+
+// linkStats returns the number of resolutions of the link, and the number of resolutions left if it is limited.
+func (env *Environment) linkStats(ctx *fasthttp.RequestCtx, ns store.Namespace, short string) {
+	stats, err := env.Links.ClickStats(ns, []byte(short))
+	if err != nil {
+		env.apiFailed(ctx, err)
+		return
+	}
+
+	writeJSON(ctx, fasthttp.StatusOK, stats)
+}
+
+// writeLink writes the link along with its short URL.
+func (env *Environment) writeLink(ctx *fasthttp.RequestCtx, ns store.Namespace, l store.Link) {
+	short, err := env.shortURL(ctx, ns, []byte(l.Short))
+	if err != nil {
+		env.apiFailed(ctx, err)
+		return
+	}
+
+	writeJSON(ctx, fasthttp.StatusOK, linkResponse{ShortURL: string(short), Link: l})
+}
+
+// deleteLink deletes the link.
+func (env *Environment) deleteLink(ctx *fasthttp.RequestCtx, ns store.Namespace, short string) {
+	if err := env.Admin.Delete(ns, []byte(short)); err != nil {
+		env.apiFailed(ctx, err)
+		return
+	}
+	env.notify(EventLinkDeleted, ns, store.Link{Short: short}, nil)
+
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
+
+// apiFailed writes the response for a failed storage operation.
+func (env *Environment) apiFailed(ctx *fasthttp.RequestCtx, err error) {
+	code, err := apiError(err)
+	writeError(ctx, code, err)
+}
+
+// apiError returns the status code and the reason of the response for a failed storage operation.
+func apiError(err error) (int, error) {
+	switch err {
+	case redis.ErrNil:
+		return fasthttp.StatusNotFound, ErrShortCodeNotFound
+	case store.ErrInvalidAlias:
+		return fasthttp.StatusBadRequest, err
+	case store.ErrConflict, store.ErrURLTaken:
+		return fasthttp.StatusConflict, err
+	case store.ErrQuotaExceeded:
+		return fasthttp.StatusForbidden, err
+	default:
+		metrics.Errors.WithLabelValues("api").Inc()
+		return fasthttp.StatusInternalServerError, err
+	}
+}
+
+// validateMeta checks link details against the limits.
+func validateMeta(m store.Meta) error {
+	switch {
+	case len(m.Title) > maxTextLen:
+		return fmt.Errorf("%w: title is longer than %d bytes", ErrInvalidMeta, maxTextLen)
+	case len(m.Description) > maxTextLen:
+		return fmt.Errorf("%w: description is longer than %d bytes", ErrInvalidMeta, maxTextLen)
+	case len(m.Notes) > maxNotesLen:
+		return fmt.Errorf("%w: notes are longer than %d bytes", ErrInvalidMeta, maxNotesLen)
+	case len(m.Tags) > maxTags:
+		return fmt.Errorf("%w: more than %d tags", ErrInvalidMeta, maxTags)
+	case len(m.Attributes) > maxAttributes:
+		return fmt.Errorf("%w: more than %d attributes", ErrInvalidMeta, maxAttributes)
+	case m.MaxClicks < 0:
+		return fmt.Errorf("%w: max clicks cannot be negative", ErrInvalidMeta)
+	case len(m.FallbackURL) > maxNotesLen:
+		return fmt.Errorf("%w: fallback URL is longer than %d bytes", ErrInvalidMeta, maxNotesLen)
+	}
+	if err := validateWindow(m.NotBefore, m.NotAfter); err != nil {
+		return err
+	}
+	if err := validateRules(m.Rules); err != nil {
+		return err
+	}
+	if err := validateVariants(m.Variants); err != nil {
+		return err
+	}
+	if err := validateUTM(m.UTM); err != nil {
+		return err
+	}
+
+	for _, t := range m.Tags {
+		if len(t) > maxTagLen {
+			return fmt.Errorf("%w: tag is longer than %d bytes", ErrInvalidMeta, maxTagLen)
+		}
+	}
+	for k, v := range m.Attributes {
+		if k == "" || len(k) > maxTagLen || len(v) > maxTextLen {
+			return fmt.Errorf("%w: attribute %q is empty or too long", ErrInvalidMeta, k)
+		}
+	}
+
+	return nil
+}
+
+// validatePatch checks the changed link details against the limits.
+func validatePatch(p store.MetaPatch) error {
+	var m store.Meta
+	for _, f := range []struct {
+		dst *string
+		src *string
+	}{
+		{&m.Title, p.Title},
+		{&m.Description, p.Description},
+		{&m.Notes, p.Notes},
+	} {
+		if f.src != nil {
+			*f.dst = *f.src
+		}
+	}
+	if p.Tags != nil {
+		m.Tags = *p.Tags
+	}
+	if p.MaxClicks != nil {
+		m.MaxClicks = *p.MaxClicks
+	}
+	if p.FallbackURL != nil {
+		m.FallbackURL = *p.FallbackURL
+	}
+	if p.Rules != nil {
+		m.Rules = *p.Rules
+	}
+	if p.Variants != nil {
+		m.Variants = *p.Variants
+	}
+	if p.UTM != nil {
+		m.UTM = *p.UTM
+	}
+
+	m.Attributes = make(map[string]string, len(p.Attributes))
+	for k, v := range p.Attributes {
+		if v != nil {
+			m.Attributes[k] = *v
+		}
+	}
+
+	return validateMeta(m)
+}
+
+// validateWindow checks that the link stops being active after it becomes active.
+func validateWindow(notBefore, notAfter *time.Time) error {
+	if notBefore == nil || notAfter == nil || notBefore.IsZero() || notAfter.IsZero() {
+		return nil
+	}
+	if !notAfter.After(*notBefore) {
+		return fmt.Errorf("%w: not_after must be later than not_before", ErrInvalidMeta)
+	}
+
+	return nil
+}
+
+// parseDate parses RFC 3339 time or date. A date given as the end of a range covers the whole day.
+func parseDate(v []byte, end bool) (time.Time, error) {
+	if len(v) == 0 {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, string(v)); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse("2006-01-02", string(v))
+	if err != nil {
+		return time.Time{}, ErrInvalidDate
+	}
+	if end {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+
+	return t, nil
+}
@@ -0,0 +1,110 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: api.go
+
+// Package handlers is a generated GoMock package.
+package handlers
+
+import (
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+	store "github.com/yexelm/shorty/store"
+)
+
+// MockLinkStore is a mock of LinkStore interface.
+type MockLinkStore struct {
+	ctrl     *gomock.Controller
+	recorder *MockLinkStoreMockRecorder
+}
+
+// MockLinkStoreMockRecorder is the mock recorder for MockLinkStore.
+type MockLinkStoreMockRecorder struct {
+	mock *MockLinkStore
+}
+
+// NewMockLinkStore creates a new mock instance.
+func NewMockLinkStore(ctrl *gomock.Controller) *MockLinkStore {
+	mock := &MockLinkStore{ctrl: ctrl}
+	mock.recorder = &MockLinkStoreMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockLinkStore) EXPECT() *MockLinkStoreMockRecorder {
+	return m.recorder
+}
+
+// ClickStats mocks base method.
+func (m *MockLinkStore) ClickStats(ns store.Namespace, short []byte) (store.ClickStats, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClickStats", ns, short)
+	ret0, _ := ret[0].(store.ClickStats)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClickStats indicates an expected call of ClickStats.
+func (mr *MockLinkStoreMockRecorder) ClickStats(ns, short interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClickStats", reflect.TypeOf((*MockLinkStore)(nil).ClickStats), ns, short)
+}
+
+// Link mocks base method.
+func (m *MockLinkStore) Link(ns store.Namespace, short []byte) (store.Link, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Link", ns, short)
+	ret0, _ := ret[0].(store.Link)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Link indicates an expected call of Link.
+func (mr *MockLinkStoreMockRecorder) Link(ns, short interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Link", reflect.TypeOf((*MockLinkStore)(nil).Link), ns, short)
+}
+
+// Search mocks base method.
+func (m *MockLinkStore) Search(ns store.Namespace, q store.Query) ([]store.Link, string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Search", ns, q)
+	ret0, _ := ret[0].([]store.Link)
+	ret1, _ := ret[1].(string)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// Search indicates an expected call of Search.
+func (mr *MockLinkStoreMockRecorder) Search(ns, q interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Search", reflect.TypeOf((*MockLinkStore)(nil).Search), ns, q)
+}
+
+// SetOpenGraph mocks base method.
+func (m *MockLinkStore) SetOpenGraph(ns store.Namespace, short []byte, og store.OpenGraph) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetOpenGraph", ns, short, og)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetOpenGraph indicates an expected call of SetOpenGraph.
+func (mr *MockLinkStoreMockRecorder) SetOpenGraph(ns, short, og interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetOpenGraph", reflect.TypeOf((*MockLinkStore)(nil).SetOpenGraph), ns, short, og)
+}
+
+// UpdateMeta mocks base method.
+func (m *MockLinkStore) UpdateMeta(ns store.Namespace, short []byte, patch store.MetaPatch) (store.Link, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateMeta", ns, short, patch)
+	ret0, _ := ret[0].(store.Link)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateMeta indicates an expected call of UpdateMeta.
+func (mr *MockLinkStoreMockRecorder) UpdateMeta(ns, short, patch interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateMeta", reflect.TypeOf((*MockLinkStore)(nil).UpdateMeta), ns, short, patch)
+}
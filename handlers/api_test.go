@@ -0,0 +1,428 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/store"
+)
+
+func Test_searchLinks(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+	mockEnv.withoutTenants()
+
+	created := time.Date(2021, 3, 2, 10, 0, 0, 0, time.UTC)
+
+	type testData struct {
+		tCase        string
+		URI          string
+		apiKey       string
+		expectedFunc func()
+
+		expectedBody string
+		expectedCode int
+	}
+
+	testTable := []testData{
+		{
+			tCase:        "API key required",
+			URI:          "/api/v1/links",
+			expectedFunc: func() {},
+			expectedBody: `{"error":"API key required"}`,
+			expectedCode: fasthttp.StatusUnauthorized,
+		},
+		{
+			tCase:  "invalid API key",
+			URI:    "/api/v1/links",
+			apiKey: "unknown",
+			expectedFunc: func() {
+				mockEnv.Keys.EXPECT().Authenticate("unknown").Return(store.Principal{}, redis.ErrNil)
+			},
+			expectedBody: `{"error":"invalid API key"}`,
+			expectedCode: fasthttp.StatusUnauthorized,
+		},
+		{
+			tCase:  "unknown endpoint",
+			URI:    "/api/v1/unknown",
+			apiKey: "key",
+			expectedFunc: func() {
+				mockEnv.Keys.EXPECT().Authenticate("key").Return(store.Principal{Owner: "team"}, nil)
+			},
+			expectedBody: `{"error":"not found"}`,
+			expectedCode: fasthttp.StatusNotFound,
+		},
+		{
+			tCase:  "invalid date",
+			URI:    "/api/v1/links?from=yesterday",
+			apiKey: "key",
+			expectedFunc: func() {
+				mockEnv.Keys.EXPECT().Authenticate("key").Return(store.Principal{Owner: "team"}, nil)
+			},
+			expectedBody: `{"error":"invalid date, expected RFC 3339 date or time"}`,
+			expectedCode: fasthttp.StatusBadRequest,
+		},
+		{
+			tCase:  "invalid cursor",
+			URI:    "/api/v1/links?cursor=-",
+			apiKey: "key",
+			expectedFunc: func() {
+				mockEnv.Keys.EXPECT().Authenticate("key").Return(store.Principal{Owner: "team"}, nil)
+				mockEnv.Links.EXPECT().Search(store.Namespace{}, store.Query{Cursor: "-", Count: defaultPageSize}).
+					Return(nil, "", store.ErrInvalidCursor)
+			},
+			expectedBody: `{"error":"invalid cursor"}`,
+			expectedCode: fasthttp.StatusBadRequest,
+		},
+		{
+			tCase:  "storage error",
+			URI:    "/api/v1/links",
+			apiKey: "key",
+			expectedFunc: func() {
+				mockEnv.Keys.EXPECT().Authenticate("key").Return(store.Principal{Owner: "team"}, nil)
+				mockEnv.Links.EXPECT().Search(store.Namespace{}, store.Query{Count: defaultPageSize}).
+					Return(nil, "", errors.New("some error"))
+			},
+			expectedBody: `{"error":"some error"}`,
+			expectedCode: fasthttp.StatusInternalServerError,
+		},
+		{
+			tCase:  "success",
+			URI:    "/api/v1/links?creator=team&tag=go&domain=go.dev&q=doc&from=2021-03-01&to=2021-03-02&cursor=e&count=1",
+			apiKey: "key",
+			expectedFunc: func() {
+				mockEnv.Keys.EXPECT().Authenticate("key").Return(store.Principal{Owner: "team"}, nil)
+				mockEnv.Links.EXPECT().Search(store.Namespace{}, store.Query{
+					Creator: "team",
+					Tag:     "go",
+					Domain:  "go.dev",
+					From:    time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC),
+					To:      time.Date(2021, 3, 3, 0, 0, 0, 0, time.UTC).Add(-time.Nanosecond),
+					Search:  "doc",
+					Cursor:  "e",
+					Count:   1,
+				}).Return([]store.Link{
+					{Short: "d", Long: "https://go.dev/doc", CreatedAt: created, Meta: store.Meta{Creator: "team"}},
+				}, "d", nil)
+			},
+			expectedBody: `{"links":[{"short":"d","long":"https://go.dev/doc","disabled":false,` +
+				`"created_at":"2021-03-02T10:00:00Z","creator":"team"}],"cursor":"d"}`,
+			expectedCode: fasthttp.StatusOK,
+		},
+	}
+
+	for _, tc := range testTable {
+		t.Run(tc.tCase, func(t *testing.T) {
+			ctx := initCtx("GET", tc.URI, nil)
+			if tc.apiKey != "" {
+				ctx.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+tc.apiKey)
+			}
+			tc.expectedFunc()
+			env.Handle(ctx)
+
+			ao.Equal(tc.expectedCode, ctx.Response.StatusCode())
+			ao.Equal(tc.expectedBody, string(ctx.Response.Body()))
+		})
+	}
+}
+
+func Test_linkDetails(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+	mockEnv.withoutTenants()
+
+	created := time.Date(2021, 3, 2, 10, 0, 0, 0, time.UTC)
+	title := "Go docs"
+	launch := time.Date(2021, 4, 1, 9, 0, 0, 0, time.UTC)
+	end := time.Date(2021, 4, 30, 23, 59, 59, 999999999, time.UTC)
+	link := store.Link{
+		Short:     "b",
+		Long:      "https://go.dev/doc",
+		CreatedAt: created,
+		Meta:      store.Meta{Creator: "team", Title: title, Tags: []string{"go"}},
+	}
+	linkJSON := `{"short_url":"http://host.com/b","short":"b","long":"https://go.dev/doc","disabled":false,` +
+		`"created_at":"2021-03-02T10:00:00Z","creator":"team","title":"Go docs","tags":["go"]}`
+
+	type testData struct {
+		tCase        string
+		method       string
+		URI          string
+		body         string
+		expectedFunc func()
+
+		expectedBody string
+		expectedCode int
+	}
+
+	testTable := []testData{
+		{
+			tCase:        "create with invalid JSON",
+			method:       "POST",
+			URI:          "http://host.com/api/v1/links",
+			body:         `{"url":`,
+			expectedFunc: func() {},
+			expectedBody: `{"error":"invalid JSON body"}`,
+			expectedCode: fasthttp.StatusBadRequest,
+		},
+		{
+			tCase:        "create without URL",
+			method:       "POST",
+			URI:          "http://host.com/api/v1/links",
+			body:         `{"title":"Go docs"}`,
+			expectedFunc: func() {},
+			expectedBody: `{"error":"empty url"}`,
+			expectedCode: fasthttp.StatusBadRequest,
+		},
+		{
+			tCase:        "create with too long tag",
+			method:       "POST",
+			URI:          "http://host.com/api/v1/links",
+			body:         `{"url":"https://go.dev/doc","tags":["` + strings.Repeat("a", maxTagLen+1) + `"]}`,
+			expectedFunc: func() {},
+			expectedBody: `{"error":"invalid link details: tag is longer than 64 bytes"}`,
+			expectedCode: fasthttp.StatusBadRequest,
+		},
+		{
+			tCase:  "create",
+			method: "POST",
+			URI:    "http://host.com/api/v1/links",
+			body:   `{"url":"https://go.dev/doc","title":"Go docs","tags":["go"],"creator":"someone else"}`,
+			expectedFunc: func() {
+				mockEnv.Cache.EXPECT().Shorter(store.Namespace{}, []byte("https://go.dev/doc"), store.Meta{
+					Creator: "team",
+					Title:   title,
+					Tags:    []string{"go"},
+				}).Return([]byte("b"), true, nil)
+				mockEnv.Links.EXPECT().Link(store.Namespace{}, []byte("b")).Return(link, nil)
+			},
+			expectedBody: linkJSON,
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:        "create with too long password",
+			method:       "POST",
+			URI:          "http://host.com/api/v1/links",
+			body:         `{"url":"https://go.dev/doc","password":"` + strings.Repeat("a", maxPasswordLen+1) + `"}`,
+			expectedFunc: func() {},
+			expectedBody: `{"error":"invalid link details: password is longer than 72 bytes"}`,
+			expectedCode: fasthttp.StatusBadRequest,
+		},
+		{
+			tCase:  "create with password",
+			method: "POST",
+			URI:    "http://host.com/api/v1/links",
+			body:   `{"url":"https://go.dev/doc","title":"Go docs","tags":["go"],"password":"s3cret"}`,
+			expectedFunc: func() {
+				mockEnv.Cache.EXPECT().Shorter(store.Namespace{}, []byte("https://go.dev/doc"), gomock.Any()).
+					DoAndReturn(func(_ store.Namespace, _ []byte, meta store.Meta) ([]byte, bool, error) {
+						ao.True(store.CheckPassword(meta.PasswordHash, "s3cret"), "the password must be saved hashed")
+						return []byte("b"), true, nil
+					})
+				mockEnv.Links.EXPECT().Link(store.Namespace{}, []byte("b")).Return(link, nil)
+			},
+			expectedBody: linkJSON,
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:        "create with invalid activation window",
+			method:       "POST",
+			URI:          "http://host.com/api/v1/links",
+			body:         `{"url":"https://go.dev/doc","not_before":"2021-04-02","not_after":"2021-04-01"}`,
+			expectedFunc: func() {},
+			expectedBody: `{"error":"invalid link details: not_after must be later than not_before"}`,
+			expectedCode: fasthttp.StatusBadRequest,
+		},
+		{
+			tCase:  "create with activation window",
+			method: "POST",
+			URI:    "http://host.com/api/v1/links",
+			body:   `{"url":"https://go.dev/doc","not_before":"2021-04-01T09:00:00Z","not_after":"2021-04-30","fallback_url":"https://go.dev"}`,
+			expectedFunc: func() {
+				mockEnv.Cache.EXPECT().Shorter(store.Namespace{}, []byte("https://go.dev/doc"), store.Meta{
+					Creator:     "team",
+					NotBefore:   &launch,
+					NotAfter:    &end,
+					FallbackURL: "https://go.dev",
+				}).Return([]byte("b"), true, nil)
+				mockEnv.Links.EXPECT().Link(store.Namespace{}, []byte("b")).Return(link, nil)
+			},
+			expectedBody: linkJSON,
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:  "get unknown link",
+			method: "GET",
+			URI:    "http://host.com/api/v1/links/z",
+			expectedFunc: func() {
+				mockEnv.Links.EXPECT().Link(store.Namespace{}, []byte("z")).Return(store.Link{}, redis.ErrNil)
+			},
+			expectedBody: `{"error":"the requested short code not found"}`,
+			expectedCode: fasthttp.StatusNotFound,
+		},
+		{
+			tCase:  "get",
+			method: "GET",
+			URI:    "http://host.com/api/v1/links/b",
+			expectedFunc: func() {
+				mockEnv.Links.EXPECT().Link(store.Namespace{}, []byte("b")).Return(link, nil)
+			},
+			expectedBody: linkJSON,
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:  "update",
+			method: "PATCH",
+			URI:    "http://host.com/api/v1/links/b",
+			body:   `{"title":"Go docs","attributes":{"cost":null}}`,
+			expectedFunc: func() {
+				mockEnv.Links.EXPECT().UpdateMeta(store.Namespace{}, []byte("b"), store.MetaPatch{
+					Title:      &title,
+					Attributes: map[string]*string{"cost": nil},
+				}).Return(link, nil)
+			},
+			expectedBody: linkJSON,
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:  "remove password",
+			method: "PATCH",
+			URI:    "http://host.com/api/v1/links/b",
+			body:   `{"password":""}`,
+			expectedFunc: func() {
+				noPassword := ""
+				mockEnv.Links.EXPECT().UpdateMeta(store.Namespace{}, []byte("b"), store.MetaPatch{PasswordHash: &noPassword}).
+					Return(link, nil)
+			},
+			expectedBody: linkJSON,
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:        "update with negative max clicks",
+			method:       "PATCH",
+			URI:          "http://host.com/api/v1/links/b",
+			body:         `{"max_clicks":-1}`,
+			expectedFunc: func() {},
+			expectedBody: `{"error":"invalid link details: max clicks cannot be negative"}`,
+			expectedCode: fasthttp.StatusBadRequest,
+		},
+		{
+			tCase:  "update activation window",
+			method: "PATCH",
+			URI:    "http://host.com/api/v1/links/b",
+			body:   `{"not_before":"2021-04-01T09:00:00Z","not_after":""}`,
+			expectedFunc: func() {
+				mockEnv.Links.EXPECT().UpdateMeta(store.Namespace{}, []byte("b"), store.MetaPatch{
+					NotBefore: &launch,
+					NotAfter:  &time.Time{},
+				}).Return(link, nil)
+			},
+			expectedBody: linkJSON,
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:  "stats",
+			method: "GET",
+			URI:    "http://host.com/api/v1/links/b/stats",
+			expectedFunc: func() {
+				left := 0
+				mockEnv.Links.EXPECT().ClickStats(store.Namespace{}, []byte("b")).
+					Return(store.ClickStats{Clicks: 1, MaxClicks: 1, ClicksLeft: &left}, nil)
+			},
+			expectedBody: `{"clicks":1,"max_clicks":1,"clicks_left":0}`,
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:  "update conflict",
+			method: "PATCH",
+			URI:    "http://host.com/api/v1/links/b",
+			body:   `{"title":"Go docs"}`,
+			expectedFunc: func() {
+				mockEnv.Links.EXPECT().UpdateMeta(store.Namespace{}, []byte("b"), store.MetaPatch{Title: &title}).
+					Return(store.Link{}, store.ErrConflict)
+			},
+			expectedBody: `{"error":"the link is being modified concurrently, try again"}`,
+			expectedCode: fasthttp.StatusConflict,
+		},
+		{
+			tCase:  "update invalid alias",
+			method: "PATCH",
+			URI:    "http://host.com/api/v1/links/b-c",
+			body:   `{"title":"Go docs"}`,
+			expectedFunc: func() {
+				mockEnv.Links.EXPECT().UpdateMeta(store.Namespace{}, []byte("b-c"), store.MetaPatch{Title: &title}).
+					Return(store.Link{}, store.ErrInvalidAlias)
+			},
+			expectedBody: `{"error":"invalid short alias"}`,
+			expectedCode: fasthttp.StatusBadRequest,
+		},
+		{
+			tCase:  "create batch",
+			method: "POST",
+			URI:    "http://host.com/api/v1/links/batch",
+			body:   `{"links":[{"url":"https://go.dev/doc","title":"Go docs","tags":["go"]},{"title":"Go docs"}]}`,
+			expectedFunc: func() {
+				mockEnv.Cache.EXPECT().Shorter(store.Namespace{}, []byte("https://go.dev/doc"), store.Meta{
+					Creator: "team",
+					Title:   title,
+					Tags:    []string{"go"},
+				}).Return([]byte("b"), true, nil)
+				mockEnv.Links.EXPECT().Link(store.Namespace{}, []byte("b")).Return(link, nil)
+			},
+			expectedBody: `{"results":[{"link":` + linkJSON + `},{"error":"empty url"}]}`,
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:        "create too large batch",
+			method:       "POST",
+			URI:          "http://host.com/api/v1/links/batch",
+			body:         `{"links":[` + strings.Repeat(`{},`, maxBatchShorten) + `{}]}`,
+			expectedFunc: func() {},
+			expectedBody: `{"error":"too many links in the batch"}`,
+			expectedCode: fasthttp.StatusBadRequest,
+		},
+		{
+			tCase:  "delete",
+			method: "DELETE",
+			URI:    "http://host.com/api/v1/links/b",
+			expectedFunc: func() {
+				mockEnv.Admin.EXPECT().Delete(store.Namespace{}, []byte("b")).Return(nil)
+			},
+			expectedCode: fasthttp.StatusNoContent,
+		},
+		{
+			tCase:  "delete unknown link",
+			method: "DELETE",
+			URI:    "http://host.com/api/v1/links/c",
+			expectedFunc: func() {
+				mockEnv.Admin.EXPECT().Delete(store.Namespace{}, []byte("c")).Return(redis.ErrNil)
+			},
+			expectedBody: `{"error":"the requested short code not found"}`,
+			expectedCode: fasthttp.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testTable {
+		t.Run(tc.tCase, func(t *testing.T) {
+			ctx := initCtx(tc.method, tc.URI, []byte(tc.body))
+			ctx.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer key")
+			mockEnv.Keys.EXPECT().Authenticate("key").Return(store.Principal{Owner: "team"}, nil)
+			tc.expectedFunc()
+			env.Handle(ctx)
+
+			ao.Equal(tc.expectedCode, ctx.Response.StatusCode())
+			ao.Equal(tc.expectedBody, string(ctx.Response.Body()))
+		})
+	}
+}
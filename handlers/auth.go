@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/metrics"
+	"github.com/yexelm/shorty/store"
+)
+
+//go:generate mockgen -source=auth.go -destination=auth_mocks.go -package=handlers -self_package=shorty/handlers
+
+var (
+	ErrAPIKeyRequired     = errors.New("API key required")
+	ErrInvalidAPIKey      = errors.New("invalid API key")
+	ErrUnauthorized       = errors.New("unauthorized")
+	ErrForeignAPIKey      = errors.New("API key belongs to another tenant")
+	ErrClientCertRequired = errors.New("client certificate required")
+)
+
+type Authenticator interface {
+	Authenticate(key string) (store.Principal, error)
+}
+
+// bearerToken returns the token passed via the Authorization header or an empty string.
+func bearerToken(ctx *fasthttp.RequestCtx) string {
+	const prefix = "Bearer "
+
+	h := ctx.Request.Header.Peek(fasthttp.HeaderAuthorization)
+	if !bytes.HasPrefix(h, []byte(prefix)) {
+		return ""
+	}
+
+	return string(bytes.TrimSpace(h[len(prefix):]))
+}
+
+// authenticate returns the owner of the API key passed with the request along with the namespace the request works
+// in. Requests without a key are allowed with an empty owner in the default namespace unless the key is required by
+// the caller or by configuration, hosts of tenants always require one. Keys of tenants work in the namespace of the host if it is served by the tenant
+// and in the namespace of its default host otherwise, keys of other tenants are rejected on hosts of a tenant. If the
+// request is rejected, the status code and the reason are returned. With mutual TLS, requests must come with a
+// verified client certificate, which the listener only asks visitors for, so that short links work without one.
+func (env *Environment) authenticate(ctx *fasthttp.RequestCtx, required bool) (string, store.Namespace, int, error) {
+	if env.Config.TLSEnabled() && env.Config.TLSClientCAFile != "" && !verifiedClient(ctx) {
+		return "", store.Namespace{}, fasthttp.StatusUnauthorized, ErrClientCertRequired
+	}
+
+	return env.authenticateKey(env.requestHost(ctx), bearerToken(ctx), required)
+}
+
+// verifiedClient reports whether the client presented a certificate signed by one of the client CAs.
+func verifiedClient(ctx *fasthttp.RequestCtx) bool {
+	state := ctx.TLSConnectionState()
+	return state != nil && len(state.VerifiedChains) > 0
+}
+
+// authenticateKey is authenticate for the API key passed with a request to the host.
+func (env *Environment) authenticateKey(host, key string, required bool) (string, store.Namespace, int, error) {
+	hostNS, err := env.Tenants.NamespaceByHost(host)
+	if err != nil {
+		metrics.Errors.WithLabelValues("auth").Inc()
+		return "", store.Namespace{}, fasthttp.StatusInternalServerError, err
+	}
+
+	if key == "" {
+		if required || env.Config.RequireAPIKey || hostNS.Tenant != "" {
+			return "", store.Namespace{}, fasthttp.StatusUnauthorized, ErrAPIKeyRequired
+		}
+		return "", hostNS, fasthttp.StatusOK, nil
+	}
+
+	p, err := env.Keys.Authenticate(key)
+	if err == redis.ErrNil {
+		return "", store.Namespace{}, fasthttp.StatusUnauthorized, ErrInvalidAPIKey
+	}
+
+	if err != nil {
+		metrics.Errors.WithLabelValues("auth").Inc()
+		return "", store.Namespace{}, fasthttp.StatusInternalServerError, err
+	}
+
+	switch hostNS.Tenant {
+	case p.Tenant:
+		return p.Owner, hostNS, fasthttp.StatusOK, nil
+	case "":
+		return p.Owner, p.Namespace(), fasthttp.StatusOK, nil
+	default:
+		return "", store.Namespace{}, fasthttp.StatusForbidden, ErrForeignAPIKey
+	}
+}
+
+// adminAuthorized reports whether the request carries the admin token. Any request is authorized if the token is
+// not configured, relying on the admin listener being bound to a private address.
+func (env *Environment) adminAuthorized(ctx *fasthttp.RequestCtx) bool {
+	if env.Config.AdminToken == "" {
+		return true
+	}
+
+	return subtle.ConstantTimeCompare([]byte(bearerToken(ctx)), []byte(env.Config.AdminToken)) == 1
+}
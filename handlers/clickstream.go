@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/clickstream"
+	"github.com/yexelm/shorty/config"
+	"github.com/yexelm/shorty/store"
+)
+
+// ClickPublisher publishes click events without blocking.
+type ClickPublisher interface {
+	Publish(e clickstream.Event)
+}
+
+// newClickSink returns the sink of click events selected by the configuration, or nil if publishing is disabled.
+func newClickSink(cfg *config.Config, s *store.Storage) (clickstream.Sink, error) {
+	switch cfg.ClickSink {
+	case "":
+		return nil, nil
+	case "file":
+		return clickstream.NewFileSink(cfg.ClickSinkFile, int64(cfg.ClickSinkFileMaxBytes), cfg.ClickSinkFileMaxFiles)
+	case "redis":
+		return clickstream.NewRedisSink(s.Pool, cfg.ClickSinkStream, cfg.ClickSinkStreamMaxLen), nil
+	case "kafka":
+		return clickstream.NewKafkaSink(cfg.ClickSinkKafkaBrokers, cfg.ClickSinkKafkaTopic), nil
+	default:
+		return nil, fmt.Errorf("unknown click sink %q", cfg.ClickSink)
+	}
+}
+
+// StartClickstream starts publishing click events to the sink, the buffered events are written by Close.
+func (env *Environment) StartClickstream(sink clickstream.Sink) {
+	cfg := env.Config
+	p := clickstream.NewPublisher(sink, cfg.ClickBuffer, cfg.ClickBatch, cfg.ClickFlushInterval, cfg.ClickWriteTimeout)
+
+	env.Clicks = p
+	env.OnClose(func() {
+		p.Close()
+	})
+}
+
+// publishClick publishes the event of the visit to the link if publishing is enabled.
+func (env *Environment) publishClick(ctx *fasthttp.RequestCtx, ns store.Namespace, l store.Link, v store.Visit) {
+	if env.Clicks == nil {
+		return
+	}
+
+	env.Clicks.Publish(clickstream.Event{
+		At:        time.Now().UTC(),
+		Tenant:    ns.Tenant,
+		Domain:    ns.Domain,
+		Short:     l.Short,
+		Long:      l.Long,
+		Country:   v.Country,
+		Variant:   v.Variant,
+		Referer:   string(ctx.Referer()),
+		UserAgent: string(ctx.UserAgent()),
+	})
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/clickstream"
+	"github.com/yexelm/shorty/store"
+)
+
+// published is a ClickPublisher keeping published events.
+type published []clickstream.Event
+
+func (p *published) Publish(e clickstream.Event) {
+	*p = append(*p, e)
+}
+
+func Test_publishClick(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+	mockEnv.withoutTenants()
+
+	events := &published{}
+	env.Clicks = events
+	env.Geo = countries{"81.2.69.142": "GB"}
+
+	link := store.Link{Short: "b", Long: "https://example.com"}
+	limited := store.Link{Short: "c", Long: "https://example.com", Meta: store.Meta{MaxClicks: 1}}
+	mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("b")).Return(link, nil).Times(2)
+	mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("c")).Return(limited, nil)
+	mockEnv.Cache.EXPECT().Click(store.Namespace{}, link, store.Visit{Country: "GB"}).Return(nil)
+	mockEnv.Cache.EXPECT().Click(store.Namespace{}, link, store.Visit{Country: "GB"}).Return(errors.New("timeout"))
+	mockEnv.Cache.EXPECT().Click(store.Namespace{}, limited, store.Visit{Country: "GB"}).Return(store.ErrExhausted)
+
+	// clicks are published even if they cannot be counted, but not when the link is not resolved
+	for _, short := range []string{"b", "b", "c"} {
+		ctx := initCtx("GET", "http://host.com/"+short, nil)
+		fromAddr(ctx, "81.2.69.142")
+		ctx.Request.Header.SetReferer("https://news.example.com")
+		ctx.Request.Header.SetUserAgent("Mozilla/5.0")
+		env.Handle(ctx)
+		if short == "c" {
+			ao.Equal(fasthttp.StatusGone, ctx.Response.StatusCode())
+		}
+	}
+
+	ao.Len(*events, 2)
+	for _, e := range *events {
+		ao.False(e.At.IsZero())
+		ao.Equal("b", e.Short)
+		ao.Equal("https://example.com", e.Long)
+		ao.Equal("GB", e.Country)
+		ao.Equal("https://news.example.com", e.Referer)
+		ao.Equal("Mozilla/5.0", e.UserAgent)
+	}
+}
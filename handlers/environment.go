@@ -1,28 +1,184 @@
 package handlers
 
 import (
+	"html/template"
 	"log"
+	"sync/atomic"
 
 	"github.com/yexelm/shorty/config"
+	"github.com/yexelm/shorty/geoip"
+	"github.com/yexelm/shorty/opengraph"
 	"github.com/yexelm/shorty/store"
 )
 
 type Environment struct {
-	Config *config.Config
-	Cache  LongerShorter
+	Config    *config.Config
+	Cache     LongerShorter
+	Admin     Admin
+	Links     LinkStore
+	Keys      Authenticator
+	Tenants   TenantResolver
+	Passwords PasswordGuard
+	Webhooks  WebhookStore
+	// Geo finds countries of visitors, it is nil if no GeoIP database is configured.
+	Geo GeoLocator
+	// Clicks publishes click events, it is nil if no click sink is configured.
+	Clicks  ClickPublisher
+	Toggles *Toggles
+
+	// secret signs cookies of visitors who unlocked password protected links.
+	secret []byte
+	// inactivePage is served for links outside of their active time, plain text errors are served if it is nil.
+	inactivePage *template.Template
+	// previewPage shows destinations of links, the default page is used if it is nil.
+	previewPage *template.Template
+	// unfurler fetches metadata of destinations of new links, it is nil if fetching is disabled.
+	unfurler *unfurler
+	// notifier sends events to webhooks, it is nil until webhooks are started.
+	notifier *notifier
+	// limiter limits requests to the API of each client, it is nil if requests are not limited.
+	limiter *rateLimiter
+
+	ready    int32
+	inFlight int64
+	closers  []func()
+	// releasers close resources used by handlers, e.g. connections to Redis.
+	releasers []func()
 }
 
 func LoadEnvironment() *Environment {
 	cfg := config.New()
-	cache, err := store.New(cfg.RedisURL, cfg.DbNum)
+	cache, err := store.New(cfg.RedisURL, cfg.DbNum, cfg.KeyPrefix)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.MigrateOnStart {
+		migrate(cache)
+	}
+	page, err := loadInactivePage(cfg.InactivePageFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	preview, err := loadPreviewPage(cfg.PreviewPageFile)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	env := Environment{
-		Config: cfg,
-		Cache:  cache,
+		Config:    cfg,
+		Cache:     cache,
+		Admin:     cache,
+		Links:     cache,
+		Keys:      cache,
+		Tenants:   cache,
+		Passwords: cache,
+		Webhooks:  cache,
+		Toggles:   NewToggles(),
+		secret:    newSecret(cfg.CookieSecret),
+
+		inactivePage: page,
+		previewPage:  preview,
+		limiter:      newRateLimiter(cfg.RateLimit, cfg.RateLimitBurst),
+	}
+	if cfg.GeoIPFile != "" {
+		db, err := geoip.Open(cfg.GeoIPFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		env.Geo = db
+	}
+	env.OnRelease(cache.Close)
+	env.StartWebhooks()
+	sink, err := newClickSink(cfg, cache)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if sink != nil {
+		env.StartClickstream(sink)
+	}
+	if cfg.Unfurl {
+		env.StartUnfurling(opengraph.NewFetcher(cfg.UnfurlTimeout, int64(cfg.UnfurlMaxBytes)), cfg.UnfurlWorkers,
+			cfg.UnfurlTimeout)
 	}
 
 	return &env
 }
+
+// migrate applies pending migrations of the stored data, logging their progress. Instances starting while another one
+// applies migrations serve the data as it is.
+func migrate(st *store.Storage) {
+	err := st.Migrate(func(p store.MigrationProgress) {
+		if p.Applied {
+			log.Printf("applied migration %v (%v), %v entries processed", p.Version, p.Description, p.Processed)
+			return
+		}
+		log.Printf("applying migration %v (%v), %v entries processed", p.Version, p.Description, p.Processed)
+	})
+	switch err {
+	case nil:
+	case store.ErrMigrating, store.ErrSchemaTooNew:
+		log.Printf("skipped migrations: %v", err)
+	default:
+		log.Fatalf("failed to apply migrations: %v", err)
+	}
+}
+
+// SetReady marks the Environment as ready or not ready to receive traffic.
+func (env *Environment) SetReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&env.ready, v)
+}
+
+// Ready reports whether the Environment is ready to receive traffic.
+func (env *Environment) Ready() bool {
+	return atomic.LoadInt32(&env.ready) == 1
+}
+
+// OnClose registers a function stopping a background worker. Functions are called by StopWorkers in reverse order of
+// registration.
+func (env *Environment) OnClose(f func()) {
+	env.closers = append(env.closers, f)
+}
+
+// OnRelease registers a function releasing a resource used by handlers and workers. Functions are called by Close in
+// reverse order of registration, after all workers are stopped and flushed.
+func (env *Environment) OnRelease(f func()) {
+	env.releasers = append(env.releasers, f)
+}
+
+// StopWorkers marks the Environment as not ready, then stops all background workers, flushing their buffered data.
+// Resources stay open for handlers which are still running.
+func (env *Environment) StopWorkers() {
+	env.SetReady(false)
+
+	for i := len(env.closers) - 1; i >= 0; i-- {
+		env.closers[i]()
+	}
+	env.closers = nil
+}
+
+// Close marks the Environment as not ready, then stops all background workers and releases all resources.
+func (env *Environment) Close() {
+	env.StopWorkers()
+
+	for i := len(env.releasers) - 1; i >= 0; i-- {
+		env.releasers[i]()
+	}
+	env.releasers = nil
+}
+
+// InFlight returns the number of HTTP requests and gRPC calls being served.
+func (env *Environment) InFlight() int64 {
+	return atomic.LoadInt64(&env.inFlight)
+}
+
+// track counts a request as in flight until the returned function is called.
+func (env *Environment) track() func() {
+	atomic.AddInt64(&env.inFlight, 1)
+	return func() {
+		atomic.AddInt64(&env.inFlight, -1)
+	}
+}
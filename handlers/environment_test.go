@@ -4,29 +4,104 @@ import (
 	"testing"
 
 	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
 
 	"github.com/yexelm/shorty/config"
+	"github.com/yexelm/shorty/store"
 )
 
 type MockEnv struct {
-	Ctrl  *gomock.Controller
-	Cache *MockLongerShorter
+	Ctrl      *gomock.Controller
+	Cache     *MockLongerShorter
+	Admin     *MockAdmin
+	Links     *MockLinkStore
+	Keys      *MockAuthenticator
+	Tenants   *MockTenantResolver
+	Passwords *MockPasswordGuard
+	Webhooks  *MockWebhookStore
 }
 
 func loadMockEnv(t *testing.T) (*MockEnv, *Environment) {
 	ctrl := gomock.NewController(t)
 
 	cache := NewMockLongerShorter(ctrl)
+	admin := NewMockAdmin(ctrl)
+	links := NewMockLinkStore(ctrl)
+	keys := NewMockAuthenticator(ctrl)
+	tenants := NewMockTenantResolver(ctrl)
+	passwords := NewMockPasswordGuard(ctrl)
+	webhooks := NewMockWebhookStore(ctrl)
 
 	mockEnv := &MockEnv{
-		Ctrl:  ctrl,
-		Cache: cache,
+		Ctrl:      ctrl,
+		Cache:     cache,
+		Admin:     admin,
+		Links:     links,
+		Keys:      keys,
+		Tenants:   tenants,
+		Passwords: passwords,
+		Webhooks:  webhooks,
 	}
 
 	env := &Environment{
-		Config: config.New(),
-		Cache:  cache,
+		Config:    config.New(),
+		Cache:     cache,
+		Admin:     admin,
+		Links:     links,
+		Keys:      keys,
+		Tenants:   tenants,
+		Passwords: passwords,
+		Webhooks:  webhooks,
+		Toggles:   NewToggles(),
+		secret:    []byte("secret"),
 	}
 
 	return mockEnv, env
 }
+
+// withoutTenants makes all hosts served by the default namespace.
+func (m *MockEnv) withoutTenants() {
+	m.Tenants.EXPECT().NamespaceByHost(gomock.Any()).Return(store.Namespace{}, nil).AnyTimes()
+}
+
+func Test_EnvironmentClose(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+
+	var order []string
+	env.OnRelease(func() { order = append(order, "storage") })
+	env.OnClose(func() { order = append(order, "worker") })
+
+	env.SetReady(true)
+	ao.True(env.Ready())
+
+	env.Close()
+	ao.False(env.Ready())
+	ao.Equal([]string{"worker", "storage"}, order)
+
+	env.Close()
+	ao.Len(order, 2)
+}
+
+func Test_EnvironmentStopWorkers(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+
+	var order []string
+	env.OnRelease(func() { order = append(order, "storage") })
+	env.OnClose(func() { order = append(order, "worker") })
+
+	done := env.track()
+	ao.EqualValues(1, env.InFlight())
+
+	env.StopWorkers()
+	ao.False(env.Ready())
+	ao.Equal([]string{"worker"}, order)
+
+	done()
+	ao.EqualValues(0, env.InFlight())
+}
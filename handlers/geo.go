@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"net"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/store"
+)
+
+// GeoLocator finds countries of IP addresses.
+type GeoLocator interface {
+	// Country returns the upper case ISO 3166 code of the country of the address or an empty string if it is unknown.
+	Country(ip net.IP) string
+}
+
+// visit describes the visitor resolving the link and assigns it to a variant of the link if it has any. The
+// country is only known if a GeoIP database is configured.
+func (env *Environment) visit(ctx *fasthttp.RequestCtx, l store.Link) store.Visit {
+	var v store.Visit
+	if env.Geo != nil {
+		v.Country = env.Geo.Country(env.clientIP(ctx))
+	}
+	env.assignVariant(ctx, l, &v)
+
+	return v
+}
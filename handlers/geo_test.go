@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/store"
+)
+
+// countries is a GeoLocator with fixed addresses.
+type countries map[string]string
+
+func (c countries) Country(ip net.IP) string {
+	return c[ip.String()]
+}
+
+func Test_clientIP(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	_, env := loadMockEnv(t)
+
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	env.Config.TrustedProxies = []*net.IPNet{proxies}
+
+	type testData struct {
+		tCase        string
+		remoteIP     string
+		forwardedFor string
+		expected     string
+	}
+
+	testTable := []testData{
+		{tCase: "direct client", remoteIP: "203.0.113.1", expected: "203.0.113.1"},
+		{tCase: "untrusted client forging header", remoteIP: "203.0.113.1", forwardedFor: "81.2.69.142", expected: "203.0.113.1"},
+		{tCase: "trusted proxy", remoteIP: "10.1.2.3", forwardedFor: "81.2.69.142", expected: "81.2.69.142"},
+		{tCase: "chain of trusted proxies", remoteIP: "10.1.2.3", forwardedFor: "81.2.69.142, 10.0.0.7", expected: "81.2.69.142"},
+		{tCase: "forged leftmost address", remoteIP: "10.1.2.3", forwardedFor: "1.1.1.1, 81.2.69.142", expected: "81.2.69.142"},
+		{tCase: "invalid address", remoteIP: "10.1.2.3", forwardedFor: "unknown, 10.0.0.7", expected: "10.0.0.7"},
+		{tCase: "no header", remoteIP: "10.1.2.3", expected: "10.1.2.3"},
+	}
+
+	for _, tc := range testTable {
+		t.Run(tc.tCase, func(t *testing.T) {
+			ctx := initCtx("GET", "http://host.com/b", nil)
+			if tc.forwardedFor != "" {
+				ctx.Request.Header.Set("X-Forwarded-For", tc.forwardedFor)
+			}
+			fromAddr(ctx, tc.remoteIP)
+
+			ao.Equal(tc.expected, env.clientIP(ctx).String())
+		})
+	}
+}
+
+func Test_longerCountry(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+	mockEnv.withoutTenants()
+
+	ctx := initCtx("GET", "http://host.com/b", nil)
+	fromAddr(ctx, "81.2.69.142")
+	ao.Equal(store.Visit{}, env.visit(ctx, store.Link{}), "the country must be unknown without a GeoIP database")
+
+	env.Geo = countries{"81.2.69.142": "GB"}
+	link := store.Link{Short: "b", Long: "https://example.com", Meta: store.Meta{Rules: []store.Rule{
+		{Country: "gb", URL: "https://example.co.uk"},
+	}}}
+	mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("b")).Return(link, nil).Times(2)
+	mockEnv.Cache.EXPECT().Click(store.Namespace{}, link, store.Visit{Country: "GB"}).Return(nil)
+	mockEnv.Cache.EXPECT().Click(store.Namespace{}, link, store.Visit{}).Return(nil)
+
+	env.Handle(ctx)
+	ao.Equal(fasthttp.StatusOK, ctx.Response.StatusCode())
+	ao.Equal("https://example.co.uk", string(ctx.Response.Body()))
+
+	ctx = initCtx("GET", "http://host.com/b", nil)
+	fromAddr(ctx, "203.0.113.1")
+	env.Handle(ctx)
+	ao.Equal("https://example.com", string(ctx.Response.Body()))
+}
@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/valyala/fasthttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/yexelm/shorty/metrics"
+	"github.com/yexelm/shorty/rpc"
+	"github.com/yexelm/shorty/store"
+)
+
+// principalKey is the context key of the caller of an RPC.
+type principalKey struct{}
+
+// principal is the authenticated caller of an RPC.
+type principal struct {
+	owner string
+	ns    store.Namespace
+	// scheme and host build short URLs of links of the default namespace, like the scheme and the host of HTTP
+	// requests do.
+	scheme string
+	host   string
+}
+
+// grpcServer serves the gRPC API, which mirrors the JSON API.
+type grpcServer struct {
+	rpc.UnimplementedShortyServer
+	env *Environment
+}
+
+// NewGRPCServer returns a gRPC server of the API sharing authentication and rate limits with the HTTP API.
+func (env *Environment) NewGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	srv := grpc.NewServer(append(opts, grpc.ChainUnaryInterceptor(env.observeRPC, env.authenticateRPC))...)
+	rpc.RegisterShortyServer(srv, &grpcServer{env: env})
+
+	return srv
+}
+
+// observeRPC records the duration and the status code of the call.
+func (env *Environment) observeRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	defer env.track()()
+
+	start := time.Now()
+	resp, err := handler(ctx, req)
+
+	method := info.FullMethod[strings.LastIndexByte(info.FullMethod, '/')+1:]
+	code := status.Code(err).String()
+	metrics.HandlerDuration.WithLabelValues("grpc", method, code).Observe(time.Since(start).Seconds())
+
+	return resp, err
+}
+
+// authenticateRPC requires an API key passed as bearer token in the authorization metadata and checks the rate limit
+// of the key.
+func (env *Environment) authenticateRPC(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	p := principal{scheme: "http", host: first(md, ":authority")}
+	if env.Config.TLSEnabled() {
+		p.scheme = "https"
+	}
+
+	key := first(md, "authorization")
+	if !strings.HasPrefix(key, "Bearer ") {
+		key = ""
+	}
+	owner, ns, code, err := env.authenticateKey(p.host, strings.TrimSpace(strings.TrimPrefix(key, "Bearer ")), true)
+	if err != nil {
+		return nil, status.Error(grpcCode(code), err.Error())
+	}
+	p.owner, p.ns = owner, ns
+
+	var ip net.IP
+	if addr, ok := peer.FromContext(ctx); ok {
+		if tcp, ok := addr.Addr.(*net.TCPAddr); ok {
+			ip = tcp.IP
+		}
+	}
+	if ok, _ := env.allowRequest("grpc", ns, owner, ip); !ok {
+		return nil, status.Error(codes.ResourceExhausted, ErrRateLimited.Error())
+	}
+
+	return handler(context.WithValue(ctx, principalKey{}, p), req)
+}
+
+func (s *grpcServer) Shorten(ctx context.Context, req *rpc.ShortenRequest) (*rpc.Link, error) {
+	p := ctx.Value(principalKey{}).(principal)
+	if !s.env.Toggles.Enabled(ToggleShorten) {
+		return nil, status.Error(codes.Unavailable, ErrTemporarilyOff.Error())
+	}
+
+	return s.shorten(p, req)
+}
+
+func (s *grpcServer) BatchShorten(ctx context.Context, req *rpc.BatchShortenRequest) (*rpc.BatchShortenResponse,
+	error) {
+	p := ctx.Value(principalKey{}).(principal)
+	if !s.env.Toggles.Enabled(ToggleShorten) {
+		return nil, status.Error(codes.Unavailable, ErrTemporarilyOff.Error())
+	}
+	if len(req.Links) > maxBatchShorten {
+		return nil, status.Error(codes.InvalidArgument, ErrBatchTooLarge.Error())
+	}
+
+	resp := &rpc.BatchShortenResponse{Results: make([]*rpc.BatchShortenResult, len(req.Links))}
+	for i, r := range req.Links {
+		l, err := s.shorten(p, r)
+		if err != nil {
+			resp.Results[i] = &rpc.BatchShortenResult{Error: status.Convert(err).Message()}
+			continue
+		}
+		resp.Results[i] = &rpc.BatchShortenResult{Link: l}
+	}
+
+	return resp, nil
+}
+
+func (s *grpcServer) shorten(p principal, req *rpc.ShortenRequest) (*rpc.Link, error) {
+	if req.Url == "" {
+		return nil, status.Error(codes.InvalidArgument, ErrEmptyLongURL.Error())
+	}
+
+	meta := store.Meta{
+		Creator:     p.owner,
+		Title:       req.Title,
+		Description: req.Description,
+		Tags:        req.Tags,
+		MaxClicks:   int(req.MaxClicks),
+	}
+	if req.NotBefore != nil {
+		t := req.NotBefore.AsTime()
+		meta.NotBefore = &t
+	}
+	if req.NotAfter != nil {
+		t := req.NotAfter.AsTime()
+		meta.NotAfter = &t
+	}
+
+	ns, short, err := s.env.saveLink(p.ns, req.Url, req.Domain, req.Password, meta)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	l, err := s.env.Links.Link(ns, short)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return s.link(p, ns, l)
+}
+
+func (s *grpcServer) Resolve(ctx context.Context, req *rpc.LinkRequest) (*rpc.Link, error) {
+	p := ctx.Value(principalKey{}).(principal)
+	if !s.env.Toggles.Enabled(ToggleResolve) {
+		return nil, status.Error(codes.Unavailable, ErrTemporarilyOff.Error())
+	}
+
+	ns, err := s.namespace(p, req)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := s.env.Cache.Longer(ns, []byte(req.Short))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return s.link(p, ns, l)
+}
+
+func (s *grpcServer) Delete(ctx context.Context, req *rpc.LinkRequest) (*rpc.DeleteResponse, error) {
+	p := ctx.Value(principalKey{}).(principal)
+	ns, err := s.namespace(p, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.env.Admin.Delete(ns, []byte(req.Short)); err != nil {
+		return nil, grpcError(err)
+	}
+	s.env.notify(EventLinkDeleted, ns, store.Link{Short: req.Short}, nil)
+
+	return &rpc.DeleteResponse{}, nil
+}
+
+func (s *grpcServer) GetStats(ctx context.Context, req *rpc.LinkRequest) (*rpc.Stats, error) {
+	p := ctx.Value(principalKey{}).(principal)
+	ns, err := s.namespace(p, req)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := s.env.Links.ClickStats(ns, []byte(req.Short))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	resp := &rpc.Stats{
+		Clicks:    int64(stats.Clicks),
+		MaxClicks: int64(stats.MaxClicks),
+		Countries: counts(stats.Countries),
+		Variants:  counts(stats.Variants),
+	}
+	if stats.ClicksLeft != nil {
+		left := int64(*stats.ClicksLeft)
+		resp.ClicksLeft = &left
+	}
+
+	return resp, nil
+}
+
+// namespace returns the namespace of the requested link, which is the one of the API key unless a domain is given.
+func (s *grpcServer) namespace(p principal, req *rpc.LinkRequest) (store.Namespace, error) {
+	if req.Short == "" {
+		return store.Namespace{}, status.Error(codes.InvalidArgument, ErrEmptyShortCode.Error())
+	}
+	if req.Domain == "" {
+		return p.ns, nil
+	}
+
+	ns, err := s.env.domainNamespace(p.ns.Tenant, req.Domain)
+	if err != nil {
+		return store.Namespace{}, grpcError(err)
+	}
+
+	return ns, nil
+}
+
+// link converts the link saved in the namespace into its message.
+func (s *grpcServer) link(p principal, ns store.Namespace, l store.Link) (*rpc.Link, error) {
+	shortURL, err := s.env.linkURL(p.scheme, p.host, ns, []byte(l.Short))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	msg := &rpc.Link{
+		Short:       l.Short,
+		ShortUrl:    string(shortURL),
+		Url:         l.Long,
+		Disabled:    l.Disabled,
+		Protected:   l.Protected,
+		CreatedAt:   timestamppb.New(l.CreatedAt),
+		Creator:     l.Creator,
+		Title:       l.Title,
+		Description: l.Description,
+		Tags:        l.Tags,
+		MaxClicks:   int64(l.MaxClicks),
+	}
+	if l.NotBefore != nil {
+		msg.NotBefore = timestamppb.New(*l.NotBefore)
+	}
+	if l.NotAfter != nil {
+		msg.NotAfter = timestamppb.New(*l.NotAfter)
+	}
+
+	return msg, nil
+}
+
+// grpcError returns the status of a failed operation.
+func grpcError(err error) error {
+	switch {
+	case err == redis.ErrNil:
+		return status.Error(codes.NotFound, ErrShortCodeNotFound.Error())
+	case errors.Is(err, ErrInvalidMeta) || err == ErrUnknownDomain || err == store.ErrInvalidAlias:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case err == store.ErrDisabled || err == store.ErrExhausted:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case err == store.ErrConflict:
+		return status.Error(codes.Aborted, err.Error())
+	case err == store.ErrURLTaken:
+		return status.Error(codes.AlreadyExists, err.Error())
+	case err == store.ErrQuotaExceeded:
+		return status.Error(codes.ResourceExhausted, err.Error())
+	default:
+		metrics.Errors.WithLabelValues("grpc").Inc()
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// grpcCode converts the HTTP status code of a rejected request into the gRPC one.
+func grpcCode(httpCode int) codes.Code {
+	switch httpCode {
+	case fasthttp.StatusUnauthorized:
+		return codes.Unauthenticated
+	case fasthttp.StatusForbidden:
+		return codes.PermissionDenied
+	default:
+		return codes.Internal
+	}
+}
+
+// first returns the first value of the metadata key or an empty string.
+func first(md metadata.MD, key string) string {
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+
+	return ""
+}
+
+func counts(m map[string]int) map[string]int64 {
+	if m == nil {
+		return nil
+	}
+
+	c := make(map[string]int64, len(m))
+	for k, v := range m {
+		c[k] = int64(v)
+	}
+
+	return c
+}
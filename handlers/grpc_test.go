@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/yexelm/shorty/rpc"
+	"github.com/yexelm/shorty/store"
+)
+
+// dialGRPC serves the gRPC API of the environment in memory and returns a client calling it on the host.
+func dialGRPC(t *testing.T, env *Environment, host string) rpc.ShortyClient {
+	ln := bufconn.Listen(1 << 20)
+	srv := env.NewGRPCServer()
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial(host, grpc.WithInsecure(), grpc.WithContextDialer(
+		func(context.Context, string) (net.Conn, error) {
+			return ln.Dial()
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return rpc.NewShortyClient(conn)
+}
+
+func withKey(key string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+key)
+}
+
+func Test_grpcAuth(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+
+	mockEnv.Tenants.EXPECT().NamespaceByHost("short.ly").Return(store.Namespace{}, nil).Times(2)
+	mockEnv.Tenants.EXPECT().NamespaceByHost("go.acme.com").Return(store.Namespace{Tenant: "acme"}, nil)
+	mockEnv.Keys.EXPECT().Authenticate("unknown").Return(store.Principal{}, redis.ErrNil)
+	mockEnv.Keys.EXPECT().Authenticate("key").Return(store.Principal{Tenant: "other", Owner: "svc"}, nil)
+
+	_, err := dialGRPC(t, env, "short.ly").Resolve(context.Background(), &rpc.LinkRequest{Short: "b"})
+	ao.Equal(codes.Unauthenticated, status.Code(err))
+	ao.Equal(ErrAPIKeyRequired.Error(), status.Convert(err).Message())
+
+	_, err = dialGRPC(t, env, "short.ly").Resolve(withKey("unknown"), &rpc.LinkRequest{Short: "b"})
+	ao.Equal(codes.Unauthenticated, status.Code(err))
+	ao.Equal(ErrInvalidAPIKey.Error(), status.Convert(err).Message())
+
+	// keys of tenants are rejected on hosts of other tenants
+	_, err = dialGRPC(t, env, "go.acme.com").Resolve(withKey("key"), &rpc.LinkRequest{Short: "b"})
+	ao.Equal(codes.PermissionDenied, status.Code(err))
+}
+
+func Test_grpcShorten(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+	mockEnv.withoutTenants()
+	client := dialGRPC(t, env, "short.ly")
+
+	createdAt := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	meta := store.Meta{Creator: "svc", Title: "Go", Tags: []string{"lang"}, MaxClicks: 10, NotAfter: &notAfter}
+	mockEnv.Keys.EXPECT().Authenticate("key").Return(store.Principal{Owner: "svc"}, nil).Times(3)
+	mockEnv.Cache.EXPECT().Shorter(store.Namespace{}, []byte("https://go.dev"), meta).Return([]byte("b"), true, nil).
+		Times(2)
+	mockEnv.Links.EXPECT().Link(store.Namespace{}, []byte("b")).
+		Return(store.Link{Short: "b", Long: "https://go.dev", CreatedAt: createdAt, Meta: meta}, nil).Times(2)
+
+	req := &rpc.ShortenRequest{
+		Url:       "https://go.dev",
+		Title:     "Go",
+		Tags:      []string{"lang"},
+		MaxClicks: 10,
+		NotAfter:  timestamppb.New(notAfter),
+	}
+	l, err := client.Shorten(withKey("key"), req)
+	ao.NoError(err)
+	ao.Equal("b", l.Short)
+	ao.Equal("http://short.ly/b", l.ShortUrl)
+	ao.Equal("https://go.dev", l.Url)
+	ao.Equal("svc", l.Creator)
+	ao.Equal(createdAt, l.CreatedAt.AsTime())
+	ao.Equal(notAfter, l.NotAfter.AsTime())
+	ao.Nil(l.NotBefore)
+
+	// links of a batch fail separately
+	resp, err := client.BatchShorten(withKey("key"), &rpc.BatchShortenRequest{Links: []*rpc.ShortenRequest{
+		req, {Url: ""}, {Url: "https://go.dev", Domain: "go.acme.com"},
+	}})
+	ao.NoError(err)
+	ao.Len(resp.Results, 3)
+	ao.Equal("b", resp.Results[0].Link.GetShort())
+	ao.Nil(resp.Results[1].Link)
+	ao.Equal(ErrEmptyLongURL.Error(), resp.Results[1].Error)
+	ao.Equal(ErrUnknownDomain.Error(), resp.Results[2].Error)
+
+	_, err = client.BatchShorten(withKey("key"), &rpc.BatchShortenRequest{
+		Links: make([]*rpc.ShortenRequest, maxBatchShorten+1),
+	})
+	ao.Equal(codes.InvalidArgument, status.Code(err))
+}
+
+func Test_grpcLinks(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+	mockEnv.withoutTenants()
+	client := dialGRPC(t, env, "short.ly")
+
+	mockEnv.Keys.EXPECT().Authenticate("key").Return(store.Principal{Owner: "svc"}, nil).AnyTimes()
+
+	// resolving does not count clicks
+	mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("b")).Return(store.Link{Short: "b", Long: "https://go.dev"},
+		nil)
+	mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("c")).Return(store.Link{}, store.ErrDisabled)
+	mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("d")).Return(store.Link{}, redis.ErrNil)
+
+	l, err := client.Resolve(withKey("key"), &rpc.LinkRequest{Short: "b"})
+	ao.NoError(err)
+	ao.Equal("https://go.dev", l.Url)
+	_, err = client.Resolve(withKey("key"), &rpc.LinkRequest{Short: "c"})
+	ao.Equal(codes.FailedPrecondition, status.Code(err))
+	_, err = client.Resolve(withKey("key"), &rpc.LinkRequest{Short: "d"})
+	ao.Equal(codes.NotFound, status.Code(err))
+	_, err = client.Resolve(withKey("key"), &rpc.LinkRequest{})
+	ao.Equal(codes.InvalidArgument, status.Code(err))
+
+	mockEnv.Admin.EXPECT().Delete(store.Namespace{}, []byte("b")).Return(nil)
+	mockEnv.Admin.EXPECT().Delete(store.Namespace{}, []byte("c")).Return(errors.New("timeout"))
+	_, err = client.Delete(withKey("key"), &rpc.LinkRequest{Short: "b"})
+	ao.NoError(err)
+	_, err = client.Delete(withKey("key"), &rpc.LinkRequest{Short: "c"})
+	ao.Equal(codes.Internal, status.Code(err))
+
+	left := 3
+	mockEnv.Links.EXPECT().ClickStats(store.Namespace{}, []byte("b")).Return(store.ClickStats{
+		Clicks: 7, MaxClicks: 10, ClicksLeft: &left, Countries: map[string]int{"NL": 7},
+	}, nil)
+	stats, err := client.GetStats(withKey("key"), &rpc.LinkRequest{Short: "b"})
+	ao.NoError(err)
+	ao.Equal(int64(7), stats.Clicks)
+	ao.Equal(int64(3), stats.GetClicksLeft())
+	ao.Equal(map[string]int64{"NL": 7}, stats.Countries)
+	ao.Nil(stats.Variants)
+}
+
+func Test_grpcRateLimit(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+	mockEnv.withoutTenants()
+	env.limiter = newRateLimiter(60, 1)
+	client := dialGRPC(t, env, "short.ly")
+
+	mockEnv.Keys.EXPECT().Authenticate(gomock.Any()).Return(store.Principal{Owner: "svc"}, nil).Times(2)
+	mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("b")).Return(store.Link{Short: "b"}, nil)
+
+	_, err := client.Resolve(withKey("key"), &rpc.LinkRequest{Short: "b"})
+	ao.NoError(err)
+	_, err = client.Resolve(withKey("key"), &rpc.LinkRequest{Short: "b"})
+	ao.Equal(codes.ResourceExhausted, status.Code(err))
+}
@@ -4,13 +4,16 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"net"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gomodule/redigo/redis"
-	"github.com/prometheus/client_golang/prometheus"
 	"github.com/valyala/fasthttp"
 
 	"github.com/yexelm/shorty/metrics"
+	"github.com/yexelm/shorty/store"
 )
 
 //go:generate mockgen -source=handlers.go -destination=handlers_mocks.go -package=handlers -self_package=shorty/handlers
@@ -19,33 +22,48 @@ var (
 	ErrEmptyShortCode    = errors.New("empty short code")
 	ErrEmptyRequestBody  = errors.New("empty request body")
 	ErrShortCodeNotFound = errors.New("the requested short code not found")
+	ErrTemporarilyOff    = errors.New("the operation is temporarily disabled")
 
-	// for metrics
-	methodToOperation = map[string]string{
-		fasthttp.MethodGet:  "Get original URI by short alias",
-		fasthttp.MethodPost: "Shorten the long URI",
+	// handler names used as metrics labels
+	methodToHandler = map[string]string{
+		fasthttp.MethodGet:  "longer",
+		fasthttp.MethodPost: "shorter",
 	}
 )
 
 type LongerShorter interface {
-	Longer(short []byte) ([]byte, error)
-	Shorter(long []byte) ([]byte, error)
+	Longer(ns store.Namespace, short []byte) (store.Link, error)
+	Click(ns store.Namespace, l store.Link, v store.Visit) error
+	Shorter(ns store.Namespace, long []byte, meta store.Meta) ([]byte, bool, error)
 }
 
 func (env *Environment) Handle(ctx *fasthttp.RequestCtx) {
-	m, ok := methodToOperation[string(ctx.Method())]
-	if !ok {
-		m = "operation not implemented"
+	defer env.track()()
+
+	if bytes.HasPrefix(ctx.Path(), apiPrefix) {
+		defer observe("api", ctx, time.Now())
+		env.api(ctx)
+		return
+	}
+
+	// POST requests to an alias of a protected link carry its password, all others shorten URLs.
+	unlock := ctx.IsPost() && len(bytes.Trim(ctx.Path(), "/")) > 0 && env.protected(ctx)
+
+	h, ok := methodToHandler[string(ctx.Method())]
+	switch {
+	case unlock:
+		h = "unlock"
+	case !ok:
+		h = "unknown"
 	}
 
-	obs := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
-		metrics.LatencyHandler.WithLabelValues(m).Observe(v)
-	}))
-	defer obs.ObserveDuration()
+	defer observe(h, ctx, time.Now())
 
 	switch {
 	case ctx.IsGet():
 		env.longer(ctx)
+	case unlock:
+		env.unlock(ctx)
 	case ctx.IsPost():
 		env.shorter(ctx)
 	default:
@@ -53,36 +71,154 @@ func (env *Environment) Handle(ctx *fasthttp.RequestCtx) {
 	}
 }
 
-// longer returns the original URI for the given short code.
+// observe records the duration and the status code of the request served by the given handler.
+func observe(handler string, ctx *fasthttp.RequestCtx, start time.Time) {
+	code := strconv.Itoa(ctx.Response.StatusCode())
+	metrics.HandlerDuration.WithLabelValues(handler, string(ctx.Method()), code).Observe(time.Since(start).Seconds())
+}
+
+// longer returns the original URI for the given short code in the namespace of the host, or the preview of the link
+// if it is requested or always shown. Password protected links are only resolved for visitors who unlocked them.
 func (env *Environment) longer(ctx *fasthttp.RequestCtx) {
-	short := []byte(strings.TrimPrefix(string(ctx.Path()), "/"))
+	ns, l, ok := env.resolve(ctx, "longer")
+	if !ok {
+		return
+	}
+
+	if l.Protected && !env.unlocked(ctx, ns, l) {
+		challenge(ctx, fasthttp.StatusUnauthorized, ErrPasswordRequired)
+		return
+	}
+
+	if isCrawler(ctx) && env.writeUnfurl(ctx, ns, l) {
+		return
+	}
+
+	if requested := previewRequested(ctx); requested || l.Preview {
+		env.preview(ctx, ns, l, !requested)
+		return
+	}
+
+	env.writeLong(ctx, ns, l)
+}
+
+// resolve returns the link requested in the path within the namespace of the host. The response is written and false
+// is returned if the link cannot be resolved or is not active.
+func (env *Environment) resolve(ctx *fasthttp.RequestCtx, handler string) (store.Namespace, store.Link, bool) {
+	if !env.Toggles.Enabled(ToggleResolve) {
+		ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+		ctx.WriteString(ErrTemporarilyOff.Error())
+		return store.Namespace{}, store.Link{}, false
+	}
+
+	alias, rest := splitAlias(string(ctx.Path()))
+	short := []byte(strings.TrimSuffix(alias, previewSuffix))
 
 	if len(short) == 0 {
 		ctx.SetStatusCode(fasthttp.StatusBadRequest)
 		ctx.WriteString(ErrEmptyShortCode.Error())
-		return
+		return store.Namespace{}, store.Link{}, false
+	}
+
+	ns, err := env.hostNamespace(ctx)
+	if err != nil {
+		metrics.Errors.WithLabelValues(handler).Inc()
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.WriteString(err.Error())
+		return store.Namespace{}, store.Link{}, false
 	}
 
-	originalURL, err := env.Cache.Longer(short)
+	l, err := env.Cache.Longer(ns, short)
+	// paths below the alias are only resolved by prefix links
+	if err == nil && rest != "" && !l.Prefix {
+		err = redis.ErrNil
+	}
 	if err != nil && err == redis.ErrNil {
+		metrics.LinksNotFound.Inc()
 		ctx.SetStatusCode(fasthttp.StatusNotFound)
 		ctx.WriteString(ErrShortCodeNotFound.Error())
-		return
+		return store.Namespace{}, store.Link{}, false
+	}
+
+	if err == store.ErrDisabled || err == store.ErrExhausted {
+		ctx.SetStatusCode(fasthttp.StatusGone)
+		ctx.WriteString(err.Error())
+		return store.Namespace{}, store.Link{}, false
 	}
 
 	if err != nil {
+		metrics.Errors.WithLabelValues(handler).Inc()
 		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
 		ctx.WriteString(err.Error())
+		return store.Namespace{}, store.Link{}, false
+	}
+
+	if !env.active(ctx, l) {
+		return store.Namespace{}, store.Link{}, false
+	}
+
+	return ns, l, true
+}
+
+// writeLong counts the resolution of the link and writes its original URL, the URL of the first routing rule
+// matching the visitor, or the URL of the variant the visitor is assigned to.
+func (env *Environment) writeLong(ctx *fasthttp.RequestCtx, ns store.Namespace, l store.Link) {
+	v := env.visit(ctx, l)
+	if !env.click(ctx, ns, l, v) {
 		return
 	}
 
-	ctx.Write(originalURL)
+	metrics.LinksResolved.Inc()
+	ctx.WriteString(env.passthrough(ctx, l, destination(ctx, l, v)))
+}
+
+// click counts the resolution of the link. The response is written and false is returned if the link has reached
+// its click limit. Links without a limit are resolved even if the click cannot be counted.
+func (env *Environment) click(ctx *fasthttp.RequestCtx, ns store.Namespace, l store.Link, v store.Visit) bool {
+	err := env.Cache.Click(ns, l, v)
+	switch {
+	case err == nil:
+		env.publishClick(ctx, ns, l, v)
+		env.notify(EventLinkClicked, ns, l, &v)
+		return true
+	case err == store.ErrExhausted:
+		ctx.SetStatusCode(fasthttp.StatusGone)
+		ctx.WriteString(err.Error())
+		return false
+	case l.MaxClicks == 0:
+		metrics.Errors.WithLabelValues("click").Inc()
+		env.publishClick(ctx, ns, l, v)
+		return true
+	default:
+		metrics.Errors.WithLabelValues("click").Inc()
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.WriteString(err.Error())
+		return false
+	}
 }
 
 // shorter converts the original URI into the short alias and returns it.
 func (env *Environment) shorter(ctx *fasthttp.RequestCtx) {
+	if !env.Toggles.Enabled(ToggleShorten) {
+		ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+		ctx.WriteString(ErrTemporarilyOff.Error())
+		return
+	}
+
+	owner, ns, code, err := env.authenticate(ctx, false)
+	if err != nil {
+		ctx.SetStatusCode(code)
+		ctx.WriteString(err.Error())
+		return
+	}
+	if env.rateLimited(ctx, "shorter", ns, owner) {
+		ctx.WriteString(ErrRateLimited.Error())
+		return
+	}
+
 	longURL, err := io.ReadAll(bytes.NewReader(ctx.Request.Body()))
 	if err != nil {
+		metrics.Errors.WithLabelValues("shorter").Inc()
 		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
 		ctx.WriteString(err.Error())
 		return
@@ -93,16 +229,46 @@ func (env *Environment) shorter(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
-	short, err := env.Cache.Shorter(longURL)
+	short, created, err := env.Cache.Shorter(ns, longURL, store.Meta{Creator: owner})
+	if err == store.ErrQuotaExceeded {
+		ctx.SetStatusCode(fasthttp.StatusForbidden)
+		ctx.WriteString(err.Error())
+		return
+	}
+	if err == store.ErrURLTaken {
+		ctx.SetStatusCode(fasthttp.StatusConflict)
+		ctx.WriteString(err.Error())
+		return
+	}
+
+	if err == nil {
+		if created {
+			env.unfurl(ns, short, string(longURL))
+			env.notify(EventLinkCreated, ns, store.Link{Short: string(short), Long: string(longURL)}, nil)
+		}
+		short, err = env.shortURL(ctx, ns, short)
+	}
+
 	if err != nil {
+		metrics.Errors.WithLabelValues("shorter").Inc()
 		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
 		ctx.WriteString(err.Error())
 		return
 	}
 
-	buf := &bytes.Buffer{}
-	buf.Write(append(ctx.URI().Host(), '/'))
-	buf.Write(short)
+	ctx.Write(short)
+}
+
+// RedirectToHTTPS permanently redirects a plain HTTP request to the same URI served over HTTPS. 308 is used instead
+// of 301 so that clients repeat POST requests instead of turning them into GET.
+func (env *Environment) RedirectToHTTPS(ctx *fasthttp.RequestCtx) {
+	host := string(ctx.Host())
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if env.Config.HostPort != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(env.Config.HostPort))
+	}
 
-	ctx.Write(buf.Bytes())
+	ctx.Redirect("https://"+host+string(ctx.URI().RequestURI()), fasthttp.StatusPermanentRedirect)
 }
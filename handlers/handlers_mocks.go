@@ -8,6 +8,7 @@ import (
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
+	store "github.com/yexelm/shorty/store"
 )
 
 // MockLongerShorter is a mock of LongerShorter interface.
@@ -33,32 +34,47 @@ func (m *MockLongerShorter) EXPECT() *MockLongerShorterMockRecorder {
 	return m.recorder
 }
 
+// Click mocks base method.
+func (m *MockLongerShorter) Click(ns store.Namespace, l store.Link, v store.Visit) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Click", ns, l, v)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Click indicates an expected call of Click.
+func (mr *MockLongerShorterMockRecorder) Click(ns, l, v interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Click", reflect.TypeOf((*MockLongerShorter)(nil).Click), ns, l, v)
+}
+
 // Longer mocks base method.
-func (m *MockLongerShorter) Longer(short []byte) ([]byte, error) {
+func (m *MockLongerShorter) Longer(ns store.Namespace, short []byte) (store.Link, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Longer", short)
-	ret0, _ := ret[0].([]byte)
+	ret := m.ctrl.Call(m, "Longer", ns, short)
+	ret0, _ := ret[0].(store.Link)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Longer indicates an expected call of Longer.
-func (mr *MockLongerShorterMockRecorder) Longer(short interface{}) *gomock.Call {
+func (mr *MockLongerShorterMockRecorder) Longer(ns, short interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Longer", reflect.TypeOf((*MockLongerShorter)(nil).Longer), short)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Longer", reflect.TypeOf((*MockLongerShorter)(nil).Longer), ns, short)
 }
 
 // Shorter mocks base method.
-func (m *MockLongerShorter) Shorter(long []byte) ([]byte, error) {
+func (m *MockLongerShorter) Shorter(ns store.Namespace, long []byte, meta store.Meta) ([]byte, bool, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Shorter", long)
+	ret := m.ctrl.Call(m, "Shorter", ns, long, meta)
 	ret0, _ := ret[0].([]byte)
-	ret1, _ := ret[1].(error)
-	return ret0, ret1
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
 }
 
 // Shorter indicates an expected call of Shorter.
-func (mr *MockLongerShorterMockRecorder) Shorter(long interface{}) *gomock.Call {
+func (mr *MockLongerShorterMockRecorder) Shorter(ns, long, meta interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Shorter", reflect.TypeOf((*MockLongerShorter)(nil).Shorter), long)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Shorter", reflect.TypeOf((*MockLongerShorter)(nil).Shorter), ns, long, meta)
 }
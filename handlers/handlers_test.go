@@ -5,8 +5,12 @@ import (
 	"testing"
 
 	"github.com/gomodule/redigo/redis"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/metrics"
+	"github.com/yexelm/shorty/store"
 )
 
 func initCtx(method, URI string, body []byte) *fasthttp.RequestCtx {
@@ -54,6 +58,7 @@ func Test_longer(t *testing.T) {
 	ao := assert.New(t)
 	mockEnv, env := loadMockEnv(t)
 	defer mockEnv.Ctrl.Finish()
+	mockEnv.withoutTenants()
 
 	type testData struct {
 		tCase        string
@@ -79,7 +84,7 @@ func Test_longer(t *testing.T) {
 			ctx:   nil,
 			URI:   "shortcode",
 			expectedFunc: func() {
-				mockEnv.Cache.EXPECT().Longer([]byte("shortcode")).Return(nil, redis.ErrNil)
+				mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("shortcode")).Return(store.Link{}, redis.ErrNil)
 			},
 
 			expectedBody: ErrShortCodeNotFound.Error(),
@@ -91,17 +96,28 @@ func Test_longer(t *testing.T) {
 			ctx:   nil,
 			URI:   "shortcode",
 			expectedFunc: func() {
-				mockEnv.Cache.EXPECT().Longer([]byte("shortcode")).Return(nil, errors.New("some cache error"))
+				mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("shortcode")).Return(store.Link{}, errors.New("some cache error"))
 			},
 			expectedBody: "some cache error",
 			expectedCode: fasthttp.StatusInternalServerError,
 		},
+		{
+			tCase: "disabled",
+			ctx:   nil,
+			URI:   "shortcode",
+			expectedFunc: func() {
+				mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("shortcode")).Return(store.Link{}, store.ErrDisabled)
+			},
+			expectedBody: store.ErrDisabled.Error(),
+			expectedCode: fasthttp.StatusGone,
+		},
 		{
 			tCase: "success",
 			ctx:   nil,
 			URI:   "shortcode",
 			expectedFunc: func() {
-				mockEnv.Cache.EXPECT().Longer([]byte("shortcode")).Return([]byte("fullURL"), nil)
+				mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("shortcode")).Return(store.Link{Long: "fullURL"}, nil)
+				mockEnv.Cache.EXPECT().Click(store.Namespace{}, store.Link{Long: "fullURL"}, store.Visit{}).Return(nil)
 			},
 			expectedBody: "fullURL",
 			expectedCode: fasthttp.StatusOK,
@@ -125,11 +141,13 @@ func Test_shorter(t *testing.T) {
 	ao := assert.New(t)
 	mockEnv, env := loadMockEnv(t)
 	defer mockEnv.Ctrl.Finish()
+	mockEnv.withoutTenants()
 
 	type testData struct {
 		tCase        string
 		ctx          *fasthttp.RequestCtx
 		body         []byte
+		apiKey       string
 		expectedFunc func()
 
 		expectedBody string
@@ -151,19 +169,52 @@ func Test_shorter(t *testing.T) {
 			ctx:   nil,
 			body:  []byte("originalURL"),
 			expectedFunc: func() {
-				mockEnv.Cache.EXPECT().Shorter([]byte("originalURL")).Return(nil, errors.New("some error"))
+				mockEnv.Cache.EXPECT().Shorter(store.Namespace{}, []byte("originalURL"), store.Meta{}).Return(nil, false, errors.New("some error"))
 			},
 			expectedBody: "some error",
 			expectedCode: fasthttp.StatusInternalServerError,
 		},
+		{
+			tCase: "URL taken by a link with settings",
+			ctx:   nil,
+			body:  []byte("originalURL"),
+			expectedFunc: func() {
+				mockEnv.Cache.EXPECT().Shorter(store.Namespace{}, []byte("originalURL"), store.Meta{}).Return(nil, false, store.ErrURLTaken)
+			},
+			expectedBody: store.ErrURLTaken.Error(),
+			expectedCode: fasthttp.StatusConflict,
+		},
 		{
 			tCase: "success",
 			ctx:   nil,
 			body:  []byte("originalURL"),
 			expectedFunc: func() {
-				mockEnv.Cache.EXPECT().Shorter([]byte("originalURL")).Return([]byte("shortcode"), nil)
+				mockEnv.Cache.EXPECT().Shorter(store.Namespace{}, []byte("originalURL"), store.Meta{}).Return([]byte("shortcode"), true, nil)
+			},
+			expectedBody: "http://host.com/shortcode",
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:  "invalid API key",
+			ctx:    nil,
+			body:   []byte("originalURL"),
+			apiKey: "unknown",
+			expectedFunc: func() {
+				mockEnv.Keys.EXPECT().Authenticate("unknown").Return(store.Principal{}, redis.ErrNil)
+			},
+			expectedBody: ErrInvalidAPIKey.Error(),
+			expectedCode: fasthttp.StatusUnauthorized,
+		},
+		{
+			tCase:  "success with API key",
+			ctx:    nil,
+			body:   []byte("originalURL"),
+			apiKey: "key",
+			expectedFunc: func() {
+				mockEnv.Keys.EXPECT().Authenticate("key").Return(store.Principal{Owner: "team"}, nil)
+				mockEnv.Cache.EXPECT().Shorter(store.Namespace{}, []byte("originalURL"), store.Meta{Creator: "team"}).Return([]byte("shortcode"), true, nil)
 			},
-			expectedBody: "host.com/shortcode",
+			expectedBody: "http://host.com/shortcode",
 			expectedCode: fasthttp.StatusOK,
 		},
 	}
@@ -171,6 +222,9 @@ func Test_shorter(t *testing.T) {
 	for _, tc := range testTable {
 		t.Run(tc.tCase, func(t *testing.T) {
 			tc.ctx = initCtx("POST", "http://host.com", tc.body)
+			if tc.apiKey != "" {
+				tc.ctx.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+tc.apiKey)
+			}
 			tc.expectedFunc()
 			env.Handle(tc.ctx)
 
@@ -179,3 +233,125 @@ func Test_shorter(t *testing.T) {
 		})
 	}
 }
+
+func Test_HandleMetrics(t *testing.T) {
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+	mockEnv.withoutTenants()
+
+	mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("missing")).Return(store.Link{}, redis.ErrNil)
+	mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("shortcode")).Return(store.Link{Long: "fullURL"}, nil)
+	mockEnv.Cache.EXPECT().Click(store.Namespace{}, store.Link{Long: "fullURL"}, store.Visit{}).Return(nil)
+
+	notFound := testutil.ToFloat64(metrics.LinksNotFound)
+	resolved := testutil.ToFloat64(metrics.LinksResolved)
+
+	env.Handle(initCtx("GET", "missing", nil))
+	env.Handle(initCtx("GET", "shortcode", nil))
+
+	ao.Equal(notFound+1, testutil.ToFloat64(metrics.LinksNotFound))
+	ao.Equal(resolved+1, testutil.ToFloat64(metrics.LinksResolved))
+	ao.NotZero(testutil.CollectAndCount(metrics.HandlerDuration))
+}
+
+func Test_RedirectToHTTPS(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+
+	type testData struct {
+		tCase    string
+		port     int
+		URI      string
+		expected string
+	}
+
+	testTable := []testData{
+		{
+			tCase:    "default HTTPS port",
+			port:     443,
+			URI:      "http://host.com:8000/shortcode?a=b",
+			expected: "https://host.com/shortcode?a=b",
+		},
+		{
+			tCase:    "custom HTTPS port",
+			port:     8443,
+			URI:      "http://host.com/shortcode",
+			expected: "https://host.com:8443/shortcode",
+		},
+	}
+
+	for _, tc := range testTable {
+		t.Run(tc.tCase, func(t *testing.T) {
+			env.Config.HostPort = tc.port
+			ctx := initCtx("POST", tc.URI, nil)
+
+			env.RedirectToHTTPS(ctx)
+			ao.Equal(fasthttp.StatusPermanentRedirect, ctx.Response.StatusCode())
+			ao.Equal(tc.expected, string(ctx.Response.Header.Peek("Location")))
+		})
+	}
+}
+
+func Test_HandleToggles(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+
+	ao.NoError(env.Toggles.Set(ToggleResolve, false))
+	ao.NoError(env.Toggles.Set(ToggleShorten, false))
+
+	ctx := initCtx("GET", "shortcode", nil)
+	env.Handle(ctx)
+	ao.Equal(fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+
+	ctx = initCtx("POST", "http://host.com", []byte("originalURL"))
+	env.Handle(ctx)
+	ao.Equal(fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+}
+
+func Test_RequireAPIKey(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+	mockEnv.withoutTenants()
+
+	env.Config.RequireAPIKey = true
+	ctx := initCtx("POST", "http://host.com", []byte("originalURL"))
+	env.Handle(ctx)
+
+	ao.Equal(fasthttp.StatusUnauthorized, ctx.Response.StatusCode())
+	ao.Equal(ErrAPIKeyRequired.Error(), string(ctx.Response.Body()))
+}
+
+func Test_ClientCertificates(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+	mockEnv.withoutTenants()
+
+	env.Config.TLSCertFile, env.Config.TLSKeyFile, env.Config.TLSClientCAFile = "cert.pem", "key.pem", "ca.pem"
+
+	// visitors without a certificate follow short links
+	mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("shortcode")).Return(store.Link{Long: "fullURL"}, nil)
+	mockEnv.Cache.EXPECT().Click(store.Namespace{}, store.Link{Long: "fullURL"}, store.Visit{}).Return(nil)
+	ctx := initCtx("GET", "shortcode", nil)
+	env.Handle(ctx)
+	ao.Equal(fasthttp.StatusOK, ctx.Response.StatusCode())
+
+	// but cannot use the API
+	ctx = initCtx("POST", "http://host.com", []byte("originalURL"))
+	env.Handle(ctx)
+	ao.Equal(fasthttp.StatusUnauthorized, ctx.Response.StatusCode())
+	ao.Equal(ErrClientCertRequired.Error(), string(ctx.Response.Body()))
+
+	ctx = initCtx("GET", "http://host.com/api/v1/links", nil)
+	env.Handle(ctx)
+	ao.Equal(fasthttp.StatusUnauthorized, ctx.Response.StatusCode())
+	ao.Contains(string(ctx.Response.Body()), ErrClientCertRequired.Error())
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/store"
+)
+
+// utmParams are the UTM parameters added to destinations, without the utm_ prefix.
+var utmParams = []string{"source", "medium", "campaign", "term", "content"}
+
+// splitAlias splits the path of the request into the alias and the rest of the path below it, which is only
+// resolved by prefix links. Aliases never contain slashes.
+func splitAlias(path string) (string, string) {
+	path = strings.TrimPrefix(path, "/")
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		return path[:i], path[i:]
+	}
+
+	return path, ""
+}
+
+// passthrough appends the rest of the path of prefix links to the destination, merges the query of the short URL
+// into it for links forwarding queries and adds the UTM parameters it does not have yet. The destination is returned
+// unchanged if there is nothing to add or it cannot be parsed.
+func (env *Environment) passthrough(ctx *fasthttp.RequestCtx, l store.Link, dest string) string {
+	var rest string
+	if l.Prefix {
+		_, rest = splitAlias(string(ctx.Path()))
+	}
+	forward := l.ForwardQuery && ctx.QueryArgs().Len() > 0
+	if rest == "" && !forward && len(l.UTM) == 0 && len(env.Config.UTMDefaults) == 0 {
+		return dest
+	}
+
+	u, err := url.Parse(dest)
+	if err != nil {
+		return dest
+	}
+	if rest != "" {
+		u.Path = strings.TrimSuffix(u.Path, "/") + rest
+		u.RawPath = ""
+	}
+
+	query := u.Query()
+	changed := false
+	if forward {
+		incoming := make(url.Values)
+		ctx.QueryArgs().VisitAll(func(k, v []byte) {
+			incoming.Add(string(k), string(v))
+		})
+		for k, vs := range incoming {
+			query[k] = vs
+		}
+		changed = true
+	}
+	for _, p := range utmParams {
+		v := l.UTM[p]
+		if v == "" {
+			v = env.Config.UTMDefaults[p]
+		}
+		if _, ok := query["utm_"+p]; v == "" || ok {
+			continue
+		}
+		query.Set("utm_"+p, v)
+		changed = true
+	}
+	// the query is only encoded again if it changed, since encoding sorts the parameters
+	if changed {
+		u.RawQuery = query.Encode()
+	}
+
+	return u.String()
+}
+
+// validateUTM checks UTM parameters of a link.
+func validateUTM(utm map[string]string) error {
+	for k, v := range utm {
+		known := false
+		for _, p := range utmParams {
+			known = known || k == p
+		}
+		switch {
+		case !known:
+			return fmt.Errorf("%w: unknown UTM parameter %q, expected one of %s", ErrInvalidMeta, k,
+				strings.Join(utmParams, ", "))
+		case len(v) > maxTagLen:
+			return fmt.Errorf("%w: UTM parameter %q is longer than %d bytes", ErrInvalidMeta, k, maxTagLen)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/store"
+)
+
+func Test_passthrough(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	_, env := loadMockEnv(t)
+	env.Config.UTMDefaults = map[string]string{"source": "shorty", "medium": "link"}
+
+	type testData struct {
+		tCase string
+		URI   string
+		meta  store.Meta
+		dest  string
+
+		expected string
+	}
+
+	testTable := []testData{
+		{
+			tCase:    "defaults only",
+			URI:      "http://host.com/b?ref=mail",
+			dest:     "https://example.com/page?b=2&a=1",
+			expected: "https://example.com/page?a=1&b=2&utm_medium=link&utm_source=shorty",
+		},
+		{
+			tCase:    "query merged",
+			URI:      "http://host.com/b?ref=mail&a=3",
+			meta:     store.Meta{ForwardQuery: true},
+			dest:     "https://example.com/page?a=1&b=2",
+			expected: "https://example.com/page?a=3&b=2&ref=mail&utm_medium=link&utm_source=shorty",
+		},
+		{
+			tCase:    "UTM parameters of link and destination take precedence",
+			URI:      "http://host.com/b?utm_medium=social",
+			meta:     store.Meta{ForwardQuery: true, UTM: map[string]string{"source": "newsletter", "campaign": "spring"}},
+			dest:     "https://example.com/?utm_source=ads",
+			expected: "https://example.com/?utm_campaign=spring&utm_medium=social&utm_source=ads",
+		},
+		{
+			tCase:    "prefix",
+			URI:      "http://host.com/b/anything/else?x=1",
+			meta:     store.Meta{Prefix: true},
+			dest:     "https://docs.example.com/",
+			expected: "https://docs.example.com/anything/else?utm_medium=link&utm_source=shorty",
+		},
+		{
+			tCase:    "prefix with path of destination",
+			URI:      "http://host.com/b/a%20b",
+			meta:     store.Meta{Prefix: true, ForwardQuery: true},
+			dest:     "https://docs.example.com/v2?lang=en",
+			expected: "https://docs.example.com/v2/a%20b?lang=en&utm_medium=link&utm_source=shorty",
+		},
+	}
+
+	for _, tc := range testTable {
+		t.Run(tc.tCase, func(t *testing.T) {
+			ctx := initCtx("GET", tc.URI, nil)
+			ao.Equal(tc.expected, env.passthrough(ctx, store.Link{Short: "b", Meta: tc.meta}, tc.dest))
+		})
+	}
+
+	env.Config.UTMDefaults = nil
+	ctx := initCtx("GET", "http://host.com/b?x=1", nil)
+	ao.Equal("https://example.com/?b=2&a=1", env.passthrough(ctx, store.Link{Short: "b"}, "https://example.com/?b=2&a=1"),
+		"destinations must not be changed if there is nothing to add")
+}
+
+func Test_longerPrefix(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+	mockEnv.withoutTenants()
+
+	prefix := store.Link{Short: "b", Long: "https://docs.example.com", Meta: store.Meta{Prefix: true}}
+	mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("b")).Return(prefix, nil)
+	mockEnv.Cache.EXPECT().Click(store.Namespace{}, prefix, store.Visit{}).Return(nil)
+	mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("c")).Return(store.Link{Short: "c", Long: "https://example.com"}, nil)
+
+	ctx := initCtx("GET", "http://host.com/b/anything/else", nil)
+	env.Handle(ctx)
+	ao.Equal(fasthttp.StatusOK, ctx.Response.StatusCode())
+	ao.Equal("https://docs.example.com/anything/else", string(ctx.Response.Body()))
+
+	ctx = initCtx("GET", "http://host.com/c/anything", nil)
+	env.Handle(ctx)
+	ao.Equal(fasthttp.StatusNotFound, ctx.Response.StatusCode(), "only prefix links resolve paths below the alias")
+}
+
+func Test_validateUTM(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+
+	ao.NoError(validateUTM(map[string]string{"source": "newsletter", "campaign": "spring"}))
+	err := validateUTM(map[string]string{"utm_source": "newsletter"})
+	ao.True(errors.Is(err, ErrInvalidMeta))
+	ao.EqualError(err, `invalid link details: unknown UTM parameter "utm_source", expected one of source, medium, campaign, term, content`)
+}
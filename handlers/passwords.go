@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/metrics"
+	"github.com/yexelm/shorty/store"
+)
+
+//go:generate mockgen -source=passwords.go -destination=passwords_mocks.go -package=handlers -self_package=shorty/handlers
+
+// maxPasswordLen is the longest password bcrypt takes into account.
+const maxPasswordLen = 72
+
+// unlockCookie prefixes names of cookies keeping the signed proof that the visitor entered the password of a link.
+const unlockCookie = "shorty_unlock_"
+
+var (
+	ErrPasswordRequired = errors.New("password required")
+	ErrWrongPassword    = errors.New("wrong password")
+	ErrLocked           = errors.New("too many wrong passwords, try again later")
+
+	challengePage = template.Must(template.New("challenge").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Password required</title>
+</head>
+<body>
+<form method="post" action="{{.Path}}{{.Query}}">
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+<label>This link is protected. Password: <input type="password" name="password" autofocus required></label>
+<button type="submit">Continue</button>
+</form>
+</body>
+</html>
+`))
+)
+
+// PasswordGuard counts wrong passwords entered for protected links.
+type PasswordGuard interface {
+	Attempts(ns store.Namespace, short []byte) (int, error)
+	FailAttempt(ns store.Namespace, short []byte, window time.Duration) (int, error)
+	ResetAttempts(ns store.Namespace, short []byte) error
+}
+
+// newSecret returns the configured cookie secret or a random one.
+func newSecret(configured string) []byte {
+	if configured != "" {
+		return []byte(configured)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+
+	return secret
+}
+
+// hashPassword returns the hash to be saved for the password, an empty password removes the protection.
+func hashPassword(password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+	if len(password) > maxPasswordLen {
+		return "", fmt.Errorf("%w: password is longer than %d bytes", ErrInvalidMeta, maxPasswordLen)
+	}
+
+	return store.HashPassword(password)
+}
+
+// unlockSignature signs the expiry of the unlock cookie of the link. The hash of the password is signed too, so that
+// changing the password locks the link again.
+func (env *Environment) unlockSignature(ns store.Namespace, l store.Link, expires string) string {
+	mac := hmac.New(sha256.New, env.secret)
+	for _, v := range []string{ns.Tenant, ns.Domain, l.Short, expires, l.PasswordHash} {
+		mac.Write([]byte(v))
+		mac.Write([]byte{0})
+	}
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// unlocked reports whether the visitor has a valid unlock cookie for the link.
+func (env *Environment) unlocked(ctx *fasthttp.RequestCtx, ns store.Namespace, l store.Link) bool {
+	v := string(ctx.Request.Header.Cookie(unlockCookie + l.Short))
+	i := strings.IndexByte(v, '.')
+	if i < 0 {
+		return false
+	}
+
+	expires, err := strconv.ParseInt(v[:i], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+
+	return hmac.Equal([]byte(v[i+1:]), []byte(env.unlockSignature(ns, l, v[:i])))
+}
+
+// setUnlockCookie lets the visitor follow the link without entering the password again until the cookie expires. The
+// cookie is sent with all paths, so that the preview and the paths below the alias are unlocked too; it is bound to
+// the alias by its name and its signature.
+func (env *Environment) setUnlockCookie(ctx *fasthttp.RequestCtx, ns store.Namespace, l store.Link) {
+	expires := time.Now().Add(env.Config.UnlockTTL)
+	unix := strconv.FormatInt(expires.Unix(), 10)
+
+	c := fasthttp.AcquireCookie()
+	defer fasthttp.ReleaseCookie(c)
+
+	c.SetKey(unlockCookie + l.Short)
+	c.SetValue(unix + "." + env.unlockSignature(ns, l, unix))
+	c.SetPath("/")
+	c.SetExpire(expires)
+	c.SetHTTPOnly(true)
+	c.SetSameSite(fasthttp.CookieSameSiteLaxMode)
+	c.SetSecure(env.requestScheme(ctx) == "https")
+	ctx.Response.Header.SetCookie(c)
+}
+
+// wantsJSON reports whether the client prefers JSON responses over HTML pages.
+func wantsJSON(ctx *fasthttp.RequestCtx) bool {
+	return bytes.Contains(ctx.Request.Header.Peek(fasthttp.HeaderAccept), []byte("application/json")) ||
+		bytes.HasPrefix(ctx.Request.Header.ContentType(), []byte("application/json"))
+}
+
+// challenge asks the visitor for the password of the link, with a form posting it back to the requested path or with
+// a JSON error.
+func challenge(ctx *fasthttp.RequestCtx, code int, err error) {
+	if wantsJSON(ctx) {
+		writeError(ctx, code, err)
+		return
+	}
+
+	var page bytes.Buffer
+	// the query is kept for routing rules matching query parameters and links forwarding queries
+	var query string
+	if q := ctx.URI().QueryString(); len(q) > 0 {
+		query = "?" + string(q)
+	}
+
+	if err := challengePage.Execute(&page, struct {
+		Path  string
+		Query string
+		Error string
+	}{string(ctx.Path()), query, errorMessage(err)}); err != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.WriteString(err.Error())
+		return
+	}
+
+	ctx.SetStatusCode(code)
+	ctx.SetContentType("text/html; charset=utf-8")
+	ctx.Write(page.Bytes())
+}
+
+// errorMessage returns the message shown on the challenge page, none is shown before the first attempt.
+func errorMessage(err error) string {
+	if err == ErrPasswordRequired {
+		return ""
+	}
+
+	return err.Error()
+}
+
+// password returns the password sent in the form or in the JSON body.
+func password(ctx *fasthttp.RequestCtx) string {
+	if !bytes.HasPrefix(ctx.Request.Header.ContentType(), []byte("application/json")) {
+		return string(ctx.PostArgs().Peek("password"))
+	}
+
+	var body struct {
+		Password string `json:"password"`
+	}
+	_ = json.Unmarshal(ctx.Request.Body(), &body)
+
+	return body.Password
+}
+
+// protected reports whether the path of the request is the alias of a password protected link in the namespace of
+// the host.
+func (env *Environment) protected(ctx *fasthttp.RequestCtx) bool {
+	alias, _ := splitAlias(string(ctx.Path()))
+	ns, err := env.hostNamespace(ctx)
+	if err != nil {
+		return false
+	}
+	l, err := env.Links.Link(ns, []byte(strings.TrimSuffix(alias, previewSuffix)))
+
+	return err == nil && l.Protected
+}
+
+// unlock checks the password of the protected link. The visitor gets the original URL, or the preview of the link if
+// it is shown, and a cookie unlocking the link for a while if it is correct. The link is locked for everybody after
+// too many wrong passwords.
+func (env *Environment) unlock(ctx *fasthttp.RequestCtx) {
+	ns, l, ok := env.resolve(ctx, "unlock")
+	if !ok {
+		return
+	}
+	if !l.Protected {
+		env.writeLong(ctx, ns, l)
+		return
+	}
+
+	short := []byte(l.Short)
+	if max := env.Config.PasswordMaxAttempts; max > 0 {
+		n, err := env.Passwords.Attempts(ns, short)
+		if err != nil {
+			env.unlockFailed(ctx, err)
+			return
+		}
+		if n >= max {
+			env.locked(ctx, l)
+			return
+		}
+	}
+
+	if !store.CheckPassword(l.PasswordHash, password(ctx)) {
+		metrics.WrongPasswords.Inc()
+		n, err := env.Passwords.FailAttempt(ns, short, env.Config.PasswordLockout)
+		if err != nil {
+			env.unlockFailed(ctx, err)
+			return
+		}
+		if max := env.Config.PasswordMaxAttempts; max > 0 && n >= max {
+			env.locked(ctx, l)
+			return
+		}
+
+		challenge(ctx, fasthttp.StatusUnauthorized, ErrWrongPassword)
+		return
+	}
+
+	if err := env.Passwords.ResetAttempts(ns, short); err != nil {
+		env.unlockFailed(ctx, err)
+		return
+	}
+	if requested := previewRequested(ctx); requested || l.Preview {
+		env.setUnlockCookie(ctx, ns, l)
+		env.preview(ctx, ns, l, !requested)
+		return
+	}
+	v := env.visit(ctx, l)
+	if !env.click(ctx, ns, l, v) {
+		return
+	}
+	env.setUnlockCookie(ctx, ns, l)
+	metrics.LinksResolved.Inc()
+
+	long := env.passthrough(ctx, l, destination(ctx, l, v))
+	if wantsJSON(ctx) {
+		writeJSON(ctx, fasthttp.StatusOK, map[string]string{"url": long})
+		return
+	}
+
+	ctx.Response.Header.Set(fasthttp.HeaderLocation, long)
+	ctx.SetStatusCode(fasthttp.StatusSeeOther)
+}
+
+// locked tells the visitor that the link is locked after too many wrong passwords.
+func (env *Environment) locked(ctx *fasthttp.RequestCtx, l store.Link) {
+	ctx.Response.Header.Set(fasthttp.HeaderRetryAfter, strconv.Itoa(int(env.Config.PasswordLockout.Seconds())))
+	challenge(ctx, fasthttp.StatusTooManyRequests, ErrLocked)
+}
+
+func (env *Environment) unlockFailed(ctx *fasthttp.RequestCtx, err error) {
+	metrics.Errors.WithLabelValues("unlock").Inc()
+	ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+	ctx.WriteString(err.Error())
+}
@@ -0,0 +1,80 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: passwords.go
+
+// Package handlers is a generated GoMock package.
+package handlers
+
+import (
+	reflect "reflect"
+	time "time"
+
+	gomock "github.com/golang/mock/gomock"
+	store "github.com/yexelm/shorty/store"
+)
+
+// MockPasswordGuard is a mock of PasswordGuard interface.
+type MockPasswordGuard struct {
+	ctrl     *gomock.Controller
+	recorder *MockPasswordGuardMockRecorder
+}
+
+// MockPasswordGuardMockRecorder is the mock recorder for MockPasswordGuard.
+type MockPasswordGuardMockRecorder struct {
+	mock *MockPasswordGuard
+}
+
+// NewMockPasswordGuard creates a new mock instance.
+func NewMockPasswordGuard(ctrl *gomock.Controller) *MockPasswordGuard {
+	mock := &MockPasswordGuard{ctrl: ctrl}
+	mock.recorder = &MockPasswordGuardMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockPasswordGuard) EXPECT() *MockPasswordGuardMockRecorder {
+	return m.recorder
+}
+
+// Attempts mocks base method.
+func (m *MockPasswordGuard) Attempts(ns store.Namespace, short []byte) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Attempts", ns, short)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Attempts indicates an expected call of Attempts.
+func (mr *MockPasswordGuardMockRecorder) Attempts(ns, short interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Attempts", reflect.TypeOf((*MockPasswordGuard)(nil).Attempts), ns, short)
+}
+
+// FailAttempt mocks base method.
+func (m *MockPasswordGuard) FailAttempt(ns store.Namespace, short []byte, window time.Duration) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FailAttempt", ns, short, window)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FailAttempt indicates an expected call of FailAttempt.
+func (mr *MockPasswordGuardMockRecorder) FailAttempt(ns, short, window interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FailAttempt", reflect.TypeOf((*MockPasswordGuard)(nil).FailAttempt), ns, short, window)
+}
+
+// ResetAttempts mocks base method.
+func (m *MockPasswordGuard) ResetAttempts(ns store.Namespace, short []byte) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetAttempts", ns, short)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResetAttempts indicates an expected call of ResetAttempts.
+func (mr *MockPasswordGuardMockRecorder) ResetAttempts(ns, short interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetAttempts", reflect.TypeOf((*MockPasswordGuard)(nil).ResetAttempts), ns, short)
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/store"
+)
+
+func Test_unlock(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+	mockEnv.withoutTenants()
+
+	hash, err := store.HashPassword("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := store.Link{Short: "b", Long: "https://go.dev", Protected: true, Meta: store.Meta{PasswordHash: hash}}
+	lockout := env.Config.PasswordLockout
+
+	type testData struct {
+		tCase        string
+		method       string
+		contentType  string
+		accept       string
+		body         string
+		expectedFunc func()
+
+		expectedBody     string
+		expectedCode     int
+		expectedLocation string
+		expectedCookie   bool
+	}
+
+	testTable := []testData{
+		{
+			tCase:  "challenge page",
+			method: "GET",
+			expectedFunc: func() {
+				mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("b")).Return(link, nil)
+			},
+			expectedBody: `<form method="post" action="/b">`,
+			expectedCode: fasthttp.StatusUnauthorized,
+		},
+		{
+			tCase:  "JSON challenge",
+			method: "GET",
+			accept: "application/json",
+			expectedFunc: func() {
+				mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("b")).Return(link, nil)
+			},
+			expectedBody: `{"error":"password required"}`,
+			expectedCode: fasthttp.StatusUnauthorized,
+		},
+		{
+			tCase:       "wrong password",
+			method:      "POST",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "password=secret",
+			expectedFunc: func() {
+				mockEnv.Links.EXPECT().Link(store.Namespace{}, []byte("b")).Return(link, nil)
+				mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("b")).Return(link, nil)
+				mockEnv.Passwords.EXPECT().Attempts(store.Namespace{}, []byte("b")).Return(0, nil)
+				mockEnv.Passwords.EXPECT().FailAttempt(store.Namespace{}, []byte("b"), lockout).Return(1, nil)
+			},
+			expectedBody: "<p>wrong password</p>",
+			expectedCode: fasthttp.StatusUnauthorized,
+		},
+		{
+			tCase:       "last wrong password",
+			method:      "POST",
+			contentType: "application/json",
+			body:        `{"password":"secret"}`,
+			expectedFunc: func() {
+				mockEnv.Links.EXPECT().Link(store.Namespace{}, []byte("b")).Return(link, nil)
+				mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("b")).Return(link, nil)
+				mockEnv.Passwords.EXPECT().Attempts(store.Namespace{}, []byte("b")).Return(4, nil)
+				mockEnv.Passwords.EXPECT().FailAttempt(store.Namespace{}, []byte("b"), lockout).Return(5, nil)
+			},
+			expectedBody: `{"error":"too many wrong passwords, try again later"}`,
+			expectedCode: fasthttp.StatusTooManyRequests,
+		},
+		{
+			tCase:       "locked",
+			method:      "POST",
+			contentType: "application/json",
+			body:        `{"password":"s3cret"}`,
+			expectedFunc: func() {
+				mockEnv.Links.EXPECT().Link(store.Namespace{}, []byte("b")).Return(link, nil)
+				mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("b")).Return(link, nil)
+				mockEnv.Passwords.EXPECT().Attempts(store.Namespace{}, []byte("b")).Return(5, nil)
+			},
+			expectedBody: `{"error":"too many wrong passwords, try again later"}`,
+			expectedCode: fasthttp.StatusTooManyRequests,
+		},
+		{
+			tCase:       "unlock with JSON",
+			method:      "POST",
+			contentType: "application/json",
+			body:        `{"password":"s3cret"}`,
+			expectedFunc: func() {
+				mockEnv.Links.EXPECT().Link(store.Namespace{}, []byte("b")).Return(link, nil)
+				mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("b")).Return(link, nil)
+				mockEnv.Passwords.EXPECT().Attempts(store.Namespace{}, []byte("b")).Return(2, nil)
+				mockEnv.Passwords.EXPECT().ResetAttempts(store.Namespace{}, []byte("b")).Return(nil)
+				mockEnv.Cache.EXPECT().Click(store.Namespace{}, link, store.Visit{}).Return(nil)
+			},
+			expectedBody:   `{"url":"https://go.dev"}`,
+			expectedCode:   fasthttp.StatusOK,
+			expectedCookie: true,
+		},
+		{
+			tCase:       "unlock with form",
+			method:      "POST",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "password=s3cret",
+			expectedFunc: func() {
+				mockEnv.Links.EXPECT().Link(store.Namespace{}, []byte("b")).Return(link, nil)
+				mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("b")).Return(link, nil)
+				mockEnv.Passwords.EXPECT().Attempts(store.Namespace{}, []byte("b")).Return(0, nil)
+				mockEnv.Passwords.EXPECT().ResetAttempts(store.Namespace{}, []byte("b")).Return(nil)
+				mockEnv.Cache.EXPECT().Click(store.Namespace{}, link, store.Visit{}).Return(nil)
+			},
+			expectedCode:     fasthttp.StatusSeeOther,
+			expectedLocation: "https://go.dev",
+			expectedCookie:   true,
+		},
+		{
+			tCase:       "not protected",
+			method:      "POST",
+			contentType: "text/plain",
+			body:        "https://example.com",
+			expectedFunc: func() {
+				mockEnv.Links.EXPECT().Link(store.Namespace{}, []byte("b")).Return(store.Link{Short: "b", Long: "https://go.dev"}, nil)
+				mockEnv.Cache.EXPECT().Shorter(store.Namespace{}, []byte("https://example.com"), store.Meta{}).
+					Return([]byte("c"), true, nil)
+			},
+			expectedBody: "http://host.com/c",
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:       "missing alias",
+			method:      "POST",
+			contentType: "text/plain",
+			body:        "https://example.com",
+			expectedFunc: func() {
+				mockEnv.Links.EXPECT().Link(store.Namespace{}, []byte("b")).Return(store.Link{}, redis.ErrNil)
+				mockEnv.Cache.EXPECT().Shorter(store.Namespace{}, []byte("https://example.com"), store.Meta{}).
+					Return([]byte("c"), true, nil)
+			},
+			expectedBody: "http://host.com/c",
+			expectedCode: fasthttp.StatusOK,
+		},
+	}
+
+	for _, tc := range testTable {
+		t.Run(tc.tCase, func(t *testing.T) {
+			ctx := initCtx(tc.method, "http://host.com/b", []byte(tc.body))
+			if tc.contentType != "" {
+				ctx.Request.Header.SetContentType(tc.contentType)
+			}
+			if tc.accept != "" {
+				ctx.Request.Header.Set(fasthttp.HeaderAccept, tc.accept)
+			}
+			tc.expectedFunc()
+			env.Handle(ctx)
+
+			ao.Equal(tc.expectedCode, ctx.Response.StatusCode())
+			ao.Contains(string(ctx.Response.Body()), tc.expectedBody)
+			if tc.expectedLocation != "" {
+				ao.Equal(tc.expectedLocation, string(ctx.Response.Header.Peek(fasthttp.HeaderLocation)))
+			}
+			if tc.expectedCode == fasthttp.StatusTooManyRequests {
+				ao.Equal("900", string(ctx.Response.Header.Peek(fasthttp.HeaderRetryAfter)))
+			}
+
+			c := fasthttp.AcquireCookie()
+			defer fasthttp.ReleaseCookie(c)
+			c.SetKey(unlockCookie + "b")
+			ao.Equal(tc.expectedCookie, ctx.Response.Header.Cookie(c))
+			if tc.expectedCookie {
+				ao.Equal("/", string(c.Path()))
+				ao.True(c.HTTPOnly())
+			}
+		})
+	}
+}
+
+func Test_unlockCookie(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+
+	link := store.Link{Short: "b", Long: "https://go.dev", Protected: true, Meta: store.Meta{PasswordHash: "hash"}}
+	ns := store.Namespace{Tenant: "acme"}
+
+	ctx := initCtx("POST", "http://host.com/b", nil)
+	env.setUnlockCookie(ctx, ns, link)
+	c := fasthttp.AcquireCookie()
+	defer fasthttp.ReleaseCookie(c)
+	c.SetKey(unlockCookie + "b")
+	ao.True(ctx.Response.Header.Cookie(c))
+
+	visit := func(value string) *fasthttp.RequestCtx {
+		ctx := initCtx("GET", "http://host.com/b", nil)
+		ctx.Request.Header.SetCookie(unlockCookie+"b", value)
+		return ctx
+	}
+
+	value := string(c.Value())
+	ao.True(env.unlocked(visit(value), ns, link))
+	ao.False(env.unlocked(visit(value), store.Namespace{}, link), "the cookie must be bound to the namespace")
+
+	changed := link
+	changed.PasswordHash = "new hash"
+	ao.False(env.unlocked(visit(value), ns, changed), "changing the password must lock the link again")
+
+	expired := strings.Replace(value, value[:strings.IndexByte(value, '.')], "1", 1)
+	ao.False(env.unlocked(visit(expired), ns, link))
+	ao.False(env.unlocked(visit("garbage"), ns, link))
+
+	env.Config.UnlockTTL = -time.Minute
+	env.setUnlockCookie(ctx, ns, link)
+	ao.True(ctx.Response.Header.Cookie(c))
+	ao.False(env.unlocked(visit(string(c.Value())), ns, link), "expired cookies must be rejected")
+}
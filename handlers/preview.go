@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/metrics"
+	"github.com/yexelm/shorty/store"
+)
+
+// previewSuffix appended to an alias requests the preview of the link instead of its destination.
+const previewSuffix = "+"
+
+var defaultPreviewPage = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>{{if .Title}}{{.Title}}{{else}}Link preview{{end}}</title>
+</head>
+<body>
+{{if .Title}}<h1>{{.Title}}</h1>{{end}}
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+<p>This link goes to <code>{{.URL}}</code></p>
+{{if not .CreatedAt.IsZero}}<p>Created on {{.CreatedAt.Format "January 2, 2006"}}</p>{{end}}
+<p><a href="{{.URL}}" rel="noopener noreferrer">Continue</a></p>
+</body>
+</html>
+`))
+
+// previewPage is executed with the link shown on the preview page, it is also written as JSON.
+type previewPage struct {
+	Short       string    `json:"short"`
+	URL         string    `json:"url"`
+	Title       string    `json:"title,omitempty"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// previewRequested reports whether the preview suffix follows the alias.
+func previewRequested(ctx *fasthttp.RequestCtx) bool {
+	alias, rest := splitAlias(string(ctx.Path()))
+	return rest == "" && strings.HasSuffix(alias, previewSuffix)
+}
+
+// loadPreviewPage parses the preview page, the default one is returned if no file is configured.
+func loadPreviewPage(file string) (*template.Template, error) {
+	if file == "" {
+		return defaultPreviewPage, nil
+	}
+
+	return template.ParseFiles(file)
+}
+
+// preview shows the destination of the link with its details instead of sending the visitor there. Previews
+// requested with the suffix are not counted as resolutions, the ones of links always showing them are.
+func (env *Environment) preview(ctx *fasthttp.RequestCtx, ns store.Namespace, l store.Link, count bool) {
+	v := env.visit(ctx, l)
+	if count {
+		if !env.click(ctx, ns, l, v) {
+			return
+		}
+		metrics.LinksResolved.Inc()
+	}
+
+	p := previewPage{
+		Short:       l.Short,
+		URL:         env.passthrough(ctx, l, destination(ctx, l, v)),
+		Title:       l.Title,
+		Description: l.Description,
+		CreatedAt:   l.CreatedAt,
+	}
+	if wantsJSON(ctx) {
+		writeJSON(ctx, fasthttp.StatusOK, p)
+		return
+	}
+
+	page := env.previewPage
+	if page == nil {
+		page = defaultPreviewPage
+	}
+
+	var buf bytes.Buffer
+	if err := page.Execute(&buf, p); err != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.WriteString(err.Error())
+		return
+	}
+
+	ctx.SetContentType("text/html; charset=utf-8")
+	ctx.Write(buf.Bytes())
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/store"
+)
+
+func Test_preview(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+	mockEnv.withoutTenants()
+
+	created := time.Date(2021, 4, 1, 9, 0, 0, 0, time.UTC)
+	link := store.Link{Short: "b", Long: "https://example.com/?a=1&b=2", CreatedAt: created, Meta: store.Meta{
+		Title: `Tom & "Jerry" <script>alert(1)</script>`,
+	}}
+	always := store.Link{Short: "c", Long: "https://example.com/c", Meta: store.Meta{Preview: true}}
+	unsafe := store.Link{Short: "d", Long: "javascript:alert(1)"}
+
+	type testData struct {
+		tCase        string
+		URI          string
+		accept       string
+		expectedFunc func()
+
+		expectedBody string
+		expectedCode int
+	}
+
+	testTable := []testData{
+		{
+			tCase: "requested preview",
+			URI:   "http://host.com/b+",
+			expectedFunc: func() {
+				mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("b")).Return(link, nil)
+			},
+			expectedBody: `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Tom &amp; &#34;Jerry&#34; &lt;script&gt;alert(1)&lt;/script&gt;</title>
+</head>
+<body>
+<h1>Tom &amp; &#34;Jerry&#34; &lt;script&gt;alert(1)&lt;/script&gt;</h1>
+
+<p>This link goes to <code>https://example.com/?a=1&amp;b=2</code></p>
+<p>Created on April 1, 2021</p>
+<p><a href="https://example.com/?a=1&amp;b=2" rel="noopener noreferrer">Continue</a></p>
+</body>
+</html>
+`,
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:  "JSON preview",
+			URI:    "http://host.com/b+",
+			accept: "application/json",
+			expectedFunc: func() {
+				mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("b")).Return(link, nil)
+			},
+			expectedBody: `{"short":"b","url":"https://example.com/?a=1\u0026b=2",` +
+				`"title":"Tom \u0026 \"Jerry\" \u003cscript\u003ealert(1)\u003c/script\u003e",` +
+				`"created_at":"2021-04-01T09:00:00Z"}`,
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:  "always shown preview is counted",
+			URI:    "http://host.com/c",
+			accept: "application/json",
+			expectedFunc: func() {
+				mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("c")).Return(always, nil)
+				mockEnv.Cache.EXPECT().Click(store.Namespace{}, always, store.Visit{}).Return(nil)
+			},
+			expectedBody: `{"short":"c","url":"https://example.com/c","created_at":"0001-01-01T00:00:00Z"}`,
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:  "exhausted link",
+			URI:    "http://host.com/c",
+			accept: "application/json",
+			expectedFunc: func() {
+				mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("c")).Return(always, nil)
+				mockEnv.Cache.EXPECT().Click(store.Namespace{}, always, store.Visit{}).Return(store.ErrExhausted)
+			},
+			expectedBody: store.ErrExhausted.Error(),
+			expectedCode: fasthttp.StatusGone,
+		},
+		{
+			tCase: "preview of a prefix path",
+			URI:   "http://host.com/b/x+",
+			expectedFunc: func() {
+				mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("b")).Return(link, nil)
+			},
+			expectedBody: ErrShortCodeNotFound.Error(),
+			expectedCode: fasthttp.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testTable {
+		t.Run(tc.tCase, func(t *testing.T) {
+			ctx := initCtx("GET", tc.URI, nil)
+			if tc.accept != "" {
+				ctx.Request.Header.Set(fasthttp.HeaderAccept, tc.accept)
+			}
+			tc.expectedFunc()
+
+			env.Handle(ctx)
+			ao.Equal(tc.expectedCode, ctx.Response.StatusCode())
+			ao.Equal(tc.expectedBody, string(ctx.Response.Body()))
+		})
+	}
+
+	mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("d")).Return(unsafe, nil)
+	ctx := initCtx("GET", "http://host.com/d+", nil)
+	env.Handle(ctx)
+	ao.Contains(string(ctx.Response.Body()), `<a href="#ZgotmplZ"`, "unsafe destinations must not be linked")
+}
+
+func Test_unlockPreview(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+	mockEnv.withoutTenants()
+
+	hash, err := store.HashPassword("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := store.Link{Short: "b", Long: "https://go.dev", Protected: true, Meta: store.Meta{PasswordHash: hash}}
+	mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("b")).Return(link, nil).Times(4)
+	mockEnv.Links.EXPECT().Link(store.Namespace{}, []byte("b")).Return(link, nil).Times(2)
+	mockEnv.Passwords.EXPECT().Attempts(store.Namespace{}, []byte("b")).Return(0, nil).Times(2)
+	mockEnv.Passwords.EXPECT().ResetAttempts(store.Namespace{}, []byte("b")).Return(nil).Times(2)
+	mockEnv.Cache.EXPECT().Click(store.Namespace{}, link, store.Visit{}).Return(nil)
+
+	ctx := initCtx("GET", "http://host.com/b+", nil)
+	env.Handle(ctx)
+	ao.Equal(fasthttp.StatusUnauthorized, ctx.Response.StatusCode(), "previews must not reveal protected destinations")
+	ao.Contains(string(ctx.Response.Body()), `<form method="post" action="/b&#43;">`)
+
+	ctx = initCtx("POST", "http://host.com/b+", []byte(`{"password":"s3cret"}`))
+	ctx.Request.Header.SetContentType("application/json")
+	env.Handle(ctx)
+	ao.Equal(fasthttp.StatusOK, ctx.Response.StatusCode())
+	ao.Equal(`{"short":"b","url":"https://go.dev","created_at":"0001-01-01T00:00:00Z"}`, string(ctx.Response.Body()))
+
+	// the link unlocked by its short URL stays unlocked in the preview
+	ctx = initCtx("POST", "http://host.com/b", []byte(`{"password":"s3cret"}`))
+	ctx.Request.Header.SetContentType("application/json")
+	env.Handle(ctx)
+	ao.Equal(fasthttp.StatusOK, ctx.Response.StatusCode())
+	c := fasthttp.AcquireCookie()
+	defer fasthttp.ReleaseCookie(c)
+	c.SetKey(unlockCookie + "b")
+	ao.True(ctx.Response.Header.Cookie(c))
+	ao.Equal("/", string(c.Path()), "the cookie must be sent with the preview too")
+
+	ctx = initCtx("GET", "http://host.com/b+", nil)
+	ctx.Request.Header.SetCookie(string(c.Key()), string(c.Value()))
+	env.Handle(ctx)
+	ao.Equal(fasthttp.StatusOK, ctx.Response.StatusCode())
+	ao.Contains(string(ctx.Response.Body()), "This link goes to <code>https://go.dev</code>")
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"bytes"
+	"net"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// fromTrustedProxy reports whether the request was sent by one of the trusted reverse proxies.
+func (env *Environment) fromTrustedProxy(ctx *fasthttp.RequestCtx) bool {
+	return env.trusted(ctx.RemoteIP())
+}
+
+// trusted reports whether the address belongs to one of the trusted reverse proxies.
+func (env *Environment) trusted(ip net.IP) bool {
+	for _, n := range env.Config.TrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forwarded returns the first value of the X-Forwarded-* header if the request was sent by a trusted proxy.
+func (env *Environment) forwarded(ctx *fasthttp.RequestCtx, header string) []byte {
+	v := ctx.Request.Header.Peek(header)
+	if len(v) == 0 || !env.fromTrustedProxy(ctx) {
+		return nil
+	}
+
+	if i := bytes.IndexByte(v, ','); i >= 0 {
+		v = v[:i]
+	}
+
+	return bytes.TrimSpace(v)
+}
+
+// requestHost returns the host the client sent the request to, which may be forwarded by a trusted proxy.
+func (env *Environment) requestHost(ctx *fasthttp.RequestCtx) string {
+	if h := env.forwarded(ctx, "X-Forwarded-Host"); len(h) > 0 {
+		return string(h)
+	}
+
+	return string(ctx.Host())
+}
+
+// requestScheme returns the scheme the client sent the request with, which may be forwarded by a trusted proxy.
+func (env *Environment) requestScheme(ctx *fasthttp.RequestCtx) string {
+	switch p := string(env.forwarded(ctx, "X-Forwarded-Proto")); p {
+	case "http", "https":
+		return p
+	}
+
+	if ctx.IsTLS() {
+		return "https"
+	}
+
+	return "http"
+}
+
+// clientIP returns the address of the client. For requests sent by trusted proxies it is the last address in
+// X-Forwarded-For which is not one of the trusted proxies, since the leftmost ones can be forged by the client.
+func (env *Environment) clientIP(ctx *fasthttp.RequestCtx) net.IP {
+	ip := ctx.RemoteIP()
+	if !env.fromTrustedProxy(ctx) {
+		return ip
+	}
+
+	hops := strings.Split(string(ctx.Request.Header.Peek("X-Forwarded-For")), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !env.trusted(hop) {
+			break
+		}
+	}
+
+	return ip
+}
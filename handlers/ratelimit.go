@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/metrics"
+	"github.com/yexelm/shorty/store"
+)
+
+// rateCleanupInterval limits how often buckets of idle clients are removed.
+const rateCleanupInterval = time.Minute
+
+var ErrRateLimited = errors.New("too many requests, try again later")
+
+// rateLimiter limits requests of each client with a token bucket, which holds up to burst requests and is refilled
+// at the rate of requests per second.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	cleanedAt time.Time
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// newRateLimiter returns a rateLimiter allowing perMinute requests of each client per minute with bursts of up to
+// burst requests, or nil if perMinute is not positive.
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &rateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// allow takes a request from the bucket of the client. If the bucket is empty, false is returned along with the time
+// until the next request is allowed.
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.cleanup(now)
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, at: now}
+		l.buckets[client] = b
+	}
+	b.tokens = l.refilled(b, now)
+	b.at = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+
+	return true, 0
+}
+
+func (l *rateLimiter) refilled(b *bucket, now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.at).Seconds()*l.rate
+	if tokens > l.burst {
+		tokens = l.burst
+	}
+
+	return tokens
+}
+
+// cleanup removes full buckets, which are no different from buckets of new clients.
+func (l *rateLimiter) cleanup(now time.Time) {
+	if now.Sub(l.cleanedAt) < rateCleanupInterval {
+		return
+	}
+	l.cleanedAt = now
+
+	for client, b := range l.buckets {
+		if l.refilled(b, now) == l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// allowRequest reports whether the rate limit lets the client make another request to the handler. Clients are
+// identified by their API keys, or by their addresses if they do not pass a key. The time until the next request is
+// allowed is returned for rejected requests.
+func (env *Environment) allowRequest(handler string, ns store.Namespace, owner string, ip net.IP) (bool,
+	time.Duration) {
+	if env.limiter == nil {
+		return true, 0
+	}
+
+	client := "ip:" + ip.String()
+	if owner != "" {
+		client = "key:" + ns.Tenant + "/" + owner
+	}
+
+	ok, wait := env.limiter.allow(client, time.Now())
+	if !ok {
+		metrics.RateLimited.WithLabelValues(handler).Inc()
+	}
+
+	return ok, wait
+}
+
+// rateLimited writes the response for a request rejected by the rate limit unless it is allowed, in which case false
+// is returned.
+func (env *Environment) rateLimited(ctx *fasthttp.RequestCtx, handler string, ns store.Namespace, owner string) bool {
+	ok, wait := env.allowRequest(handler, ns, owner, env.clientIP(ctx))
+	if ok {
+		return false
+	}
+
+	// Retry-After is in whole seconds, rounded up so that the retry is allowed
+	ctx.Response.Header.Set(fasthttp.HeaderRetryAfter, strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+	ctx.SetStatusCode(fasthttp.StatusTooManyRequests)
+
+	return true
+}
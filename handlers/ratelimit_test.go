@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/store"
+)
+
+func Test_rateLimiter(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+
+	ao.Nil(newRateLimiter(0, 10), "requests must not be limited without a rate")
+
+	l := newRateLimiter(60, 2)
+	now := time.Now()
+
+	// bursts are allowed, then one request per second
+	ok, _ := l.allow("a", now)
+	ao.True(ok)
+	ok, _ = l.allow("a", now)
+	ao.True(ok)
+	ok, wait := l.allow("a", now)
+	ao.False(ok)
+	ao.Equal(time.Second, wait)
+	ok, _ = l.allow("b", now)
+	ao.True(ok, "clients must be limited separately")
+
+	ok, wait = l.allow("a", now.Add(500*time.Millisecond))
+	ao.False(ok)
+	ao.Equal(500*time.Millisecond, wait)
+	ok, _ = l.allow("a", now.Add(time.Second))
+	ao.True(ok)
+
+	// buckets refilled completely are removed
+	l.allow("c", now.Add(time.Minute))
+	ao.Len(l.buckets, 1)
+}
+
+func Test_rateLimited(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+	mockEnv.withoutTenants()
+	env.limiter = newRateLimiter(30, 1)
+
+	mockEnv.Cache.EXPECT().Shorter(store.Namespace{}, []byte("https://go.dev"), store.Meta{}).Return([]byte("b"), true, nil)
+
+	ctx := initCtx("POST", "http://host.com", []byte("https://go.dev"))
+	env.Handle(ctx)
+	ao.Equal(fasthttp.StatusOK, ctx.Response.StatusCode())
+
+	ctx = initCtx("POST", "http://host.com", []byte("https://go.dev"))
+	env.Handle(ctx)
+	ao.Equal(fasthttp.StatusTooManyRequests, ctx.Response.StatusCode())
+	ao.Equal("2", string(ctx.Response.Header.Peek(fasthttp.HeaderRetryAfter)))
+	ao.Equal(ErrRateLimited.Error(), string(ctx.Response.Body()))
+
+	// requests of other clients are allowed
+	mockEnv.Keys.EXPECT().Authenticate("key").Return(store.Principal{Owner: "team"}, nil)
+	mockEnv.Links.EXPECT().Search(store.Namespace{}, gomock.Any()).Return(nil, "", nil)
+	ctx = initCtx("GET", "http://host.com/api/v1/links", nil)
+	ctx.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer key")
+	env.Handle(ctx)
+	ao.Equal(fasthttp.StatusOK, ctx.Response.StatusCode())
+}
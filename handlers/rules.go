@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/store"
+)
+
+// maxRules limits the number of routing rules of a link.
+const maxRules = 32
+
+var countryCode = regexp.MustCompile(`^[A-Za-z]{2}$`)
+
+// platforms match User-Agent substrings to operating systems, more specific ones go first since iOS user agents
+// mention Mac OS X and Android ones mention Linux.
+var platforms = []struct {
+	name    string
+	mobile  bool
+	markers []string
+}{
+	{"ios", true, []string{"iPhone", "iPad", "iPod"}},
+	{"android", true, []string{"Android"}},
+	{"windows", false, []string{"Windows"}},
+	{"macos", false, []string{"Macintosh", "Mac OS X"}},
+	{"linux", false, []string{"Linux", "X11", "CrOS"}},
+}
+
+// platform returns the operating system of the visitor and whether it is a mobile one, the name is empty if the
+// system is unknown.
+func platform(userAgent []byte) (string, bool) {
+	for _, p := range platforms {
+		for _, m := range p.markers {
+			if bytes.Contains(userAgent, []byte(m)) {
+				return p.name, p.mobile
+			}
+		}
+	}
+
+	return "", false
+}
+
+// preferredLanguage returns the lowercase language tag with the highest quality in the Accept-Language header.
+func preferredLanguage(header []byte) string {
+	type language struct {
+		tag string
+		q   float64
+	}
+
+	var languages []language
+	for _, part := range strings.Split(string(header), ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		for _, f := range fields[1:] {
+			if v := strings.TrimSpace(f); strings.HasPrefix(v, "q=") {
+				if parsed, err := strconv.ParseFloat(v[2:], 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > 0 {
+			languages = append(languages, language{tag, q})
+		}
+	}
+	if len(languages) == 0 {
+		return ""
+	}
+
+	sort.SliceStable(languages, func(i, j int) bool { return languages[i].q > languages[j].q })
+	return languages[0].tag
+}
+
+// matchRule reports whether the visitor matches all conditions of the rule.
+func matchRule(ctx *fasthttp.RequestCtx, r store.Rule, v store.Visit) bool {
+	if r.Country != "" && !strings.EqualFold(r.Country, v.Country) {
+		return false
+	}
+
+	if r.Platform != "" {
+		name, mobile := platform(ctx.UserAgent())
+		switch r.Platform {
+		case "mobile":
+			if !mobile {
+				return false
+			}
+		case "desktop":
+			if mobile || name == "" {
+				return false
+			}
+		default:
+			if r.Platform != name {
+				return false
+			}
+		}
+	}
+
+	if r.Language != "" {
+		lang := preferredLanguage(ctx.Request.Header.Peek(fasthttp.HeaderAcceptLanguage))
+		want := strings.ToLower(r.Language)
+		if lang != want && !strings.HasPrefix(lang, want+"-") {
+			return false
+		}
+	}
+
+	args := ctx.QueryArgs()
+	for k, v := range r.Query {
+		if !args.Has(k) || v != "" && string(args.Peek(k)) != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchingRule returns the first rule of the link matching the visitor or nil.
+func matchingRule(ctx *fasthttp.RequestCtx, l store.Link, v store.Visit) *store.Rule {
+	for i := range l.Rules {
+		if matchRule(ctx, l.Rules[i], v) {
+			return &l.Rules[i]
+		}
+	}
+
+	return nil
+}
+
+// destination returns the URL of the first rule of the link matching the visitor, the URL of the variant the
+// visitor is assigned to, or the original URL.
+func destination(ctx *fasthttp.RequestCtx, l store.Link, v store.Visit) string {
+	if r := matchingRule(ctx, l, v); r != nil {
+		return r.URL
+	}
+
+	for _, variant := range l.Variants {
+		if v.Variant != "" && variant.Name == v.Variant {
+			return variant.URL
+		}
+	}
+
+	return l.Long
+}
+
+// validateRules checks routing rules against the limits.
+func validateRules(rules []store.Rule) error {
+	if len(rules) > maxRules {
+		return fmt.Errorf("%w: more than %d rules", ErrInvalidMeta, maxRules)
+	}
+
+	for i, r := range rules {
+		switch {
+		case r.URL == "" || len(r.URL) > maxNotesLen:
+			return fmt.Errorf("%w: rule %d has an empty or too long url", ErrInvalidMeta, i)
+		case r.Platform != "" && r.Platform != "mobile" && r.Platform != "desktop" && !knownPlatform(r.Platform):
+			return fmt.Errorf("%w: rule %d has unknown platform %q", ErrInvalidMeta, i, r.Platform)
+		case len(r.Language) > maxTagLen:
+			return fmt.Errorf("%w: rule %d has too long language", ErrInvalidMeta, i)
+		case r.Country != "" && !countryCode.MatchString(r.Country):
+			return fmt.Errorf("%w: rule %d has invalid country code %q", ErrInvalidMeta, i, r.Country)
+		case len(r.Query) > maxAttributes:
+			return fmt.Errorf("%w: rule %d has more than %d query parameters", ErrInvalidMeta, i, maxAttributes)
+		}
+	}
+
+	return nil
+}
+
+func knownPlatform(name string) bool {
+	for _, p := range platforms {
+		if p.name == name {
+			return true
+		}
+	}
+
+	return false
+}
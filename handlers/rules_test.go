@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/store"
+)
+
+const (
+	iPhoneUA  = "Mozilla/5.0 (iPhone; CPU iPhone OS 14_4 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148"
+	androidUA = "Mozilla/5.0 (Linux; Android 11; Pixel 5) AppleWebKit/537.36 Chrome/89.0 Mobile Safari/537.36"
+	windowsUA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/89.0 Safari/537.36"
+	macUA     = "Mozilla/5.0 (Macintosh; Intel Mac OS X 11_2_3) AppleWebKit/605.1.15 Version/14.0.3 Safari/605.1.15"
+	linuxUA   = "Mozilla/5.0 (X11; Linux x86_64; rv:86.0) Gecko/20100101 Firefox/86.0"
+)
+
+func Test_platform(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+
+	type testData struct {
+		userAgent      string
+		expectedName   string
+		expectedMobile bool
+	}
+
+	testTable := []testData{
+		{iPhoneUA, "ios", true},
+		{"Mozilla/5.0 (iPad; CPU OS 12_5 like Mac OS X)", "ios", true},
+		{androidUA, "android", true},
+		{windowsUA, "windows", false},
+		{macUA, "macos", false},
+		{linuxUA, "linux", false},
+		{"curl/7.68.0", "", false},
+		{"", "", false},
+	}
+
+	for _, tc := range testTable {
+		name, mobile := platform([]byte(tc.userAgent))
+		ao.Equal(tc.expectedName, name, tc.userAgent)
+		ao.Equal(tc.expectedMobile, mobile, tc.userAgent)
+	}
+}
+
+func Test_preferredLanguage(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+
+	type testData struct {
+		header   string
+		expected string
+	}
+
+	testTable := []testData{
+		{"", ""},
+		{"de", "de"},
+		{"fr-CH, fr;q=0.9, en;q=0.8, *;q=0.5", "fr-ch"},
+		{"en;q=0.5, de-AT;q=0.9", "de-at"},
+		{"en;q=0.5, de;q=0.5", "en"},
+		{"*", ""},
+		{"de;q=0, en;q=0.1", "en"},
+		{"es;q=invalid", "es"},
+	}
+
+	for _, tc := range testTable {
+		ao.Equal(tc.expected, preferredLanguage([]byte(tc.header)), tc.header)
+	}
+}
+
+func Test_destination(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+
+	link := store.Link{Long: "https://example.com", Meta: store.Meta{Rules: []store.Rule{
+		{Query: map[string]string{"preview": ""}, URL: "https://example.com/preview"},
+		{Country: "ch", Language: "fr", URL: "https://example.ch/fr"},
+		{Platform: "ios", URL: "https://apps.apple.com/app/example"},
+		{Platform: "android", URL: "https://play.google.com/store/apps/details?id=com.example"},
+		{Language: "de", Query: map[string]string{"utm_source": "newsletter"}, URL: "https://example.de/newsletter"},
+		{Language: "de", URL: "https://example.de"},
+		{Platform: "desktop", Language: "fr-CA", URL: "https://example.ca/fr"},
+	}}}
+
+	type testData struct {
+		tCase          string
+		query          string
+		userAgent      string
+		acceptLanguage string
+		country        string
+
+		expected string
+	}
+
+	testTable := []testData{
+		{tCase: "no rule matches", userAgent: windowsUA, expected: "https://example.com"},
+		{tCase: "unknown platform", userAgent: "curl/7.68.0", acceptLanguage: "fr-CA", expected: "https://example.com"},
+		{tCase: "iOS", userAgent: iPhoneUA, expected: "https://apps.apple.com/app/example"},
+		{tCase: "Android", userAgent: androidUA, acceptLanguage: "de", expected: "https://play.google.com/store/apps/details?id=com.example"},
+		{tCase: "earlier rule wins", query: "preview", userAgent: iPhoneUA, expected: "https://example.com/preview"},
+		{tCase: "regional language", userAgent: macUA, acceptLanguage: "de-CH, en;q=0.8", expected: "https://example.de"},
+		{tCase: "not preferred language", userAgent: macUA, acceptLanguage: "en, de;q=0.8", expected: "https://example.com"},
+		{tCase: "language and query", query: "utm_source=newsletter", userAgent: linuxUA, acceptLanguage: "de", expected: "https://example.de/newsletter"},
+		{tCase: "other query value", query: "utm_source=ads", userAgent: linuxUA, acceptLanguage: "de", expected: "https://example.de"},
+		{tCase: "desktop and language", userAgent: linuxUA, acceptLanguage: "fr-ca", expected: "https://example.ca/fr"},
+		{tCase: "primary tag does not match regional rule", userAgent: linuxUA, acceptLanguage: "fr", expected: "https://example.com"},
+		{tCase: "country and language", userAgent: linuxUA, acceptLanguage: "fr", country: "CH", expected: "https://example.ch/fr"},
+		{tCase: "other country", userAgent: linuxUA, acceptLanguage: "fr", country: "FR", expected: "https://example.com"},
+	}
+
+	for _, tc := range testTable {
+		t.Run(tc.tCase, func(t *testing.T) {
+			uri := "http://host.com/b"
+			if tc.query != "" {
+				uri += "?" + tc.query
+			}
+			ctx := initCtx("GET", uri, nil)
+			ctx.Request.Header.SetUserAgent(tc.userAgent)
+			if tc.acceptLanguage != "" {
+				ctx.Request.Header.Set(fasthttp.HeaderAcceptLanguage, tc.acceptLanguage)
+			}
+
+			ao.Equal(tc.expected, destination(ctx, link, store.Visit{Country: tc.country}))
+		})
+	}
+}
+
+func Test_validateRules(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+
+	type testData struct {
+		tCase    string
+		rules    []store.Rule
+		expected string
+	}
+
+	testTable := []testData{
+		{tCase: "valid", rules: []store.Rule{{Platform: "mobile", URL: "https://m.example.com"}, {Language: "en", URL: "https://example.com"}}},
+		{tCase: "no rules"},
+		{tCase: "empty URL", rules: []store.Rule{{Platform: "ios"}}, expected: "invalid link details: rule 0 has an empty or too long url"},
+		{tCase: "unknown platform", rules: []store.Rule{{Platform: "symbian", URL: "https://example.com"}}, expected: `invalid link details: rule 0 has unknown platform "symbian"`},
+		{tCase: "too long language", rules: []store.Rule{{Language: strings.Repeat("a", maxTagLen+1), URL: "https://example.com"}}, expected: "invalid link details: rule 0 has too long language"},
+		{tCase: "invalid country", rules: []store.Rule{{Country: "CHE", URL: "https://example.ch"}}, expected: `invalid link details: rule 0 has invalid country code "CHE"`},
+		{tCase: "too many rules", rules: make([]store.Rule, maxRules+1), expected: "invalid link details: more than 32 rules"},
+	}
+
+	for _, tc := range testTable {
+		t.Run(tc.tCase, func(t *testing.T) {
+			err := validateRules(tc.rules)
+			if tc.expected == "" {
+				ao.NoError(err)
+				return
+			}
+			ao.True(errors.Is(err, ErrInvalidMeta))
+			ao.EqualError(err, tc.expected)
+		})
+	}
+}
+
+func Test_longerRules(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+	mockEnv.withoutTenants()
+
+	link := store.Link{Short: "b", Long: "https://example.com", Meta: store.Meta{Rules: []store.Rule{
+		{Platform: "ios", URL: "https://apps.apple.com/app/example"},
+	}}}
+	mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("b")).Return(link, nil).Times(2)
+	mockEnv.Cache.EXPECT().Click(store.Namespace{}, link, store.Visit{}).Return(nil).Times(2)
+
+	ctx := initCtx("GET", "http://host.com/b", nil)
+	ctx.Request.Header.SetUserAgent(iPhoneUA)
+	env.Handle(ctx)
+	ao.Equal("https://apps.apple.com/app/example", string(ctx.Response.Body()))
+
+	ctx = initCtx("GET", "http://host.com/b", nil)
+	ctx.Request.Header.SetUserAgent(windowsUA)
+	env.Handle(ctx)
+	ao.Equal("https://example.com", string(ctx.Response.Body()))
+}
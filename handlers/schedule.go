@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"bytes"
+	"html/template"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/store"
+)
+
+// inactivePage is executed with the link and the reason it is not active.
+type inactivePage struct {
+	Short     string
+	NotBefore *time.Time
+	NotAfter  *time.Time
+	// Expired is false before the link becomes active and true after it stops being active.
+	Expired bool
+	Error   string
+}
+
+// loadInactivePage parses the page served for links outside of their active time, nil is returned if no file is
+// configured.
+func loadInactivePage(file string) (*template.Template, error) {
+	if file == "" {
+		return nil, nil
+	}
+
+	return template.ParseFiles(file)
+}
+
+// active checks the time the link is active. Outside of it the fallback URL of the link is written if there is one,
+// otherwise the inactive page, and false is returned.
+func (env *Environment) active(ctx *fasthttp.RequestCtx, l store.Link) bool {
+	err := l.ActiveAt(time.Now())
+	if err == nil {
+		return true
+	}
+
+	if l.FallbackURL != "" {
+		ctx.WriteString(l.FallbackURL)
+		return false
+	}
+
+	code := fasthttp.StatusNotFound
+	if err == store.ErrExpired {
+		code = fasthttp.StatusGone
+	}
+
+	switch {
+	case wantsJSON(ctx):
+		writeError(ctx, code, err)
+	case env.inactivePage != nil:
+		var page bytes.Buffer
+		if err := env.inactivePage.Execute(&page, inactivePage{
+			Short:     l.Short,
+			NotBefore: l.NotBefore,
+			NotAfter:  l.NotAfter,
+			Expired:   err == store.ErrExpired,
+			Error:     err.Error(),
+		}); err != nil {
+			ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+			ctx.WriteString(err.Error())
+			return false
+		}
+
+		ctx.SetStatusCode(code)
+		ctx.SetContentType("text/html; charset=utf-8")
+		ctx.Write(page.Bytes())
+	default:
+		ctx.SetStatusCode(code)
+		ctx.WriteString(err.Error())
+	}
+
+	return false
+}
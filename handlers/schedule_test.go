@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/store"
+)
+
+func Test_active(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+	mockEnv.withoutTenants()
+
+	past, future := time.Now().AddDate(-1, 0, 0), time.Now().AddDate(1, 0, 0)
+	upcoming := store.Link{Short: "b", Long: "https://go.dev/launch", Meta: store.Meta{NotBefore: &future}}
+	ended := store.Link{Short: "b", Long: "https://go.dev/launch", Meta: store.Meta{NotAfter: &past}}
+	running := store.Link{Short: "b", Long: "https://go.dev/launch", Meta: store.Meta{NotBefore: &past, NotAfter: &future}}
+	withFallback := upcoming
+	withFallback.FallbackURL = "https://go.dev"
+
+	type testData struct {
+		tCase        string
+		accept       string
+		expectedFunc func()
+
+		expectedBody string
+		expectedCode int
+	}
+
+	testTable := []testData{
+		{
+			tCase: "not active yet",
+			expectedFunc: func() {
+				mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("b")).Return(upcoming, nil)
+			},
+			expectedBody: store.ErrNotActive.Error(),
+			expectedCode: fasthttp.StatusNotFound,
+		},
+		{
+			tCase:  "not active yet with JSON",
+			accept: "application/json",
+			expectedFunc: func() {
+				mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("b")).Return(upcoming, nil)
+			},
+			expectedBody: `{"error":"the link is not active yet"}`,
+			expectedCode: fasthttp.StatusNotFound,
+		},
+		{
+			tCase: "no longer active",
+			expectedFunc: func() {
+				mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("b")).Return(ended, nil)
+			},
+			expectedBody: store.ErrExpired.Error(),
+			expectedCode: fasthttp.StatusGone,
+		},
+		{
+			tCase: "fallback URL",
+			expectedFunc: func() {
+				mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("b")).Return(withFallback, nil)
+			},
+			expectedBody: "https://go.dev",
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase: "active",
+			expectedFunc: func() {
+				mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("b")).Return(running, nil)
+				mockEnv.Cache.EXPECT().Click(store.Namespace{}, running, store.Visit{}).Return(nil)
+			},
+			expectedBody: "https://go.dev/launch",
+			expectedCode: fasthttp.StatusOK,
+		},
+	}
+
+	for _, tc := range testTable {
+		t.Run(tc.tCase, func(t *testing.T) {
+			ctx := initCtx("GET", "http://host.com/b", nil)
+			if tc.accept != "" {
+				ctx.Request.Header.Set(fasthttp.HeaderAccept, tc.accept)
+			}
+			tc.expectedFunc()
+			env.Handle(ctx)
+
+			ao.Equal(tc.expectedCode, ctx.Response.StatusCode())
+			ao.Equal(tc.expectedBody, string(ctx.Response.Body()))
+		})
+	}
+}
+
+func Test_inactivePage(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+	mockEnv.withoutTenants()
+
+	page, err := loadInactivePage("")
+	ao.NoError(err)
+	ao.Nil(page)
+
+	file := filepath.Join(t.TempDir(), "inactive.html")
+	content := `<p>{{if .Expired}}Ended{{else}}Starts {{.NotBefore.Format "2006-01-02"}}{{end}}: {{.Short}}</p>`
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	env.inactivePage, err = loadInactivePage(file)
+	ao.NoError(err)
+
+	launch := time.Now().AddDate(1, 0, 0)
+	mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("b")).
+		Return(store.Link{Short: "b", Meta: store.Meta{NotBefore: &launch}}, nil)
+
+	ctx := initCtx("GET", "http://host.com/b", nil)
+	env.Handle(ctx)
+	ao.Equal(fasthttp.StatusNotFound, ctx.Response.StatusCode())
+	ao.Equal("<p>Starts "+launch.Format("2006-01-02")+": b</p>", string(ctx.Response.Body()))
+	ao.Equal("text/html; charset=utf-8", string(ctx.Response.Header.ContentType()))
+
+	_, err = loadInactivePage(filepath.Join(t.TempDir(), "missing.html"))
+	ao.Error(err)
+}
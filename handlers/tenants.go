@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/store"
+)
+
+//go:generate mockgen -source=tenants.go -destination=tenants_mocks.go -package=handlers -self_package=shorty/handlers
+
+var ErrUnknownDomain = errors.New("the domain is not served by the tenant")
+
+type TenantResolver interface {
+	NamespaceByHost(host string) (store.Namespace, error)
+	Tenant(id string) (store.Tenant, error)
+}
+
+// hostNamespace returns the namespace of links served on the host the request was sent to.
+func (env *Environment) hostNamespace(ctx *fasthttp.RequestCtx) (store.Namespace, error) {
+	return env.Tenants.NamespaceByHost(env.requestHost(ctx))
+}
+
+// domainNamespace returns the namespace of the domain, which must be one of the hosts of the tenant.
+func (env *Environment) domainNamespace(tenant, domain string) (store.Namespace, error) {
+	ns, err := env.Tenants.NamespaceByHost(domain)
+	if err != nil {
+		return store.Namespace{}, err
+	}
+	if ns.Tenant != tenant || tenant == "" {
+		return store.Namespace{}, ErrUnknownDomain
+	}
+
+	return ns, nil
+}
+
+// shortURL returns the absolute short URL of the alias saved in the namespace. Links of tenants are built on their
+// domains, links of the default namespace on the public base URL if it is configured or on the host of the request.
+func (env *Environment) shortURL(ctx *fasthttp.RequestCtx, ns store.Namespace, short []byte) ([]byte, error) {
+	return env.linkURL(env.requestScheme(ctx), env.requestHost(ctx), ns, short)
+}
+
+// linkURL is shortURL for a request sent with the scheme to the host.
+func (env *Environment) linkURL(scheme, requestHost string, ns store.Namespace, short []byte) ([]byte, error) {
+	if i := strings.Index(env.Config.PublicBaseURL, "://"); i > 0 {
+		scheme = env.Config.PublicBaseURL[:i]
+	}
+
+	host := ns.Domain
+	if host == "" && ns.Tenant != "" {
+		t, err := env.Tenants.Tenant(ns.Tenant)
+		if err != nil {
+			return nil, err
+		}
+		host = t.DefaultHost
+	}
+
+	switch {
+	case host != "":
+		return []byte(scheme + "://" + host + "/" + string(short)), nil
+	case env.Config.PublicBaseURL != "":
+		return []byte(env.Config.PublicBaseURL + "/" + string(short)), nil
+	default:
+		return []byte(scheme + "://" + requestHost + "/" + string(short)), nil
+	}
+}
+
+// adminNamespace returns the namespace given in the query of an admin request, either by the domain or by the
+// tenant whose default host is meant.
+func (env *Environment) adminNamespace(ctx *fasthttp.RequestCtx) (store.Namespace, error) {
+	args := ctx.QueryArgs()
+	if domain := args.Peek("domain"); len(domain) > 0 {
+		ns, err := env.Tenants.NamespaceByHost(string(domain))
+		if err == nil && ns.Tenant == "" {
+			err = redis.ErrNil
+		}
+		return ns, err
+	}
+
+	return store.Namespace{Tenant: string(args.Peek("tenant"))}, nil
+}
+
+func (env *Environment) adminTenants(ctx *fasthttp.RequestCtx) {
+	tenants, err := env.Admin.Tenants()
+	if err != nil {
+		env.adminFailed(ctx, err)
+		return
+	}
+
+	writeJSON(ctx, fasthttp.StatusOK, tenants)
+}
+
+func (env *Environment) adminTenant(ctx *fasthttp.RequestCtx, id string) {
+	t, err := env.Tenants.Tenant(id)
+	if err != nil {
+		env.adminFailed(ctx, err)
+		return
+	}
+
+	writeJSON(ctx, fasthttp.StatusOK, t)
+}
+
+// adminSaveTenant creates the tenant or replaces its hosts and quota.
+func (env *Environment) adminSaveTenant(ctx *fasthttp.RequestCtx, id string) {
+	var t store.Tenant
+	if err := json.Unmarshal(ctx.Request.Body(), &t); err != nil || t.Quota < 0 {
+		writeError(ctx, fasthttp.StatusBadRequest, ErrInvalidJSON)
+		return
+	}
+	t.ID = id
+
+	saved, err := env.Admin.SaveTenant(t)
+	switch err {
+	case nil:
+		writeJSON(ctx, fasthttp.StatusOK, saved)
+	case store.ErrInvalidTenant, store.ErrInvalidHost:
+		writeError(ctx, fasthttp.StatusBadRequest, err)
+	case store.ErrHostTaken, store.ErrDefaultHostChanged, store.ErrConflict:
+		writeError(ctx, fasthttp.StatusConflict, err)
+	default:
+		env.adminFailed(ctx, err)
+	}
+}
+
+// adminDeleteTenant removes the tenant and revokes its API keys, keeping its links.
+func (env *Environment) adminDeleteTenant(ctx *fasthttp.RequestCtx, id string) {
+	if err := env.Admin.DeleteTenant(id); err != nil {
+		env.adminFailed(ctx, err)
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
@@ -0,0 +1,65 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: tenants.go
+
+// Package handlers is a generated GoMock package.
+package handlers
+
+import (
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+	store "github.com/yexelm/shorty/store"
+)
+
+// MockTenantResolver is a mock of TenantResolver interface.
+type MockTenantResolver struct {
+	ctrl     *gomock.Controller
+	recorder *MockTenantResolverMockRecorder
+}
+
+// MockTenantResolverMockRecorder is the mock recorder for MockTenantResolver.
+type MockTenantResolverMockRecorder struct {
+	mock *MockTenantResolver
+}
+
+// NewMockTenantResolver creates a new mock instance.
+func NewMockTenantResolver(ctrl *gomock.Controller) *MockTenantResolver {
+	mock := &MockTenantResolver{ctrl: ctrl}
+	mock.recorder = &MockTenantResolverMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTenantResolver) EXPECT() *MockTenantResolverMockRecorder {
+	return m.recorder
+}
+
+// NamespaceByHost mocks base method.
+func (m *MockTenantResolver) NamespaceByHost(host string) (store.Namespace, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NamespaceByHost", host)
+	ret0, _ := ret[0].(store.Namespace)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// NamespaceByHost indicates an expected call of NamespaceByHost.
+func (mr *MockTenantResolverMockRecorder) NamespaceByHost(host interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NamespaceByHost", reflect.TypeOf((*MockTenantResolver)(nil).NamespaceByHost), host)
+}
+
+// Tenant mocks base method.
+func (m *MockTenantResolver) Tenant(id string) (store.Tenant, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Tenant", id)
+	ret0, _ := ret[0].(store.Tenant)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Tenant indicates an expected call of Tenant.
+func (mr *MockTenantResolverMockRecorder) Tenant(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Tenant", reflect.TypeOf((*MockTenantResolver)(nil).Tenant), id)
+}
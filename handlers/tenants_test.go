@@ -0,0 +1,399 @@
+package handlers
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/store"
+)
+
+// fromAddr makes the request look as sent from the given IP address.
+func fromAddr(ctx *fasthttp.RequestCtx, ip string) {
+	req := &fasthttp.Request{}
+	ctx.Request.CopyTo(req)
+	ctx.Init(req, &net.TCPAddr{IP: net.ParseIP(ip)}, nil)
+}
+
+func Test_tenants(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	env.Config.TrustedProxies = []*net.IPNet{proxies}
+
+	acme := store.Namespace{Tenant: "acme"}
+	promo := store.Namespace{Tenant: "acme", Domain: "promo.acme.com"}
+	mockEnv.Tenants.EXPECT().NamespaceByHost("go.acme.com").Return(acme, nil).AnyTimes()
+	mockEnv.Tenants.EXPECT().NamespaceByHost("promo.acme.com").Return(promo, nil).AnyTimes()
+	mockEnv.Tenants.EXPECT().NamespaceByHost("shorty.io").Return(store.Namespace{}, nil).AnyTimes()
+	mockEnv.Tenants.EXPECT().NamespaceByHost("globex.io").Return(store.Namespace{Tenant: "globex"}, nil).AnyTimes()
+	mockEnv.Tenants.EXPECT().Tenant("acme").
+		Return(store.Tenant{ID: "acme", Hosts: []string{"go.acme.com", "promo.acme.com"}, DefaultHost: "go.acme.com"}, nil).
+		AnyTimes()
+
+	type testData struct {
+		tCase        string
+		method       string
+		URI          string
+		body         []byte
+		apiKey       string
+		remoteIP     string
+		headers      map[string]string
+		expectedFunc func()
+
+		expectedBody string
+		expectedCode int
+	}
+
+	testTable := []testData{
+		{
+			tCase:  "resolve on default host of tenant",
+			method: "GET",
+			URI:    "http://go.acme.com/b",
+			expectedFunc: func() {
+				mockEnv.Cache.EXPECT().Longer(acme, []byte("b")).Return(store.Link{Long: "https://acme.com"}, nil)
+				mockEnv.Cache.EXPECT().Click(acme, store.Link{Long: "https://acme.com"}, store.Visit{}).Return(nil)
+			},
+			expectedBody: "https://acme.com",
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:  "resolve on another host of tenant",
+			method: "GET",
+			URI:    "http://promo.acme.com/b",
+			expectedFunc: func() {
+				mockEnv.Cache.EXPECT().Longer(promo, []byte("b")).Return(store.Link{}, redis.ErrNil)
+			},
+			expectedBody: ErrShortCodeNotFound.Error(),
+			expectedCode: fasthttp.StatusNotFound,
+		},
+		{
+			tCase:  "shorten on default host of tenant",
+			method: "POST",
+			URI:    "http://go.acme.com",
+			body:   []byte("https://acme.com"),
+			apiKey: "acme",
+			expectedFunc: func() {
+				mockEnv.Keys.EXPECT().Authenticate("acme").Return(store.Principal{Tenant: "acme", Owner: "team"}, nil)
+				mockEnv.Cache.EXPECT().Shorter(acme, []byte("https://acme.com"), store.Meta{Creator: "team"}).
+					Return([]byte("b"), true, nil)
+			},
+			expectedBody: "http://go.acme.com/b",
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:  "shorten on another host of tenant",
+			method: "POST",
+			URI:    "http://promo.acme.com",
+			body:   []byte("https://acme.com"),
+			apiKey: "acme",
+			expectedFunc: func() {
+				mockEnv.Keys.EXPECT().Authenticate("acme").Return(store.Principal{Tenant: "acme", Owner: "team"}, nil)
+				mockEnv.Cache.EXPECT().Shorter(promo, []byte("https://acme.com"), store.Meta{Creator: "team"}).
+					Return([]byte("b"), true, nil)
+			},
+			expectedBody: "http://promo.acme.com/b",
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:        "shorten on host of tenant without key",
+			method:       "POST",
+			URI:          "http://promo.acme.com",
+			body:         []byte("https://acme.com"),
+			expectedFunc: func() {},
+			expectedBody: ErrAPIKeyRequired.Error(),
+			expectedCode: fasthttp.StatusUnauthorized,
+		},
+		{
+			tCase:  "key of another tenant",
+			method: "POST",
+			URI:    "http://go.acme.com",
+			body:   []byte("https://acme.com"),
+			apiKey: "globex",
+			expectedFunc: func() {
+				mockEnv.Keys.EXPECT().Authenticate("globex").Return(store.Principal{Tenant: "globex", Owner: "team"}, nil)
+			},
+			expectedBody: ErrForeignAPIKey.Error(),
+			expectedCode: fasthttp.StatusForbidden,
+		},
+		{
+			tCase:  "tenant key on shared host",
+			method: "POST",
+			URI:    "http://shorty.io",
+			body:   []byte("https://acme.com"),
+			apiKey: "acme",
+			expectedFunc: func() {
+				mockEnv.Keys.EXPECT().Authenticate("acme").Return(store.Principal{Tenant: "acme", Owner: "team"}, nil)
+				mockEnv.Cache.EXPECT().Shorter(acme, []byte("https://acme.com"), store.Meta{Creator: "team"}).
+					Return([]byte("c"), true, nil)
+			},
+			expectedBody: "http://go.acme.com/c",
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:  "quota exceeded",
+			method: "POST",
+			URI:    "http://go.acme.com",
+			body:   []byte("https://acme.com/new"),
+			apiKey: "acme",
+			expectedFunc: func() {
+				mockEnv.Keys.EXPECT().Authenticate("acme").Return(store.Principal{Tenant: "acme", Owner: "team"}, nil)
+				mockEnv.Cache.EXPECT().Shorter(acme, []byte("https://acme.com/new"), store.Meta{Creator: "team"}).
+					Return(nil, false, store.ErrQuotaExceeded)
+			},
+			expectedBody: store.ErrQuotaExceeded.Error(),
+			expectedCode: fasthttp.StatusForbidden,
+		},
+		{
+			tCase:    "behind trusted proxy",
+			method:   "POST",
+			URI:      "http://shorty.io",
+			body:     []byte("https://acme.com"),
+			remoteIP: "10.1.2.3",
+			apiKey:   "acme",
+			headers:  map[string]string{"X-Forwarded-Host": "promo.acme.com, shorty.io", "X-Forwarded-Proto": "https"},
+			expectedFunc: func() {
+				mockEnv.Keys.EXPECT().Authenticate("acme").Return(store.Principal{Tenant: "acme", Owner: "team"}, nil)
+				mockEnv.Cache.EXPECT().Shorter(promo, []byte("https://acme.com"), store.Meta{Creator: "team"}).
+					Return([]byte("d"), true, nil)
+			},
+			expectedBody: "https://promo.acme.com/d",
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:    "forwarded headers of untrusted client",
+			method:   "POST",
+			URI:      "http://shorty.io",
+			body:     []byte("https://acme.com"),
+			remoteIP: "203.0.113.1",
+			headers:  map[string]string{"X-Forwarded-Host": "promo.acme.com", "X-Forwarded-Proto": "https"},
+			expectedFunc: func() {
+				mockEnv.Cache.EXPECT().Shorter(store.Namespace{}, []byte("https://acme.com"), store.Meta{}).
+					Return([]byte("e"), true, nil)
+			},
+			expectedBody: "http://shorty.io/e",
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:  "create link on domain of tenant",
+			method: "POST",
+			URI:    "http://shorty.io/api/v1/links",
+			body:   []byte(`{"url":"https://acme.com","domain":"promo.acme.com"}`),
+			apiKey: "acme",
+			expectedFunc: func() {
+				mockEnv.Keys.EXPECT().Authenticate("acme").Return(store.Principal{Tenant: "acme", Owner: "team"}, nil)
+				mockEnv.Cache.EXPECT().Shorter(promo, []byte("https://acme.com"), store.Meta{Creator: "team"}).
+					Return([]byte("f"), true, nil)
+				mockEnv.Links.EXPECT().Link(promo, []byte("f")).Return(store.Link{Short: "f", Long: "https://acme.com"}, nil)
+			},
+			expectedBody: `{"short_url":"http://promo.acme.com/f","short":"f","long":"https://acme.com","disabled":false,` +
+				`"created_at":"0001-01-01T00:00:00Z"}`,
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:  "create link on domain of another tenant",
+			method: "POST",
+			URI:    "http://shorty.io/api/v1/links",
+			body:   []byte(`{"url":"https://acme.com","domain":"globex.io"}`),
+			apiKey: "acme",
+			expectedFunc: func() {
+				mockEnv.Keys.EXPECT().Authenticate("acme").Return(store.Principal{Tenant: "acme", Owner: "team"}, nil)
+			},
+			expectedBody: `{"error":"the domain is not served by the tenant"}`,
+			expectedCode: fasthttp.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testTable {
+		t.Run(tc.tCase, func(t *testing.T) {
+			ctx := initCtx(tc.method, tc.URI, tc.body)
+			if tc.apiKey != "" {
+				ctx.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+tc.apiKey)
+			}
+			for k, v := range tc.headers {
+				ctx.Request.Header.Set(k, v)
+			}
+			if tc.remoteIP != "" {
+				fromAddr(ctx, tc.remoteIP)
+			}
+			tc.expectedFunc()
+			env.Handle(ctx)
+
+			ao.Equal(tc.expectedCode, ctx.Response.StatusCode())
+			ao.Equal(tc.expectedBody, string(ctx.Response.Body()))
+		})
+	}
+}
+
+func Test_PublicBaseURL(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+	env.Config.PublicBaseURL = "https://sho.rt"
+
+	ctx := initCtx("POST", "http://localhost:8080", nil)
+	short, err := env.shortURL(ctx, store.Namespace{}, []byte("b"))
+	ao.NoError(err)
+	ao.Equal("https://sho.rt/b", string(short))
+
+	short, err = env.shortURL(ctx, store.Namespace{Tenant: "acme", Domain: "promo.acme.com"}, []byte("b"))
+	ao.NoError(err)
+	ao.Equal("https://promo.acme.com/b", string(short), "links of tenants must be built on their domains")
+}
+
+func Test_HandleAdminTenants(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+
+	acme := store.Tenant{ID: "acme", Hosts: []string{"go.acme.com"}, Quota: 100}
+
+	type testData struct {
+		tCase        string
+		method       string
+		URI          string
+		body         []byte
+		expectedFunc func()
+
+		expectedBody string
+		expectedCode int
+	}
+
+	testTable := []testData{
+		{
+			tCase:  "list tenants",
+			method: "GET",
+			URI:    "/tenants",
+			expectedFunc: func() {
+				mockEnv.Admin.EXPECT().Tenants().Return([]store.Tenant{acme}, nil)
+			},
+			expectedBody: `[{"id":"acme","hosts":["go.acme.com"],"quota":100}]`,
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:  "unknown tenant",
+			method: "GET",
+			URI:    "/tenants/missing",
+			expectedFunc: func() {
+				mockEnv.Tenants.EXPECT().Tenant("missing").Return(store.Tenant{}, redis.ErrNil)
+			},
+			expectedBody: `{"error":"not found"}`,
+			expectedCode: fasthttp.StatusNotFound,
+		},
+		{
+			tCase:  "save tenant",
+			method: "PUT",
+			URI:    "/tenants/acme",
+			body:   []byte(`{"hosts":["Go.Acme.com"],"quota":100}`),
+			expectedFunc: func() {
+				mockEnv.Admin.EXPECT().SaveTenant(store.Tenant{ID: "acme", Hosts: []string{"Go.Acme.com"}, Quota: 100}).
+					Return(acme, nil)
+			},
+			expectedBody: `{"id":"acme","hosts":["go.acme.com"],"quota":100}`,
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:  "host taken",
+			method: "PUT",
+			URI:    "/tenants/globex",
+			body:   []byte(`{"hosts":["go.acme.com"]}`),
+			expectedFunc: func() {
+				mockEnv.Admin.EXPECT().SaveTenant(store.Tenant{ID: "globex", Hosts: []string{"go.acme.com"}}).
+					Return(store.Tenant{}, store.ErrHostTaken)
+			},
+			expectedBody: `{"error":"the host is already served by another tenant"}`,
+			expectedCode: fasthttp.StatusConflict,
+		},
+		{
+			tCase:  "default host changed",
+			method: "PUT",
+			URI:    "/tenants/acme",
+			body:   []byte(`{"hosts":["acme.link"]}`),
+			expectedFunc: func() {
+				mockEnv.Admin.EXPECT().SaveTenant(store.Tenant{ID: "acme", Hosts: []string{"acme.link"}}).
+					Return(store.Tenant{}, store.ErrDefaultHostChanged)
+			},
+			expectedBody: `{"error":"the default host of the tenant cannot be changed"}`,
+			expectedCode: fasthttp.StatusConflict,
+		},
+		{
+			tCase:        "negative quota",
+			method:       "PUT",
+			URI:          "/tenants/acme",
+			body:         []byte(`{"quota":-1}`),
+			expectedFunc: func() {},
+			expectedBody: `{"error":"invalid JSON body"}`,
+			expectedCode: fasthttp.StatusBadRequest,
+		},
+		{
+			tCase:  "delete tenant",
+			method: "DELETE",
+			URI:    "/tenants/acme",
+			expectedFunc: func() {
+				mockEnv.Admin.EXPECT().DeleteTenant("acme").Return(nil)
+			},
+			expectedCode: fasthttp.StatusNoContent,
+		},
+		{
+			tCase:  "issue key of tenant",
+			method: "POST",
+			URI:    "/keys/team?tenant=acme",
+			expectedFunc: func() {
+				mockEnv.Admin.EXPECT().IssueKey("acme", "team").Return("key", nil)
+			},
+			expectedBody: `{"tenant":"acme","owner":"team","key":"key"}`,
+			expectedCode: fasthttp.StatusCreated,
+		},
+		{
+			tCase:  "links of domain",
+			method: "GET",
+			URI:    "/links?domain=promo.acme.com",
+			expectedFunc: func() {
+				promo := store.Namespace{Tenant: "acme", Domain: "promo.acme.com"}
+				mockEnv.Tenants.EXPECT().NamespaceByHost("promo.acme.com").Return(promo, nil)
+				mockEnv.Admin.EXPECT().Links(promo, uint64(0), defaultPageSize, "").Return(nil, uint64(0), nil)
+			},
+			expectedBody: `{"links":null,"cursor":0}`,
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:  "unknown domain",
+			method: "GET",
+			URI:    "/links?domain=example.com",
+			expectedFunc: func() {
+				mockEnv.Tenants.EXPECT().NamespaceByHost("example.com").Return(store.Namespace{}, nil)
+			},
+			expectedBody: `{"error":"not found"}`,
+			expectedCode: fasthttp.StatusNotFound,
+		},
+		{
+			tCase:  "stats of tenant",
+			method: "GET",
+			URI:    "/stats?tenant=acme",
+			expectedFunc: func() {
+				mockEnv.Admin.EXPECT().Stats(store.Namespace{Tenant: "acme"}).Return(store.Stats{Links: 2, LastID: 9}, nil)
+			},
+			expectedBody: `{"links":2,"disabled":0,"last_id":9}`,
+			expectedCode: fasthttp.StatusOK,
+		},
+	}
+
+	for _, tc := range testTable {
+		t.Run(tc.tCase, func(t *testing.T) {
+			ctx := initCtx(tc.method, tc.URI, tc.body)
+			tc.expectedFunc()
+			env.HandleAdmin(ctx)
+
+			ao.Equal(tc.expectedCode, ctx.Response.StatusCode())
+			ao.Equal(tc.expectedBody, string(ctx.Response.Body()))
+		})
+	}
+}
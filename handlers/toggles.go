@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"errors"
+	"sync"
+)
+
+// Names of runtime toggles.
+const (
+	// ToggleShorten allows shortening of new links.
+	ToggleShorten = "shorten"
+	// ToggleResolve allows resolution of short links.
+	ToggleResolve = "resolve"
+)
+
+var ErrUnknownToggle = errors.New("unknown toggle")
+
+// Toggles are runtime switches managed via the admin API. All toggles are on by default.
+type Toggles struct {
+	mu     sync.RWMutex
+	values map[string]bool
+}
+
+// NewToggles returns an instance of Toggles with all toggles on.
+func NewToggles() *Toggles {
+	return &Toggles{
+		values: map[string]bool{
+			ToggleShorten: true,
+			ToggleResolve: true,
+		},
+	}
+}
+
+// Enabled reports whether the toggle is on.
+func (t *Toggles) Enabled(name string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.values[name]
+}
+
+// Set switches the toggle on or off.
+func (t *Toggles) Set(name string, on bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.values[name]; !ok {
+		return ErrUnknownToggle
+	}
+	t.values[name] = on
+
+	return nil
+}
+
+// All returns a copy of all toggles and their states.
+func (t *Toggles) All() map[string]bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	all := make(map[string]bool, len(t.values))
+	for k, v := range t.values {
+		all[k] = v
+	}
+
+	return all
+}
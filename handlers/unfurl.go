@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/metrics"
+	"github.com/yexelm/shorty/opengraph"
+	"github.com/yexelm/shorty/store"
+)
+
+// unfurlQueueSize limits links waiting for metadata of their destinations, more are dropped.
+const unfurlQueueSize = 1024
+
+// crawlers are User-Agent substrings of chat apps and social networks unfurling links.
+var crawlers = [][]byte{
+	[]byte("Slackbot"), []byte("Twitterbot"), []byte("facebookexternalhit"), []byte("Facebot"),
+	[]byte("LinkedInBot"), []byte("Discordbot"), []byte("TelegramBot"), []byte("WhatsApp"), []byte("SkypeUriPreview"),
+	[]byte("redditbot"), []byte("Embedly"), []byte("Mastodon"), []byte("vkShare"), []byte("Pinterest"),
+}
+
+var unfurlPage = template.Must(template.New("unfurl").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>{{.Title}}</title>
+<meta property="og:url" content="{{.ShortURL}}">
+{{if .Title}}<meta property="og:title" content="{{.Title}}">
+{{end}}{{if .Description}}<meta name="description" content="{{.Description}}">
+<meta property="og:description" content="{{.Description}}">
+{{end}}{{if .Image}}<meta property="og:image" content="{{.Image}}">
+<meta name="twitter:card" content="summary_large_image">
+{{end}}</head>
+<body>
+{{if .URL}}<a href="{{.URL}}">{{.URL}}</a>
+{{end}}</body>
+</html>
+`))
+
+// MetadataFetcher fetches metadata of web pages.
+type MetadataFetcher interface {
+	Fetch(ctx context.Context, url string) (opengraph.Metadata, error)
+}
+
+type unfurlJob struct {
+	ns    store.Namespace
+	short []byte
+	long  string
+}
+
+// unfurler fetches metadata of destinations of new links in the background and saves it with the links.
+type unfurler struct {
+	fetcher MetadataFetcher
+	links   LinkStore
+	timeout time.Duration
+
+	jobs chan unfurlJob
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// StartUnfurling starts workers fetching metadata of destinations of new links, they are stopped by Close.
+func (env *Environment) StartUnfurling(fetcher MetadataFetcher, workers int, timeout time.Duration) {
+	u := &unfurler{
+		fetcher: fetcher,
+		links:   env.Links,
+		timeout: timeout,
+		jobs:    make(chan unfurlJob, unfurlQueueSize),
+		stop:    make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		u.wg.Add(1)
+		go u.work()
+	}
+
+	env.unfurler = u
+	env.OnClose(func() {
+		close(u.stop)
+		u.wg.Wait()
+	})
+}
+
+// unfurl queues fetching metadata of the destination of the link. It never blocks, the link is skipped if the queue
+// is full or fetching is disabled.
+func (env *Environment) unfurl(ns store.Namespace, short []byte, long string) {
+	if env.unfurler == nil {
+		return
+	}
+
+	select {
+	case env.unfurler.jobs <- unfurlJob{ns: ns, short: short, long: long}:
+	default:
+		metrics.Unfurls.WithLabelValues("dropped").Inc()
+	}
+}
+
+func (u *unfurler) work() {
+	defer u.wg.Done()
+
+	for {
+		select {
+		case <-u.stop:
+			return
+		case job := <-u.jobs:
+			u.fetch(job)
+		}
+	}
+}
+
+// fetch saves metadata of the destination of the link unless it has been fetched already, since shortening a known
+// URL returns the existing link.
+func (u *unfurler) fetch(job unfurlJob) {
+	l, err := u.links.Link(job.ns, job.short)
+	if err != nil || l.OpenGraph != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), u.timeout)
+	defer cancel()
+
+	m, err := u.fetcher.Fetch(ctx, job.long)
+	if err != nil {
+		metrics.Unfurls.WithLabelValues("failed").Inc()
+		log.Printf("failed to fetch metadata of %v: %v", job.long, err)
+		return
+	}
+
+	og := store.OpenGraph{
+		Title:       truncate(m.Title, maxTextLen),
+		Description: truncate(m.Description, maxTextLen),
+		Image:       truncate(m.Image, maxNotesLen),
+		FetchedAt:   time.Now().UTC(),
+	}
+	if err := u.links.SetOpenGraph(job.ns, job.short, og); err != nil {
+		metrics.Unfurls.WithLabelValues("failed").Inc()
+		log.Printf("failed to save metadata of %v: %v", job.long, err)
+		return
+	}
+	metrics.Unfurls.WithLabelValues("fetched").Inc()
+}
+
+// truncate cuts s to at most n bytes without splitting UTF-8 sequences.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && s[n]&0xC0 == 0x80 {
+		n--
+	}
+
+	return s[:n]
+}
+
+// isCrawler reports whether the request comes from a chat app or a social network unfurling the link.
+func isCrawler(ctx *fasthttp.RequestCtx) bool {
+	ua := ctx.UserAgent()
+	for _, c := range crawlers {
+		if bytes.Contains(ua, c) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeUnfurl serves the metadata of the link to crawlers, the details given to the link take precedence over the
+// fetched ones. False is returned if the link has no metadata at all. Crawlers are not counted as clicks, so the
+// destinations of links with a click limit are left out of the page.
+func (env *Environment) writeUnfurl(ctx *fasthttp.RequestCtx, ns store.Namespace, l store.Link) bool {
+	var og store.OpenGraph
+	if l.OpenGraph != nil {
+		og = *l.OpenGraph
+	}
+	if l.Title != "" {
+		og.Title = l.Title
+	}
+	if l.Description != "" {
+		og.Description = l.Description
+	}
+	if og.Title == "" && og.Description == "" && og.Image == "" {
+		return false
+	}
+
+	shortURL, err := env.shortURL(ctx, ns, []byte(l.Short))
+	if err != nil {
+		metrics.Errors.WithLabelValues("longer").Inc()
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.WriteString(err.Error())
+		return true
+	}
+
+	var long string
+	if l.MaxClicks == 0 {
+		long = env.passthrough(ctx, l, destination(ctx, l, store.Visit{}))
+	}
+
+	var page bytes.Buffer
+	if err := unfurlPage.Execute(&page, struct {
+		store.OpenGraph
+		ShortURL string
+		URL      string
+	}{og, string(shortURL), long}); err != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.WriteString(err.Error())
+		return true
+	}
+
+	ctx.SetContentType("text/html; charset=utf-8")
+	ctx.Write(page.Bytes())
+	return true
+}
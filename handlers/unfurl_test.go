@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/opengraph"
+	"github.com/yexelm/shorty/store"
+)
+
+// pages is a MetadataFetcher with fixed pages.
+type pages map[string]opengraph.Metadata
+
+func (p pages) Fetch(_ context.Context, url string) (opengraph.Metadata, error) {
+	m, ok := p[url]
+	if !ok {
+		return opengraph.Metadata{}, errors.New("not found")
+	}
+
+	return m, nil
+}
+
+func Test_unfurl(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+	mockEnv.withoutTenants()
+
+	env.StartUnfurling(pages{"https://go.dev": {Title: "Go", Image: "https://go.dev/logo.png"}}, 1, time.Second)
+
+	saved := make(chan store.OpenGraph)
+	mockEnv.Cache.EXPECT().Shorter(store.Namespace{}, []byte("https://go.dev"), store.Meta{}).Return([]byte("b"), true, nil)
+	mockEnv.Links.EXPECT().Link(store.Namespace{}, []byte("b")).Return(store.Link{Short: "b", Long: "https://go.dev"}, nil)
+	mockEnv.Links.EXPECT().SetOpenGraph(store.Namespace{}, []byte("b"), gomock.Any()).
+		DoAndReturn(func(_ store.Namespace, _ []byte, og store.OpenGraph) error {
+			saved <- og
+			return nil
+		})
+
+	ctx := initCtx("POST", "http://host.com", []byte("https://go.dev"))
+	env.Handle(ctx)
+	ao.Equal("http://host.com/b", string(ctx.Response.Body()))
+
+	select {
+	case og := <-saved:
+		ao.Equal("Go", og.Title)
+		ao.Equal("https://go.dev/logo.png", og.Image)
+		ao.False(og.FetchedAt.IsZero())
+	case <-time.After(time.Second):
+		t.Fatal("metadata must be fetched in the background")
+	}
+
+	// known links and failed fetches are not saved
+	mockEnv.Cache.EXPECT().Shorter(store.Namespace{}, []byte("https://go.dev"), store.Meta{}).Return([]byte("b"), true, nil)
+	fetched := store.Link{Short: "b", Long: "https://go.dev", Meta: store.Meta{OpenGraph: &store.OpenGraph{Title: "Go"}}}
+	done := make(chan struct{})
+	mockEnv.Links.EXPECT().Link(store.Namespace{}, []byte("b")).
+		DoAndReturn(func(store.Namespace, []byte) (store.Link, error) {
+			close(done)
+			return fetched, nil
+		})
+	env.Handle(initCtx("POST", "http://host.com", []byte("https://go.dev")))
+	<-done
+
+	// links returned again are not looked up at all
+	mockEnv.Cache.EXPECT().Shorter(store.Namespace{}, []byte("https://go.dev"), store.Meta{}).Return([]byte("b"), false, nil)
+	env.Handle(initCtx("POST", "http://host.com", []byte("https://go.dev")))
+
+	env.Close()
+	env.unfurl(store.Namespace{}, []byte("c"), "https://example.com")
+}
+
+func Test_writeUnfurl(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+	mockEnv.withoutTenants()
+
+	link := store.Link{Short: "b", Long: "https://go.dev", Meta: store.Meta{
+		Description: "Custom <description>",
+		OpenGraph:   &store.OpenGraph{Title: "Go", Description: "Fetched", Image: "https://go.dev/logo.png"},
+	}}
+	plain := store.Link{Short: "c", Long: "https://example.com"}
+	mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("b")).Return(link, nil).Times(2)
+	mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("c")).Return(plain, nil)
+	mockEnv.Cache.EXPECT().Click(store.Namespace{}, link, store.Visit{}).Return(nil)
+	mockEnv.Cache.EXPECT().Click(store.Namespace{}, plain, store.Visit{}).Return(nil)
+
+	ctx := initCtx("GET", "http://host.com/b", nil)
+	ctx.Request.Header.SetUserAgent("Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)")
+	env.Handle(ctx)
+	ao.Equal(fasthttp.StatusOK, ctx.Response.StatusCode())
+	ao.Equal(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Go</title>
+<meta property="og:url" content="http://host.com/b">
+<meta property="og:title" content="Go">
+<meta name="description" content="Custom &lt;description&gt;">
+<meta property="og:description" content="Custom &lt;description&gt;">
+<meta property="og:image" content="https://go.dev/logo.png">
+<meta name="twitter:card" content="summary_large_image">
+</head>
+<body>
+<a href="https://go.dev">https://go.dev</a>
+</body>
+</html>
+`, string(ctx.Response.Body()))
+
+	ctx = initCtx("GET", "http://host.com/b", nil)
+	env.Handle(ctx)
+	ao.Equal("https://go.dev", string(ctx.Response.Body()), "visitors must not get the metadata")
+
+	ctx = initCtx("GET", "http://host.com/c", nil)
+	ctx.Request.Header.SetUserAgent("Twitterbot/1.0")
+	env.Handle(ctx)
+	ao.Equal("https://example.com", string(ctx.Response.Body()), "links without metadata must be resolved")
+}
+
+func Test_writeUnfurlMaxClicks(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+	mockEnv.withoutTenants()
+
+	link := store.Link{Short: "b", Long: "https://go.dev/secret", Meta: store.Meta{Title: "Go", MaxClicks: 1}}
+	mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("b")).Return(link, nil).Times(2)
+
+	for i := 0; i < 2; i++ {
+		ctx := initCtx("GET", "http://host.com/b", nil)
+		ctx.Request.Header.SetUserAgent("Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)")
+		env.Handle(ctx)
+		ao.Equal(fasthttp.StatusOK, ctx.Response.StatusCode())
+		ao.Contains(string(ctx.Response.Body()), `<meta property="og:title" content="Go">`)
+		ao.NotContains(string(ctx.Response.Body()), "go.dev", "crawlers must not read destinations of limited links")
+	}
+}
+
+func Test_truncate(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+
+	ao.Equal("abc", truncate("abc", 5))
+	ao.Equal("ab", truncate("abc", 2))
+	ao.Equal("a", truncate("aé", 2), "UTF-8 sequences must not be split")
+}
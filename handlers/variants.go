@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/store"
+)
+
+const (
+	// variantCookie keeps the variant a visitor was assigned to, its path is the alias of the link.
+	variantCookie = "shorty_variant"
+	// maxVariants limits the number of destinations a link rotates between.
+	maxVariants = 16
+)
+
+// variantName keeps names of variants safe for cookie values.
+var variantName = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// variantRand assigns visitors to variants, rand.Rand is not safe for concurrent use.
+var variantRand = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// pickVariant returns the variant named sticky if it still takes visitors, or a random one in proportion to the
+// weights. False is returned if all weights are zero.
+func pickVariant(variants []store.Variant, sticky string) (store.Variant, bool) {
+	total := 0
+	for _, v := range variants {
+		if sticky != "" && v.Name == sticky && v.Weight > 0 {
+			return v, true
+		}
+		total += v.Weight
+	}
+	if total == 0 {
+		return store.Variant{}, false
+	}
+
+	variantRand.Lock()
+	n := variantRand.Intn(total)
+	variantRand.Unlock()
+
+	for _, v := range variants {
+		if n < v.Weight {
+			return v, true
+		}
+		n -= v.Weight
+	}
+
+	// unreachable since n is less than the total weight
+	return store.Variant{}, false
+}
+
+// assignVariant assigns the visitor to a variant of the link and keeps it in a cookie, so the visitor gets the same
+// destination next time. Visitors matching a routing rule are not assigned since rules take precedence.
+func (env *Environment) assignVariant(ctx *fasthttp.RequestCtx, l store.Link, v *store.Visit) {
+	if len(l.Variants) == 0 || matchingRule(ctx, l, *v) != nil {
+		return
+	}
+
+	variant, ok := pickVariant(l.Variants, string(ctx.Request.Header.Cookie(variantCookie)))
+	if !ok {
+		return
+	}
+	v.Variant = variant.Name
+
+	c := fasthttp.AcquireCookie()
+	defer fasthttp.ReleaseCookie(c)
+
+	c.SetKey(variantCookie)
+	c.SetValue(variant.Name)
+	c.SetPath("/" + l.Short)
+	c.SetExpire(time.Now().Add(env.Config.VariantTTL))
+	c.SetHTTPOnly(true)
+	c.SetSameSite(fasthttp.CookieSameSiteLaxMode)
+	c.SetSecure(env.requestScheme(ctx) == "https")
+	ctx.Response.Header.SetCookie(c)
+}
+
+// validateVariants checks variants against the limits.
+func validateVariants(variants []store.Variant) error {
+	if len(variants) > maxVariants {
+		return fmt.Errorf("%w: more than %d variants", ErrInvalidMeta, maxVariants)
+	}
+
+	names := make(map[string]bool, len(variants))
+	total := 0
+	for i, v := range variants {
+		switch {
+		case !variantName.MatchString(v.Name):
+			return fmt.Errorf("%w: variant %d must be named with up to 64 letters, digits, '_', '.' or '-'",
+				ErrInvalidMeta, i)
+		case names[v.Name]:
+			return fmt.Errorf("%w: variant name %q is not unique", ErrInvalidMeta, v.Name)
+		case v.URL == "" || len(v.URL) > maxNotesLen:
+			return fmt.Errorf("%w: variant %q has an empty or too long url", ErrInvalidMeta, v.Name)
+		case v.Weight < 0:
+			return fmt.Errorf("%w: variant %q has a negative weight", ErrInvalidMeta, v.Name)
+		}
+		names[v.Name] = true
+		total += v.Weight
+	}
+	if len(variants) > 0 && total == 0 {
+		return fmt.Errorf("%w: at least one variant must have a positive weight", ErrInvalidMeta)
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/store"
+)
+
+func Test_pickVariant(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+
+	variants := []store.Variant{
+		{Name: "a", URL: "https://example.com/a", Weight: 3},
+		{Name: "b", URL: "https://example.com/b", Weight: 1},
+		{Name: "off", URL: "https://example.com/off"},
+	}
+
+	picked := make(map[string]int)
+	for i := 0; i < 4000; i++ {
+		v, ok := pickVariant(variants, "")
+		ao.True(ok)
+		picked[v.Name]++
+	}
+	ao.Zero(picked["off"], "variants without weight must not get new visitors")
+	ao.InDelta(3000, picked["a"], 200)
+	ao.InDelta(1000, picked["b"], 200)
+
+	v, ok := pickVariant(variants, "b")
+	ao.True(ok)
+	ao.Equal("b", v.Name, "assigned visitors must stay on their variant")
+
+	v, ok = pickVariant(variants[1:], "off")
+	ao.True(ok)
+	ao.Equal("b", v.Name, "visitors of variants without weight must be assigned again")
+
+	_, ok = pickVariant(variants[2:], "")
+	ao.False(ok)
+}
+
+func Test_longerVariants(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+	mockEnv.withoutTenants()
+
+	link := store.Link{Short: "b", Long: "https://example.com", Meta: store.Meta{
+		Rules: []store.Rule{{Platform: "ios", URL: "https://apps.apple.com/app/example"}},
+		Variants: []store.Variant{
+			{Name: "control", URL: "https://example.com/control", Weight: 1},
+			{Name: "new", URL: "https://example.com/new"},
+		},
+	}}
+	mockEnv.Cache.EXPECT().Longer(store.Namespace{}, []byte("b")).Return(link, nil).Times(3)
+	mockEnv.Cache.EXPECT().Click(store.Namespace{}, link, store.Visit{Variant: "control"}).Return(nil)
+	mockEnv.Cache.EXPECT().Click(store.Namespace{}, link, store.Visit{Variant: "new"}).Return(nil)
+	mockEnv.Cache.EXPECT().Click(store.Namespace{}, link, store.Visit{}).Return(nil)
+
+	ctx := initCtx("GET", "http://host.com/b", nil)
+	env.Handle(ctx)
+	ao.Equal("https://example.com/control", string(ctx.Response.Body()))
+	c := fasthttp.AcquireCookie()
+	defer fasthttp.ReleaseCookie(c)
+	c.SetKey(variantCookie)
+	ao.True(ctx.Response.Header.Cookie(c))
+	ao.Equal("control", string(c.Value()))
+	ao.Equal("/b", string(c.Path()))
+
+	// the weights changed, yet the visitor assigned before stays on the variant
+	link.Variants[0].Weight, link.Variants[1].Weight = 0, 1
+	ctx = initCtx("GET", "http://host.com/b", nil)
+	ctx.Request.Header.SetCookie(variantCookie, "new")
+	env.Handle(ctx)
+	ao.Equal("https://example.com/new", string(ctx.Response.Body()))
+
+	ctx = initCtx("GET", "http://host.com/b", nil)
+	ctx.Request.Header.SetUserAgent(iPhoneUA)
+	env.Handle(ctx)
+	ao.Equal("https://apps.apple.com/app/example", string(ctx.Response.Body()), "rules must take precedence")
+	ao.False(ctx.Response.Header.Cookie(c))
+}
+
+func Test_validateVariants(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+
+	type testData struct {
+		tCase    string
+		variants []store.Variant
+		expected string
+	}
+
+	testTable := []testData{
+		{tCase: "valid", variants: []store.Variant{{Name: "a", URL: "https://example.com/a", Weight: 1}, {Name: "b", URL: "https://example.com/b"}}},
+		{tCase: "no variants"},
+		{tCase: "invalid name", variants: []store.Variant{{Name: "a b", URL: "https://example.com", Weight: 1}}, expected: "invalid link details: variant 0 must be named with up to 64 letters, digits, '_', '.' or '-'"},
+		{tCase: "duplicate name", variants: []store.Variant{{Name: "a", URL: "https://example.com", Weight: 1}, {Name: "a", URL: "https://example.com"}}, expected: `invalid link details: variant name "a" is not unique`},
+		{tCase: "empty URL", variants: []store.Variant{{Name: "a", Weight: 1}}, expected: `invalid link details: variant "a" has an empty or too long url`},
+		{tCase: "negative weight", variants: []store.Variant{{Name: "a", URL: "https://example.com", Weight: -1}}, expected: `invalid link details: variant "a" has a negative weight`},
+		{tCase: "zero weights", variants: []store.Variant{{Name: "a", URL: "https://example.com"}}, expected: "invalid link details: at least one variant must have a positive weight"},
+		{tCase: "too many variants", variants: make([]store.Variant, maxVariants+1), expected: "invalid link details: more than 16 variants"},
+		{tCase: "too long name", variants: []store.Variant{{Name: strings.Repeat("a", 65), URL: "https://example.com", Weight: 1}}, expected: "invalid link details: variant 0 must be named with up to 64 letters, digits, '_', '.' or '-'"},
+	}
+
+	for _, tc := range testTable {
+		t.Run(tc.tCase, func(t *testing.T) {
+			err := validateVariants(tc.variants)
+			if tc.expected == "" {
+				ao.NoError(err)
+				return
+			}
+			ao.True(errors.Is(err, ErrInvalidMeta))
+			ao.EqualError(err, tc.expected)
+		})
+	}
+}
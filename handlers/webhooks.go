@@ -0,0 +1,429 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/metrics"
+	"github.com/yexelm/shorty/store"
+)
+
+//go:generate mockgen -source=webhooks.go -destination=webhooks_mocks.go -package=handlers -self_package=shorty/handlers
+
+// names of events sent to webhooks
+const (
+	EventLinkCreated = "link.created"
+	EventLinkDeleted = "link.deleted"
+	EventLinkClicked = "link.clicked"
+)
+
+const (
+	// webhookEventQueueSize limits events waiting to be queued for delivery, more are dropped.
+	webhookEventQueueSize = 4096
+	// webhookPollInterval is how often the delivery queue is checked for due deliveries once it has none left.
+	webhookPollInterval = time.Second
+	// webhooksCacheTTL is how long webhooks of a tenant are cached before they are loaded again.
+	webhooksCacheTTL = 10 * time.Second
+)
+
+var (
+	ErrInvalidWebhook = errors.New("webhook URL must be an absolute HTTP URL and events must be known")
+
+	events = []string{EventLinkCreated, EventLinkDeleted, EventLinkClicked}
+)
+
+// WebhookStore keeps webhooks and the queue of their deliveries.
+type WebhookStore interface {
+	SaveWebhook(tenant string, h store.Webhook) (store.Webhook, error)
+	Webhooks(tenant string) ([]store.Webhook, error)
+	Webhook(tenant, id string) (store.Webhook, error)
+	DeleteWebhook(tenant, id string) error
+	EnqueueDelivery(d store.Delivery, at time.Time) (store.Delivery, error)
+	ClaimDeliveries(now time.Time, lease time.Duration, count int) ([]store.Delivery, error)
+	RetryDelivery(d store.Delivery, at time.Time, a store.DeliveryAttempt) error
+	FinishDelivery(d store.Delivery, a store.DeliveryAttempt) error
+	DeliveryLog(tenant, id string, count int) ([]store.DeliveryAttempt, error)
+}
+
+// webhookEvent is the payload sent to webhooks.
+type webhookEvent struct {
+	Event  string       `json:"event"`
+	At     time.Time    `json:"at"`
+	Tenant string       `json:"tenant,omitempty"`
+	Domain string       `json:"domain,omitempty"`
+	Short  string       `json:"short"`
+	Long   string       `json:"long,omitempty"`
+	Visit  *store.Visit `json:"visit,omitempty"`
+}
+
+type cachedWebhooks struct {
+	hooks    []store.Webhook
+	loadedAt time.Time
+}
+
+// notifier queues events for delivery to the webhooks of their tenants and sends due deliveries, retrying failed
+// ones with exponential backoff.
+type notifier struct {
+	hooks       WebhookStore
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+
+	events chan webhookEvent
+	// slots has a buffer for each worker, deliveries being sent hold one of them
+	slots chan struct{}
+	// cache is only used by the goroutine queueing events
+	cache map[string]cachedWebhooks
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// StartWebhooks starts workers queueing events and sending them to webhooks, they are stopped by Close.
+func (env *Environment) StartWebhooks() {
+	cfg := env.Config
+	n := &notifier{
+		hooks:       env.Webhooks,
+		client:      &http.Client{Timeout: cfg.WebhookTimeout},
+		maxAttempts: cfg.WebhookMaxAttempts,
+		backoff:     cfg.WebhookBackoff,
+		maxBackoff:  cfg.WebhookMaxBackoff,
+		events:      make(chan webhookEvent, webhookEventQueueSize),
+		slots:       make(chan struct{}, cfg.WebhookWorkers),
+		cache:       make(map[string]cachedWebhooks),
+		stop:        make(chan struct{}),
+	}
+	n.wg.Add(2)
+	go n.queue()
+	go n.send()
+
+	env.notifier = n
+	env.OnClose(func() {
+		close(n.stop)
+		n.wg.Wait()
+	})
+}
+
+// notify sends the event about the link to the webhooks of its tenant. It never blocks, the event is dropped if the
+// queue is full or webhooks are not started.
+func (env *Environment) notify(event string, ns store.Namespace, l store.Link, v *store.Visit) {
+	if env.notifier == nil {
+		return
+	}
+
+	select {
+	case env.notifier.events <- webhookEvent{
+		Event:  event,
+		At:     time.Now().UTC(),
+		Tenant: ns.Tenant,
+		Domain: ns.Domain,
+		Short:  l.Short,
+		Long:   l.Long,
+		Visit:  v,
+	}:
+	default:
+		metrics.Webhooks.WithLabelValues("dropped").Inc()
+	}
+}
+
+// queue saves a delivery for each webhook of the tenant subscribed to the event.
+func (n *notifier) queue() {
+	defer n.wg.Done()
+
+	for {
+		select {
+		case <-n.stop:
+			return
+		case e := <-n.events:
+			hooks, err := n.webhooks(e.Tenant)
+			if err != nil {
+				metrics.Webhooks.WithLabelValues("dropped").Inc()
+				log.Printf("failed to load webhooks of tenant %q: %v", e.Tenant, err)
+				continue
+			}
+
+			// the error is always nil for events made of strings and times
+			payload, _ := json.Marshal(e)
+			for _, h := range hooks {
+				if !h.Wants(e.Event) {
+					continue
+				}
+				d := store.Delivery{Tenant: e.Tenant, Webhook: h.ID, Event: e.Event, Payload: payload}
+				if _, err := n.hooks.EnqueueDelivery(d, time.Now()); err != nil {
+					metrics.Webhooks.WithLabelValues("dropped").Inc()
+					log.Printf("failed to queue %v delivery to webhook %v: %v", e.Event, h.ID, err)
+				}
+			}
+		}
+	}
+}
+
+// webhooks returns the webhooks of the tenant, caching them for a while since every click is an event.
+func (n *notifier) webhooks(tenant string) ([]store.Webhook, error) {
+	if c, ok := n.cache[tenant]; ok && time.Since(c.loadedAt) < webhooksCacheTTL {
+		return c.hooks, nil
+	}
+
+	hooks, err := n.hooks.Webhooks(tenant)
+	if err != nil {
+		return nil, err
+	}
+	n.cache[tenant] = cachedWebhooks{hooks: hooks, loadedAt: time.Now()}
+
+	return hooks, nil
+}
+
+// send polls the delivery queue and sends due deliveries concurrently, claiming more as soon as workers are free
+// until none are due.
+func (n *notifier) send() {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+		}
+
+		for n.claim() {
+		}
+	}
+}
+
+// claim waits for a free worker, claims due deliveries for all free workers and starts sending them. It reports
+// whether more deliveries may be due.
+func (n *notifier) claim() bool {
+	select {
+	case <-n.stop:
+		return false
+	case n.slots <- struct{}{}:
+	}
+
+	count := 1
+free:
+	for count < cap(n.slots) {
+		select {
+		case n.slots <- struct{}{}:
+			count++
+		default:
+			break free
+		}
+	}
+
+	// the lease outlasts the attempt, so that no delivery is claimed by another worker meanwhile
+	deliveries, err := n.hooks.ClaimDeliveries(time.Now(), 2*n.client.Timeout, count)
+	if err != nil {
+		log.Printf("failed to claim webhook deliveries: %v", err)
+	}
+	for i := len(deliveries); i < count; i++ {
+		<-n.slots
+	}
+	for _, d := range deliveries {
+		n.wg.Add(1)
+		go func(d store.Delivery) {
+			defer n.wg.Done()
+			n.deliver(d)
+			<-n.slots
+		}(d)
+	}
+
+	return err == nil && len(deliveries) == count
+}
+
+// deliver sends the delivery to its webhook and either finishes it or queues it for another attempt.
+func (n *notifier) deliver(d store.Delivery) {
+	a := store.DeliveryAttempt{Delivery: d.ID, Event: d.Event, Attempt: d.Attempts + 1, At: time.Now().UTC()}
+
+	h, err := n.hooks.Webhook(d.Tenant, d.Webhook)
+	switch {
+	case err == redis.ErrNil:
+		a.Status, a.Error = "failed", "the webhook has been deleted"
+	case err != nil:
+		// the delivery is claimed again once the lease expires
+		log.Printf("failed to load webhook %v: %v", d.Webhook, err)
+		return
+	default:
+		a.Code, err = n.post(h, d)
+		if err == nil {
+			a.Status = "delivered"
+		} else {
+			a.Error = err.Error()
+		}
+	}
+
+	if a.Status == "" && a.Attempt < n.maxAttempts {
+		d.Attempts++
+		next := time.Now().Add(n.delay(d.Attempts)).UTC()
+		a.Status, a.NextAttempt = "retrying", &next
+		metrics.Webhooks.WithLabelValues("retried").Inc()
+		if err := n.hooks.RetryDelivery(d, next, a); err != nil {
+			log.Printf("failed to queue delivery %v for retry: %v", d.ID, err)
+		}
+		return
+	}
+
+	if a.Status == "" {
+		a.Status = "failed"
+	}
+	metrics.Webhooks.WithLabelValues(a.Status).Inc()
+	if err := n.hooks.FinishDelivery(d, a); err != nil {
+		log.Printf("failed to finish delivery %v: %v", d.ID, err)
+	}
+}
+
+// post sends the payload of the delivery to the webhook signed with its secret and returns the status code of the
+// response. Responses other than 2xx are errors.
+func (n *notifier) post(h store.Webhook, d store.Delivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "shorty-webhooks")
+	req.Header.Set("X-Shorty-Event", d.Event)
+	req.Header.Set("X-Shorty-Delivery", d.ID)
+	req.Header.Set("X-Shorty-Timestamp", timestamp)
+	req.Header.Set("X-Shorty-Signature", "sha256="+webhookSignature(h.Secret, timestamp, d.Payload))
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %v", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// delay returns the time to wait before the next attempt after the given number of failed ones, which doubles with
+// every attempt up to the maximum.
+func (n *notifier) delay(failed int) time.Duration {
+	d := n.backoff
+	for i := 1; i < failed && d < n.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > n.maxBackoff {
+		d = n.maxBackoff
+	}
+
+	return d
+}
+
+// webhookSignature signs the timestamp and the payload, receivers compute it the same way to check the request.
+func webhookSignature(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(payload)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validateWebhook checks the URL and the events of the webhook.
+func validateWebhook(h store.Webhook) error {
+	u, err := url.Parse(h.URL)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return ErrInvalidWebhook
+	}
+
+	for _, e := range h.Events {
+		known := false
+		for _, k := range events {
+			known = known || e == k
+		}
+		if !known {
+			return ErrInvalidWebhook
+		}
+	}
+
+	return nil
+}
+
+// adminWebhooks lists the webhooks of the tenant without their secrets.
+func (env *Environment) adminWebhooks(ctx *fasthttp.RequestCtx, tenant string) {
+	hooks, err := env.Webhooks.Webhooks(tenant)
+	if err != nil {
+		env.adminFailed(ctx, err)
+		return
+	}
+
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	writeJSON(ctx, fasthttp.StatusOK, hooks)
+}
+
+// adminSaveWebhook creates a webhook of the tenant, or replaces the one with the given ID. The secret is returned,
+// it is generated if none is given.
+func (env *Environment) adminSaveWebhook(ctx *fasthttp.RequestCtx, tenant, id string) {
+	var h store.Webhook
+	if err := json.Unmarshal(ctx.Request.Body(), &h); err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, ErrInvalidJSON)
+		return
+	}
+	if err := validateWebhook(h); err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err)
+		return
+	}
+	h.ID = id
+
+	saved, err := env.Webhooks.SaveWebhook(tenant, h)
+	if err != nil {
+		env.adminFailed(ctx, err)
+		return
+	}
+
+	code := fasthttp.StatusOK
+	if id == "" {
+		code = fasthttp.StatusCreated
+	}
+	writeJSON(ctx, code, saved)
+}
+
+func (env *Environment) adminDeleteWebhook(ctx *fasthttp.RequestCtx, tenant, id string) {
+	if err := env.Webhooks.DeleteWebhook(tenant, id); err != nil {
+		env.adminFailed(ctx, err)
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
+
+// adminDeliveries returns the latest delivery attempts of the webhook, newest first.
+func (env *Environment) adminDeliveries(ctx *fasthttp.RequestCtx, tenant, id string) {
+	count, err := parseCount(ctx.QueryArgs().Peek("count"))
+	if err != nil {
+		writeError(ctx, fasthttp.StatusBadRequest, err)
+		return
+	}
+
+	attempts, err := env.Webhooks.DeliveryLog(tenant, id, count)
+	if err != nil {
+		env.adminFailed(ctx, err)
+		return
+	}
+
+	writeJSON(ctx, fasthttp.StatusOK, attempts)
+}
@@ -0,0 +1,168 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: webhooks.go
+
+// Package handlers is a generated GoMock package.
+package handlers
+
+import (
+	reflect "reflect"
+	time "time"
+
+	gomock "github.com/golang/mock/gomock"
+	store "github.com/yexelm/shorty/store"
+)
+
+// MockWebhookStore is a mock of WebhookStore interface.
+type MockWebhookStore struct {
+	ctrl     *gomock.Controller
+	recorder *MockWebhookStoreMockRecorder
+}
+
+// MockWebhookStoreMockRecorder is the mock recorder for MockWebhookStore.
+type MockWebhookStoreMockRecorder struct {
+	mock *MockWebhookStore
+}
+
+// NewMockWebhookStore creates a new mock instance.
+func NewMockWebhookStore(ctrl *gomock.Controller) *MockWebhookStore {
+	mock := &MockWebhookStore{ctrl: ctrl}
+	mock.recorder = &MockWebhookStoreMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockWebhookStore) EXPECT() *MockWebhookStoreMockRecorder {
+	return m.recorder
+}
+
+// ClaimDeliveries mocks base method.
+func (m *MockWebhookStore) ClaimDeliveries(now time.Time, lease time.Duration, count int) ([]store.Delivery, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimDeliveries", now, lease, count)
+	ret0, _ := ret[0].([]store.Delivery)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimDeliveries indicates an expected call of ClaimDeliveries.
+func (mr *MockWebhookStoreMockRecorder) ClaimDeliveries(now, lease, count interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimDeliveries", reflect.TypeOf((*MockWebhookStore)(nil).ClaimDeliveries), now, lease, count)
+}
+
+// DeleteWebhook mocks base method.
+func (m *MockWebhookStore) DeleteWebhook(tenant, id string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteWebhook", tenant, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteWebhook indicates an expected call of DeleteWebhook.
+func (mr *MockWebhookStoreMockRecorder) DeleteWebhook(tenant, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteWebhook", reflect.TypeOf((*MockWebhookStore)(nil).DeleteWebhook), tenant, id)
+}
+
+// DeliveryLog mocks base method.
+func (m *MockWebhookStore) DeliveryLog(tenant, id string, count int) ([]store.DeliveryAttempt, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeliveryLog", tenant, id, count)
+	ret0, _ := ret[0].([]store.DeliveryAttempt)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeliveryLog indicates an expected call of DeliveryLog.
+func (mr *MockWebhookStoreMockRecorder) DeliveryLog(tenant, id, count interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeliveryLog", reflect.TypeOf((*MockWebhookStore)(nil).DeliveryLog), tenant, id, count)
+}
+
+// EnqueueDelivery mocks base method.
+func (m *MockWebhookStore) EnqueueDelivery(d store.Delivery, at time.Time) (store.Delivery, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EnqueueDelivery", d, at)
+	ret0, _ := ret[0].(store.Delivery)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// EnqueueDelivery indicates an expected call of EnqueueDelivery.
+func (mr *MockWebhookStoreMockRecorder) EnqueueDelivery(d, at interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnqueueDelivery", reflect.TypeOf((*MockWebhookStore)(nil).EnqueueDelivery), d, at)
+}
+
+// FinishDelivery mocks base method.
+func (m *MockWebhookStore) FinishDelivery(d store.Delivery, a store.DeliveryAttempt) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FinishDelivery", d, a)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// FinishDelivery indicates an expected call of FinishDelivery.
+func (mr *MockWebhookStoreMockRecorder) FinishDelivery(d, a interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FinishDelivery", reflect.TypeOf((*MockWebhookStore)(nil).FinishDelivery), d, a)
+}
+
+// RetryDelivery mocks base method.
+func (m *MockWebhookStore) RetryDelivery(d store.Delivery, at time.Time, a store.DeliveryAttempt) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RetryDelivery", d, at, a)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RetryDelivery indicates an expected call of RetryDelivery.
+func (mr *MockWebhookStoreMockRecorder) RetryDelivery(d, at, a interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RetryDelivery", reflect.TypeOf((*MockWebhookStore)(nil).RetryDelivery), d, at, a)
+}
+
+// SaveWebhook mocks base method.
+func (m *MockWebhookStore) SaveWebhook(tenant string, h store.Webhook) (store.Webhook, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveWebhook", tenant, h)
+	ret0, _ := ret[0].(store.Webhook)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SaveWebhook indicates an expected call of SaveWebhook.
+func (mr *MockWebhookStoreMockRecorder) SaveWebhook(tenant, h interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveWebhook", reflect.TypeOf((*MockWebhookStore)(nil).SaveWebhook), tenant, h)
+}
+
+// Webhook mocks base method.
+func (m *MockWebhookStore) Webhook(tenant, id string) (store.Webhook, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Webhook", tenant, id)
+	ret0, _ := ret[0].(store.Webhook)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Webhook indicates an expected call of Webhook.
+func (mr *MockWebhookStoreMockRecorder) Webhook(tenant, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Webhook", reflect.TypeOf((*MockWebhookStore)(nil).Webhook), tenant, id)
+}
+
+// Webhooks mocks base method.
+func (m *MockWebhookStore) Webhooks(tenant string) ([]store.Webhook, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Webhooks", tenant)
+	ret0, _ := ret[0].([]store.Webhook)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Webhooks indicates an expected call of Webhooks.
+func (mr *MockWebhookStoreMockRecorder) Webhooks(tenant interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Webhooks", reflect.TypeOf((*MockWebhookStore)(nil).Webhooks), tenant)
+}
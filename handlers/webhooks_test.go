@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+
+	"github.com/yexelm/shorty/store"
+)
+
+func Test_notify(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+	mockEnv.withoutTenants()
+
+	mockEnv.Webhooks.EXPECT().ClaimDeliveries(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+	env.StartWebhooks()
+	defer env.Close()
+
+	queued := make(chan store.Delivery)
+	mockEnv.Webhooks.EXPECT().Webhooks("").Return([]store.Webhook{
+		{ID: "a", URL: "https://hooks.example.com/a", Events: []string{EventLinkClicked}},
+		{ID: "b", URL: "https://hooks.example.com/b"},
+	}, nil)
+	mockEnv.Webhooks.EXPECT().EnqueueDelivery(gomock.Any(), gomock.Any()).Times(3).
+		DoAndReturn(func(d store.Delivery, _ time.Time) (store.Delivery, error) {
+			queued <- d
+			return d, nil
+		})
+	mockEnv.Cache.EXPECT().Shorter(store.Namespace{}, []byte("https://go.dev"), store.Meta{}).Return([]byte("b"), true, nil)
+
+	ctx := initCtx("POST", "http://host.com", []byte("https://go.dev"))
+	env.Handle(ctx)
+	ao.Equal(fasthttp.StatusOK, ctx.Response.StatusCode())
+
+	// the event is only queued for the webhook subscribed to all events
+	select {
+	case d := <-queued:
+		ao.Equal("b", d.Webhook)
+		ao.Equal(EventLinkCreated, d.Event)
+
+		var e webhookEvent
+		ao.NoError(json.Unmarshal(d.Payload, &e))
+		ao.Equal("b", e.Short)
+		ao.Equal("https://go.dev", e.Long)
+	case <-time.After(time.Second):
+		t.Fatal("the event must be queued in the background")
+	}
+
+	// shortening the URL again returns the existing link without announcing it
+	mockEnv.Cache.EXPECT().Shorter(store.Namespace{}, []byte("https://go.dev"), store.Meta{}).Return([]byte("b"), false, nil)
+	ctx = initCtx("POST", "http://host.com", []byte("https://go.dev"))
+	env.Handle(ctx)
+	ao.Equal(fasthttp.StatusOK, ctx.Response.StatusCode())
+
+	// clicks reach both webhooks, the webhooks are cached
+	l := store.Link{Short: "b", Long: "https://go.dev"}
+	env.notify(EventLinkClicked, store.Namespace{}, l, &store.Visit{Country: "NL"})
+	for i := 0; i < 2; i++ {
+		select {
+		case d := <-queued:
+			ao.Equal(EventLinkClicked, d.Event)
+			ao.Contains(string(d.Payload), `"visit":{"country":"NL"}`)
+		case <-time.After(time.Second):
+			t.Fatal("the event must be queued for every subscribed webhook")
+		}
+	}
+}
+
+func Test_deliver(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, _ := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+
+	status := make(chan int, 1)
+	received := make(chan *http.Request, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		ts := r.Header.Get("X-Shorty-Timestamp")
+		if r.Header.Get("X-Shorty-Signature") != "sha256="+webhookSignature("secret", ts, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		received <- r
+		w.WriteHeader(<-status)
+	}))
+	defer receiver.Close()
+
+	n := &notifier{
+		hooks:       mockEnv.Webhooks,
+		client:      receiver.Client(),
+		maxAttempts: 3,
+		backoff:     time.Minute,
+		maxBackoff:  time.Hour,
+	}
+	hook := store.Webhook{ID: "h", URL: receiver.URL, Secret: "secret"}
+	d := store.Delivery{ID: "d", Tenant: "acme", Webhook: "h", Event: EventLinkClicked, Payload: []byte(`{"short":"b"}`)}
+
+	// failed attempts are retried with backoff
+	mockEnv.Webhooks.EXPECT().Webhook("acme", "h").Return(hook, nil)
+	mockEnv.Webhooks.EXPECT().RetryDelivery(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(retried store.Delivery, at time.Time, a store.DeliveryAttempt) error {
+			ao.Equal(1, retried.Attempts)
+			ao.WithinDuration(time.Now().Add(time.Minute), at, time.Second)
+			ao.Equal("retrying", a.Status)
+			ao.Equal(http.StatusInternalServerError, a.Code)
+			ao.Equal(1, a.Attempt)
+			ao.Equal(at, *a.NextAttempt)
+			return nil
+		})
+	status <- http.StatusInternalServerError
+	n.deliver(d)
+
+	r := <-received
+	ao.Equal(EventLinkClicked, r.Header.Get("X-Shorty-Event"))
+	ao.Equal("d", r.Header.Get("X-Shorty-Delivery"))
+
+	// successful attempts finish the delivery
+	mockEnv.Webhooks.EXPECT().Webhook("acme", "h").Return(hook, nil)
+	mockEnv.Webhooks.EXPECT().FinishDelivery(d, gomock.Any()).
+		DoAndReturn(func(_ store.Delivery, a store.DeliveryAttempt) error {
+			ao.Equal("delivered", a.Status)
+			ao.Equal(http.StatusNoContent, a.Code)
+			ao.Nil(a.NextAttempt)
+			return nil
+		})
+	status <- http.StatusNoContent
+	n.deliver(d)
+	<-received
+
+	// the last attempt fails the delivery
+	last := d
+	last.Attempts = 2
+	mockEnv.Webhooks.EXPECT().Webhook("acme", "h").Return(hook, nil)
+	mockEnv.Webhooks.EXPECT().FinishDelivery(last, gomock.Any()).
+		DoAndReturn(func(_ store.Delivery, a store.DeliveryAttempt) error {
+			ao.Equal("failed", a.Status)
+			ao.Equal(3, a.Attempt)
+			ao.Contains(a.Error, "502")
+			return nil
+		})
+	status <- http.StatusBadGateway
+	n.deliver(last)
+	<-received
+
+	// deliveries to deleted webhooks fail at once
+	mockEnv.Webhooks.EXPECT().Webhook("acme", "h").Return(store.Webhook{}, redis.ErrNil)
+	mockEnv.Webhooks.EXPECT().FinishDelivery(d, gomock.Any()).
+		DoAndReturn(func(_ store.Delivery, a store.DeliveryAttempt) error {
+			ao.Equal("failed", a.Status)
+			return nil
+		})
+	n.deliver(d)
+}
+
+func Test_claim(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, _ := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+
+	received := make(chan struct{}, 2)
+	release := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	n := &notifier{
+		hooks:       mockEnv.Webhooks,
+		client:      receiver.Client(),
+		maxAttempts: 3,
+		slots:       make(chan struct{}, 2),
+		stop:        make(chan struct{}),
+	}
+	hook := store.Webhook{ID: "h", URL: receiver.URL}
+	due := []store.Delivery{{ID: "a", Webhook: "h"}, {ID: "b", Webhook: "h"}}
+
+	mockEnv.Webhooks.EXPECT().ClaimDeliveries(gomock.Any(), gomock.Any(), 2).Return(due, nil)
+	mockEnv.Webhooks.EXPECT().Webhook("", "h").Return(hook, nil).Times(3)
+	mockEnv.Webhooks.EXPECT().FinishDelivery(gomock.Any(), gomock.Any()).Return(nil).Times(3)
+
+	ao.True(n.claim(), "a full claim must be followed by another one")
+	for i := 0; i < 2; i++ {
+		select {
+		case <-received:
+		case <-time.After(time.Second):
+			t.Fatal("deliveries must be sent concurrently")
+		}
+	}
+
+	// workers are claimed for again once they are free
+	close(release)
+	mockEnv.Webhooks.EXPECT().ClaimDeliveries(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ time.Time, _ time.Duration, count int) ([]store.Delivery, error) {
+			ao.GreaterOrEqual(count, 1)
+			return due[:1], nil
+		})
+	n.claim()
+	n.wg.Wait()
+	<-received
+	ao.Len(n.slots, 0, "workers must be freed after their deliveries")
+}
+
+func Test_delay(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+
+	n := &notifier{backoff: 30 * time.Second, maxBackoff: 5 * time.Minute}
+	ao.Equal(30*time.Second, n.delay(1))
+	ao.Equal(time.Minute, n.delay(2))
+	ao.Equal(4*time.Minute, n.delay(4))
+	ao.Equal(5*time.Minute, n.delay(5))
+	ao.Equal(5*time.Minute, n.delay(100))
+}
+
+func Test_adminWebhooks(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	mockEnv, env := loadMockEnv(t)
+	defer mockEnv.Ctrl.Finish()
+	mockEnv.withoutTenants()
+
+	createdAt := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	type testData struct {
+		tCase        string
+		method       string
+		URI          string
+		body         []byte
+		expectedFunc func()
+
+		expectedBody string
+		expectedCode int
+	}
+
+	testTable := []testData{
+		{
+			tCase:  "list without secrets",
+			method: "GET",
+			URI:    "/webhooks",
+			expectedFunc: func() {
+				mockEnv.Webhooks.EXPECT().Webhooks("").Return([]store.Webhook{
+					{ID: "a", URL: "https://hooks.example.com", Secret: "secret", CreatedAt: createdAt},
+				}, nil)
+			},
+			expectedBody: `[{"id":"a","url":"https://hooks.example.com","created_at":"2021-03-01T00:00:00Z"}]`,
+			expectedCode: fasthttp.StatusOK,
+		},
+		{
+			tCase:  "create",
+			method: "POST",
+			URI:    "/webhooks",
+			body:   []byte(`{"url":"https://hooks.example.com","events":["link.created"]}`),
+			expectedFunc: func() {
+				h := store.Webhook{URL: "https://hooks.example.com", Events: []string{EventLinkCreated}}
+				saved := h
+				saved.ID, saved.Secret, saved.CreatedAt = "a", "secret", createdAt
+				mockEnv.Webhooks.EXPECT().SaveWebhook("", h).Return(saved, nil)
+			},
+			expectedBody: `{"id":"a","url":"https://hooks.example.com","events":["link.created"],"secret":"secret",` +
+				`"created_at":"2021-03-01T00:00:00Z"}`,
+			expectedCode: fasthttp.StatusCreated,
+		},
+		{
+			tCase:        "unknown event",
+			method:       "POST",
+			URI:          "/webhooks",
+			body:         []byte(`{"url":"https://hooks.example.com","events":["link.updated"]}`),
+			expectedFunc: func() {},
+			expectedBody: `{"error":"webhook URL must be an absolute HTTP URL and events must be known"}`,
+			expectedCode: fasthttp.StatusBadRequest,
+		},
+		{
+			tCase:        "invalid URL",
+			method:       "POST",
+			URI:          "/webhooks",
+			body:         []byte(`{"url":"ftp://hooks.example.com"}`),
+			expectedFunc: func() {},
+			expectedBody: `{"error":"webhook URL must be an absolute HTTP URL and events must be known"}`,
+			expectedCode: fasthttp.StatusBadRequest,
+		},
+		{
+			tCase:  "update unknown",
+			method: "PUT",
+			URI:    "/webhooks/b",
+			body:   []byte(`{"url":"https://hooks.example.com"}`),
+			expectedFunc: func() {
+				mockEnv.Webhooks.EXPECT().SaveWebhook("", store.Webhook{ID: "b", URL: "https://hooks.example.com"}).
+					Return(store.Webhook{}, redis.ErrNil)
+			},
+			expectedBody: `{"error":"not found"}`,
+			expectedCode: fasthttp.StatusNotFound,
+		},
+		{
+			tCase:  "delete",
+			method: "DELETE",
+			URI:    "/webhooks/a",
+			expectedFunc: func() {
+				mockEnv.Webhooks.EXPECT().DeleteWebhook("", "a").Return(nil)
+			},
+			expectedCode: fasthttp.StatusNoContent,
+		},
+		{
+			tCase:  "deliveries",
+			method: "GET",
+			URI:    "/webhooks/a/deliveries?count=1",
+			expectedFunc: func() {
+				mockEnv.Webhooks.EXPECT().DeliveryLog("", "a", 1).Return([]store.DeliveryAttempt{
+					{Delivery: "d", Event: EventLinkClicked, Attempt: 1, Status: "delivered", Code: 200, At: createdAt},
+				}, nil)
+			},
+			expectedBody: `[{"delivery":"d","event":"link.clicked","attempt":1,"status":"delivered","code":200,` +
+				`"at":"2021-03-01T00:00:00Z"}]`,
+			expectedCode: fasthttp.StatusOK,
+		},
+	}
+
+	for _, tc := range testTable {
+		t.Run(tc.tCase, func(t *testing.T) {
+			ctx := initCtx(tc.method, tc.URI, tc.body)
+			tc.expectedFunc()
+			env.HandleAdmin(ctx)
+
+			ao.Equal(tc.expectedCode, ctx.Response.StatusCode())
+			ao.Equal(tc.expectedBody, string(ctx.Response.Body()))
+		})
+	}
+}
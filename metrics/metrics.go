@@ -2,15 +2,146 @@ package metrics
 
 import "github.com/prometheus/client_golang/prometheus"
 
+const namespace = "shorty"
+
 var (
-	// LatencyHandler records basic stats about Shorty, which can be viewed at the /metrics handler
-	LatencyHandler = prometheus.NewSummaryVec(prometheus.SummaryOpts{
-		Namespace:  "shorty",
-		Name:       "latency_of_handlers",
-		Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
-	}, []string{"handler_name"})
+	// HandlerDuration records the time spent serving HTTP requests, labeled by handler, method and status code.
+	HandlerDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Time spent serving HTTP requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"handler", "method", "code"})
+
+	// LinksCreated counts short aliases generated for URLs which have not been seen before.
+	LinksCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "links_created_total",
+		Help:      "Number of new short links created.",
+	})
+
+	// LinksResolved counts short aliases successfully resolved into original URLs.
+	LinksResolved = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "links_resolved_total",
+		Help:      "Number of short links resolved into original URLs.",
+	})
+
+	// LinksNotFound counts requests for short aliases which do not exist.
+	LinksNotFound = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "links_not_found_total",
+		Help:      "Number of requests for unknown short links.",
+	})
+
+	// WrongPasswords counts wrong passwords entered for protected links.
+	WrongPasswords = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "wrong_passwords_total",
+		Help:      "Number of wrong passwords entered for protected links.",
+	})
+
+	// Unfurls counts background fetches of metadata of destinations, labeled by result: fetched, failed or dropped
+	// when the queue is full.
+	Unfurls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "unfurls_total",
+		Help:      "Number of background fetches of metadata of destinations.",
+	}, []string{"result"})
+
+	// Webhooks counts events sent to webhooks, labeled by result: delivered, retried, failed after all attempts or
+	// dropped before being queued.
+	Webhooks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhooks_total",
+		Help:      "Number of events sent to webhooks.",
+	}, []string{"result"})
+
+	// ClickEvents counts click events published to the configured sink, labeled by result: published, failed to be
+	// written or dropped because the buffer was full.
+	ClickEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "click_events_total",
+		Help:      "Number of click events published to the sink.",
+	}, []string{"result"})
+
+	// RateLimited counts requests to the API rejected by the rate limit, labeled by handler.
+	RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Number of requests rejected by the rate limit.",
+	}, []string{"handler"})
+
+	// Errors counts requests which failed with an internal error, labeled by handler.
+	Errors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "errors_total",
+		Help:      "Number of requests failed due to internal errors.",
+	}, []string{"handler"})
+
+	// RedisDuration records the latency of Redis commands, labeled by command.
+	RedisDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "redis",
+		Name:      "command_duration_seconds",
+		Help:      "Latency of Redis commands.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"command"})
+
+	// RedisErrors counts failed Redis commands, labeled by command.
+	RedisErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "redis",
+		Name:      "command_errors_total",
+		Help:      "Number of failed Redis commands.",
+	}, []string{"command"})
+
+	// LastID shows the last ID handed out by the short alias generator.
+	LastID = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "ids",
+		Name:      "last_id",
+		Help:      "Last ID handed out by the short alias generator.",
+	})
+
+	// IntegrityProblems counts inconsistencies between aliases, URLs and the last ID found by integrity checks,
+	// labeled by kind.
+	IntegrityProblems = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "integrity_problems_total",
+		Help:      "Number of inconsistencies found by integrity checks.",
+	}, []string{"kind"})
+
+	// BuildInfo is always 1 and exposes the build version as labels.
+	BuildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "build_info",
+		Help:      "Build information of the running Shorty binary, the value is always 1.",
+	}, []string{"version", "revision", "goversion"})
 )
 
 func init() {
-	prometheus.MustRegister(LatencyHandler)
+	prometheus.MustRegister(
+		HandlerDuration,
+		LinksCreated,
+		LinksResolved,
+		LinksNotFound,
+		WrongPasswords,
+		Unfurls,
+		Webhooks,
+		ClickEvents,
+		RateLimited,
+		Errors,
+		RedisDuration,
+		RedisErrors,
+		LastID,
+		IntegrityProblems,
+		BuildInfo,
+	)
+}
+
+// SetBuildInfo publishes version information of the running binary.
+func SetBuildInfo(version, revision, goVersion string) {
+	BuildInfo.WithLabelValues(version, revision, goVersion).Set(1)
 }
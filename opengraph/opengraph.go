@@ -0,0 +1,247 @@
+package opengraph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// maxRedirects limits redirects followed to the page.
+const maxRedirects = 3
+
+var (
+	// ErrBlocked is returned for pages on addresses which must not be reached from the server.
+	ErrBlocked = errors.New("the address is not allowed")
+	// ErrNotHTML is returned for destinations which are not HTML pages.
+	ErrNotHTML = errors.New("the page is not HTML")
+)
+
+// blockedNetworks are private, loopback, link-local and other special purpose networks. Pages on them may be services
+// internal to the network of the server, so they are never fetched.
+var blockedNetworks = parseNetworks(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12", "192.0.0.0/24",
+	"192.0.2.0/24", "192.168.0.0/16", "198.18.0.0/15", "198.51.100.0/24", "203.0.113.0/24", "224.0.0.0/4",
+	"240.0.0.0/4", "::/128", "::1/128", "64:ff9b::/96", "100::/64", "2001:db8::/32", "fc00::/7", "fe80::/10",
+	"ff00::/8",
+)
+
+// Metadata describes a web page.
+type Metadata struct {
+	Title       string
+	Description string
+	// Image is the absolute URL of the image of the page.
+	Image string
+}
+
+// Fetcher downloads web pages and extracts their metadata. Only public addresses are connected to, which is checked
+// on every connection, so that host names resolving to internal addresses and redirects to them are refused too.
+type Fetcher struct {
+	client   *http.Client
+	maxBytes int64
+	blocked  []*net.IPNet
+}
+
+// NewFetcher returns a Fetcher giving up on pages after the timeout and reading at most maxBytes of each page.
+func NewFetcher(timeout time.Duration, maxBytes int64) *Fetcher {
+	f := &Fetcher{maxBytes: maxBytes, blocked: blockedNetworks}
+	dialer := &net.Dialer{Timeout: timeout, Control: f.control}
+	f.client = &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   timeout,
+			ResponseHeaderTimeout: timeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       time.Minute,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return checkScheme(req.URL)
+		},
+	}
+
+	return f
+}
+
+// control refuses connections to blocked addresses after the host name has been resolved.
+func (f *Fetcher) control(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ErrBlocked
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, n := range f.blocked {
+		if n.Contains(ip) {
+			return fmt.Errorf("%w: %v", ErrBlocked, ip)
+		}
+	}
+
+	return nil
+}
+
+// Fetch downloads the page and returns its metadata.
+func (f *Fetcher) Fetch(ctx context.Context, pageURL string) (Metadata, error) {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return Metadata{}, err
+	}
+	if err := checkScheme(u); err != nil {
+		return Metadata{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return Metadata{}, err
+	}
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", "shorty (link preview)")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Metadata{}, fmt.Errorf("unexpected status %v", resp.Status)
+	}
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err != nil ||
+		mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return Metadata{}, ErrNotHTML
+	}
+
+	// relative images are resolved against the final URL after redirects
+	return parse(io.LimitReader(resp.Body, f.maxBytes), resp.Request.URL), nil
+}
+
+func checkScheme(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: unsupported scheme %q", ErrBlocked, u.Scheme)
+	}
+
+	return nil
+}
+
+// parse extracts the metadata from the head of the page. Open Graph tags take precedence over the title element and
+// the description meta tag.
+func parse(r io.Reader, base *url.URL) Metadata {
+	var m, fallback Metadata
+	z := html.NewTokenizer(r)
+	for inTitle := false; ; {
+		switch z.Next() {
+		case html.ErrorToken:
+			return m.or(fallback, base)
+		case html.TextToken:
+			if inTitle {
+				fallback.Title += string(z.Text())
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "title":
+				inTitle = false
+			case "head":
+				return m.or(fallback, base)
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "title":
+				inTitle = true
+			case "body":
+				return m.or(fallback, base)
+			case "meta":
+				if !hasAttr {
+					continue
+				}
+				attrs := make(map[string]string)
+				for more := true; more; {
+					var k, v []byte
+					k, v, more = z.TagAttr()
+					attrs[string(k)] = string(v)
+				}
+				key := attrs["property"]
+				if key == "" {
+					key = attrs["name"]
+				}
+				switch strings.ToLower(key) {
+				case "og:title":
+					m.Title = attrs["content"]
+				case "og:description":
+					m.Description = attrs["content"]
+				case "og:image", "og:image:url":
+					if m.Image == "" {
+						m.Image = attrs["content"]
+					}
+				case "description":
+					fallback.Description = attrs["content"]
+				case "twitter:image":
+					fallback.Image = attrs["content"]
+				}
+			}
+		}
+	}
+}
+
+// or fills the fields missing in m from the fallback, trims them and makes the image URL absolute.
+func (m Metadata) or(fallback Metadata, base *url.URL) Metadata {
+	for _, f := range []struct{ dst, src *string }{
+		{&m.Title, &fallback.Title},
+		{&m.Description, &fallback.Description},
+		{&m.Image, &fallback.Image},
+	} {
+		if strings.TrimSpace(*f.dst) == "" {
+			*f.dst = *f.src
+		}
+		*f.dst = strings.Join(strings.Fields(*f.dst), " ")
+	}
+
+	m.Image = resolveImage(m.Image, base)
+	return m
+}
+
+// resolveImage returns the absolute URL of the image, or an empty string if it is not an HTTP URL.
+func resolveImage(image string, base *url.URL) string {
+	if image == "" {
+		return ""
+	}
+
+	u, err := base.Parse(image)
+	if err != nil || checkScheme(u) != nil {
+		return ""
+	}
+
+	return u.String()
+}
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+
+	return nets
+}
@@ -0,0 +1,105 @@
+package opengraph
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const page = `<!DOCTYPE html>
+<html>
+<head>
+<title>
+  Fallback &amp; title
+</title>
+<meta name="description" content="Fallback description">
+<meta property="og:title" content="Go &amp; you">
+<meta property="og:image" content="/images/logo.png">
+<meta property="og:image" content="/images/second.png">
+</head>
+<body>
+<meta property="og:description" content="ignored in the body">
+</body>
+</html>
+`
+
+func Test_Fetch(t *testing.T) {
+	ao := assert.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(page))
+		case "/moved":
+			http.Redirect(w, r, "/page", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/local":
+			http.Redirect(w, r, "http://[::1]:1/", http.StatusFound)
+		case "/large":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html><head><title>" + strings.Repeat("a", 100) + "</title></head></html>"))
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	f := NewFetcher(100*time.Millisecond, 50)
+	_, err := f.Fetch(ctx, srv.URL+"/page")
+	ao.True(errors.Is(err, ErrBlocked), "loopback addresses must be blocked: %v", err)
+	_, err = f.Fetch(ctx, "file:///etc/passwd")
+	ao.True(errors.Is(err, ErrBlocked))
+
+	f.blocked = parseNetworks("::1/128")
+	_, err = f.Fetch(ctx, srv.URL+"/local")
+	ao.True(errors.Is(err, ErrBlocked), "redirects must be checked too: %v", err)
+
+	f = NewFetcher(100*time.Millisecond, 1<<20)
+	f.blocked = nil
+	m, err := f.Fetch(ctx, srv.URL+"/moved")
+	ao.NoError(err)
+	ao.Equal(Metadata{Title: "Go & you", Description: "Fallback description", Image: srv.URL + "/images/logo.png"}, m)
+
+	_, err = f.Fetch(ctx, srv.URL+"/loop")
+	ao.Error(err)
+	_, err = f.Fetch(ctx, srv.URL+"/image")
+	ao.Equal(ErrNotHTML, err)
+	_, err = f.Fetch(ctx, srv.URL+"/missing")
+	ao.EqualError(err, "unexpected status 404 Not Found")
+	_, err = f.Fetch(ctx, srv.URL+"/slow")
+	ao.Error(err)
+
+	f.maxBytes = 50
+	m, err = f.Fetch(ctx, srv.URL+"/large")
+	ao.NoError(err)
+	ao.Equal(strings.Repeat("a", 31), m.Title, "pages must be read up to the limit")
+}
+
+func Test_control(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	f := NewFetcher(time.Second, 1)
+
+	for _, address := range []string{
+		"127.0.0.1:80", "10.1.2.3:443", "172.16.0.1:80", "192.168.1.1:80", "169.254.169.254:80", "[::1]:80",
+		"[fd00::1]:80", "[fe80::1]:80", "[::ffff:127.0.0.1]:80", "0.0.0.0:80", "100.64.0.1:80",
+	} {
+		ao.True(errors.Is(f.control("tcp", address, nil), ErrBlocked), address)
+	}
+	for _, address := range []string{"8.8.8.8:80", "[2606:4700:4700::1111]:443"} {
+		ao.NoError(f.control("tcp", address, nil), address)
+	}
+}
@@ -0,0 +1,4 @@
+// Package rpc contains the gRPC API of shorty along with Go client stubs generated from shorty.proto.
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative shorty.proto
@@ -0,0 +1,255 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// ShortyClient is the client API for Shorty service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ShortyClient interface {
+	// Shorten shortens the URL, returning the existing link if the URL has been shortened before.
+	Shorten(ctx context.Context, in *ShortenRequest, opts ...grpc.CallOption) (*Link, error)
+	// Resolve returns the link without counting a click.
+	Resolve(ctx context.Context, in *LinkRequest, opts ...grpc.CallOption) (*Link, error)
+	// BatchShorten shortens up to 100 URLs, failing each of them separately.
+	BatchShorten(ctx context.Context, in *BatchShortenRequest, opts ...grpc.CallOption) (*BatchShortenResponse, error)
+	// Delete deletes the link.
+	Delete(ctx context.Context, in *LinkRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	// GetStats returns the number of clicks of the link.
+	GetStats(ctx context.Context, in *LinkRequest, opts ...grpc.CallOption) (*Stats, error)
+}
+
+type shortyClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewShortyClient(cc grpc.ClientConnInterface) ShortyClient {
+	return &shortyClient{cc}
+}
+
+func (c *shortyClient) Shorten(ctx context.Context, in *ShortenRequest, opts ...grpc.CallOption) (*Link, error) {
+	out := new(Link)
+	err := c.cc.Invoke(ctx, "/shorty.v1.Shorty/Shorten", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shortyClient) Resolve(ctx context.Context, in *LinkRequest, opts ...grpc.CallOption) (*Link, error) {
+	out := new(Link)
+	err := c.cc.Invoke(ctx, "/shorty.v1.Shorty/Resolve", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shortyClient) BatchShorten(ctx context.Context, in *BatchShortenRequest, opts ...grpc.CallOption) (*BatchShortenResponse, error) {
+	out := new(BatchShortenResponse)
+	err := c.cc.Invoke(ctx, "/shorty.v1.Shorty/BatchShorten", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shortyClient) Delete(ctx context.Context, in *LinkRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, "/shorty.v1.Shorty/Delete", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shortyClient) GetStats(ctx context.Context, in *LinkRequest, opts ...grpc.CallOption) (*Stats, error) {
+	out := new(Stats)
+	err := c.cc.Invoke(ctx, "/shorty.v1.Shorty/GetStats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ShortyServer is the server API for Shorty service.
+// All implementations must embed UnimplementedShortyServer
+// for forward compatibility
+type ShortyServer interface {
+	// Shorten shortens the URL, returning the existing link if the URL has been shortened before.
+	Shorten(context.Context, *ShortenRequest) (*Link, error)
+	// Resolve returns the link without counting a click.
+	Resolve(context.Context, *LinkRequest) (*Link, error)
+	// BatchShorten shortens up to 100 URLs, failing each of them separately.
+	BatchShorten(context.Context, *BatchShortenRequest) (*BatchShortenResponse, error)
+	// Delete deletes the link.
+	Delete(context.Context, *LinkRequest) (*DeleteResponse, error)
+	// GetStats returns the number of clicks of the link.
+	GetStats(context.Context, *LinkRequest) (*Stats, error)
+	mustEmbedUnimplementedShortyServer()
+}
+
+// UnimplementedShortyServer must be embedded to have forward compatible implementations.
+type UnimplementedShortyServer struct {
+}
+
+func (UnimplementedShortyServer) Shorten(context.Context, *ShortenRequest) (*Link, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Shorten not implemented")
+}
+func (UnimplementedShortyServer) Resolve(context.Context, *LinkRequest) (*Link, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Resolve not implemented")
+}
+func (UnimplementedShortyServer) BatchShorten(context.Context, *BatchShortenRequest) (*BatchShortenResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchShorten not implemented")
+}
+func (UnimplementedShortyServer) Delete(context.Context, *LinkRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedShortyServer) GetStats(context.Context, *LinkRequest) (*Stats, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStats not implemented")
+}
+func (UnimplementedShortyServer) mustEmbedUnimplementedShortyServer() {}
+
+// UnsafeShortyServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ShortyServer will
+// result in compilation errors.
+type UnsafeShortyServer interface {
+	mustEmbedUnimplementedShortyServer()
+}
+
+func RegisterShortyServer(s grpc.ServiceRegistrar, srv ShortyServer) {
+	s.RegisterService(&Shorty_ServiceDesc, srv)
+}
+
+func _Shorty_Shorten_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ShortenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortyServer).Shorten(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/shorty.v1.Shorty/Shorten",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortyServer).Shorten(ctx, req.(*ShortenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Shorty_Resolve_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LinkRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortyServer).Resolve(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/shorty.v1.Shorty/Resolve",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortyServer).Resolve(ctx, req.(*LinkRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Shorty_BatchShorten_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchShortenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortyServer).BatchShorten(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/shorty.v1.Shorty/BatchShorten",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortyServer).BatchShorten(ctx, req.(*BatchShortenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Shorty_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LinkRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortyServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/shorty.v1.Shorty/Delete",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortyServer).Delete(ctx, req.(*LinkRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Shorty_GetStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LinkRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortyServer).GetStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/shorty.v1.Shorty/GetStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortyServer).GetStats(ctx, req.(*LinkRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Shorty_ServiceDesc is the grpc.ServiceDesc for Shorty service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Shorty_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "shorty.v1.Shorty",
+	HandlerType: (*ShortyServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Shorten",
+			Handler:    _Shorty_Shorten_Handler,
+		},
+		{
+			MethodName: "Resolve",
+			Handler:    _Shorty_Resolve_Handler,
+		},
+		{
+			MethodName: "BatchShorten",
+			Handler:    _Shorty_BatchShorten_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _Shorty_Delete_Handler,
+		},
+		{
+			MethodName: "GetStats",
+			Handler:    _Shorty_GetStats_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "shorty.proto",
+}
@@ -0,0 +1,155 @@
+package store
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const disabledKey = "disabled"
+
+// unlinkURLScript removes the URL given as the first argument from the hash unless it is matched to another alias
+// than the second argument, since links with settings share URLs with the deduplicated ones.
+const unlinkURLScript = `
+if redis.call("HGET", KEYS[1], ARGV[1]) == ARGV[2] then
+	return redis.call("HDEL", KEYS[1], ARGV[1])
+end
+return 0`
+
+// ErrDisabled is returned when a disabled short alias is requested.
+var ErrDisabled = errors.New("the requested short code is disabled")
+
+// Stats contains basic figures about the saved links.
+type Stats struct {
+	Links    int `json:"links"`
+	Disabled int `json:"disabled"`
+	LastID   int `json:"last_id"`
+}
+
+// Links returns a page of links saved in the namespace starting at the given cursor along with the cursor of the next page, which is
+// zero after the last page. If query is not empty, only links whose original URL contains it are returned, so a
+// page may contain fewer than count links.
+func (s *Storage) Links(ns Namespace, cursor uint64, count int, query string) ([]Link, uint64, error) {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	reply, err := redis.Values(do(conn, "HSCAN", ns.key(shortToLong), cursor, "COUNT", count))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		next  uint64
+		pairs []string
+	)
+	if _, err := redis.Scan(reply, &next, &pairs); err != nil {
+		return nil, 0, err
+	}
+
+	shorts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if query == "" || strings.Contains(pairs[i+1], query) {
+			shorts = append(shorts, pairs[i])
+		}
+	}
+
+	links, err := loadLinks(conn, ns, shorts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return links, next, nil
+}
+
+// Each calls fn for every link saved in the namespace, stopping at the first error.
+func (s *Storage) Each(ns Namespace, fn func(Link) error) error {
+	const pageSize = 1000
+
+	var cursor uint64
+	for {
+		links, next, err := s.Links(ns, cursor, pageSize, "")
+		if err != nil {
+			return err
+		}
+
+		for _, l := range links {
+			if err := fn(l); err != nil {
+				return err
+			}
+		}
+
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// Disable makes the short alias unresolvable without deleting it.
+func (s *Storage) Disable(ns Namespace, short []byte) error {
+	return s.setDisabled(ns, short, "SADD")
+}
+
+// Enable makes the previously disabled short alias resolvable again.
+func (s *Storage) Enable(ns Namespace, short []byte) error {
+	return s.setDisabled(ns, short, "SREM")
+}
+
+func (s *Storage) setDisabled(ns Namespace, short []byte, cmd string) error {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	exists, err := redis.Bool(do(conn, "HEXISTS", ns.key(shortToLong), short))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return redis.ErrNil
+	}
+
+	_, err = do(conn, cmd, ns.key(disabledKey), short)
+	return err
+}
+
+// Delete removes the short alias along with its match to the original URL, its record and index entries.
+func (s *Storage) Delete(ns Namespace, short []byte) error {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	return modifyLink(conn, ns, short, func(l Link) {
+		_, _ = do(conn, "HDEL", ns.key(shortToLong), short)
+		_, _ = do(conn, "EVAL", unlinkURLScript, 1, ns.key(longToShort), l.Long, short)
+		_, _ = do(conn, "SREM", ns.key(disabledKey), short)
+		_, _ = do(conn, "DEL", ns.key(linkPrefix+l.Short))
+		_, _ = do(conn, "DEL", ns.key(attemptsPrefix+l.Short))
+		_, _ = do(conn, "DEL", ns.key(statsPrefix+l.Short))
+		_, _ = do(conn, "ZREM", ns.key(indexCreated), short)
+		for _, index := range l.indexes(ns) {
+			_, _ = do(conn, "ZREM", index, short)
+		}
+	})
+}
+
+// Stats returns basic figures about the links saved in the namespace. The last ID is shared by all namespaces.
+func (s *Storage) Stats(ns Namespace) (Stats, error) {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	var (
+		st  Stats
+		err error
+	)
+
+	if st.Links, err = redis.Int(do(conn, "HLEN", ns.key(shortToLong))); err != nil {
+		return Stats{}, err
+	}
+	if st.Disabled, err = redis.Int(do(conn, "SCARD", ns.key(disabledKey))); err != nil {
+		return Stats{}, err
+	}
+	if st.LastID, err = redis.Int(do(conn, "GET", lastIDKey)); err != nil && err != redis.ErrNil {
+		return Stats{}, err
+	}
+
+	return st, nil
+}
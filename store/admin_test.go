@@ -0,0 +1,106 @@
+package store_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yexelm/shorty/store"
+)
+
+// newStorage returns Storage connected to a fresh miniredis instance, so that the test does not depend on the
+// aliases generated by other tests.
+func newStorage(t *testing.T) *store.Storage {
+	t.Helper()
+
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+
+	st, err := store.New(s.Addr(), 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(st.Close)
+
+	return st
+}
+
+func Test_Admin(t *testing.T) {
+	ao := assert.New(t)
+	st := newStorage(t)
+
+	for _, l := range []string{"https://go.dev/doc", "https://ya.ru", "https://go.dev/blog"} {
+		_, _, err := st.Shorter(store.Namespace{}, []byte(l), store.Meta{})
+		ao.NoError(err)
+	}
+
+	links, next, err := st.Links(store.Namespace{}, 0, 100, "go.dev")
+	ao.NoError(err)
+	ao.Zero(next)
+	sort.Slice(links, func(i, j int) bool { return links[i].Short < links[j].Short })
+	ao.Len(links, 2)
+	ao.Equal("https://go.dev/doc", links[0].Long)
+	ao.Equal("https://go.dev/blog", links[1].Long)
+	ao.False(links[0].CreatedAt.IsZero())
+
+	ao.NoError(st.Disable(store.Namespace{}, []byte("b")))
+	_, err = st.Longer(store.Namespace{}, []byte("b"))
+	ao.Equal(store.ErrDisabled, err)
+	ao.Equal(redis.ErrNil, st.Disable(store.Namespace{}, []byte("missing")))
+
+	stats, err := st.Stats(store.Namespace{})
+	ao.NoError(err)
+	ao.Equal(store.Stats{Links: 3, Disabled: 1, LastID: 3}, stats)
+
+	ao.NoError(st.Enable(store.Namespace{}, []byte("b")))
+	long, err := st.Longer(store.Namespace{}, []byte("b"))
+	ao.NoError(err)
+	ao.Equal("https://go.dev/doc", long.Long)
+
+	ao.NoError(st.Delete(store.Namespace{}, []byte("c")))
+	_, err = st.Longer(store.Namespace{}, []byte("c"))
+	ao.Equal(redis.ErrNil, err)
+	ao.Equal(redis.ErrNil, st.Delete(store.Namespace{}, []byte("c")))
+
+	var exported []string
+	ao.NoError(st.Each(store.Namespace{}, func(l store.Link) error {
+		exported = append(exported, l.Short)
+		return nil
+	}))
+	sort.Strings(exported)
+	ao.Equal([]string{"b", "d"}, exported)
+
+	short, _, err := st.Shorter(store.Namespace{}, []byte("https://ya.ru"), store.Meta{})
+	ao.NoError(err)
+	ao.NotEqual("c", string(short), "deleted URL must get a new alias")
+}
+
+func Test_Keys(t *testing.T) {
+	ao := assert.New(t)
+	st := newStorage(t)
+
+	key, err := st.IssueKey("", "team")
+	ao.NoError(err)
+
+	p, err := st.Authenticate(key)
+	ao.NoError(err)
+	ao.Equal(store.Principal{Owner: "team"}, p)
+
+	reissued, err := st.IssueKey("", "team")
+	ao.NoError(err)
+	ao.NotEqual(key, reissued)
+
+	_, err = st.Authenticate(key)
+	ao.Equal(redis.ErrNil, err, "reissued key must revoke the previous one")
+
+	ao.NoError(st.RevokeKey("", "team"))
+	_, err = st.Authenticate(reissued)
+	ao.Equal(redis.ErrNil, err)
+	ao.Equal(redis.ErrNil, st.RevokeKey("", "team"))
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// statsPrefix prefixes keys of hashes counting resolutions of each link.
+	statsPrefix = "stats:"
+	// countryPrefix prefixes fields of the stats hashes counting resolutions by the country of the visitor.
+	countryPrefix = "country:"
+	// variantPrefix prefixes fields of the stats hashes counting resolutions by the variant the visitor was sent to.
+	variantPrefix = "variant:"
+)
+
+var ErrExhausted = errors.New("the link has reached its click limit")
+
+// ClickStats contains figures about resolutions of a link.
+type ClickStats struct {
+	Clicks    int `json:"clicks"`
+	MaxClicks int `json:"max_clicks,omitempty"`
+	// ClicksLeft is the number of resolutions left for links with a click limit.
+	ClicksLeft *int `json:"clicks_left,omitempty"`
+	// Countries counts resolutions by ISO 3166 codes of countries of the visitors, if they are known.
+	Countries map[string]int `json:"countries,omitempty"`
+	// Variants counts resolutions by names of the variants of links rotating between several destinations.
+	Variants map[string]int `json:"variants,omitempty"`
+}
+
+// Visit describes the visitor who resolved a link.
+type Visit struct {
+	// Country is the ISO 3166 code of the country of the visitor, it is empty if unknown.
+	Country string `json:"country,omitempty"`
+	// Variant is the name of the variant the visitor is sent to, it is empty for links without variants.
+	Variant string `json:"variant,omitempty"`
+}
+
+// Click counts a resolution of the link by the visitor. ErrExhausted is returned if the link has reached its click
+// limit, the counter is incremented atomically, so concurrent resolutions never exceed the limit.
+func (s *Storage) Click(ns Namespace, l Link, v Visit) error {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	key := ns.key(statsPrefix + l.Short)
+	n, err := redis.Int(do(conn, "HINCRBY", key, "clicks", 1))
+	if err != nil {
+		return err
+	}
+	if l.MaxClicks > 0 && n > l.MaxClicks {
+		// the failed resolution is not counted, the counter still cannot drop below the limit
+		if _, err := do(conn, "HINCRBY", key, "clicks", -1); err != nil {
+			return err
+		}
+		return ErrExhausted
+	}
+
+	for prefix, value := range map[string]string{countryPrefix: v.Country, variantPrefix: v.Variant} {
+		if value == "" {
+			continue
+		}
+		if _, err := do(conn, "HINCRBY", key, prefix+value, 1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ClickStats returns figures about resolutions of the link saved in the namespace.
+func (s *Storage) ClickStats(ns Namespace, short []byte) (ClickStats, error) {
+	l, err := s.Link(ns, short)
+	if err != nil {
+		return ClickStats{}, err
+	}
+
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	counters, err := redis.IntMap(do(conn, "HGETALL", ns.key(statsPrefix+l.Short)))
+	if err != nil {
+		return ClickStats{}, err
+	}
+
+	st := ClickStats{Clicks: counters["clicks"], MaxClicks: l.MaxClicks}
+	for field, n := range counters {
+		switch {
+		case strings.HasPrefix(field, countryPrefix):
+			if st.Countries == nil {
+				st.Countries = make(map[string]int)
+			}
+			st.Countries[field[len(countryPrefix):]] = n
+		case strings.HasPrefix(field, variantPrefix):
+			if st.Variants == nil {
+				st.Variants = make(map[string]int)
+			}
+			st.Variants[field[len(variantPrefix):]] = n
+		}
+	}
+
+	if l.MaxClicks > 0 {
+		left := l.MaxClicks - st.Clicks
+		if left < 0 {
+			left = 0
+		}
+		st.ClicksLeft = &left
+	}
+
+	return st, nil
+}
+
+func loadClicks(conn redis.Conn, ns Namespace, l Link) (int, error) {
+	clicks, err := redis.Int(do(conn, "HGET", ns.key(statsPrefix+l.Short), "clicks"))
+	if err == redis.ErrNil {
+		return 0, nil
+	}
+
+	return clicks, err
+}
@@ -0,0 +1,142 @@
+package store_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yexelm/shorty/store"
+)
+
+func Test_Click(t *testing.T) {
+	ao := assert.New(t)
+	st := newStorage(t)
+
+	ns := store.Namespace{}
+	short, _, err := st.Shorter(ns, []byte("https://go.dev/invite"), store.Meta{MaxClicks: 3})
+	ao.NoError(err)
+	l, err := st.Longer(ns, short)
+	ao.NoError(err)
+	ao.Equal(3, l.MaxClicks)
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		resolved  int
+		exhausted int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := st.Click(ns, l, store.Visit{})
+			mu.Lock()
+			defer mu.Unlock()
+			switch err {
+			case nil:
+				resolved++
+			case store.ErrExhausted:
+				exhausted++
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	ao.Equal(3, resolved, "concurrent clicks must not exceed the limit")
+	ao.Equal(7, exhausted)
+
+	_, err = st.Longer(ns, short)
+	ao.Equal(store.ErrExhausted, err)
+
+	left := 0
+	stats, err := st.ClickStats(ns, short)
+	ao.NoError(err)
+	ao.Equal(store.ClickStats{Clicks: 3, MaxClicks: 3, ClicksLeft: &left}, stats)
+
+	more := 5
+	_, err = st.UpdateMeta(ns, short, store.MetaPatch{MaxClicks: &more})
+	ao.NoError(err)
+	l, err = st.Longer(ns, short)
+	ao.NoError(err, "raising the limit must make the link available again")
+	ao.NoError(st.Click(ns, l, store.Visit{}))
+
+	other, _, err := st.Shorter(ns, []byte("https://go.dev"), store.Meta{})
+	ao.NoError(err)
+	l, err = st.Longer(ns, other)
+	ao.NoError(err)
+	ao.NoError(st.Click(ns, l, store.Visit{Country: "SE"}))
+	ao.NoError(st.Click(ns, l, store.Visit{Country: "SE"}))
+	ao.NoError(st.Click(ns, l, store.Visit{Country: "GB"}))
+	ao.NoError(st.Click(ns, l, store.Visit{Variant: "b"}))
+	ao.NoError(st.Click(ns, l, store.Visit{Country: "SE", Variant: "a"}))
+	stats, err = st.ClickStats(ns, other)
+	ao.NoError(err)
+	ao.Equal(store.ClickStats{
+		Clicks:    5,
+		Countries: map[string]int{"SE": 3, "GB": 1},
+		Variants:  map[string]int{"a": 1, "b": 1},
+	}, stats,
+		"links without a limit must be counted too")
+
+	ao.NoError(st.Delete(ns, other))
+	_, err = st.ClickStats(ns, other)
+	ao.Equal(redis.ErrNil, err)
+}
+
+func Test_ShorterWithSettings(t *testing.T) {
+	ao := assert.New(t)
+	st := newStorage(t)
+
+	ns := store.Namespace{}
+	url := []byte("https://go.dev/invite")
+	first, _, err := st.Shorter(ns, url, store.Meta{MaxClicks: 1})
+	ao.NoError(err)
+	second, _, err := st.Shorter(ns, url, store.Meta{MaxClicks: 1})
+	ao.NoError(err)
+	ao.NotEqual(first, second, "every one-time link must get its own alias")
+
+	l, err := st.Longer(ns, first)
+	ao.NoError(err)
+	ao.NoError(st.Click(ns, l, store.Visit{}))
+	_, err = st.Longer(ns, first)
+	ao.Equal(store.ErrExhausted, err)
+	_, err = st.Longer(ns, second)
+	ao.NoError(err, "exhausting a link must not exhaust others of the same URL")
+
+	plain, _, err := st.Shorter(ns, url, store.Meta{Creator: "team"})
+	ao.NoError(err)
+	ao.NotContains([][]byte{first, second}, plain, "links with settings must not be returned to others")
+	l, err = st.Longer(ns, plain)
+	ao.NoError(err)
+	ao.Zero(l.MaxClicks)
+	again, _, err := st.Shorter(ns, url, store.Meta{})
+	ao.NoError(err)
+	ao.Equal(plain, again, "links without settings must be deduplicated")
+
+	notAfter := time.Date(2099, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, meta := range []store.Meta{
+		{NotAfter: &notAfter, FallbackURL: "https://go.dev"},
+		{Rules: []store.Rule{{Platform: "ios", URL: "https://apps.apple.com"}}},
+		{Variants: []store.Variant{{Name: "a", URL: "https://go.dev/a", Weight: 1}}},
+	} {
+		short, _, err := st.Shorter(ns, url, meta)
+		ao.NoError(err)
+		ao.NotEqual(plain, short)
+		l, err := st.Link(ns, short)
+		ao.NoError(err)
+		ao.Equal(meta, l.Meta, "settings must be saved instead of dropped")
+	}
+
+	one := 1
+	_, err = st.UpdateMeta(ns, plain, store.MetaPatch{MaxClicks: &one})
+	ao.NoError(err)
+	short, created, err := st.Shorter(ns, url, store.Meta{})
+	ao.NoError(err)
+	ao.True(created)
+	ao.NotEqual(plain, short, "a link given settings must not be returned to others")
+}
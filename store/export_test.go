@@ -0,0 +1,15 @@
+package store
+
+import "time"
+
+// SetNow replaces the clock of the Storage.
+func (s *Storage) SetNow(now func() time.Time) {
+	s.now = now
+}
+
+// SetMigrations replaces the migrations applied by the Storage.
+func (s *Storage) SetMigrations(m []Migration) {
+	s.migrations = m
+}
+
+var Decode = decode
@@ -0,0 +1,349 @@
+package store
+
+import (
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/yexelm/shorty/metrics"
+)
+
+// Kinds of problems found by CheckIntegrity.
+const (
+	// ProblemOrphanAlias is an alias in shortToLong whose URL is missing in longToShort. Links with settings are not
+	// deduplicated, so their aliases are not expected in longToShort and are never reported as orphan, mismatched
+	// or duplicate.
+	ProblemOrphanAlias = "orphan_alias"
+	// ProblemOrphanURL is a URL in longToShort whose alias is missing in shortToLong.
+	ProblemOrphanURL = "orphan_url"
+	// ProblemMismatchedAlias is an alias in shortToLong whose URL is matched to another alias in longToShort, which
+	// does not match the URL back.
+	ProblemMismatchedAlias = "mismatched_alias"
+	// ProblemDuplicateAlias is an alias in shortToLong whose URL is matched to another alias in longToShort, which
+	// matches the URL back, so the URL has two aliases.
+	ProblemDuplicateAlias = "duplicate_alias"
+	// ProblemMismatchedURL is a URL in longToShort whose alias is matched to another URL in shortToLong.
+	ProblemMismatchedURL = "mismatched_url"
+	// ProblemAliasAboveLastID is an alias decoding to an ID above lastID, which is going to be generated again.
+	ProblemAliasAboveLastID = "alias_above_last_id"
+	// ProblemInvalidAlias is an alias in shortToLong which cannot be generated.
+	ProblemInvalidAlias = "invalid_alias"
+)
+
+// Repairs of problems. shortToLong is what resolves links, so it is kept as is and longToShort, which only
+// deduplicates URLs, is made to match it.
+const (
+	// FixLinkURL matches the URL to the alias in longToShort.
+	FixLinkURL = "link_url"
+	// FixUnlinkURL removes the URL from longToShort, so that it gets a new alias when it is shortened next time.
+	FixUnlinkURL = "unlink_url"
+	// FixRaiseLastID raises lastID to the largest ID of the aliases, so that they are not generated again.
+	FixRaiseLastID = "raise_last_id"
+)
+
+// integrityBatch is the number of entries scanned at once while checking integrity.
+const integrityBatch = 1000
+
+// Problem is an inconsistency between longToShort, shortToLong and lastID.
+type Problem struct {
+	Kind  string `json:"kind"`
+	Short string `json:"short"`
+	Long  string `json:"long"`
+	// Other is the URL or the alias the other hash has instead, it is empty for orphans.
+	Other string `json:"other,omitempty"`
+	// Fix is the repair of the problem, problems without one cannot be repaired without breaking short URLs.
+	Fix string `json:"fix,omitempty"`
+	// Fixed reports whether the problem has been repaired. Problems whose entries have been changed since the check
+	// are skipped.
+	Fixed bool `json:"fixed,omitempty"`
+}
+
+// IntegrityReport lists the problems found in a namespace.
+type IntegrityReport struct {
+	// Aliases and URLs are the numbers of entries of shortToLong and longToShort scanned.
+	Aliases int `json:"aliases"`
+	URLs    int `json:"urls"`
+	// LastID is the value of lastID at the start of the check.
+	LastID   int       `json:"last_id"`
+	Problems []Problem `json:"problems"`
+}
+
+// CheckIntegrity scans both matches between aliases and URLs saved in the namespace and reports the entries which
+// do not match each other, along with aliases which are going to be generated again. Links modified during the scan
+// may be reported spuriously. If repair is true, the problems are repaired by their fixes afterwards; every fix
+// checks that the entry it changes is still the same.
+func (s *Storage) CheckIntegrity(ns Namespace, repair bool) (IntegrityReport, error) {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	lastID, err := redis.Int(do(conn, "GET", lastIDKey))
+	if err != nil && err != redis.ErrNil {
+		return IntegrityReport{}, err
+	}
+
+	r := IntegrityReport{LastID: lastID, Problems: []Problem{}}
+	seen := make(map[Problem]bool)
+	add := func(p Problem) {
+		if !seen[p] {
+			seen[p] = true
+			r.Problems = append(r.Problems, p)
+		}
+	}
+
+	// unmatched aliases are checked after the scan, so that Redis is not queried inside the callback
+	var unmatched []Problem
+	r.Aliases, err = scanPairs(conn, ns.key(shortToLong), ns.key(longToShort), func(short, long, other string, ok bool) {
+		switch id, err := decode([]byte(short)); {
+		case err != nil:
+			add(Problem{Kind: ProblemInvalidAlias, Short: short, Long: long})
+		case id > lastID:
+			add(Problem{Kind: ProblemAliasAboveLastID, Short: short, Long: long, Fix: FixRaiseLastID})
+		}
+
+		if !ok || other != short {
+			unmatched = append(unmatched, Problem{Short: short, Long: long, Other: other})
+		}
+	})
+	if err != nil {
+		return IntegrityReport{}, err
+	}
+
+	settled, err := withSettings(conn, ns, unmatched)
+	if err != nil {
+		return IntegrityReport{}, err
+	}
+	for _, p := range unmatched {
+		// links with settings are not deduplicated, so their URLs are matched to other aliases or to none
+		if settled[p.Short] {
+			continue
+		}
+		if p.Other == "" {
+			p.Kind, p.Fix = ProblemOrphanAlias, FixLinkURL
+			add(p)
+			continue
+		}
+
+		// mismatched aliases are told from duplicates by the alias the URL is matched to
+		back, err := redis.String(do(conn, "HGET", ns.key(shortToLong), p.Other))
+		if err != nil && err != redis.ErrNil {
+			return IntegrityReport{}, err
+		}
+
+		p.Kind, p.Fix = ProblemMismatchedAlias, FixLinkURL
+		if back == p.Long {
+			p.Kind, p.Fix = ProblemDuplicateAlias, ""
+		}
+		add(p)
+	}
+
+	r.URLs, err = scanPairs(conn, ns.key(longToShort), ns.key(shortToLong), func(long, short, other string, ok bool) {
+		switch {
+		case !ok:
+			add(Problem{Kind: ProblemOrphanURL, Short: short, Long: long, Fix: FixUnlinkURL})
+		case other != long:
+			add(Problem{Kind: ProblemMismatchedURL, Short: short, Long: long, Other: other, Fix: FixUnlinkURL})
+		}
+	})
+	if err != nil {
+		return IntegrityReport{}, err
+	}
+
+	for _, p := range r.Problems {
+		metrics.IntegrityProblems.WithLabelValues(p.Kind).Inc()
+	}
+
+	if repair {
+		if err := s.repair(conn, ns, r.Problems); err != nil {
+			return IntegrityReport{}, err
+		}
+	}
+
+	return r, nil
+}
+
+// repair applies the fixes of the problems in order, so that URLs are matched to the aliases of shortToLong before
+// the stale entries of longToShort are removed.
+func (s *Storage) repair(conn redis.Conn, ns Namespace, problems []Problem) error {
+	var (
+		maxID  int
+		raised []*Problem
+	)
+	for i := range problems {
+		p := &problems[i]
+
+		var err error
+		switch p.Fix {
+		case FixLinkURL:
+			p.Fixed, err = swapField(conn, ns.key(longToShort), p.Long, p.Other, p.Short)
+		case FixUnlinkURL:
+			p.Fixed, err = swapField(conn, ns.key(longToShort), p.Long, p.Short, "")
+		case FixRaiseLastID:
+			if id, _ := decode([]byte(p.Short)); id > maxID {
+				maxID = id
+			}
+			raised = append(raised, p)
+		}
+		if err != nil && err != ErrConflict {
+			return err
+		}
+	}
+
+	if maxID > 0 {
+		if err := s.raiseLastID(conn, maxID); err != nil {
+			return err
+		}
+		for _, p := range raised {
+			p.Fixed = true
+		}
+	}
+
+	return nil
+}
+
+// withSettings returns the aliases of the problems whose links have settings.
+func withSettings(conn redis.Conn, ns Namespace, problems []Problem) (map[string]bool, error) {
+	settled := make(map[string]bool)
+	for i := 0; i < len(problems); i += integrityBatch {
+		batch := problems[i:]
+		if len(batch) > integrityBatch {
+			batch = batch[:integrityBatch]
+		}
+
+		shorts := make([]string, len(batch))
+		for j, p := range batch {
+			shorts[j] = p.Short
+		}
+		links, err := loadLinks(conn, ns, shorts)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range links {
+			if l.hasSettings() {
+				settled[l.Short] = true
+			}
+		}
+	}
+
+	return settled, nil
+}
+
+// swapField sets the field of the hash to the new value, or deletes it if the new value is empty, provided that its
+// current value is the old one, empty meaning missing. It reports whether the field has been changed.
+func swapField(conn redis.Conn, key, field, old, new string) (bool, error) {
+	for i := 0; i < updateAttempts; i++ {
+		if _, err := do(conn, "WATCH", key); err != nil {
+			return false, err
+		}
+
+		current, err := redis.String(do(conn, "HGET", key, field))
+		if err != nil && err != redis.ErrNil {
+			_, _ = do(conn, "UNWATCH")
+			return false, err
+		}
+		if current != old {
+			_, _ = do(conn, "UNWATCH")
+			return false, nil
+		}
+
+		if _, err := do(conn, "MULTI"); err != nil {
+			return false, err
+		}
+		if new == "" {
+			_, _ = do(conn, "HDEL", key, field)
+		} else {
+			_, _ = do(conn, "HSET", key, field, new)
+		}
+
+		reply, err := do(conn, "EXEC")
+		if err != nil {
+			return false, err
+		}
+		// EXEC replies with nil if the watched key has been modified
+		if reply != nil {
+			return true, nil
+		}
+	}
+
+	return false, ErrConflict
+}
+
+// raiseLastID raises lastID in Redis and the ID generator of the Storage to at least the given ID. Other instances
+// keep generating IDs from their own last ID until they are restarted.
+func (s *Storage) raiseLastID(conn redis.Conn, id int) error {
+	for i := 0; ; i++ {
+		if i == updateAttempts {
+			return ErrConflict
+		}
+
+		if _, err := do(conn, "WATCH", lastIDKey); err != nil {
+			return err
+		}
+		current, err := redis.Int(do(conn, "GET", lastIDKey))
+		if err != nil && err != redis.ErrNil {
+			_, _ = do(conn, "UNWATCH")
+			return err
+		}
+		if current >= id {
+			_, _ = do(conn, "UNWATCH")
+			break
+		}
+
+		if _, err := do(conn, "MULTI"); err != nil {
+			return err
+		}
+		_, _ = do(conn, "SET", lastIDKey, id)
+		reply, err := do(conn, "EXEC")
+		if err != nil {
+			return err
+		}
+		if reply != nil {
+			break
+		}
+	}
+
+	select {
+	case s.raise <- id:
+		return nil
+	case <-s.done:
+		return ErrClosed
+	}
+}
+
+// scanPairs calls fn for every field of the hash with its value and the value of the reverse hash at the value,
+// ok is false if the reverse hash has no such field. It returns the number of scanned fields.
+func scanPairs(conn redis.Conn, hash, reverse string, fn func(field, value, other string, ok bool)) (int, error) {
+	var (
+		cursor  uint64
+		scanned int
+	)
+	for {
+		reply, err := redis.Values(do(conn, "HSCAN", hash, cursor, "COUNT", integrityBatch))
+		if err != nil {
+			return 0, err
+		}
+
+		var pairs []string
+		if _, err := redis.Scan(reply, &cursor, &pairs); err != nil {
+			return 0, err
+		}
+
+		if len(pairs) > 0 {
+			args := []interface{}{reverse}
+			for i := 1; i < len(pairs); i += 2 {
+				args = append(args, pairs[i])
+			}
+			others, err := redis.Values(do(conn, "HMGET", args...))
+			if err != nil {
+				return 0, err
+			}
+
+			for i := 0; i+1 < len(pairs); i += 2 {
+				other, err := redis.String(others[i/2], nil)
+				fn(pairs[i], pairs[i+1], other, err == nil)
+			}
+			scanned += len(pairs) / 2
+		}
+
+		if cursor == 0 {
+			return scanned, nil
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package store_test
+
+import (
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yexelm/shorty/store"
+)
+
+func Test_CheckIntegrity(t *testing.T) {
+	ao := assert.New(t)
+	st := newStorage(t)
+	ns := store.Namespace{Tenant: "acme"}
+
+	for _, l := range []string{"https://go.dev", "https://ya.ru", "https://golang.org", "https://example.org"} {
+		_, _, err := st.Shorter(ns, []byte(l), store.Meta{})
+		ao.NoError(err)
+	}
+	// links with settings are not deduplicated, so their URLs are matched to other aliases
+	limited, _, err := st.Shorter(ns, []byte("https://go.dev"), store.Meta{MaxClicks: 1})
+	ao.NoError(err)
+
+	r, err := st.CheckIntegrity(ns, false)
+	ao.NoError(err)
+	ao.Equal(store.IntegrityReport{Aliases: 5, URLs: 4, LastID: 5, Problems: []store.Problem{}}, r)
+
+	conn := st.Pool.Get()
+	defer conn.Close()
+
+	// deleting them keeps the deduplicated link
+	ao.NoError(st.Delete(ns, limited))
+	alias, err := redis.String(conn.Do("HGET", "tenant:acme:longToShort", "https://go.dev"))
+	ao.NoError(err)
+	ao.Equal("b", alias)
+
+	for _, cmd := range [][]interface{}{
+		// the URL of c lost its alias
+		{"HDEL", "tenant:acme:longToShort", "https://ya.ru"},
+		// the alias d of https://golang.org points to another URL
+		{"HSET", "tenant:acme:shortToLong", "d", "https://example.com"},
+		// the URL of b is matched to a missing alias
+		{"HSET", "tenant:acme:longToShort", "https://go.dev", "z"},
+		// the URL of e has another alias
+		{"HSET", "tenant:acme:shortToLong", "ba", "https://example.org"},
+		// the alias is going to be generated again
+		{"HSET", "tenant:acme:shortToLong", "9", "https://nine.dev"},
+		{"HSET", "tenant:acme:longToShort", "https://nine.dev", "9"},
+		// the alias cannot be generated at all
+		{"HSET", "tenant:acme:shortToLong", "-", "https://dash.dev"},
+		{"HSET", "tenant:acme:longToShort", "https://dash.dev", "-"},
+	} {
+		_, err := conn.Do(cmd[0].(string), cmd[1:]...)
+		ao.NoError(err)
+	}
+
+	problems := []store.Problem{
+		{Kind: store.ProblemOrphanAlias, Short: "c", Long: "https://ya.ru", Fix: store.FixLinkURL},
+		{Kind: store.ProblemOrphanAlias, Short: "d", Long: "https://example.com", Fix: store.FixLinkURL},
+		{Kind: store.ProblemMismatchedAlias, Short: "b", Long: "https://go.dev", Other: "z", Fix: store.FixLinkURL},
+		{Kind: store.ProblemDuplicateAlias, Short: "ba", Long: "https://example.org", Other: "e"},
+		{Kind: store.ProblemAliasAboveLastID, Short: "9", Long: "https://nine.dev", Fix: store.FixRaiseLastID},
+		{Kind: store.ProblemInvalidAlias, Short: "-", Long: "https://dash.dev"},
+		{Kind: store.ProblemOrphanURL, Short: "z", Long: "https://go.dev", Fix: store.FixUnlinkURL},
+		{Kind: store.ProblemMismatchedURL, Short: "d", Long: "https://golang.org", Other: "https://example.com",
+			Fix: store.FixUnlinkURL},
+	}
+
+	r, err = st.CheckIntegrity(ns, false)
+	ao.NoError(err)
+	ao.Equal(7, r.Aliases)
+	ao.Equal(5, r.URLs)
+	ao.ElementsMatch(problems, r.Problems)
+
+	r, err = st.CheckIntegrity(ns, true)
+	ao.NoError(err)
+	fixed := make(map[string]bool)
+	for _, p := range r.Problems {
+		fixed[p.Kind] = p.Fixed
+	}
+	ao.Equal(map[string]bool{
+		store.ProblemOrphanAlias:      true,
+		store.ProblemMismatchedAlias:  true,
+		store.ProblemDuplicateAlias:   false,
+		store.ProblemAliasAboveLastID: true,
+		store.ProblemInvalidAlias:     false,
+		// the URL has been matched to its alias already
+		store.ProblemOrphanURL:     false,
+		store.ProblemMismatchedURL: true,
+	}, fixed)
+
+	links, err := redis.StringMap(conn.Do("HGETALL", "tenant:acme:longToShort"))
+	ao.NoError(err)
+	ao.Equal(map[string]string{
+		"https://go.dev":      "b",
+		"https://ya.ru":       "c",
+		"https://example.com": "d",
+		"https://example.org": "e",
+		"https://nine.dev":    "9",
+		"https://dash.dev":    "-",
+	}, links)
+
+	r, err = st.CheckIntegrity(ns, false)
+	ao.NoError(err)
+	ao.Equal(61, r.LastID)
+	ao.ElementsMatch([]store.Problem{problems[3], problems[5]}, r.Problems)
+
+	// the generator continues after the raised ID
+	short, _, err := st.Shorter(ns, []byte("https://new.dev"), store.Meta{})
+	ao.NoError(err)
+	ao.Equal("ab", string(short))
+
+	r, err = st.CheckIntegrity(store.Namespace{}, true)
+	ao.NoError(err)
+	ao.Empty(r.Problems)
+}
@@ -0,0 +1,125 @@
+package store
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// apiKeys matches hashes of API keys to their owners.
+	apiKeys = "apiKeys"
+	// apiKeyTenants matches hashes of API keys to their tenants, keys of the default namespace are not listed.
+	apiKeyTenants = "apiKeyTenants"
+	// apiKeyOwners matches owners to hashes of their current API keys within the namespace of a tenant.
+	apiKeyOwners = "apiKeyOwners"
+)
+
+// Principal is the owner of an API key along with the tenant the key belongs to.
+type Principal struct {
+	Tenant string
+	Owner  string
+}
+
+// Namespace returns the namespace of the default host of the principal's tenant.
+func (p Principal) Namespace() Namespace {
+	return Namespace{Tenant: p.Tenant}
+}
+
+// IssueKey generates a new API key for the owner within the tenant, revoking the previous one if any. Only the hash
+// of the key is saved, so the returned key cannot be retrieved later.
+func (s *Storage) IssueKey(tenant, owner string) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	key := hex.EncodeToString(raw)
+
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	ns := Namespace{Tenant: tenant}
+	if tenant != "" {
+		if _, err := loadTenant(conn, tenant); err != nil {
+			return "", err
+		}
+	}
+
+	old, err := redis.String(do(conn, "HGET", ns.key(apiKeyOwners), owner))
+	if err != nil && err != redis.ErrNil {
+		return "", err
+	}
+
+	if _, err := do(conn, "MULTI"); err != nil {
+		return "", err
+	}
+	if old != "" {
+		_, _ = do(conn, "HDEL", apiKeys, old)
+		_, _ = do(conn, "HDEL", apiKeyTenants, old)
+	}
+	_, _ = do(conn, "HSET", apiKeys, hashKey(key), owner)
+	if ns.Tenant != "" {
+		_, _ = do(conn, "HSET", apiKeyTenants, hashKey(key), ns.Tenant)
+	}
+	_, _ = do(conn, "HSET", ns.key(apiKeyOwners), owner, hashKey(key))
+	if _, err := do(conn, "EXEC"); err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+// RevokeKey revokes the current API key of the owner within the tenant.
+func (s *Storage) RevokeKey(tenant, owner string) error {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	ns := Namespace{Tenant: tenant}
+	old, err := redis.String(do(conn, "HGET", ns.key(apiKeyOwners), owner))
+	if err != nil {
+		return err
+	}
+
+	if _, err := do(conn, "MULTI"); err != nil {
+		return err
+	}
+	_, _ = do(conn, "HDEL", apiKeys, old)
+	_, _ = do(conn, "HDEL", apiKeyTenants, old)
+	_, _ = do(conn, "HDEL", ns.key(apiKeyOwners), owner)
+	_, err = do(conn, "EXEC")
+
+	return err
+}
+
+// Authenticate returns the principal of the given API key or redis.ErrNil if the key is unknown.
+func (s *Storage) Authenticate(key string) (Principal, error) {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	hash := hashKey(key)
+	replies, err := pipeline(conn, []command{
+		{"HGET", []interface{}{apiKeys, hash}},
+		{"HGET", []interface{}{apiKeyTenants, hash}},
+	})
+	if err != nil {
+		return Principal{}, err
+	}
+
+	owner, err := redis.String(replies[0], nil)
+	if err != nil {
+		return Principal{}, err
+	}
+	tenant, err := redis.String(replies[1], nil)
+	if err != nil && err != redis.ErrNil {
+		return Principal{}, err
+	}
+
+	return Principal{Tenant: tenant, Owner: owner}, nil
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
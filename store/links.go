@@ -0,0 +1,718 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// linkPrefix prefixes keys of hashes keeping the record of each link.
+	linkPrefix = "link:"
+
+	// indexAll orders all links by their IDs.
+	indexAll = "idx:links"
+	// indexCreated orders all links by their creation time in microseconds.
+	indexCreated = "idx:created"
+	// indexCreatorPrefix, indexDomainPrefix prefix indexes of links by the creator and by the domain of the original
+	// URL, ordered by IDs.
+	indexCreatorPrefix = "idx:creator:"
+	indexDomainPrefix  = "idx:domain:"
+	indexTagPrefix     = "idx:tag:"
+
+	// updateAttempts limits retries of optimistic updates of link records modified concurrently.
+	updateAttempts = 5
+
+	// searchBatch is the number of candidates loaded at once while searching.
+	searchBatch = 100
+	// searchScanFactor limits the number of scanned candidates per requested link, so that a very selective search
+	// returns a partial page with a cursor instead of scanning all links at once.
+	searchScanFactor = 20
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrConflict      = errors.New("the link is being modified concurrently, try again")
+	ErrNotActive     = errors.New("the link is not active yet")
+	ErrExpired       = errors.New("the link is no longer active")
+)
+
+// Meta contains details of a link provided on its creation. Everything but the creator can be edited later.
+type Meta struct {
+	Creator     string            `json:"creator,omitempty"`
+	Title       string            `json:"title,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Notes       string            `json:"notes,omitempty"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	// MaxClicks limits the number of resolutions of the link, zero means no limit.
+	MaxClicks int `json:"max_clicks,omitempty"`
+	// NotBefore and NotAfter limit the time the link is active, FallbackURL is resolved instead outside of it.
+	NotBefore   *time.Time `json:"not_before,omitempty"`
+	NotAfter    *time.Time `json:"not_after,omitempty"`
+	FallbackURL string     `json:"fallback_url,omitempty"`
+	// Rules send visitors matching them to their own destinations, the first matching rule wins and the original URL
+	// is the destination of visitors not matching any rule.
+	Rules []Rule `json:"rules,omitempty"`
+	// Variants split visitors not matching any rule between several destinations by weight.
+	Variants []Variant `json:"variants,omitempty"`
+	// ForwardQuery merges the query of the short URL into the destination, its parameters override the ones of the
+	// destination with the same names.
+	ForwardQuery bool `json:"forward_query,omitempty"`
+	// Prefix makes the link resolve paths below the alias, the rest of the path is appended to the destination.
+	Prefix bool `json:"prefix,omitempty"`
+	// UTM lists UTM parameters without the utm_ prefix added to the destination unless it already has them.
+	UTM map[string]string `json:"utm,omitempty"`
+	// Preview shows visitors a page with the destination instead of sending them there right away.
+	Preview bool `json:"preview,omitempty"`
+	// OpenGraph is metadata of the destination shown by chat apps and social networks unfurling the short URL.
+	OpenGraph *OpenGraph `json:"open_graph,omitempty"`
+	// PasswordHash protects the link with a password, it is never exposed.
+	PasswordHash string `json:"-"`
+}
+
+// MetaPatch describes changes of Meta. Nil fields are left unchanged, attributes with nil values are removed.
+type MetaPatch struct {
+	Title       *string            `json:"title"`
+	Description *string            `json:"description"`
+	Tags        *[]string          `json:"tags"`
+	Notes       *string            `json:"notes"`
+	Attributes  map[string]*string `json:"attributes"`
+	MaxClicks   *int               `json:"max_clicks"`
+	// NotBefore and NotAfter replace the bounds of the time the link is active, zero times remove them.
+	NotBefore    *time.Time `json:"-"`
+	NotAfter     *time.Time `json:"-"`
+	FallbackURL  *string    `json:"fallback_url"`
+	Rules        *[]Rule    `json:"rules"`
+	Variants     *[]Variant `json:"variants"`
+	ForwardQuery *bool      `json:"forward_query"`
+	Prefix       *bool      `json:"prefix"`
+	// UTM replaces all UTM parameters of the link, an empty map removes them.
+	UTM     *map[string]string `json:"utm"`
+	Preview *bool              `json:"preview"`
+	// PasswordHash replaces the password of the link, an empty hash removes it.
+	PasswordHash *string `json:"-"`
+}
+
+// Rule routes visitors matching all of its conditions to its URL. Empty conditions match everybody.
+type Rule struct {
+	// Platform is the operating system of the visitor detected by the User-Agent: ios, android, windows, macos or
+	// linux, or mobile and desktop for groups of them.
+	Platform string `json:"platform,omitempty"`
+	// Language is the preferred language of the visitor in the Accept-Language header. Primary tags such as en match
+	// regional ones such as en-GB too.
+	Language string `json:"language,omitempty"`
+	// Country is the ISO 3166 code of the country of the visitor detected by the GeoIP database.
+	Country string `json:"country,omitempty"`
+	// Query lists parameters of the request query with their values, empty values only require the parameter.
+	Query map[string]string `json:"query,omitempty"`
+	URL   string            `json:"url"`
+}
+
+// Variant is one of the destinations of a link rotating between several ones. Visitors are assigned to variants at
+// random in proportion to their weights, a zero weight stops assigning new visitors to the variant.
+type Variant struct {
+	// Name identifies the variant in click stats and sticky assignments, so it survives changes of the weights.
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// OpenGraph is metadata of a web page fetched from its title, description and Open Graph tags.
+type OpenGraph struct {
+	Title       string    `json:"title,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Image       string    `json:"image,omitempty"`
+	FetchedAt   time.Time `json:"fetched_at"`
+}
+
+// Link is a saved match between a short alias and the original URL.
+type Link struct {
+	Short     string    `json:"short"`
+	Long      string    `json:"long"`
+	Disabled  bool      `json:"disabled"`
+	CreatedAt time.Time `json:"created_at"`
+	// Protected reports whether the link requires a password.
+	Protected bool `json:"protected,omitempty"`
+	Meta
+}
+
+// Query filters and paginates links. Zero fields do not filter anything.
+type Query struct {
+	Creator string
+	Tag     string
+	Domain  string
+	From    time.Time
+	To      time.Time
+	// Search is a substring of the original URL.
+	Search string
+
+	// Cursor is the cursor returned along with the previous page.
+	Cursor string
+	Count  int
+}
+
+// Search returns links of the namespace matching the query from newest to oldest, along with the cursor of the next
+// page, which is empty after the last page. A page may contain fewer links than requested even if it is not the last
+// one.
+func (s *Storage) Search(ns Namespace, q Query) ([]Link, string, error) {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	links := make([]Link, 0, q.Count)
+
+	// IDs start at 1, so [lo, hi] covers all links by default
+	lo, hi := 1, math.MaxInt64
+	if q.Cursor != "" {
+		id, err := decode([]byte(q.Cursor))
+		if err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+		hi = id - 1
+	}
+
+	if !q.From.IsZero() {
+		id, ok, err := createdBound(conn, ns, "ZRANGEBYSCORE", micros(q.From), "+inf")
+		if err != nil {
+			return nil, "", err
+		}
+		if !ok {
+			return links, "", nil
+		}
+		lo = id
+	}
+
+	if !q.To.IsZero() {
+		id, ok, err := createdBound(conn, ns, "ZREVRANGEBYSCORE", micros(q.To), "-inf")
+		if err != nil {
+			return nil, "", err
+		}
+		if !ok {
+			return links, "", nil
+		}
+		if id < hi {
+			hi = id
+		}
+	}
+
+	index := ns.key(indexAll)
+	switch {
+	case q.Creator != "":
+		index = ns.key(indexCreatorPrefix + q.Creator)
+	case q.Tag != "":
+		index = ns.key(indexTagPrefix + normalizeTag(q.Tag))
+	case q.Domain != "":
+		index = ns.key(indexDomainPrefix + strings.ToLower(q.Domain))
+	}
+
+	for scanned := 0; scanned < q.Count*searchScanFactor && lo <= hi; {
+		shorts, err := redis.Strings(do(conn, "ZREVRANGEBYSCORE", index, hi, lo, "LIMIT", 0, searchBatch))
+		if err != nil {
+			return nil, "", err
+		}
+
+		batch, err := loadLinks(conn, ns, shorts)
+		if err != nil {
+			return nil, "", err
+		}
+
+		for _, l := range batch {
+			scanned++
+			if q.matches(l) {
+				links = append(links, l)
+			}
+			if len(links) == q.Count || scanned == q.Count*searchScanFactor {
+				return links, l.Short, nil
+			}
+		}
+
+		if len(shorts) < searchBatch {
+			return links, "", nil
+		}
+
+		id, err := decode([]byte(shorts[len(shorts)-1]))
+		if err != nil {
+			return nil, "", err
+		}
+		hi = id - 1
+	}
+
+	return links, "", nil
+}
+
+// matches checks the filters which might not be covered by the index the link was found in.
+func (q Query) matches(l Link) bool {
+	switch {
+	case q.Creator != "" && l.Creator != q.Creator:
+		return false
+	case q.Tag != "" && !l.hasTag(normalizeTag(q.Tag)):
+		return false
+	case q.Domain != "" && destinationDomain([]byte(l.Long)) != strings.ToLower(q.Domain):
+		return false
+	case !q.From.IsZero() && l.CreatedAt.Before(q.From):
+		return false
+	case !q.To.IsZero() && l.CreatedAt.After(q.To):
+		return false
+	case q.Search != "" && !strings.Contains(l.Long, q.Search):
+		return false
+	}
+
+	return true
+}
+
+// createdBound returns the ID of the first link found in the creation time index by the given range command.
+func createdBound(conn redis.Conn, ns Namespace, cmd string, from int64, to string) (int, bool, error) {
+	shorts, err := redis.Strings(do(conn, cmd, ns.key(indexCreated), from, to, "LIMIT", 0, 1))
+	if err != nil || len(shorts) == 0 {
+		return 0, false, err
+	}
+
+	id, err := decode([]byte(shorts[0]))
+	if err != nil {
+		return 0, false, err
+	}
+
+	return id, true, nil
+}
+
+// loadLinks loads the original URLs and the records of the given short aliases. Aliases deleted in the meantime
+// are skipped.
+func loadLinks(conn redis.Conn, ns Namespace, shorts []string) ([]Link, error) {
+	cmds := make([]command, 0, len(shorts)*3)
+	for _, short := range shorts {
+		cmds = append(cmds,
+			command{"HGET", []interface{}{ns.key(shortToLong), short}},
+			command{"SISMEMBER", []interface{}{ns.key(disabledKey), short}},
+			command{"HGETALL", []interface{}{ns.key(linkPrefix + short)}},
+		)
+	}
+
+	replies, err := pipeline(conn, cmds)
+	if err != nil {
+		return nil, err
+	}
+
+	links := make([]Link, 0, len(shorts))
+	for i, short := range shorts {
+		long, err := redis.String(replies[i*3], nil)
+		if err == redis.ErrNil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		disabled, err := redis.Bool(replies[i*3+1], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		record, err := redis.StringMap(replies[i*3+2], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		l := Link{Short: short, Long: long, Disabled: disabled}
+		l.fromRecord(record)
+		links = append(links, l)
+	}
+
+	return links, nil
+}
+
+// Link returns the link saved in the namespace by its short alias.
+func (s *Storage) Link(ns Namespace, short []byte) (Link, error) {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	links, err := loadLinks(conn, ns, []string{string(short)})
+	if err != nil {
+		return Link{}, err
+	}
+	if len(links) == 0 {
+		return Link{}, redis.ErrNil
+	}
+
+	return links[0], nil
+}
+
+// UpdateMeta applies the patch to the details of the link and returns the updated link. Tag indexes are updated
+// atomically with the record. Links given settings stop being shared, so their URL is no longer matched to them.
+func (s *Storage) UpdateMeta(ns Namespace, short []byte, patch MetaPatch) (Link, error) {
+	id, err := decode(short)
+	if err != nil {
+		return Link{}, err
+	}
+
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	var updated Link
+	err = modifyLink(conn, ns, short, func(old Link) {
+		updated = old
+		updated.Meta = patch.apply(old.Meta)
+		updated.Protected = updated.PasswordHash != ""
+
+		queueRecord(conn, ns, updated)
+		if updated.hasSettings() && !old.hasSettings() {
+			_, _ = do(conn, "EVAL", unlinkURLScript, 1, ns.key(longToShort), updated.Long, updated.Short)
+		}
+		for _, index := range old.indexes(ns) {
+			_, _ = do(conn, "ZREM", index, updated.Short)
+		}
+		for _, index := range updated.indexes(ns) {
+			_, _ = do(conn, "ZADD", index, id, updated.Short)
+		}
+	})
+	if err != nil {
+		return Link{}, err
+	}
+
+	return updated, nil
+}
+
+// SetOpenGraph saves metadata of the destination of the link. redis.ErrNil is returned if the link does not exist.
+func (s *Storage) SetOpenGraph(ns Namespace, short []byte, og OpenGraph) error {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	return modifyLink(conn, ns, short, func(l Link) {
+		l.OpenGraph = &og
+		queueRecord(conn, ns, l)
+	})
+}
+
+// modifyLink loads the link and calls queue inside MULTI to queue commands modifying it, then executes them. The
+// transaction is retried if the link record or the matches of aliases are modified concurrently, so that a deleted
+// link is not saved again, and ErrConflict is returned if it keeps colliding. redis.ErrNil is returned if the link
+// does not exist.
+func modifyLink(conn redis.Conn, ns Namespace, short []byte, queue func(l Link)) error {
+	for i := 0; i < updateAttempts; i++ {
+		if _, err := do(conn, "WATCH", ns.key(linkPrefix+string(short)), ns.key(shortToLong)); err != nil {
+			return err
+		}
+
+		links, err := loadLinks(conn, ns, []string{string(short)})
+		if err != nil || len(links) == 0 {
+			_, _ = do(conn, "UNWATCH")
+			if err == nil {
+				err = redis.ErrNil
+			}
+			return err
+		}
+
+		if _, err := do(conn, "MULTI"); err != nil {
+			return err
+		}
+		queue(links[0])
+
+		reply, err := do(conn, "EXEC")
+		if err != nil {
+			return err
+		}
+		// EXEC replies with nil if the watched key has been modified
+		if reply != nil {
+			return nil
+		}
+	}
+
+	return ErrConflict
+}
+
+// hasSettings reports whether the details change how the link resolves, so that it cannot be shared by everybody
+// shortening its URL.
+func (m Meta) hasSettings() bool {
+	return m.PasswordHash != "" || m.MaxClicks > 0 || m.NotBefore != nil || m.NotAfter != nil || m.FallbackURL != "" ||
+		len(m.Rules) > 0 || len(m.Variants) > 0 || m.ForwardQuery || m.Prefix || len(m.UTM) > 0 || m.Preview
+}
+
+// apply returns a copy of m with the patch applied.
+func (p MetaPatch) apply(m Meta) Meta {
+	if p.Title != nil {
+		m.Title = *p.Title
+	}
+	if p.Description != nil {
+		m.Description = *p.Description
+	}
+	if p.Tags != nil {
+		m.Tags = normalizeTags(*p.Tags)
+	}
+	if p.Notes != nil {
+		m.Notes = *p.Notes
+	}
+	if p.MaxClicks != nil {
+		m.MaxClicks = *p.MaxClicks
+	}
+	if p.NotBefore != nil {
+		m.NotBefore = optionalTime(*p.NotBefore)
+	}
+	if p.NotAfter != nil {
+		m.NotAfter = optionalTime(*p.NotAfter)
+	}
+	if p.FallbackURL != nil {
+		m.FallbackURL = *p.FallbackURL
+	}
+	if p.Rules != nil {
+		m.Rules = *p.Rules
+	}
+	if p.Variants != nil {
+		m.Variants = *p.Variants
+	}
+	if p.ForwardQuery != nil {
+		m.ForwardQuery = *p.ForwardQuery
+	}
+	if p.Prefix != nil {
+		m.Prefix = *p.Prefix
+	}
+	if p.UTM != nil {
+		m.UTM = *p.UTM
+	}
+	if p.Preview != nil {
+		m.Preview = *p.Preview
+	}
+	if p.PasswordHash != nil {
+		m.PasswordHash = *p.PasswordHash
+	}
+
+	if len(p.Attributes) > 0 {
+		attrs := make(map[string]string, len(m.Attributes)+len(p.Attributes))
+		for k, v := range m.Attributes {
+			attrs[k] = v
+		}
+		for k, v := range p.Attributes {
+			if v == nil {
+				delete(attrs, k)
+				continue
+			}
+			attrs[k] = *v
+		}
+		m.Attributes = attrs
+	}
+
+	return m
+}
+
+// queueRecord queues commands replacing the record of the link inside a transaction.
+func queueRecord(conn redis.Conn, ns Namespace, l Link) {
+	_, _ = do(conn, "DEL", ns.key(linkPrefix+l.Short))
+	if fields := l.record(); len(fields) > 0 {
+		_, _ = do(conn, "HSET", append([]interface{}{ns.key(linkPrefix + l.Short)}, fields...)...)
+	}
+}
+
+// record returns fields of the hash keeping the record of the link. Empty fields are not saved.
+func (l Link) record() []interface{} {
+	var fields []interface{}
+	if !l.CreatedAt.IsZero() {
+		fields = append(fields, "created_at", l.CreatedAt.UnixNano())
+	}
+	if l.MaxClicks > 0 {
+		fields = append(fields, "max_clicks", l.MaxClicks)
+	}
+	if l.NotBefore != nil {
+		fields = append(fields, "not_before", l.NotBefore.UnixNano())
+	}
+	if l.NotAfter != nil {
+		fields = append(fields, "not_after", l.NotAfter.UnixNano())
+	}
+	if l.ForwardQuery {
+		fields = append(fields, "forward_query", 1)
+	}
+	if l.Prefix {
+		fields = append(fields, "prefix", 1)
+	}
+	if l.Preview {
+		fields = append(fields, "preview", 1)
+	}
+
+	for _, f := range []struct{ name, value string }{
+		{"creator", l.Creator},
+		{"title", l.Title},
+		{"description", l.Description},
+		{"notes", l.Notes},
+		{"fallback_url", l.FallbackURL},
+		{"password", l.PasswordHash},
+	} {
+		if f.value != "" {
+			fields = append(fields, f.name, f.value)
+		}
+	}
+
+	if len(l.Tags) > 0 {
+		// the error is always nil for slices of strings
+		tags, _ := json.Marshal(l.Tags)
+		fields = append(fields, "tags", tags)
+	}
+	if len(l.Attributes) > 0 {
+		// the error is always nil for maps of strings
+		attrs, _ := json.Marshal(l.Attributes)
+		fields = append(fields, "attributes", attrs)
+	}
+	if len(l.Rules) > 0 {
+		// the error is always nil for rules made of strings
+		rules, _ := json.Marshal(l.Rules)
+		fields = append(fields, "rules", rules)
+	}
+	if len(l.Variants) > 0 {
+		// the error is always nil for variants made of strings and numbers
+		variants, _ := json.Marshal(l.Variants)
+		fields = append(fields, "variants", variants)
+	}
+	if l.OpenGraph != nil {
+		// the error is always nil for metadata made of strings and times
+		og, _ := json.Marshal(l.OpenGraph)
+		fields = append(fields, "open_graph", og)
+	}
+	if len(l.UTM) > 0 {
+		// the error is always nil for maps of strings
+		utm, _ := json.Marshal(l.UTM)
+		fields = append(fields, "utm", utm)
+	}
+
+	return fields
+}
+
+func (l *Link) fromRecord(record map[string]string) {
+	if ns, err := strconv.ParseInt(record["created_at"], 10, 64); err == nil {
+		l.CreatedAt = time.Unix(0, ns).UTC()
+	}
+	l.Creator = record["creator"]
+	l.Title = record["title"]
+	l.Description = record["description"]
+	l.Notes = record["notes"]
+	l.MaxClicks, _ = strconv.Atoi(record["max_clicks"])
+	l.NotBefore = recordTime(record["not_before"])
+	l.NotAfter = recordTime(record["not_after"])
+	l.FallbackURL = record["fallback_url"]
+	l.PasswordHash = record["password"]
+	l.Protected = l.PasswordHash != ""
+	l.ForwardQuery = record["forward_query"] == "1"
+	l.Prefix = record["prefix"] == "1"
+	l.Preview = record["preview"] == "1"
+
+	if tags, ok := record["tags"]; ok {
+		_ = json.Unmarshal([]byte(tags), &l.Tags)
+	}
+	if attrs, ok := record["attributes"]; ok {
+		_ = json.Unmarshal([]byte(attrs), &l.Attributes)
+	}
+	if rules, ok := record["rules"]; ok {
+		_ = json.Unmarshal([]byte(rules), &l.Rules)
+	}
+	if variants, ok := record["variants"]; ok {
+		_ = json.Unmarshal([]byte(variants), &l.Variants)
+	}
+	if utm, ok := record["utm"]; ok {
+		_ = json.Unmarshal([]byte(utm), &l.UTM)
+	}
+	if og, ok := record["open_graph"]; ok {
+		_ = json.Unmarshal([]byte(og), &l.OpenGraph)
+	}
+}
+
+// ActiveAt returns ErrNotActive before the link becomes active and ErrExpired after it stops being active.
+func (l Link) ActiveAt(t time.Time) error {
+	switch {
+	case l.NotBefore != nil && t.Before(*l.NotBefore):
+		return ErrNotActive
+	case l.NotAfter != nil && t.After(*l.NotAfter):
+		return ErrExpired
+	default:
+		return nil
+	}
+}
+
+// optionalTime returns nil for the zero time.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+
+	return &t
+}
+
+// recordTime parses the time saved in the record in nanoseconds.
+func recordTime(v string) *time.Time {
+	ns, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return nil
+	}
+
+	return optionalTime(time.Unix(0, ns))
+}
+
+// indexes returns keys of the indexes ordered by IDs which the link belongs to in the namespace.
+func (l Link) indexes(ns Namespace) []string {
+	keys := []string{ns.key(indexAll)}
+	if l.Creator != "" {
+		keys = append(keys, ns.key(indexCreatorPrefix+l.Creator))
+	}
+	if d := destinationDomain([]byte(l.Long)); d != "" {
+		keys = append(keys, ns.key(indexDomainPrefix+d))
+	}
+	for _, tag := range l.Tags {
+		keys = append(keys, ns.key(indexTagPrefix+tag))
+	}
+
+	return keys
+}
+
+func (l Link) hasTag(tag string) bool {
+	for _, t := range l.Tags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizeTags returns lowercase tags without surrounding spaces, empty tags and duplicates.
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, t := range tags {
+		t = normalizeTag(t)
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		normalized = append(normalized, t)
+	}
+
+	if len(normalized) == 0 {
+		return nil
+	}
+
+	return normalized
+}
+
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// destinationDomain returns the lowercase host name of the URL, which may be given without a scheme.
+func destinationDomain(longURL []byte) string {
+	s := string(longURL)
+	if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(u.Hostname())
+}
+
+func micros(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Microsecond)
+}
@@ -0,0 +1,404 @@
+package store_test
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yexelm/shorty/store"
+)
+
+func Test_Decode(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+
+	for _, short := range []string{"b", "c", "9", "ab", "Zz9"} {
+		id, err := store.Decode([]byte(short))
+		ao.NoError(err)
+		ao.NotZero(id)
+	}
+
+	ao.Equal(1, mustDecode(t, "b"))
+	ao.Equal(62, mustDecode(t, "ab"))
+
+	_, err := store.Decode([]byte("a-b"))
+	ao.Equal(store.ErrInvalidAlias, err)
+	_, err = store.Decode(nil)
+	ao.Equal(store.ErrInvalidAlias, err)
+
+	ao.Equal(math.MaxInt64, mustDecode(t, "hWifIaXiv9k"))
+	_, err = store.Decode([]byte("hWifIaXiv9l"))
+	ao.Equal(store.ErrInvalidAlias, err, "IDs above the largest int must not overflow")
+	_, err = store.Decode([]byte("bbbbbbbbbbbb"))
+	ao.Equal(store.ErrInvalidAlias, err, "aliases longer than the largest ID must be rejected")
+}
+
+func mustDecode(t *testing.T, short string) int {
+	t.Helper()
+
+	id, err := store.Decode([]byte(short))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+func shorts(links []store.Link) []string {
+	s := make([]string, 0, len(links))
+	for _, l := range links {
+		s = append(s, l.Short)
+	}
+
+	return s
+}
+
+func Test_Search(t *testing.T) {
+	ao := assert.New(t)
+	st := newStorage(t)
+
+	start := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	day := 0
+	st.SetNow(func() time.Time {
+		day++
+		return start.AddDate(0, 0, day)
+	})
+
+	seed := []struct {
+		long    string
+		creator string
+	}{
+		{"https://go.dev/doc", "alice"},  // b, 2021-03-02
+		{"https://ya.ru", "bob"},         // c, 2021-03-03
+		{"https://GO.dev/blog", "alice"}, // d, 2021-03-04
+		{"golang.org/pkg", ""},           // e, 2021-03-05
+		{"https://go.dev/play", "bob"},   // f, 2021-03-06
+	}
+	for _, l := range seed {
+		_, _, err := st.Shorter(store.Namespace{}, []byte(l.long), store.Meta{Creator: l.creator})
+		ao.NoError(err)
+	}
+
+	type testData struct {
+		tCase string
+		query store.Query
+
+		expectedShorts []string
+		expectedCursor string
+	}
+
+	testTable := []testData{
+		{
+			tCase:          "all links from newest",
+			query:          store.Query{Count: 10},
+			expectedShorts: []string{"f", "e", "d", "c", "b"},
+		},
+		{
+			tCase:          "first page",
+			query:          store.Query{Count: 2},
+			expectedShorts: []string{"f", "e"},
+			expectedCursor: "e",
+		},
+		{
+			tCase:          "next page",
+			query:          store.Query{Count: 2, Cursor: "e"},
+			expectedShorts: []string{"d", "c"},
+			expectedCursor: "c",
+		},
+		{
+			tCase:          "by creator",
+			query:          store.Query{Count: 10, Creator: "alice"},
+			expectedShorts: []string{"d", "b"},
+		},
+		{
+			tCase:          "by domain",
+			query:          store.Query{Count: 10, Domain: "Go.Dev"},
+			expectedShorts: []string{"f", "d", "b"},
+		},
+		{
+			tCase:          "by domain of URL without scheme",
+			query:          store.Query{Count: 10, Domain: "golang.org"},
+			expectedShorts: []string{"e"},
+		},
+		{
+			tCase:          "by creator and domain",
+			query:          store.Query{Count: 10, Creator: "bob", Domain: "go.dev"},
+			expectedShorts: []string{"f"},
+		},
+		{
+			tCase: "by date range",
+			query: store.Query{
+				Count: 10,
+				From:  time.Date(2021, 3, 3, 0, 0, 0, 0, time.UTC),
+				To:    time.Date(2021, 3, 5, 0, 0, 0, 0, time.UTC),
+			},
+			expectedShorts: []string{"e", "d", "c"},
+		},
+		{
+			tCase:          "date range without links",
+			query:          store.Query{Count: 10, From: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+			expectedShorts: []string{},
+		},
+		{
+			tCase:          "by substring",
+			query:          store.Query{Count: 10, Search: "go.dev/"},
+			expectedShorts: []string{"f", "b"},
+		},
+	}
+
+	for _, tc := range testTable {
+		t.Run(tc.tCase, func(t *testing.T) {
+			links, cursor, err := st.Search(store.Namespace{}, tc.query)
+			ao.NoError(err)
+			ao.NotNil(links, "empty pages must be empty lists")
+			ao.Equal(tc.expectedShorts, shorts(links))
+			ao.Equal(tc.expectedCursor, cursor)
+		})
+	}
+
+	ao.NoError(st.Delete(store.Namespace{}, []byte("d")))
+	links, _, err := st.Search(store.Namespace{}, store.Query{Count: 10, Creator: "alice"})
+	ao.NoError(err)
+	ao.Equal([]string{"b"}, shorts(links), "deleted links must be removed from indexes")
+
+	_, _, err = st.Search(store.Namespace{}, store.Query{Count: 10, Cursor: "not valid"})
+	ao.Equal(store.ErrInvalidCursor, err)
+	_, _, err = st.Search(store.Namespace{}, store.Query{Count: 10, Cursor: strings.Repeat("9", 20)})
+	ao.Equal(store.ErrInvalidCursor, err)
+}
+
+func Test_Meta(t *testing.T) {
+	ao := assert.New(t)
+	st := newStorage(t)
+
+	short, _, err := st.Shorter(store.Namespace{}, []byte("https://go.dev/doc"), store.Meta{
+		Creator:    "alice",
+		Title:      "Docs",
+		Tags:       []string{" Go ", "docs", "go", ""},
+		Attributes: map[string]string{"team": "core", "cost": "0"},
+	})
+	ao.NoError(err)
+	_, _, err = st.Shorter(store.Namespace{}, []byte("https://go.dev/blog"), store.Meta{Tags: []string{"go"}})
+	ao.NoError(err)
+
+	l, err := st.Link(store.Namespace{}, short)
+	ao.NoError(err)
+	ao.Equal(store.Meta{
+		Creator:    "alice",
+		Title:      "Docs",
+		Tags:       []string{"go", "docs"},
+		Attributes: map[string]string{"team": "core", "cost": "0"},
+	}, l.Meta)
+
+	links, _, err := st.Search(store.Namespace{}, store.Query{Count: 10, Tag: "GO"})
+	ao.NoError(err)
+	ao.Equal([]string{"c", "b"}, shorts(links))
+
+	title, notes, tags := "Go docs", "read them", []string{"reference"}
+	updated, err := st.UpdateMeta(store.Namespace{}, short, store.MetaPatch{
+		Title:      &title,
+		Notes:      &notes,
+		Tags:       &tags,
+		Attributes: map[string]*string{"cost": nil, "owner": &title},
+	})
+	ao.NoError(err)
+	ao.Equal(store.Meta{
+		Creator:    "alice",
+		Title:      "Go docs",
+		Tags:       []string{"reference"},
+		Notes:      "read them",
+		Attributes: map[string]string{"team": "core", "owner": "Go docs"},
+	}, updated.Meta)
+
+	l, err = st.Link(store.Namespace{}, short)
+	ao.NoError(err)
+	ao.Equal(updated, l)
+
+	links, _, err = st.Search(store.Namespace{}, store.Query{Count: 10, Tag: "go"})
+	ao.NoError(err)
+	ao.Equal([]string{"c"}, shorts(links), "removed tags must be removed from indexes")
+
+	links, _, err = st.Search(store.Namespace{}, store.Query{Count: 10, Tag: "reference"})
+	ao.NoError(err)
+	ao.Equal([]string{"b"}, shorts(links))
+
+	_, err = st.UpdateMeta(store.Namespace{}, []byte("missing"), store.MetaPatch{Title: &title})
+	ao.Equal(redis.ErrNil, err)
+	_, err = st.UpdateMeta(store.Namespace{}, []byte("not-valid"), store.MetaPatch{Title: &title})
+	ao.Equal(store.ErrInvalidAlias, err)
+	_, err = st.Link(store.Namespace{}, []byte("missing"))
+	ao.Equal(redis.ErrNil, err)
+}
+
+func Test_UpdateMetaSettings(t *testing.T) {
+	ao := assert.New(t)
+	st := newStorage(t)
+
+	short, _, err := st.Shorter(store.Namespace{}, []byte("https://go.dev"), store.Meta{})
+	ao.NoError(err)
+
+	hash := "hash"
+	_, err = st.UpdateMeta(store.Namespace{}, short, store.MetaPatch{PasswordHash: &hash})
+	ao.NoError(err)
+
+	again, created, err := st.Shorter(store.Namespace{}, []byte("https://go.dev"), store.Meta{})
+	ao.NoError(err)
+	ao.True(created, "links given settings must not be shared anymore")
+	ao.NotEqual(string(short), string(again))
+
+	l, err := st.Link(store.Namespace{}, short)
+	ao.NoError(err)
+	ao.True(l.Protected)
+
+	same, created, err := st.Shorter(store.Namespace{}, []byte("https://go.dev"), store.Meta{})
+	ao.NoError(err)
+	ao.False(created)
+	ao.Equal(string(again), string(same))
+}
+
+func Test_ActiveAt(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	st := newStorage(t)
+
+	launch := time.Date(2021, 4, 1, 9, 0, 0, 0, time.UTC)
+	end := launch.AddDate(0, 1, 0)
+	ns := store.Namespace{}
+	short, _, err := st.Shorter(ns, []byte("https://go.dev/campaign"), store.Meta{
+		NotBefore:   &launch,
+		NotAfter:    &end,
+		FallbackURL: "https://go.dev",
+	})
+	ao.NoError(err)
+
+	l, err := st.Link(ns, short)
+	ao.NoError(err)
+	ao.Equal(&launch, l.NotBefore)
+	ao.Equal(&end, l.NotAfter)
+	ao.Equal("https://go.dev", l.FallbackURL)
+
+	ao.Equal(store.ErrNotActive, l.ActiveAt(launch.Add(-time.Second)))
+	ao.NoError(l.ActiveAt(launch))
+	ao.NoError(l.ActiveAt(end))
+	ao.Equal(store.ErrExpired, l.ActiveAt(end.Add(time.Second)))
+
+	later := launch.AddDate(0, 0, 7)
+	l, err = st.UpdateMeta(ns, short, store.MetaPatch{NotBefore: &later, NotAfter: &time.Time{}})
+	ao.NoError(err)
+	ao.Equal(&later, l.NotBefore)
+	ao.Nil(l.NotAfter, "the zero time must remove the bound")
+	ao.NoError(l.ActiveAt(end.AddDate(1, 0, 0)))
+
+	l, err = st.Link(ns, short)
+	ao.NoError(err)
+	ao.Equal(&later, l.NotBefore)
+	ao.Nil(l.NotAfter)
+}
+
+func Test_Rules(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	st := newStorage(t)
+
+	ns := store.Namespace{}
+	rules := []store.Rule{
+		{Platform: "ios", URL: "https://apps.apple.com/app/example"},
+		{Language: "de", Query: map[string]string{"ref": ""}, URL: "https://example.de"},
+	}
+	short, _, err := st.Shorter(ns, []byte("https://example.com"), store.Meta{Rules: rules})
+	ao.NoError(err)
+
+	l, err := st.Link(ns, short)
+	ao.NoError(err)
+	ao.Equal(rules, l.Rules, "rules must keep their order")
+
+	l, err = st.UpdateMeta(ns, short, store.MetaPatch{Rules: &[]store.Rule{}})
+	ao.NoError(err)
+	ao.Empty(l.Rules)
+	l, err = st.Link(ns, short)
+	ao.NoError(err)
+	ao.Empty(l.Rules)
+}
+
+func Test_Variants(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	st := newStorage(t)
+
+	ns := store.Namespace{}
+	variants := []store.Variant{
+		{Name: "control", URL: "https://example.com", Weight: 90},
+		{Name: "new", URL: "https://example.com/new", Weight: 10},
+	}
+	short, _, err := st.Shorter(ns, []byte("https://example.com"), store.Meta{Variants: variants})
+	ao.NoError(err)
+
+	l, err := st.Link(ns, short)
+	ao.NoError(err)
+	ao.Equal(variants, l.Variants)
+
+	variants[0].Weight, variants[1].Weight = 50, 50
+	l, err = st.UpdateMeta(ns, short, store.MetaPatch{Variants: &variants})
+	ao.NoError(err)
+	ao.Equal(variants, l.Variants, "weights must be changed without changing the alias")
+	l, err = st.Longer(ns, short)
+	ao.NoError(err)
+	ao.Equal(variants, l.Variants)
+}
+
+func Test_Passthrough(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	st := newStorage(t)
+
+	ns := store.Namespace{}
+	meta := store.Meta{ForwardQuery: true, Prefix: true, UTM: map[string]string{"source": "shorty"}, Preview: true}
+	short, _, err := st.Shorter(ns, []byte("https://docs.example.com"), meta)
+	ao.NoError(err)
+
+	l, err := st.Longer(ns, short)
+	ao.NoError(err)
+	ao.Equal(meta, l.Meta)
+
+	off := false
+	_, err = st.UpdateMeta(ns, short, store.MetaPatch{Prefix: &off, UTM: &map[string]string{}, Preview: &off})
+	ao.NoError(err)
+	l, err = st.Link(ns, short)
+	ao.NoError(err)
+	ao.Equal(store.Meta{ForwardQuery: true}, l.Meta)
+}
+
+func Test_SetOpenGraph(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	st := newStorage(t)
+
+	ns := store.Namespace{}
+	short, _, err := st.Shorter(ns, []byte("https://go.dev"), store.Meta{Title: "Go", Tags: []string{"go"}})
+	ao.NoError(err)
+
+	og := store.OpenGraph{
+		Title:       "The Go Programming Language",
+		Description: "Go is an open source programming language.",
+		Image:       "https://go.dev/images/go-logo-white.svg",
+		FetchedAt:   time.Date(2021, 4, 1, 9, 0, 0, 0, time.UTC),
+	}
+	ao.NoError(st.SetOpenGraph(ns, short, og))
+
+	l, err := st.Link(ns, short)
+	ao.NoError(err)
+	ao.Equal(&og, l.OpenGraph)
+	ao.Equal("Go", l.Title, "other details must be kept")
+	ao.Equal([]string{"go"}, l.Tags)
+
+	title := "Go"
+	l, err = st.UpdateMeta(ns, short, store.MetaPatch{Title: &title})
+	ao.NoError(err)
+	ao.Equal(&og, l.OpenGraph, "editing details must keep the fetched metadata")
+
+	ao.Equal(redis.ErrNil, st.SetOpenGraph(ns, []byte("zz"), og))
+}
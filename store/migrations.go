@@ -0,0 +1,358 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// schemaVersionKey keeps the version of the last migration applied, it is missing before the first one.
+	schemaVersionKey = "schemaVersion"
+	// migrationPrefix prefixes keys of hashes keeping the progress of migrations being applied.
+	migrationPrefix = "migration:"
+	// migrationLockKey is held by the instance applying migrations.
+	migrationLockKey = "migrationLock"
+	// migrationLockTTL is the number of seconds the lock is held for unless it is extended by the next batch.
+	migrationLockTTL = 60
+
+	// migrationBatch is the number of entries migrated at once.
+	migrationBatch = 1000
+
+	// releaseLockScript deletes the lock given as the key unless it is held with another token than the argument.
+	releaseLockScript = `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`
+	// extendLockScript sets the TTL of the lock given as the key to the second argument provided that the lock is
+	// held with the token given as the first one, and returns 0 otherwise.
+	extendLockScript = `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("EXPIRE", KEYS[1], ARGV[2])
+end
+return 0`
+)
+
+var (
+	// ErrMigrating is returned when migrations are being applied by another instance.
+	ErrMigrating = errors.New("migrations are being applied by another instance")
+	// ErrSchemaTooNew is returned when the data has been migrated by a newer version of shorty.
+	ErrSchemaTooNew = errors.New("the schema of the data is newer than the supported one")
+)
+
+// Migration changes how data is stored from the previous schema version to its own one. It is applied in batches:
+// Migrate processes the batch starting at the cursor, which is empty for the first batch, and returns the cursor of
+// the next batch, which is empty after the last one, along with the number of processed entries. The cursor is saved
+// after every batch, so an interrupted migration resumes from the last saved one; batches must therefore be safe to
+// apply more than once.
+type Migration struct {
+	Version     int
+	Description string
+	Migrate     func(s *Storage, cursor string) (next string, processed int, err error)
+}
+
+// MigrationProgress describes the state of a migration.
+type MigrationProgress struct {
+	Version     int    `json:"version"`
+	Description string `json:"description"`
+	// Processed is the number of entries processed so far by the migration being applied.
+	Processed int  `json:"processed"`
+	Applied   bool `json:"applied"`
+}
+
+// migrations lists all migrations in the order of their versions. New migrations are appended with the next version.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "index links saved before secondary indexes",
+		Migrate: func(s *Storage, cursor string) (string, int, error) {
+			return s.scanLinks(cursor, indexLinks)
+		},
+	},
+}
+
+// SchemaVersion returns the version of the last migration applied to the data, zero if none has been applied.
+func (s *Storage) SchemaVersion() (int, error) {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	return schemaVersion(conn)
+}
+
+func schemaVersion(conn redis.Conn) (int, error) {
+	version, err := redis.Int(do(conn, "GET", schemaVersionKey))
+	if err == redis.ErrNil {
+		return 0, nil
+	}
+
+	return version, err
+}
+
+// Migrations returns the progress of all known migrations.
+func (s *Storage) Migrations() ([]MigrationProgress, error) {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	version, err := schemaVersion(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	progress := make([]MigrationProgress, len(s.migrations))
+	for i, m := range s.migrations {
+		progress[i] = MigrationProgress{Version: m.Version, Description: m.Description, Applied: m.Version <= version}
+		if progress[i].Applied {
+			continue
+		}
+
+		processed, err := redis.Int(do(conn, "HGET", migrationPrefix+strconv.Itoa(m.Version), "processed"))
+		if err != nil && err != redis.ErrNil {
+			return nil, err
+		}
+		progress[i].Processed = processed
+	}
+
+	return progress, nil
+}
+
+// Migrate applies the migrations newer than the schema version of the data in order, calling progress after every
+// batch. Only one instance applies migrations at a time, ErrMigrating is returned to others.
+func (s *Storage) Migrate(progress func(MigrationProgress)) error {
+	if progress == nil {
+		progress = func(MigrationProgress) {}
+	}
+
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	version, err := schemaVersion(conn)
+	if err != nil {
+		return err
+	}
+	latest := 0
+	if len(s.migrations) > 0 {
+		latest = s.migrations[len(s.migrations)-1].Version
+	}
+	switch {
+	case version > latest:
+		return ErrSchemaTooNew
+	case version == latest:
+		return nil
+	}
+
+	token := randomHex(16)
+	if _, err := redis.String(do(conn, "SET", migrationLockKey, token, "NX", "EX", migrationLockTTL)); err != nil {
+		if err == redis.ErrNil {
+			return ErrMigrating
+		}
+		return err
+	}
+	defer func() {
+		_, _ = do(conn, "EVAL", releaseLockScript, 1, migrationLockKey, token)
+	}()
+
+	// the version may have been raised by another instance before the lock was taken
+	if version, err = schemaVersion(conn); err != nil {
+		return err
+	}
+	for _, m := range s.migrations {
+		if m.Version <= version {
+			continue
+		}
+		if err := s.applyMigration(conn, token, m, progress); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyMigration applies the migration batch by batch from the last saved cursor and raises the schema version
+// once it is done. The lock held with the token is extended after every batch; if it has expired in the meantime,
+// ErrMigrating is returned without saving the progress, which belongs to the instance holding the lock now.
+func (s *Storage) applyMigration(conn redis.Conn, token string, m Migration, progress func(MigrationProgress)) error {
+	key := migrationPrefix + strconv.Itoa(m.Version)
+	state, err := redis.StringMap(do(conn, "HGETALL", key))
+	if err != nil {
+		return err
+	}
+
+	cursor := state["cursor"]
+	p := MigrationProgress{Version: m.Version, Description: m.Description}
+	p.Processed, _ = strconv.Atoi(state["processed"])
+
+	for {
+		next, processed, err := m.Migrate(s, cursor)
+		if err != nil {
+			return err
+		}
+		p.Processed += processed
+
+		if err := extendLock(conn, token); err != nil {
+			return err
+		}
+
+		if next == "" {
+			if _, err := do(conn, "MULTI"); err != nil {
+				return err
+			}
+			_, _ = do(conn, "SET", schemaVersionKey, m.Version)
+			_, _ = do(conn, "DEL", key)
+			if _, err := do(conn, "EXEC"); err != nil {
+				return err
+			}
+
+			p.Applied = true
+			progress(p)
+			return nil
+		}
+
+		if _, err := do(conn, "HSET", key, "cursor", next, "processed", p.Processed); err != nil {
+			return err
+		}
+		progress(p)
+		cursor = next
+	}
+}
+
+// extendLock extends the migration lock held with the token, ErrMigrating is returned if it is not held anymore.
+func extendLock(conn redis.Conn, token string) error {
+	extended, err := redis.Bool(do(conn, "EVAL", extendLockScript, 1, migrationLockKey, token, migrationLockTTL))
+	if err != nil {
+		return err
+	}
+	if !extended {
+		return ErrMigrating
+	}
+
+	return nil
+}
+
+// linksCursor is the position of a migration scanning links of all namespaces.
+type linksCursor struct {
+	Tenant string `json:"tenant,omitempty"`
+	Domain string `json:"domain,omitempty"`
+	Scan   uint64 `json:"scan,omitempty"`
+}
+
+// scanLinks calls fn for the batch of aliases saved in all namespaces which starts at the cursor, and returns the
+// cursor of the next batch along with the number of aliases in the batch. Namespaces are scanned in the order of
+// their tenants and domains, so namespaces of tenants created during the scan may be skipped; their links are saved
+// by the current version anyway.
+func (s *Storage) scanLinks(cursor string, fn func(conn redis.Conn, ns Namespace, shorts []string) error) (string,
+	int, error) {
+	var c linksCursor
+	if cursor != "" {
+		if err := json.Unmarshal([]byte(cursor), &c); err != nil {
+			return "", 0, ErrInvalidCursor
+		}
+	}
+
+	tenants, err := s.Tenants()
+	if err != nil {
+		return "", 0, err
+	}
+	namespaces := []Namespace{{}}
+	for _, t := range tenants {
+		namespaces = append(namespaces, t.namespaces()...)
+	}
+	less := func(a, b Namespace) bool {
+		return a.Tenant < b.Tenant || (a.Tenant == b.Tenant && a.Domain < b.Domain)
+	}
+	sort.Slice(namespaces, func(i, j int) bool { return less(namespaces[i], namespaces[j]) })
+
+	// resume at the namespace of the cursor, or at the next one if it has been deleted since
+	at := Namespace{Tenant: c.Tenant, Domain: c.Domain}
+	i := sort.Search(len(namespaces), func(i int) bool { return !less(namespaces[i], at) })
+	if i == len(namespaces) {
+		return "", 0, nil
+	}
+	ns := namespaces[i]
+	if ns != at {
+		c.Scan = 0
+	}
+
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	reply, err := redis.Values(do(conn, "HSCAN", ns.key(shortToLong), c.Scan, "COUNT", migrationBatch))
+	if err != nil {
+		return "", 0, err
+	}
+	var (
+		next  uint64
+		pairs []string
+	)
+	if _, err := redis.Scan(reply, &next, &pairs); err != nil {
+		return "", 0, err
+	}
+
+	shorts := make([]string, 0, len(pairs)/2)
+	for j := 0; j+1 < len(pairs); j += 2 {
+		shorts = append(shorts, pairs[j])
+	}
+	if len(shorts) > 0 {
+		if err := fn(conn, ns, shorts); err != nil {
+			return "", 0, err
+		}
+	}
+
+	switch {
+	case next != 0:
+		c = linksCursor{Tenant: ns.Tenant, Domain: ns.Domain, Scan: next}
+	case i+1 < len(namespaces):
+		c = linksCursor{Tenant: namespaces[i+1].Tenant, Domain: namespaces[i+1].Domain}
+	default:
+		return "", len(shorts), nil
+	}
+
+	raw, err := json.Marshal(c)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return string(raw), len(shorts), nil
+}
+
+// indexLinks adds the links to the indexes they belong to. Adding a link to an index again does not change it.
+func indexLinks(conn redis.Conn, ns Namespace, shorts []string) error {
+	links, err := loadLinks(conn, ns, shorts)
+	if err != nil {
+		return err
+	}
+
+	var cmds []command
+	for _, l := range links {
+		id, err := decode([]byte(l.Short))
+		if err != nil {
+			// aliases which cannot be generated are reported by the integrity check
+			continue
+		}
+
+		for _, index := range l.indexes(ns) {
+			cmds = append(cmds, command{"ZADD", []interface{}{index, id, l.Short}})
+		}
+		if !l.CreatedAt.IsZero() {
+			cmds = append(cmds, command{"ZADD", []interface{}{ns.key(indexCreated), micros(l.CreatedAt), l.Short}})
+		}
+	}
+	if len(cmds) == 0 {
+		return nil
+	}
+
+	replies, err := pipeline(conn, cmds)
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if err, ok := reply.(redis.Error); ok {
+			return err
+		}
+	}
+
+	return nil
+}
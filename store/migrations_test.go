@@ -0,0 +1,203 @@
+package store_test
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yexelm/shorty/store"
+)
+
+// legacyFixture fills Redis with links saved by versions of shorty which did not index links: aliases of the
+// default namespace and of both hosts of a tenant, one of them with a record.
+func legacyFixture(s *miniredis.Miniredis) {
+	s.Set("lastID", "5")
+	s.HSet("tenants", "acme", `{"id":"acme","hosts":["go.acme.io","promo.acme.com"],"default_host":"go.acme.io"}`)
+	s.HSet("tenantHosts", "go.acme.io", "acme", "promo.acme.com", "acme")
+
+	for prefix, links := range map[string][]string{
+		"":                                   {"b", "https://go.dev", "c", "https://ya.ru"},
+		"tenant:acme:":                       {"d", "https://acme.io/docs"},
+		"tenant:acme:domain:promo.acme.com:": {"e", "https://acme.io/sale", "f", "https://acme.io/blog"},
+	} {
+		for i := 0; i < len(links); i += 2 {
+			s.HSet(prefix+"shortToLong", links[i], links[i+1])
+			s.HSet(prefix+"longToShort", links[i+1], links[i])
+		}
+	}
+	s.HSet("tenant:acme:link:d", "created_at", "1614556800000000000", "creator", "team")
+}
+
+func Test_Migrate(t *testing.T) {
+	ao := assert.New(t)
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	legacyFixture(s)
+
+	st, err := store.New(s.Addr(), 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	version, err := st.SchemaVersion()
+	ao.NoError(err)
+	ao.Zero(version)
+
+	pending, err := st.Migrations()
+	ao.NoError(err)
+	ao.Equal([]store.MigrationProgress{{Version: 1, Description: "index links saved before secondary indexes"}}, pending)
+
+	// legacy links are not found by the search before they are indexed
+	links, _, err := st.Search(store.Namespace{}, store.Query{Count: 10})
+	ao.NoError(err)
+	ao.Empty(links)
+
+	var progress []store.MigrationProgress
+	ao.NoError(st.Migrate(func(p store.MigrationProgress) {
+		progress = append(progress, p)
+	}))
+	ao.NotEmpty(progress)
+	last := progress[len(progress)-1]
+	ao.True(last.Applied)
+	ao.Equal(5, last.Processed)
+
+	version, err = st.SchemaVersion()
+	ao.NoError(err)
+	ao.Equal(1, version)
+
+	for ns, expected := range map[store.Namespace][]string{
+		{}:               {"c", "b"},
+		{Tenant: "acme"}: {"d"},
+		{Tenant: "acme", Domain: "promo.acme.com"}: {"f", "e"},
+	} {
+		links, _, err := st.Search(ns, store.Query{Count: 10})
+		ao.NoError(err)
+		ao.Equal(expected, shorts(links), ns)
+	}
+	links, _, err = st.Search(store.Namespace{Tenant: "acme"}, store.Query{Creator: "team", Domain: "acme.io", Count: 10})
+	ao.NoError(err)
+	ao.Equal([]string{"d"}, shorts(links))
+	created, err := s.ZMembers("tenant:acme:idx:created")
+	ao.NoError(err)
+	ao.Equal([]string{"d"}, created)
+
+	// applied migrations are not applied again
+	progress = nil
+	ao.NoError(st.Migrate(func(p store.MigrationProgress) {
+		progress = append(progress, p)
+	}))
+	ao.Empty(progress)
+	applied, err := st.Migrations()
+	ao.NoError(err)
+	ao.True(applied[0].Applied)
+}
+
+func Test_MigrateResumes(t *testing.T) {
+	ao := assert.New(t)
+	st := newStorage(t)
+
+	var (
+		cursors []string
+		fail    = true
+	)
+	st.SetMigrations([]store.Migration{
+		{
+			Version:     1,
+			Description: "count to three",
+			Migrate: func(_ *store.Storage, cursor string) (string, int, error) {
+				cursors = append(cursors, cursor)
+				n, _ := strconv.Atoi(cursor)
+				if n == 2 && fail {
+					return "", 0, errors.New("connection reset")
+				}
+				if n == 3 {
+					return "", 1, nil
+				}
+				return strconv.Itoa(n + 1), 10, nil
+			},
+		},
+		{
+			Version:     2,
+			Description: "nothing to do",
+			Migrate: func(*store.Storage, string) (string, int, error) {
+				return "", 0, nil
+			},
+		},
+	})
+
+	ao.EqualError(st.Migrate(nil), "connection reset")
+	ao.Equal([]string{"", "1", "2"}, cursors)
+	progress, err := st.Migrations()
+	ao.NoError(err)
+	ao.Equal([]store.MigrationProgress{
+		{Version: 1, Description: "count to three", Processed: 20},
+		{Version: 2, Description: "nothing to do"},
+	}, progress)
+
+	// the migration resumes from the batch which failed
+	fail = false
+	cursors = nil
+	var last store.MigrationProgress
+	ao.NoError(st.Migrate(func(p store.MigrationProgress) {
+		last = p
+	}))
+	ao.Equal([]string{"2", "3"}, cursors)
+	ao.Equal(store.MigrationProgress{Version: 2, Description: "nothing to do", Applied: true}, last)
+
+	progress, err = st.Migrations()
+	ao.NoError(err)
+	ao.Equal([]store.MigrationProgress{
+		{Version: 1, Description: "count to three", Applied: true},
+		{Version: 2, Description: "nothing to do", Applied: true},
+	}, progress)
+	version, err := st.SchemaVersion()
+	ao.NoError(err)
+	ao.Equal(2, version)
+
+	st.SetMigrations(nil)
+	ao.Equal(store.ErrSchemaTooNew, st.Migrate(nil))
+}
+
+func Test_MigrateLocked(t *testing.T) {
+	ao := assert.New(t)
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Set("migrationLock", "another")
+
+	st, err := store.New(s.Addr(), 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	ao.Equal(store.ErrMigrating, st.Migrate(nil))
+
+	s.Del("migrationLock")
+	ao.NoError(st.Migrate(nil))
+	ao.False(s.Exists("migrationLock"))
+
+	// the lock expires during a batch and is taken by another instance
+	st.SetMigrations([]store.Migration{{
+		Version:     2,
+		Description: "slow",
+		Migrate: func(*store.Storage, string) (string, int, error) {
+			s.Set("migrationLock", "another")
+			return "next", 1, nil
+		},
+	}})
+	ao.Equal(store.ErrMigrating, st.Migrate(nil))
+	held, err := s.Get("migrationLock")
+	ao.NoError(err)
+	ao.Equal("another", held, "the lock of another instance must not be released")
+	ao.False(s.Exists("migration:2"), "the progress must be left to the instance holding the lock")
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// attemptsPrefix prefixes keys counting failed password attempts of each link.
+const attemptsPrefix = "attempts:"
+
+// HashPassword returns the bcrypt hash of the password of a link.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+// CheckPassword reports whether the password matches the hash.
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// Attempts returns the number of failed password attempts of the link within the current lockout window.
+func (s *Storage) Attempts(ns Namespace, short []byte) (int, error) {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	n, err := redis.Int(do(conn, "GET", ns.key(attemptsPrefix+string(short))))
+	if err == redis.ErrNil {
+		return 0, nil
+	}
+
+	return n, err
+}
+
+// FailAttempt records a failed password attempt of the link and returns the number of failed attempts. The window
+// starts at the first failed attempt, and the counter is reset when it ends.
+func (s *Storage) FailAttempt(ns Namespace, short []byte, window time.Duration) (int, error) {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	key := ns.key(attemptsPrefix + string(short))
+	n, err := redis.Int(do(conn, "INCR", key))
+	if err != nil {
+		return 0, err
+	}
+	if n == 1 {
+		if _, err := do(conn, "PEXPIRE", key, window.Milliseconds()); err != nil {
+			return 0, err
+		}
+	}
+
+	return n, nil
+}
+
+// ResetAttempts forgets failed password attempts of the link.
+func (s *Storage) ResetAttempts(ns Namespace, short []byte) error {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	_, err := do(conn, "DEL", ns.key(attemptsPrefix+string(short)))
+	return err
+}
@@ -0,0 +1,61 @@
+package store_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yexelm/shorty/store"
+)
+
+func Test_Passwords(t *testing.T) {
+	ao := assert.New(t)
+	st := newStorage(t)
+
+	hash, err := store.HashPassword("s3cret")
+	ao.NoError(err)
+	ao.True(store.CheckPassword(hash, "s3cret"))
+	ao.False(store.CheckPassword(hash, "secret"))
+	ao.False(store.CheckPassword("", ""))
+
+	ns := store.Namespace{}
+	open, _, err := st.Shorter(ns, []byte("https://go.dev"), store.Meta{})
+	ao.NoError(err)
+	short, _, err := st.Shorter(ns, []byte("https://go.dev"), store.Meta{PasswordHash: hash})
+	ao.NoError(err)
+	ao.NotEqual(open, short, "protected links must not reuse the alias of the URL")
+	l, err := st.Longer(ns, short)
+	ao.NoError(err)
+	ao.True(l.Protected)
+	ao.Equal(hash, l.PasswordHash)
+	again, _, err := st.Shorter(ns, []byte("https://go.dev"), store.Meta{})
+	ao.NoError(err)
+	ao.Equal(open, again, "protected links must not be returned to others shortening the URL")
+
+	for i := 1; i <= 3; i++ {
+		n, err := st.FailAttempt(ns, short, time.Minute)
+		ao.NoError(err)
+		ao.Equal(i, n)
+	}
+	n, err := st.Attempts(ns, short)
+	ao.NoError(err)
+	ao.Equal(3, n)
+	n, err = st.Attempts(store.Namespace{Tenant: "acme"}, short)
+	ao.NoError(err)
+	ao.Zero(n, "attempts must be counted within the namespace")
+
+	ao.NoError(st.ResetAttempts(ns, short))
+	n, err = st.Attempts(ns, short)
+	ao.NoError(err)
+	ao.Zero(n)
+
+	empty := ""
+	l, err = st.UpdateMeta(ns, short, store.MetaPatch{PasswordHash: &empty})
+	ao.NoError(err)
+	ao.False(l.Protected)
+	l, err = st.Longer(ns, short)
+	ao.NoError(err)
+	ao.False(l.Protected)
+	ao.Empty(l.PasswordHash)
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Positions of keys among the arguments of commands.
+const (
+	noKeys = iota
+	firstKey
+	allKeys
+	// scriptKeys are the keys of scripts following the script and the number of keys.
+	scriptKeys
+)
+
+// ErrUnprefixedCommand is returned for commands whose keys are not known to the connection prefixing them.
+var ErrUnprefixedCommand = errors.New("the command cannot be sent with a key prefix")
+
+// keyArgs tells which arguments of the commands sent by the store are keys.
+var keyArgs = map[string]int{
+	"": noKeys, "PING": noKeys, "MULTI": noKeys, "EXEC": noKeys, "DISCARD": noKeys, "UNWATCH": noKeys,
+
+	"DEL": allKeys, "EXISTS": allKeys, "WATCH": allKeys, "MGET": allKeys,
+
+	"GET": firstKey, "SET": firstKey, "INCR": firstKey, "INCRBY": firstKey, "EXPIRE": firstKey, "PEXPIRE": firstKey,
+	"TTL": firstKey, "PTTL": firstKey, "TYPE": firstKey,
+	"HGET": firstKey, "HSET": firstKey, "HSETNX": firstKey, "HDEL": firstKey, "HGETALL": firstKey, "HMGET": firstKey,
+	"HSCAN": firstKey, "HINCRBY": firstKey, "HEXISTS": firstKey, "HLEN": firstKey, "HKEYS": firstKey,
+	"SADD": firstKey, "SREM": firstKey, "SISMEMBER": firstKey, "SCARD": firstKey, "SMEMBERS": firstKey,
+	"ZADD": firstKey, "ZREM": firstKey, "ZSCORE": firstKey, "ZCARD": firstKey, "ZINCRBY": firstKey, "ZRANGE": firstKey,
+	"ZREVRANGE": firstKey, "ZRANGEBYSCORE": firstKey, "ZREVRANGEBYSCORE": firstKey,
+	"LPUSH": firstKey, "RPUSH": firstKey, "LTRIM": firstKey, "LRANGE": firstKey, "LLEN": firstKey,
+	"XADD": firstKey, "XLEN": firstKey, "XRANGE": firstKey,
+
+	"EVAL": scriptKeys, "EVALSHA": scriptKeys,
+}
+
+// normalizePrefix makes the prefix end with a colon like the other separators of keys, unless it is empty.
+func normalizePrefix(prefix string) string {
+	if prefix == "" || strings.HasSuffix(prefix, ":") {
+		return prefix
+	}
+
+	return prefix + ":"
+}
+
+// prefixedConn prepends the prefix to the keys of all commands, so that several deployments of shorty can share a
+// Redis database. Replies are not changed, as none of the commands sent by the store replies with key names.
+type prefixedConn struct {
+	redis.Conn
+	prefix string
+}
+
+func (c prefixedConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	args, err := c.prefixed(cmd, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Conn.Do(cmd, args...)
+}
+
+func (c prefixedConn) Send(cmd string, args ...interface{}) error {
+	args, err := c.prefixed(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	return c.Conn.Send(cmd, args...)
+}
+
+// prefixed returns a copy of the arguments of the command with the prefix prepended to the keys.
+func (c prefixedConn) prefixed(cmd string, args []interface{}) ([]interface{}, error) {
+	keys, ok := keyArgs[strings.ToUpper(cmd)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrUnprefixedCommand, cmd)
+	}
+
+	from, to := 0, 0
+	switch keys {
+	case firstKey:
+		to = 1
+	case allKeys:
+		to = len(args)
+	case scriptKeys:
+		if len(args) < 2 {
+			break
+		}
+		n, err := strconv.Atoi(fmt.Sprint(args[1]))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v with an invalid number of keys", ErrUnprefixedCommand, cmd)
+		}
+		from, to = 2, 2+n
+	}
+	if to > len(args) {
+		to = len(args)
+	}
+	if from >= to {
+		return args, nil
+	}
+
+	prefixed := make([]interface{}, len(args))
+	copy(prefixed, args)
+	for i := from; i < to; i++ {
+		switch key := args[i].(type) {
+		case string:
+			prefixed[i] = c.prefix + key
+		case []byte:
+			prefixed[i] = c.prefix + string(key)
+		default:
+			prefixed[i] = c.prefix + fmt.Sprint(key)
+		}
+	}
+
+	return prefixed, nil
+}
@@ -0,0 +1,75 @@
+package store_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yexelm/shorty/store"
+)
+
+func Test_KeyPrefix(t *testing.T) {
+	ao := assert.New(t)
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, prefix := range []string{"staging", "prod:"} {
+		st, err := store.New(s.Addr(), 0, prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ao.NoError(st.Migrate(nil))
+		_, err = st.SaveTenant(store.Tenant{ID: "acme", Hosts: []string{"go.acme.io", "promo.acme.com"}})
+		ao.NoError(err)
+		ns, err := st.NamespaceByHost("promo.acme.com")
+		ao.NoError(err)
+
+		for _, ns := range []store.Namespace{{}, ns} {
+			// both deployments generate the same aliases independently
+			short, _, err := st.Shorter(ns, []byte("https://go.dev"), store.Meta{Tags: []string{"go"}, MaxClicks: 5})
+			ao.NoError(err)
+			l, err := st.Longer(ns, short)
+			ao.NoError(err)
+			ao.NoError(st.Click(ns, l, store.Visit{Country: "NL"}))
+			ao.NoError(st.Disable(ns, short))
+		}
+
+		_, err = st.IssueKey("acme", "team")
+		ao.NoError(err)
+		h, err := st.SaveWebhook("acme", store.Webhook{URL: "https://example.com/hook"})
+		ao.NoError(err)
+		_, err = st.EnqueueDelivery(store.Delivery{Tenant: "acme", Webhook: h.ID, Event: "link.created"}, time.Now())
+		ao.NoError(err)
+		r, err := st.CheckIntegrity(store.Namespace{}, true)
+		ao.NoError(err)
+		ao.Empty(r.Problems)
+
+		stats, err := st.Stats(store.Namespace{})
+		ao.NoError(err)
+		ao.Equal(store.Stats{Links: 1, Disabled: 1, LastID: 2}, stats)
+
+		conn := st.Pool.Get()
+		_, err = conn.Do("KEYS", "*")
+		ao.True(errors.Is(err, store.ErrUnprefixedCommand))
+		conn.Close()
+
+		st.Close()
+	}
+
+	keys := s.Keys()
+	ao.NotEmpty(keys)
+	for _, key := range keys {
+		ao.True(strings.HasPrefix(key, "staging:") || strings.HasPrefix(key, "prod:"), key)
+	}
+	ao.Contains(keys, "staging:lastID")
+	ao.Contains(keys, "prod:tenant:acme:domain:promo.acme.com:shortToLong")
+	ao.Equal(len(keys)/2, strings.Count(strings.Join(keys, " "), "staging:"))
+}
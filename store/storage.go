@@ -2,15 +2,36 @@ package store
 
 import (
 	"bytes"
+	"errors"
 	"log"
+	"math"
+	"sync"
+	"time"
 
 	"github.com/gomodule/redigo/redis"
+
+	"github.com/yexelm/shorty/metrics"
 )
 
 const (
 	longToShort = "longToShort"
 	shortToLong = "shortToLong"
 	lastIDKey   = "lastID"
+
+	// allowedChars are digits of short aliases, which are IDs of links written in base 62 from the least significant
+	// digit.
+	allowedChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	// maxAliasLen is the length of the alias of the largest ID, math.MaxInt64.
+	maxAliasLen = 11
+)
+
+var (
+	// ErrClosed is returned when a new short alias is requested from the closed Storage.
+	ErrClosed = errors.New("storage is closed")
+	// ErrInvalidAlias is returned for strings which cannot be generated as short aliases.
+	ErrInvalidAlias = errors.New("invalid short alias")
+	// ErrURLTaken is returned when a URL is shortened without settings, but its deduplicated link has some.
+	ErrURLTaken = errors.New("the URL already has a link with other settings")
 )
 
 // Storage keeps pool of connections for redis, number of saved URLs and channel required for generation of short
@@ -19,44 +40,92 @@ type Storage struct {
 	Pool      *redis.Pool
 	IDChannel chan int
 	LastID    int
+
+	// raise raises the last ID of the generator
+	raise     chan int
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+
+	// hosts caches tenants serving host names
+	hosts hostCache
+
+	// migrations are applied by Migrate, they are replaced in tests
+	migrations []Migration
+
+	// now returns the current time, it is replaced in tests
+	now func() time.Time
 }
 
-// New returns an instance of Storage.
-func New(redisURL string, db int) (*Storage, error) {
+// New returns an instance of Storage. The prefix is prepended to all keys the Storage touches, so that several
+// deployments can share a Redis database; a colon is appended to it unless it is empty or ends with one.
+func New(redisURL string, db int, prefix string) (*Storage, error) {
 	s := Storage{
-		Pool:      newPool(redisURL, db),
+		Pool:      newPool(redisURL, db, normalizePrefix(prefix)),
 		IDChannel: make(chan int),
-	}
+		raise:     make(chan int),
+		done:      make(chan struct{}),
+		now:       time.Now,
 
-	conn := s.Pool.Get()
+		migrations: migrations,
+	}
 
 	lastID, err := s.retrieveLastID()
 	if err != nil {
 		return nil, err
 	}
 	s.LastID = lastID
+	metrics.LastID.Set(float64(lastID))
 
-	go func() {
-		defer conn.Close()
+	s.wg.Add(1)
+	go s.generateIDs()
+
+	return &s, nil
+}
+
+// generateIDs hands out consecutive IDs via IDChannel and persists the last one in Redis until the Storage is closed.
+func (s *Storage) generateIDs() {
+	defer s.wg.Done()
 
-		for {
-			s.LastID++
-			s.IDChannel <- s.LastID
-			_, err = conn.Do("INCR", lastIDKey)
-			if err != nil {
-				log.Printf("failed to incr %v due to: %v", lastIDKey, err)
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	for {
+		select {
+		case s.IDChannel <- s.LastID + 1:
+		case id := <-s.raise:
+			if id > s.LastID {
+				s.LastID = id
+				metrics.LastID.Set(float64(id))
 			}
+			continue
+		case <-s.done:
+			return
 		}
-	}()
 
-	return &s, nil
+		s.LastID++
+		metrics.LastID.Set(float64(s.LastID))
+		if _, err := do(conn, "INCR", lastIDKey); err != nil {
+			log.Printf("failed to incr %v due to: %v", lastIDKey, err)
+		}
+	}
+}
+
+// nextID returns the next unused ID or ErrClosed if the Storage has been closed.
+func (s *Storage) nextID() (int, error) {
+	select {
+	case id := <-s.IDChannel:
+		return id, nil
+	case <-s.done:
+		return 0, ErrClosed
+	}
 }
 
 func (s *Storage) exists(key string) (bool, error) {
 	conn := s.Pool.Get()
 	defer conn.Close()
 
-	exists, err := redis.Bool(conn.Do("EXISTS", key))
+	exists, err := redis.Bool(do(conn, "EXISTS", key))
 	if err != nil {
 		log.Printf("failed while checking if %v exists", key)
 		return false, err
@@ -76,7 +145,7 @@ func (s *Storage) retrieveLastID() (int, error) {
 
 	switch exists {
 	case true:
-		lastID, err := redis.Int(conn.Do("GET", lastIDKey))
+		lastID, err := redis.Int(do(conn, "GET", lastIDKey))
 		if err != nil {
 			log.Printf("failed while getting %v", lastIDKey)
 			return 0, err
@@ -86,7 +155,7 @@ func (s *Storage) retrieveLastID() (int, error) {
 		const lastID = 0
 
 		log.Printf("key %v does not exist, creating it", lastIDKey)
-		_, err = conn.Do("SET", lastIDKey, lastID)
+		_, err = do(conn, "SET", lastIDKey, lastID)
 		if err != nil {
 			log.Printf("failed while setting %v", lastIDKey)
 			return 0, err
@@ -97,72 +166,192 @@ func (s *Storage) retrieveLastID() (int, error) {
 	}
 }
 
-func newPool(redisURL string, db int) *redis.Pool {
+func newPool(redisURL string, db int, prefix string) *redis.Pool {
 	p := redis.Pool{
 		Dial: func() (redis.Conn, error) {
-			return redis.Dial("tcp", redisURL, redis.DialDatabase(db))
+			conn, err := redis.Dial("tcp", redisURL, redis.DialDatabase(db))
+			if err != nil || prefix == "" {
+				return conn, err
+			}
+			return prefixedConn{Conn: conn, prefix: prefix}, nil
 		},
 	}
 
 	return &p
 }
 
-// Longer searches the original URL in Redis by given short alias.
-func (s *Storage) Longer(short []byte) ([]byte, error) {
-	conn := s.Pool.Get()
-	defer conn.Close()
+// do executes the Redis command on the given connection, recording its latency and failures.
+func do(conn redis.Conn, cmd string, args ...interface{}) (interface{}, error) {
+	start := time.Now()
+	reply, err := conn.Do(cmd, args...)
+	metrics.RedisDuration.WithLabelValues(cmd).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.RedisErrors.WithLabelValues(cmd).Inc()
+	}
+
+	return reply, err
+}
+
+// command is a Redis command sent as a part of a pipeline.
+type command struct {
+	name string
+	args []interface{}
+}
+
+// pipeline sends all commands at once and returns their replies in order, recording the latency of the whole
+// pipeline. Error replies are returned as redis.Error values.
+func pipeline(conn redis.Conn, cmds []command) ([]interface{}, error) {
+	const name = "PIPELINE"
+
+	start := time.Now()
+	defer func() {
+		metrics.RedisDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	}()
+
+	for _, c := range cmds {
+		if err := conn.Send(c.name, c.args...); err != nil {
+			metrics.RedisErrors.WithLabelValues(name).Inc()
+			return nil, err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		metrics.RedisErrors.WithLabelValues(name).Inc()
+		return nil, err
+	}
+
+	replies := make([]interface{}, len(cmds))
+	for i := range cmds {
+		reply, err := conn.Receive()
+		if _, ok := err.(redis.Error); ok {
+			reply = err
+		} else if err != nil {
+			metrics.RedisErrors.WithLabelValues(name).Inc()
+			return nil, err
+		}
+		replies[i] = reply
+	}
+
+	return replies, nil
+}
+
+// Longer returns the link saved in the namespace by the given short alias. ErrDisabled is returned for disabled
+// aliases, ErrExhausted for links which have reached their click limit.
+func (s *Storage) Longer(ns Namespace, short []byte) (Link, error) {
+	l, err := s.Link(ns, short)
+	if err != nil {
+		return Link{}, err
+	}
+	if l.Disabled {
+		return Link{}, ErrDisabled
+	}
+
+	if l.MaxClicks > 0 {
+		conn := s.Pool.Get()
+		defer conn.Close()
+
+		clicks, err := loadClicks(conn, ns, l)
+		if err != nil {
+			return Link{}, err
+		}
+		if clicks >= l.MaxClicks {
+			return Link{}, ErrExhausted
+		}
+	}
 
-	return redis.Bytes(conn.Do("HGET", shortToLong, short))
+	return l, nil
 }
 
-// Shorter checks if the given URL has a short version saved earlier. If not, it saves it into Redis and returns
-// a short alias for the given URL.
-func (s *Storage) Shorter(longURL []byte) ([]byte, error) {
+// Shorter returns the short alias of the link saved earlier in the namespace for the given URL, or saves a new one
+// along with the given details and reports that it has been created. Only links without settings are deduplicated: a
+// link with settings, such as a password or a click limit, always gets its own alias. UpdateMeta unmatches URLs from
+// links given settings, ErrURLTaken is returned if the URL is still matched to such a link.
+func (s *Storage) Shorter(ns Namespace, longURL []byte, meta Meta) ([]byte, bool, error) {
+	if meta.hasSettings() {
+		return created(s.SaveFull(ns, longURL, meta))
+	}
+
 	conn := s.Pool.Get()
 	defer conn.Close()
 
-	short, err := redis.Bytes(conn.Do("HGET", longToShort, longURL))
-	if err == nil {
-		return short, nil
+	short, err := redis.Bytes(do(conn, "HGET", ns.key(longToShort), longURL))
+	if err == redis.ErrNil {
+		return created(s.SaveFull(ns, longURL, meta))
+	}
+	if err != nil {
+		return nil, false, err
 	}
 
-	if err == redis.ErrNil {
-		return s.SaveFull(longURL)
+	links, err := loadLinks(conn, ns, []string{string(short)})
+	switch {
+	case err != nil:
+		return nil, false, err
+	case len(links) == 0:
+		// the alias has been deleted without its URL
+		return created(s.SaveFull(ns, longURL, meta))
+	case links[0].hasSettings():
+		return nil, false, ErrURLTaken
 	}
 
-	return nil, err
+	return short, false, nil
+}
 
+// created reports the alias returned by SaveFull as created unless saving it failed.
+func created(short []byte, err error) ([]byte, bool, error) {
+	return short, err == nil, err
 }
 
-// SaveFull generates a unique short alias for the given URL, saves the match between this alias and the given
-// URL into Redis and returns alias.
-func (s *Storage) SaveFull(longURL []byte) ([]byte, error) {
-	short := hash(s.IDChannel)
+// SaveFull generates a unique short alias for the given URL, atomically saves the match between this alias and the
+// given URL into the namespace along with the record of the link and its index entries, and returns alias. The URL is
+// matched back to the alias for deduplication unless the link has settings. ErrQuotaExceeded is returned if the
+// tenant of the namespace has no links left.
+func (s *Storage) SaveFull(ns Namespace, longURL []byte, meta Meta) ([]byte, error) {
 	conn := s.Pool.Get()
 	defer conn.Close()
 
-	if _, err := conn.Do("HSET", longToShort, longURL, short); err != nil {
-		log.Printf("failed to save long link %q as short %q into Redis", longURL, short)
+	if err := checkQuota(conn, ns); err != nil {
 		return nil, err
 	}
 
-	if _, err := conn.Do("HSET", shortToLong, short, longURL); err != nil {
-		log.Printf("failed to save short link %q as long %q into Redis", short, longURL)
+	id, err := s.nextID()
+	if err != nil {
 		return nil, err
 	}
 
+	short := hash(id)
+	meta.Tags = normalizeTags(meta.Tags)
+	l := Link{
+		Short:     string(short),
+		Long:      string(longURL),
+		CreatedAt: s.now().UTC(),
+		Meta:      meta,
+	}
+
+	if _, err := do(conn, "MULTI"); err != nil {
+		return nil, err
+	}
+	if !meta.hasSettings() {
+		_, _ = do(conn, "HSET", ns.key(longToShort), longURL, short)
+	}
+	_, _ = do(conn, "HSET", ns.key(shortToLong), short, longURL)
+	queueRecord(conn, ns, l)
+	_, _ = do(conn, "ZADD", ns.key(indexCreated), micros(l.CreatedAt), short)
+	for _, index := range l.indexes(ns) {
+		_, _ = do(conn, "ZADD", index, id, short)
+	}
+	if _, err := do(conn, "EXEC"); err != nil {
+		log.Printf("failed to save link %q as short %q into Redis", longURL, short)
+		return nil, err
+	}
+	metrics.LinksCreated.Inc()
+
 	return short, nil
 }
 
-// hash generates the unique short alias for the incoming link
-func hash(IDChannel <-chan int) []byte {
-	const (
-		allowedChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-		lenChars     = len(allowedChars)
-	)
+// hash generates the unique short alias for the incoming link from its ID
+func hash(id int) []byte {
+	const lenChars = len(allowedChars)
 
 	buf := new(bytes.Buffer)
-	id := <-IDChannel
 	for id > 0 {
 		// the returned error here is always nil according to godoc
 		_ = buf.WriteByte(allowedChars[id%lenChars])
@@ -172,12 +361,36 @@ func hash(IDChannel <-chan int) []byte {
 	return buf.Bytes()
 }
 
-// Close closes all connections to Redis, releasing all resources.
-func (s *Storage) Close() {
-	err := s.Pool.Close()
-	if err != nil {
-		log.Printf("failed to close connections to Redis: %v", err)
-	} else {
-		log.Println("successfully disconnected from Redis")
+// decode returns the ID the short alias was generated from. Aliases of IDs which do not fit into int are invalid.
+func decode(short []byte) (int, error) {
+	if len(short) == 0 || len(short) > maxAliasLen {
+		return 0, ErrInvalidAlias
+	}
+
+	id := 0
+	for i := len(short) - 1; i >= 0; i-- {
+		pos := bytes.IndexByte([]byte(allowedChars), short[i])
+		if pos < 0 || id > (math.MaxInt64-pos)/len(allowedChars) {
+			return 0, ErrInvalidAlias
+		}
+		id = id*len(allowedChars) + pos
 	}
+
+	return id, nil
+}
+
+// Close stops the ID generator and closes all connections to Redis, releasing all resources. It is safe to call
+// Close more than once.
+func (s *Storage) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+
+		err := s.Pool.Close()
+		if err != nil {
+			log.Printf("failed to close connections to Redis: %v", err)
+		} else {
+			log.Println("successfully disconnected from Redis")
+		}
+	})
 }
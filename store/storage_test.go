@@ -20,7 +20,7 @@ func TestMain(m *testing.M) {
 	}
 	defer s.Close()
 
-	db, _ = store.New(s.Addr(), 0)
+	db, _ = store.New(s.Addr(), 0, "")
 	code := m.Run()
 	conn := db.Pool.Get()
 	_, _ = conn.Do("FLUSHDB")
@@ -64,7 +64,7 @@ func Test_Shorter(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(string(tc.longURL), func(t *testing.T) {
-			got, gotErr := db.Shorter(tc.longURL)
+			got, _, gotErr := db.Shorter(store.Namespace{}, tc.longURL, store.Meta{})
 			if !bytes.Equal(got, tc.want) {
 				t.Errorf("\ngot:  %q\nwant: %q\n", got, tc.want)
 			}
@@ -89,7 +89,7 @@ func Test_Longer(t *testing.T) {
 		rand.Read(buf)
 
 		longURL := buf
-		short, err := db.SaveFull(longURL)
+		short, err := db.SaveFull(store.Namespace{}, longURL, store.Meta{})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -98,12 +98,23 @@ func Test_Longer(t *testing.T) {
 	}
 
 	for l, s := range longToShortMap {
-		long, err := db.Longer(s)
+		long, err := db.Longer(store.Namespace{}, s)
 		if err != nil {
 			t.Fatal(err)
 		}
-		if !bytes.Equal(long, []byte(l)) {
-			t.Fatalf("long in map: %q, long in Redis: %q", long, l)
+		if long.Long != l {
+			t.Fatalf("long in map: %q, long in Redis: %q", l, long.Long)
 		}
 	}
 }
+
+// Test_Close checks that the closed Storage refuses to generate new short aliases instead of blocking forever.
+func Test_Close(t *testing.T) {
+	closed := newStorage(t)
+	closed.Close()
+	closed.Close()
+
+	if _, err := closed.SaveFull(store.Namespace{}, []byte("ya.ru"), store.Meta{}); err != store.ErrClosed {
+		t.Errorf("\ngot:  %v\nwant: %v\n", err, store.ErrClosed)
+	}
+}
@@ -0,0 +1,389 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// tenantPrefix prefixes all keys in namespaces of tenants, domainPrefix prefixes keys in namespaces of their
+	// additional domains.
+	tenantPrefix = "tenant:"
+	domainPrefix = "domain:"
+	// tenantsKey matches IDs of tenants to their JSON encoded settings.
+	tenantsKey = "tenants"
+	// tenantHosts matches host names to IDs of the tenants serving them.
+	tenantHosts = "tenantHosts"
+
+	// tenantCacheTTL limits how long host names resolved to tenants are cached, so that changes made by other
+	// instances are picked up.
+	tenantCacheTTL = 10 * time.Second
+)
+
+var (
+	ErrInvalidTenant = errors.New("tenant ID must consist of 1 to 64 lowercase letters, digits and dashes")
+	ErrHostTaken     = errors.New("the host is already served by another tenant")
+	ErrInvalidHost   = errors.New("the default host must be one of the hosts of the tenant")
+	ErrQuotaExceeded = errors.New("link quota of the tenant is exceeded")
+	// ErrDefaultHostChanged is returned when the default host of a tenant is changed or removed. Links of the default
+	// host are kept in the namespace of the tenant itself, so another host would take them over.
+	ErrDefaultHostChanged = errors.New("the default host of the tenant cannot be changed")
+
+	tenantID = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+)
+
+// Namespace selects the keys a storage operation works with, so that tenants cannot see or modify links of each
+// other. The zero Namespace is the default one, which keeps the keys saved before tenants were introduced. Links of
+// the default host of a tenant are kept without a domain, other hosts of the tenant have their own namespaces.
+type Namespace struct {
+	Tenant string
+	Domain string
+}
+
+// key returns the name of the given key in the namespace.
+func (ns Namespace) key(name string) string {
+	if ns.Domain != "" {
+		name = domainPrefix + ns.Domain + ":" + name
+	}
+	if ns.Tenant != "" {
+		name = tenantPrefix + ns.Tenant + ":" + name
+	}
+
+	return name
+}
+
+// Tenant contains settings of a workspace with its own links, API keys and statistics.
+type Tenant struct {
+	ID string `json:"id"`
+	// Hosts are served by the tenant, each of them has its own namespace of short aliases.
+	Hosts []string `json:"hosts,omitempty"`
+	// DefaultHost is the host short URLs are built on unless another one is requested, it is the first host by
+	// default. It cannot be changed once set, since its links are kept in the namespace of the tenant itself.
+	DefaultHost string `json:"default_host,omitempty"`
+	// Quota limits the number of links of the tenant, zero means no limit.
+	Quota int `json:"quota,omitempty"`
+}
+
+// namespace returns the namespace of links served on the host of the tenant.
+func (t Tenant) namespace(host string) Namespace {
+	if host == t.DefaultHost {
+		return Namespace{Tenant: t.ID}
+	}
+
+	return Namespace{Tenant: t.ID, Domain: host}
+}
+
+// namespaces returns all namespaces of links of the tenant.
+func (t Tenant) namespaces() []Namespace {
+	namespaces := []Namespace{{Tenant: t.ID}}
+	for _, h := range t.Hosts {
+		if h != t.DefaultHost {
+			namespaces = append(namespaces, t.namespace(h))
+		}
+	}
+
+	return namespaces
+}
+
+// hostCache keeps host names recently resolved to namespaces.
+type hostCache struct {
+	mu      sync.Mutex
+	entries map[string]hostEntry
+}
+
+type hostEntry struct {
+	ns      Namespace
+	expires time.Time
+}
+
+func (c *hostCache) get(host string, now time.Time) (Namespace, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[host]
+	if !ok || now.After(e.expires) {
+		return Namespace{}, false
+	}
+
+	return e.ns, true
+}
+
+func (c *hostCache) set(host string, ns Namespace, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]hostEntry)
+	}
+	c.entries[host] = hostEntry{ns: ns, expires: now.Add(tenantCacheTTL)}
+}
+
+func (c *hostCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = nil
+}
+
+// NamespaceByHost returns the namespace of links served on the host, which is the default namespace for hosts not
+// served by any tenant.
+func (s *Storage) NamespaceByHost(host string) (Namespace, error) {
+	host = normalizeHost(host)
+	if ns, ok := s.hosts.get(host, s.now()); ok {
+		return ns, nil
+	}
+
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	var ns Namespace
+	tenant, err := redis.String(do(conn, "HGET", tenantHosts, host))
+	switch {
+	case err == nil:
+		t, err := loadTenant(conn, tenant)
+		if err != nil {
+			return Namespace{}, err
+		}
+		ns = t.namespace(host)
+	case err != redis.ErrNil:
+		return Namespace{}, err
+	}
+	s.hosts.set(host, ns, s.now())
+
+	return ns, nil
+}
+
+// Tenant returns settings of the tenant or redis.ErrNil if it does not exist.
+func (s *Storage) Tenant(id string) (Tenant, error) {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	return loadTenant(conn, id)
+}
+
+func loadTenant(conn redis.Conn, id string) (Tenant, error) {
+	raw, err := redis.Bytes(do(conn, "HGET", tenantsKey, id))
+	if err != nil {
+		return Tenant{}, err
+	}
+
+	var t Tenant
+	if err := json.Unmarshal(raw, &t); err != nil {
+		return Tenant{}, err
+	}
+
+	return t, nil
+}
+
+// Tenants returns settings of all tenants ordered by their IDs.
+func (s *Storage) Tenants() ([]Tenant, error) {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	raw, err := redis.StringMap(do(conn, "HGETALL", tenantsKey))
+	if err != nil {
+		return nil, err
+	}
+
+	tenants := make([]Tenant, 0, len(raw))
+	for _, v := range raw {
+		var t Tenant
+		if err := json.Unmarshal([]byte(v), &t); err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, t)
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].ID < tenants[j].ID })
+
+	return tenants, nil
+}
+
+// SaveTenant creates the tenant or replaces its settings and returns the saved settings. ErrHostTaken is returned if
+// any of its hosts is served by another tenant, ErrDefaultHostChanged if the settings change its default host. The
+// default host is kept if none is given.
+func (s *Storage) SaveTenant(t Tenant) (Tenant, error) {
+	if !tenantID.MatchString(t.ID) {
+		return Tenant{}, ErrInvalidTenant
+	}
+	hosts := make([]string, 0, len(t.Hosts))
+	for _, h := range t.Hosts {
+		if h = normalizeHost(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	t.Hosts = hosts
+
+	defaultHost := normalizeHost(t.DefaultHost)
+	if defaultHost != "" && !contains(hosts, defaultHost) {
+		return Tenant{}, ErrInvalidHost
+	}
+
+	conn := s.Pool.Get()
+	defer conn.Close()
+	defer s.hosts.reset()
+
+	for i := 0; i < updateAttempts; i++ {
+		if _, err := do(conn, "WATCH", tenantsKey, tenantHosts); err != nil {
+			return Tenant{}, err
+		}
+
+		old, err := loadTenant(conn, t.ID)
+		if err != nil && err != redis.ErrNil {
+			_, _ = do(conn, "UNWATCH")
+			return Tenant{}, err
+		}
+
+		for _, h := range t.Hosts {
+			owner, err := redis.String(do(conn, "HGET", tenantHosts, h))
+			if err != nil && err != redis.ErrNil {
+				_, _ = do(conn, "UNWATCH")
+				return Tenant{}, err
+			}
+			if owner != "" && owner != t.ID {
+				_, _ = do(conn, "UNWATCH")
+				return Tenant{}, ErrHostTaken
+			}
+		}
+
+		t.DefaultHost = defaultHost
+		switch {
+		case t.DefaultHost == "" && contains(hosts, old.DefaultHost):
+			t.DefaultHost = old.DefaultHost
+		case t.DefaultHost == "" && len(hosts) > 0:
+			t.DefaultHost = hosts[0]
+		}
+		if old.DefaultHost != "" && t.DefaultHost != old.DefaultHost {
+			_, _ = do(conn, "UNWATCH")
+			return Tenant{}, ErrDefaultHostChanged
+		}
+		settings, err := json.Marshal(t)
+		if err != nil {
+			_, _ = do(conn, "UNWATCH")
+			return Tenant{}, err
+		}
+
+		if _, err := do(conn, "MULTI"); err != nil {
+			return Tenant{}, err
+		}
+		for _, h := range old.Hosts {
+			_, _ = do(conn, "HDEL", tenantHosts, h)
+		}
+		for _, h := range t.Hosts {
+			_, _ = do(conn, "HSET", tenantHosts, h, t.ID)
+		}
+		_, _ = do(conn, "HSET", tenantsKey, t.ID, settings)
+
+		reply, err := do(conn, "EXEC")
+		if err != nil {
+			return Tenant{}, err
+		}
+		if reply != nil {
+			return t, nil
+		}
+	}
+
+	return Tenant{}, ErrConflict
+}
+
+// DeleteTenant removes settings and webhooks of the tenant and revokes its API keys. Links of the tenant are kept, so
+// that they are available again if the tenant is recreated.
+func (s *Storage) DeleteTenant(id string) error {
+	conn := s.Pool.Get()
+	defer conn.Close()
+	defer s.hosts.reset()
+
+	t, err := loadTenant(conn, id)
+	if err != nil {
+		return err
+	}
+
+	ns := Namespace{Tenant: id}
+	keys, err := redis.StringMap(do(conn, "HGETALL", ns.key(apiKeyOwners)))
+	if err != nil {
+		return err
+	}
+
+	if _, err := do(conn, "MULTI"); err != nil {
+		return err
+	}
+	for _, hash := range keys {
+		_, _ = do(conn, "HDEL", apiKeys, hash)
+		_, _ = do(conn, "HDEL", apiKeyTenants, hash)
+	}
+	_, _ = do(conn, "DEL", ns.key(apiKeyOwners))
+	_, _ = do(conn, "DEL", ns.key(webhooks))
+	for _, h := range t.Hosts {
+		_, _ = do(conn, "HDEL", tenantHosts, h)
+	}
+	_, _ = do(conn, "HDEL", tenantsKey, id)
+	_, err = do(conn, "EXEC")
+
+	return err
+}
+
+// checkQuota returns ErrQuotaExceeded if the tenant of the namespace cannot save more links on all of its hosts.
+// Concurrent requests may exceed the quota by a few links.
+func checkQuota(conn redis.Conn, ns Namespace) error {
+	if ns.Tenant == "" {
+		return nil
+	}
+
+	t, err := loadTenant(conn, ns.Tenant)
+	if err == redis.ErrNil || err == nil && t.Quota == 0 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	namespaces := t.namespaces()
+	cmds := make([]command, 0, len(namespaces))
+	for _, ns := range namespaces {
+		cmds = append(cmds, command{"HLEN", []interface{}{ns.key(shortToLong)}})
+	}
+	replies, err := pipeline(conn, cmds)
+	if err != nil {
+		return err
+	}
+
+	n := 0
+	for _, reply := range replies {
+		count, err := redis.Int(reply, nil)
+		if err != nil {
+			return err
+		}
+		n += count
+	}
+	if n >= t.Quota {
+		return ErrQuotaExceeded
+	}
+
+	return nil
+}
+
+// normalizeHost returns the lowercase host name without the port.
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if i := strings.LastIndexByte(host, ':'); i >= 0 && !strings.HasSuffix(host, "]") {
+		host = host[:i]
+	}
+
+	return strings.Trim(host, "[]")
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,123 @@
+package store_test
+
+import (
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yexelm/shorty/store"
+)
+
+func Test_Tenants(t *testing.T) {
+	ao := assert.New(t)
+	st := newStorage(t)
+
+	acme := store.Namespace{Tenant: "acme"}
+	_, err := st.SaveTenant(store.Tenant{ID: "Not Valid"})
+	ao.Equal(store.ErrInvalidTenant, err)
+	_, err = st.SaveTenant(store.Tenant{ID: "acme", Hosts: []string{"go.acme.com"}, DefaultHost: "acme.com"})
+	ao.Equal(store.ErrInvalidHost, err)
+	saved, err := st.SaveTenant(store.Tenant{ID: "acme", Hosts: []string{"Go.Acme.com:8080"}, Quota: 2})
+	ao.NoError(err)
+	ao.Equal(store.Tenant{ID: "acme", Hosts: []string{"go.acme.com"}, DefaultHost: "go.acme.com", Quota: 2}, saved)
+	_, err = st.SaveTenant(store.Tenant{ID: "globex", Hosts: []string{"globex.io"}})
+	ao.NoError(err)
+	_, err = st.SaveTenant(store.Tenant{ID: "globex", Hosts: []string{"go.acme.com"}})
+	ao.Equal(store.ErrHostTaken, err)
+
+	tenants, err := st.Tenants()
+	ao.NoError(err)
+	ao.Equal([]store.Tenant{
+		{ID: "acme", Hosts: []string{"go.acme.com"}, DefaultHost: "go.acme.com", Quota: 2},
+		{ID: "globex", Hosts: []string{"globex.io"}, DefaultHost: "globex.io"},
+	}, tenants)
+
+	ns, err := st.NamespaceByHost("GO.acme.com:443")
+	ao.NoError(err)
+	ao.Equal(acme, ns, "the default host of a tenant must not have a domain namespace")
+	ns, err = st.NamespaceByHost("localhost:8080")
+	ao.NoError(err)
+	ao.Equal(store.Namespace{}, ns)
+
+	shared, _, err := st.Shorter(store.Namespace{}, []byte("https://go.dev"), store.Meta{})
+	ao.NoError(err)
+	own, _, err := st.Shorter(acme, []byte("https://go.dev"), store.Meta{})
+	ao.NoError(err)
+	ao.NotEqual(shared, own, "links must be deduplicated within a tenant only")
+
+	again, _, err := st.Shorter(acme, []byte("https://go.dev"), store.Meta{})
+	ao.NoError(err)
+	ao.Equal(own, again)
+
+	_, err = st.Longer(store.Namespace{}, own)
+	ao.Equal(redis.ErrNil, err, "links of a tenant must not be visible outside of it")
+	ao.Equal(redis.ErrNil, st.Delete(store.Namespace{Tenant: "globex"}, own))
+	long, err := st.Longer(acme, own)
+	ao.NoError(err)
+	ao.Equal("https://go.dev", long.Long)
+
+	key, err := st.IssueKey("acme", "team")
+	ao.NoError(err)
+	p, err := st.Authenticate(key)
+	ao.NoError(err)
+	ao.Equal(store.Principal{Tenant: "acme", Owner: "team"}, p)
+	ao.Equal(acme, p.Namespace())
+
+	_, err = st.IssueKey("missing", "team")
+	ao.Equal(redis.ErrNil, err)
+
+	ao.NoError(st.DeleteTenant("acme"))
+	_, err = st.Authenticate(key)
+	ao.Equal(redis.ErrNil, err, "keys of a deleted tenant must be revoked")
+	ns, err = st.NamespaceByHost("go.acme.com")
+	ao.NoError(err)
+	ao.Equal(store.Namespace{}, ns)
+	ao.Equal(redis.ErrNil, st.DeleteTenant("acme"))
+}
+
+func Test_Domains(t *testing.T) {
+	ao := assert.New(t)
+	st := newStorage(t)
+
+	_, err := st.SaveTenant(store.Tenant{ID: "acme", Hosts: []string{"go.acme.io", "promo.acme.com"}, Quota: 3})
+	ao.NoError(err)
+
+	goNS, err := st.NamespaceByHost("go.acme.io")
+	ao.NoError(err)
+	promoNS, err := st.NamespaceByHost("promo.acme.com")
+	ao.NoError(err)
+	ao.Equal(store.Namespace{Tenant: "acme", Domain: "promo.acme.com"}, promoNS)
+
+	short, _, err := st.Shorter(goNS, []byte("https://acme.com"), store.Meta{})
+	ao.NoError(err)
+	_, err = st.Longer(promoNS, short)
+	ao.Equal(redis.ErrNil, err, "each domain must have its own aliases")
+
+	promo, _, err := st.Shorter(promoNS, []byte("https://acme.com"), store.Meta{})
+	ao.NoError(err)
+	ao.NotEqual(short, promo)
+	_, _, err = st.Shorter(promoNS, []byte("https://acme.com/sale"), store.Meta{})
+	ao.NoError(err)
+	_, _, err = st.Shorter(goNS, []byte("https://acme.com/jobs"), store.Meta{})
+	ao.Equal(store.ErrQuotaExceeded, err, "the quota must cover all hosts of the tenant")
+
+	_, err = st.SaveTenant(store.Tenant{ID: "acme", Hosts: []string{"acme.link", "promo.acme.com"}, Quota: 3})
+	ao.Equal(store.ErrDefaultHostChanged, err, "removing the default host must not hand its links over")
+	_, err = st.SaveTenant(store.Tenant{ID: "acme", Hosts: []string{"go.acme.io", "promo.acme.com"},
+		DefaultHost: "promo.acme.com"})
+	ao.Equal(store.ErrDefaultHostChanged, err)
+
+	saved, err := st.SaveTenant(store.Tenant{ID: "acme", Hosts: []string{"acme.link", "go.acme.io", "promo.acme.com"},
+		Quota: 4})
+	ao.NoError(err)
+	ao.Equal("go.acme.io", saved.DefaultHost, "the default host must be kept if none is given")
+
+	for host, want := range map[string][]byte{"go.acme.io": short, "promo.acme.com": promo} {
+		ns, err := st.NamespaceByHost(host)
+		ao.NoError(err)
+		long, err := st.Longer(ns, want)
+		ao.NoError(err, host)
+		ao.Equal("https://acme.com", long.Long)
+	}
+}
@@ -0,0 +1,339 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// webhooks matches IDs of webhooks of a tenant to their JSON encoded settings.
+	webhooks = "webhooks"
+	// webhookLogPrefix prefixes lists of the latest delivery attempts of each webhook, newest first.
+	webhookLogPrefix = "webhookLog:"
+	// webhookQueue is the sorted set of IDs of pending deliveries of all tenants scored by the Unix time in
+	// milliseconds they are due at.
+	webhookQueue = "webhookQueue"
+	// webhookDeliveryPrefix prefixes JSON encoded pending deliveries.
+	webhookDeliveryPrefix = "webhookDelivery:"
+	// deliveryLogSize limits the number of delivery attempts kept for each webhook.
+	deliveryLogSize = 100
+)
+
+// Webhook sends events of a tenant to a URL.
+type Webhook struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Events lists the names of the events sent to the webhook, all events are sent if it is empty.
+	Events []string `json:"events,omitempty"`
+	// Secret signs the payloads, so that the receiver can check they were sent by the application.
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Wants reports whether the event is sent to the webhook.
+func (h Webhook) Wants(event string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Delivery is an event waiting to be sent to a webhook.
+type Delivery struct {
+	ID      string          `json:"id"`
+	Tenant  string          `json:"tenant,omitempty"`
+	Webhook string          `json:"webhook"`
+	Event   string          `json:"event"`
+	Payload json.RawMessage `json:"payload"`
+	// Attempts is the number of failed attempts to send the delivery.
+	Attempts  int       `json:"attempts"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DeliveryAttempt is an entry of the delivery log of a webhook.
+type DeliveryAttempt struct {
+	Delivery string `json:"delivery"`
+	Event    string `json:"event"`
+	Attempt  int    `json:"attempt"`
+	// Status is delivered, retrying or failed once all attempts are used up.
+	Status string `json:"status"`
+	// Code is the status code of the response, it is zero if no response was received.
+	Code        int        `json:"code,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	At          time.Time  `json:"at"`
+	NextAttempt *time.Time `json:"next_attempt,omitempty"`
+}
+
+// SaveWebhook creates the webhook of the tenant, or replaces it if it has an ID. A random ID and secret are generated
+// if they are empty.
+func (s *Storage) SaveWebhook(tenant string, h Webhook) (Webhook, error) {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	ns := Namespace{Tenant: tenant}
+	if tenant != "" {
+		if _, err := loadTenant(conn, tenant); err != nil {
+			return Webhook{}, err
+		}
+	}
+
+	if h.ID == "" {
+		h.ID = randomHex(8)
+		h.CreatedAt = time.Now().UTC()
+	} else {
+		old, err := loadWebhook(conn, ns, h.ID)
+		if err != nil {
+			return Webhook{}, err
+		}
+		h.CreatedAt = old.CreatedAt
+		if h.Secret == "" {
+			h.Secret = old.Secret
+		}
+	}
+	if h.Secret == "" {
+		h.Secret = randomHex(32)
+	}
+
+	// the error is always nil for webhooks made of strings and times
+	raw, _ := json.Marshal(h)
+	if _, err := do(conn, "HSET", ns.key(webhooks), h.ID, raw); err != nil {
+		return Webhook{}, err
+	}
+
+	return h, nil
+}
+
+// Webhooks returns the webhooks of the tenant from the oldest to the newest.
+func (s *Storage) Webhooks(tenant string) ([]Webhook, error) {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	all, err := redis.StringMap(do(conn, "HGETALL", Namespace{Tenant: tenant}.key(webhooks)))
+	if err != nil {
+		return nil, err
+	}
+
+	hooks := make([]Webhook, 0, len(all))
+	for _, raw := range all {
+		var h Webhook
+		if err := json.Unmarshal([]byte(raw), &h); err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, h)
+	}
+	sort.Slice(hooks, func(i, j int) bool {
+		if !hooks[i].CreatedAt.Equal(hooks[j].CreatedAt) {
+			return hooks[i].CreatedAt.Before(hooks[j].CreatedAt)
+		}
+		return hooks[i].ID < hooks[j].ID
+	})
+
+	return hooks, nil
+}
+
+// Webhook returns the webhook of the tenant or redis.ErrNil if it does not exist.
+func (s *Storage) Webhook(tenant, id string) (Webhook, error) {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	return loadWebhook(conn, Namespace{Tenant: tenant}, id)
+}
+
+// DeleteWebhook removes the webhook of the tenant along with its delivery log. Pending deliveries are dropped when
+// they are due.
+func (s *Storage) DeleteWebhook(tenant, id string) error {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	ns := Namespace{Tenant: tenant}
+	n, err := redis.Int(do(conn, "HDEL", ns.key(webhooks), id))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return redis.ErrNil
+	}
+
+	_, err = do(conn, "DEL", ns.key(webhookLogPrefix+id))
+	return err
+}
+
+// EnqueueDelivery saves the delivery and queues it to be sent at the given time. A random ID is generated if it is
+// empty.
+func (s *Storage) EnqueueDelivery(d Delivery, at time.Time) (Delivery, error) {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	if d.ID == "" {
+		d.ID = randomHex(16)
+	}
+	if d.CreatedAt.IsZero() {
+		d.CreatedAt = time.Now().UTC()
+	}
+
+	// the error is always nil for deliveries made of strings, times and valid JSON
+	raw, _ := json.Marshal(d)
+	if _, err := do(conn, "MULTI"); err != nil {
+		return Delivery{}, err
+	}
+	_, _ = do(conn, "SET", webhookDeliveryPrefix+d.ID, raw)
+	_, _ = do(conn, "ZADD", webhookQueue, millis(at), d.ID)
+	if _, err := do(conn, "EXEC"); err != nil {
+		return Delivery{}, err
+	}
+
+	return d, nil
+}
+
+// ClaimDeliveries returns up to count deliveries due at the given time and postpones them by the lease, so that
+// other workers do not send them at the same time. Deliveries which are neither retried nor finished within the lease,
+// for example because the worker has crashed, are claimed again after it expires.
+func (s *Storage) ClaimDeliveries(now time.Time, lease time.Duration, count int) ([]Delivery, error) {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	due, err := redis.Values(do(conn, "ZRANGEBYSCORE", webhookQueue, "-inf", millis(now), "WITHSCORES",
+		"LIMIT", 0, count))
+	if err != nil {
+		return nil, err
+	}
+
+	var claimed []Delivery
+	for i := 0; i+1 < len(due); i += 2 {
+		id, _ := redis.String(due[i], nil)
+		score, _ := redis.Float64(due[i+1], nil)
+
+		// the worker which moved the score first by exactly the lease has claimed the delivery, scores are whole
+		// milliseconds, so they are compared exactly
+		moved, err := redis.Float64(do(conn, "ZINCRBY", webhookQueue, lease.Milliseconds(), id))
+		if err != nil {
+			return claimed, err
+		}
+		if moved != score+float64(lease.Milliseconds()) {
+			continue
+		}
+
+		raw, err := redis.Bytes(do(conn, "GET", webhookDeliveryPrefix+id))
+		if err == redis.ErrNil {
+			// finished by another worker in the meantime
+			_, _ = do(conn, "ZREM", webhookQueue, id)
+			continue
+		}
+		if err != nil {
+			return claimed, err
+		}
+
+		var d Delivery
+		if err := json.Unmarshal(raw, &d); err != nil {
+			return claimed, err
+		}
+		claimed = append(claimed, d)
+	}
+
+	return claimed, nil
+}
+
+// RetryDelivery logs the failed attempt and queues the delivery to be sent again at the given time.
+func (s *Storage) RetryDelivery(d Delivery, at time.Time, a DeliveryAttempt) error {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	// the error is always nil for deliveries made of strings, times and valid JSON
+	raw, _ := json.Marshal(d)
+	if _, err := do(conn, "MULTI"); err != nil {
+		return err
+	}
+	_, _ = do(conn, "SET", webhookDeliveryPrefix+d.ID, raw)
+	_, _ = do(conn, "ZADD", webhookQueue, millis(at), d.ID)
+	queueAttempt(conn, d, a)
+	_, err := do(conn, "EXEC")
+
+	return err
+}
+
+// FinishDelivery logs the last attempt and removes the delivery from the queue.
+func (s *Storage) FinishDelivery(d Delivery, a DeliveryAttempt) error {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	if _, err := do(conn, "MULTI"); err != nil {
+		return err
+	}
+	_, _ = do(conn, "ZREM", webhookQueue, d.ID)
+	_, _ = do(conn, "DEL", webhookDeliveryPrefix+d.ID)
+	queueAttempt(conn, d, a)
+	_, err := do(conn, "EXEC")
+
+	return err
+}
+
+// DeliveryLog returns up to count latest delivery attempts of the webhook of the tenant, newest first.
+func (s *Storage) DeliveryLog(tenant, id string, count int) ([]DeliveryAttempt, error) {
+	conn := s.Pool.Get()
+	defer conn.Close()
+
+	ns := Namespace{Tenant: tenant}
+	if _, err := loadWebhook(conn, ns, id); err != nil {
+		return nil, err
+	}
+
+	entries, err := redis.ByteSlices(do(conn, "LRANGE", ns.key(webhookLogPrefix+id), 0, count-1))
+	if err != nil {
+		return nil, err
+	}
+
+	log := make([]DeliveryAttempt, 0, len(entries))
+	for _, raw := range entries {
+		var a DeliveryAttempt
+		if err := json.Unmarshal(raw, &a); err != nil {
+			return nil, err
+		}
+		log = append(log, a)
+	}
+
+	return log, nil
+}
+
+func loadWebhook(conn redis.Conn, ns Namespace, id string) (Webhook, error) {
+	raw, err := redis.Bytes(do(conn, "HGET", ns.key(webhooks), id))
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	var h Webhook
+	err = json.Unmarshal(raw, &h)
+	return h, err
+}
+
+// queueAttempt queues commands adding the attempt to the delivery log inside a transaction.
+func queueAttempt(conn redis.Conn, d Delivery, a DeliveryAttempt) {
+	key := Namespace{Tenant: d.Tenant}.key(webhookLogPrefix + d.Webhook)
+	// the error is always nil for attempts made of strings, numbers and times
+	raw, _ := json.Marshal(a)
+	_, _ = do(conn, "LPUSH", key, raw)
+	_, _ = do(conn, "LTRIM", key, 0, deliveryLogSize-1)
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func randomHex(n int) string {
+	raw := make([]byte, n)
+	if _, err := rand.Read(raw); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(raw)
+}
@@ -0,0 +1,114 @@
+package store_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/yexelm/shorty/store"
+)
+
+func Test_Webhooks(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	st := newStorage(t)
+
+	_, err := st.SaveWebhook("acme", store.Webhook{URL: "https://example.com/hook"})
+	ao.Equal(redis.ErrNil, err, "webhooks can only be added to existing tenants")
+
+	first, err := st.SaveWebhook("", store.Webhook{URL: "https://example.com/hook", Events: []string{"link.created"}})
+	ao.NoError(err)
+	ao.Len(first.ID, 16)
+	ao.Len(first.Secret, 64)
+	ao.False(first.CreatedAt.IsZero())
+	second, err := st.SaveWebhook("", store.Webhook{URL: "https://example.com/all"})
+	ao.NoError(err)
+
+	first.URL = "https://example.com/moved"
+	secret := first.Secret
+	first.Secret = ""
+	updated, err := st.SaveWebhook("", first)
+	ao.NoError(err)
+	ao.Equal(secret, updated.Secret, "the secret must be kept if none is given")
+	_, err = st.SaveWebhook("", store.Webhook{ID: "unknown", URL: "https://example.com"})
+	ao.Equal(redis.ErrNil, err)
+
+	hooks, err := st.Webhooks("")
+	ao.NoError(err)
+	ao.Equal([]store.Webhook{updated, second}, hooks)
+	ao.True(updated.Wants("link.created"))
+	ao.False(updated.Wants("link.clicked"))
+	ao.True(second.Wants("link.clicked"))
+
+	ao.NoError(st.DeleteWebhook("", second.ID))
+	ao.Equal(redis.ErrNil, st.DeleteWebhook("", second.ID))
+	_, err = st.Webhook("", second.ID)
+	ao.Equal(redis.ErrNil, err)
+}
+
+func Test_Deliveries(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	st := newStorage(t)
+
+	hook, err := st.SaveWebhook("", store.Webhook{URL: "https://example.com/hook"})
+	ao.NoError(err)
+
+	now := time.Now()
+	d, err := st.EnqueueDelivery(store.Delivery{
+		Webhook: hook.ID,
+		Event:   "link.created",
+		Payload: json.RawMessage(`{"event":"link.created"}`),
+	}, now)
+	ao.NoError(err)
+	ao.NotEmpty(d.ID)
+	_, err = st.EnqueueDelivery(store.Delivery{Webhook: hook.ID, Event: "link.deleted", Payload: json.RawMessage(`{}`)},
+		now.Add(time.Hour))
+	ao.NoError(err)
+
+	claimed, err := st.ClaimDeliveries(now, time.Minute, 10)
+	ao.NoError(err)
+	ao.Len(claimed, 1, "only due deliveries must be claimed")
+	ao.Equal(d.ID, claimed[0].ID)
+	ao.JSONEq(`{"event":"link.created"}`, string(claimed[0].Payload))
+
+	claimed, err = st.ClaimDeliveries(now, time.Minute, 10)
+	ao.NoError(err)
+	ao.Empty(claimed, "claimed deliveries must not be claimed again within the lease")
+	claimed, err = st.ClaimDeliveries(now.Add(2*time.Minute), time.Minute, 10)
+	ao.NoError(err)
+	ao.Len(claimed, 1, "deliveries must be claimed again after the lease expires")
+
+	d.Attempts++
+	next := now.Add(10 * time.Second)
+	ao.NoError(st.RetryDelivery(d, next, store.DeliveryAttempt{
+		Delivery: d.ID, Event: d.Event, Attempt: 1, Status: "retrying", Code: 500, At: now.UTC(), NextAttempt: &next,
+	}))
+	claimed, err = st.ClaimDeliveries(next, time.Minute, 10)
+	ao.NoError(err)
+	ao.Len(claimed, 1)
+	ao.Equal(1, claimed[0].Attempts)
+
+	ao.NoError(st.FinishDelivery(d, store.DeliveryAttempt{
+		Delivery: d.ID, Event: d.Event, Attempt: 2, Status: "delivered", Code: 204, At: next.UTC(),
+	}))
+	claimed, err = st.ClaimDeliveries(next.Add(2*time.Minute), time.Minute, 10)
+	ao.NoError(err)
+	ao.Empty(claimed, "finished deliveries must not be claimed")
+
+	log, err := st.DeliveryLog("", hook.ID, 10)
+	ao.NoError(err)
+	ao.Len(log, 2)
+	ao.Equal("delivered", log[0].Status, "the newest attempt must go first")
+	ao.Equal("retrying", log[1].Status)
+	ao.Equal(500, log[1].Code)
+
+	log, err = st.DeliveryLog("", hook.ID, 1)
+	ao.NoError(err)
+	ao.Len(log, 1)
+	_, err = st.DeliveryLog("", "unknown", 10)
+	ao.Equal(redis.ErrNil, err)
+}
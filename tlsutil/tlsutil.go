@@ -0,0 +1,147 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// checkInterval limits how often the certificate files are checked for changes.
+var checkInterval = 10 * time.Second
+
+var ErrNoClientCAs = errors.New("no client CA certificates found")
+
+// CertReloader keeps the server certificate loaded from the given files and reloads it after the files have been
+// rotated, so that renewed certificates are picked up without restarting the application.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu          sync.Mutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	checkedAt   time.Time
+}
+
+// NewCertReloader loads the certificate and the key from the given files and returns an instance of CertReloader.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.load(modTime); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// GetCertificate returns the current certificate, reloading it first if the files have changed. If the reload
+// fails, the previously loaded certificate is kept. It is meant to be used as tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checkedAt) < checkInterval {
+		return r.cert, nil
+	}
+	r.checkedAt = time.Now()
+
+	modTime, err := r.filesModTime()
+	if err != nil {
+		log.Printf("failed to check TLS certificate %v for changes: %v", r.certFile, err)
+		return r.cert, nil
+	}
+
+	if modTime.Equal(r.certModTime) {
+		return r.cert, nil
+	}
+
+	if err := r.load(modTime); err != nil {
+		log.Printf("failed to reload TLS certificate %v, keeping the previous one: %v", r.certFile, err)
+		return r.cert, nil
+	}
+	log.Printf("reloaded TLS certificate %v", r.certFile)
+
+	return r.cert, nil
+}
+
+// load reads the certificate and the key, which must be called with mu held or before r is shared.
+func (r *CertReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.cert = &cert
+	r.certModTime = modTime
+	r.checkedAt = time.Now()
+
+	return nil
+}
+
+// filesModTime returns the latest modification time of the certificate and the key files.
+func (r *CertReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// ServerConfig returns TLS configuration serving certificates from the given CertReloader. If clientCAFile is not
+// empty, clients are required to present a certificate signed by one of the CAs from that file.
+//
+// Only TLS 1.2+ and AEAD cipher suites with forward secrecy are allowed, which keeps the configuration acceptable
+// for HTTP/2 clients and proxies. Only HTTP/1.1 is negotiated via ALPN since fasthttp does not implement HTTP/2.
+func ServerConfig(r *CertReloader, clientCAFile string) (*tls.Config, error) {
+	cfg := tls.Config{
+		GetCertificate: r.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		},
+		NextProtos: []string{"http/1.1"},
+	}
+
+	if clientCAFile == "" {
+		return &cfg, nil
+	}
+
+	pem, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%w in %v", ErrNoClientCAs, clientCAFile)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+
+	return &cfg, nil
+}
@@ -0,0 +1,161 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeCert generates a self-signed certificate for the given common name and writes it with its key into dir.
+func writeCert(t *testing.T, dir, commonName string) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+func commonName(t *testing.T, cert *tls.Certificate) string {
+	t.Helper()
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return leaf.Subject.CommonName
+}
+
+func Test_CertReloader(t *testing.T) {
+	ao := assert.New(t)
+	dir := t.TempDir()
+	checkInterval = 0
+
+	certFile, keyFile := writeCert(t, dir, "old")
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := r.GetCertificate(nil)
+	ao.NoError(err)
+	ao.Equal("old", commonName(t, cert))
+
+	writeCert(t, dir, "new")
+	later := time.Now().Add(time.Minute)
+	ao.NoError(os.Chtimes(certFile, later, later))
+
+	cert, err = r.GetCertificate(nil)
+	ao.NoError(err)
+	ao.Equal("new", commonName(t, cert))
+
+	ao.NoError(os.WriteFile(keyFile, []byte("broken"), 0600))
+	later = later.Add(time.Minute)
+	ao.NoError(os.Chtimes(keyFile, later, later))
+
+	cert, err = r.GetCertificate(nil)
+	ao.NoError(err)
+	ao.Equal("new", commonName(t, cert), "the previous certificate must be kept if the reload fails")
+}
+
+func Test_ServerConfig(t *testing.T) {
+	t.Parallel()
+	ao := assert.New(t)
+	dir := t.TempDir()
+
+	certFile, keyFile := writeCert(t, dir, "server")
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emptyCA := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(emptyCA, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	type testData struct {
+		tCase        string
+		clientCAFile string
+
+		expectedAuth tls.ClientAuthType
+		expectedErr  bool
+	}
+
+	testTable := []testData{
+		{
+			tCase:        "without client certificates",
+			clientCAFile: "",
+			expectedAuth: tls.NoClientCert,
+		},
+		{
+			tCase:        "mutual TLS",
+			clientCAFile: certFile,
+			expectedAuth: tls.RequireAndVerifyClientCert,
+		},
+		{
+			tCase:        "missing CA file",
+			clientCAFile: filepath.Join(dir, "missing.pem"),
+			expectedErr:  true,
+		},
+		{
+			tCase:        "no certificates in CA file",
+			clientCAFile: emptyCA,
+			expectedErr:  true,
+		},
+	}
+
+	for _, tc := range testTable {
+		t.Run(tc.tCase, func(t *testing.T) {
+			cfg, err := ServerConfig(r, tc.clientCAFile)
+			if tc.expectedErr {
+				ao.Error(err)
+				return
+			}
+
+			ao.NoError(err)
+			ao.Equal(tc.expectedAuth, cfg.ClientAuth)
+			ao.Equal(uint16(tls.VersionTLS12), cfg.MinVersion)
+		})
+	}
+}